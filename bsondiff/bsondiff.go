@@ -0,0 +1,414 @@
+// Package bsondiff compares BSON files and dump directories document by document.
+package bsondiff
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/json"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/options"
+	"github.com/mongodb/mongo-tools/common/util"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Types of the lines of the report.
+const (
+	OnlyInSource           = "onlyInSource"
+	OnlyInTarget           = "onlyInTarget"
+	Changed                = "changed"
+	Summary                = "summary"
+	CollectionOnlyInSource = "collectionOnlyInSource"
+	CollectionOnlyInTarget = "collectionOnlyInTarget"
+)
+
+// DocumentDiff is a line of the report for a document that is found on only
+// one side, or that has fields that differ.
+type DocumentDiff struct {
+	Namespace string           `json:"ns"`
+	Type      string           `json:"type"`
+	Key       *json.RawMessage `json:"key"`
+	Fields    []FieldDiff      `json:"fields,omitempty"`
+}
+
+// CollectionDiff is a line of the report for a collection of a dump directory
+// that is found on only one side.
+type CollectionDiff struct {
+	Namespace string `json:"ns"`
+	Type      string `json:"type"`
+}
+
+// CollectionSummary is the last line of the report for a compared collection.
+type CollectionSummary struct {
+	Namespace       string `json:"ns"`
+	Type            string `json:"type"`
+	SourceDocuments int64  `json:"sourceDocuments"`
+	TargetDocuments int64  `json:"targetDocuments"`
+	Matched         int64  `json:"matched"`
+	Changed         int64  `json:"changed"`
+	OnlyInSource    int64  `json:"onlyInSource"`
+	OnlyInTarget    int64  `json:"onlyInTarget"`
+}
+
+// Differences returns the number of documents that differ between the two sides.
+func (summary *CollectionSummary) Differences() int64 {
+	return summary.Changed + summary.OnlyInSource + summary.OnlyInTarget
+}
+
+// BSONDiff is a container for the user-specified options and
+// internal state used for running bsondiff.
+type BSONDiff struct {
+	// generic mongo tool options
+	ToolOptions *options.ToolOptions
+
+	// bsondiff-specific options
+	DiffOptions *DiffOptions
+
+	// the files or dump directories to compare, given as positional arguments
+	Source string
+	Target string
+	isDir  bool
+
+	// Writer for the report when writing to stdout.
+	// This is initialized to os.Stdout if unset.
+	OutputWriter io.Writer
+
+	key     [][]string
+	ignored [][]string
+	report  *bufio.Writer
+}
+
+// ValidateOptions ensures the arguments and options supplied are valid.
+func (bd *BSONDiff) ValidateOptions(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("bsondiff requires a source and a target to compare")
+	}
+	var err error
+	if bd.key, err = splitFields(bd.DiffOptions.Key); err != nil {
+		return fmt.Errorf("invalid --key: %v", err)
+	}
+	if bd.DiffOptions.IgnoreFields != "" {
+		if bd.ignored, err = splitFields(bd.DiffOptions.IgnoreFields); err != nil {
+			return fmt.Errorf("invalid --ignoreFields: %v", err)
+		}
+	}
+	if bd.DiffOptions.SortBufferSize <= 0 {
+		return fmt.Errorf("--sortBufferSize must be greater than 0")
+	}
+
+	source, err := os.Stat(util.ToUniversalPath(args[0]))
+	if err != nil {
+		return err
+	}
+	target, err := os.Stat(util.ToUniversalPath(args[1]))
+	if err != nil {
+		return err
+	}
+	if source.IsDir() != target.IsDir() {
+		return fmt.Errorf("the source and target must both be BSON files or both be dump directories")
+	}
+	bd.Source, bd.Target, bd.isDir = args[0], args[1], source.IsDir()
+	return nil
+}
+
+// Run compares the source and target that were set by ValidateOptions, and
+// writes the report. It returns the number of documents that differ.
+func (bd *BSONDiff) Run() (int64, error) {
+	if bd.OutputWriter == nil {
+		bd.OutputWriter = os.Stdout
+	}
+	out := bd.OutputWriter
+	if bd.DiffOptions.Out != "" && bd.DiffOptions.Out != "-" {
+		file, err := os.Create(util.ToUniversalPath(bd.DiffOptions.Out))
+		if err != nil {
+			return 0, fmt.Errorf("couldn't create report: %v", err)
+		}
+		defer file.Close()
+		out = file
+	}
+	bd.report = bufio.NewWriter(out)
+
+	differences, err := bd.compare()
+	if flushErr := bd.report.Flush(); err == nil && flushErr != nil {
+		err = fmt.Errorf("error writing report: %v", flushErr)
+	}
+	return differences, err
+}
+
+func (bd *BSONDiff) compare() (int64, error) {
+	if !bd.isDir {
+		name := strings.TrimSuffix(strings.TrimSuffix(filepath.Base(bd.Source), ".gz"), ".bson")
+		summary, err := bd.compareFiles(name, bd.Source, bd.Target)
+		if err != nil {
+			return 0, err
+		}
+		return summary.Differences(), nil
+	}
+
+	sourceFiles, err := listBSONFiles(bd.Source)
+	if err != nil {
+		return 0, err
+	}
+	targetFiles, err := listBSONFiles(bd.Target)
+	if err != nil {
+		return 0, err
+	}
+	namespaces := []string{}
+	for namespace := range sourceFiles {
+		namespaces = append(namespaces, namespace)
+	}
+	for namespace := range targetFiles {
+		if _, ok := sourceFiles[namespace]; !ok {
+			namespaces = append(namespaces, namespace)
+		}
+	}
+	sort.Strings(namespaces)
+
+	var differences int64
+	for _, namespace := range namespaces {
+		source, inSource := sourceFiles[namespace]
+		target, inTarget := targetFiles[namespace]
+		if !inSource || !inTarget {
+			diff := CollectionDiff{Namespace: namespace, Type: CollectionOnlyInSource}
+			side := "source"
+			if !inSource {
+				diff.Type = CollectionOnlyInTarget
+				side = "target"
+			}
+			log.Logvf(log.Always, "%v: collection found only in the %v", namespace, side)
+			if err = bd.writeLine(diff); err != nil {
+				return differences, err
+			}
+			differences++
+			continue
+		}
+		summary, err := bd.compareFiles(namespace, source, target)
+		if err != nil {
+			return differences, err
+		}
+		differences += summary.Differences()
+	}
+	return differences, nil
+}
+
+// listBSONFiles returns the .bson and .bson.gz files of a dump directory by namespace.
+func listBSONFiles(dir string) (map[string]string, error) {
+	files := map[string]string{}
+	root := util.ToUniversalPath(dir)
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		name := strings.TrimSuffix(path, ".gz")
+		if !strings.HasSuffix(name, ".bson") {
+			return nil
+		}
+		rel, err := filepath.Rel(root, strings.TrimSuffix(name, ".bson"))
+		if err != nil {
+			return err
+		}
+		namespace := strings.Replace(filepath.ToSlash(rel), "/", ".", -1)
+		if other, ok := files[namespace]; ok {
+			return fmt.Errorf("found both %v and %v for %v", other, path, namespace)
+		}
+		files[namespace] = path
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error reading dump directory %v: %v", dir, err)
+	}
+	return files, nil
+}
+
+// openBSONFile opens a BSON file, decompressing it if its name ends with .gz.
+func openBSONFile(name string) (io.ReadCloser, error) {
+	file, err := os.Open(util.ToUniversalPath(name))
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(name, ".gz") {
+		return file, nil
+	}
+	gzipReader, err := gzip.NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("error decompressing %v: %v", name, err)
+	}
+	return &util.WrappedReadCloser{ReadCloser: gzipReader, Inner: file}, nil
+}
+
+// sortFile reads the documents of a BSON file into a sorter, by key. It
+// returns the sorted documents and the number of documents in the file.
+func (bd *BSONDiff) sortFile(name string) (*externalSorter, int64, error) {
+	in, err := openBSONFile(name)
+	if err != nil {
+		return nil, 0, err
+	}
+	source := db.NewBSONSource(in)
+	defer source.Close()
+
+	// the source and target are sorted at the same time, so each gets half the memory
+	sorter := newExternalSorter(bd.DiffOptions.SortBufferSize*1024*1024/2, bd.DiffOptions.TempDir)
+	var count int64
+	for raw := source.LoadNext(); raw != nil; raw = source.LoadNext() {
+		doc := bson.D{}
+		if err = bson.Unmarshal(raw, &doc); err != nil {
+			sorter.Close()
+			return nil, count, fmt.Errorf("error decoding document #%v of %v: %v", count+1, name, err)
+		}
+		key, err := extractKey(doc, bd.key)
+		if err != nil {
+			sorter.Close()
+			return nil, count, fmt.Errorf("error encoding the key of document #%v of %v: %v", count+1, name, err)
+		}
+		if err = sorter.Add(key, raw); err != nil {
+			sorter.Close()
+			return nil, count, err
+		}
+		count++
+	}
+	if err = source.Err(); err != nil {
+		sorter.Close()
+		return nil, count, fmt.Errorf("error reading %v: %v", name, err)
+	}
+	return sorter, count, nil
+}
+
+// compareFiles compares the documents of two BSON files, matching them by key,
+// and writes their differences and the summary to the report. Documents with
+// the same key on one side are matched in the order they appear in the files.
+func (bd *BSONDiff) compareFiles(namespace, sourceName, targetName string) (*CollectionSummary, error) {
+	summary := &CollectionSummary{Namespace: namespace, Type: Summary}
+	sourceSorter, count, err := bd.sortFile(sourceName)
+	if err != nil {
+		return nil, err
+	}
+	defer sourceSorter.Close()
+	summary.SourceDocuments = count
+	targetSorter, count, err := bd.sortFile(targetName)
+	if err != nil {
+		return nil, err
+	}
+	defer targetSorter.Close()
+	summary.TargetDocuments = count
+
+	sourceIter, err := sourceSorter.Iterator()
+	if err != nil {
+		return nil, err
+	}
+	defer sourceIter.Close()
+	targetIter, err := targetSorter.Iterator()
+	if err != nil {
+		return nil, err
+	}
+	defer targetIter.Close()
+
+	source, haveSource := sourceIter.Next()
+	target, haveTarget := targetIter.Next()
+	for haveSource || haveTarget {
+		c := 0
+		switch {
+		case !haveTarget:
+			c = -1
+		case !haveSource:
+			c = 1
+		default:
+			c = bytes.Compare(source.key, target.key)
+		}
+
+		switch {
+		case c < 0:
+			summary.OnlyInSource++
+			err = bd.writeDocumentDiff(namespace, OnlyInSource, source.key, nil)
+		case c > 0:
+			summary.OnlyInTarget++
+			err = bd.writeDocumentDiff(namespace, OnlyInTarget, target.key, nil)
+		default:
+			var fields []FieldDiff
+			if fields, err = bd.diffDocuments(source.doc, target.doc); err == nil {
+				if len(fields) == 0 {
+					summary.Matched++
+				} else {
+					summary.Changed++
+					err = bd.writeDocumentDiff(namespace, Changed, source.key, fields)
+				}
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if c <= 0 {
+			source, haveSource = sourceIter.Next()
+		}
+		if c >= 0 {
+			target, haveTarget = targetIter.Next()
+		}
+	}
+	if err = sourceIter.Err(); err != nil {
+		return nil, err
+	}
+	if err = targetIter.Err(); err != nil {
+		return nil, err
+	}
+
+	log.Logvf(log.Always, "%v: %v matched, %v changed, %v only in source, %v only in target",
+		namespace, summary.Matched, summary.Changed, summary.OnlyInSource, summary.OnlyInTarget)
+	return summary, bd.writeLine(summary)
+}
+
+// diffDocuments returns the fields that differ between two raw documents,
+// leaving out the ignored fields.
+func (bd *BSONDiff) diffDocuments(sourceRaw, targetRaw []byte) ([]FieldDiff, error) {
+	if bytes.Equal(sourceRaw, targetRaw) {
+		return nil, nil
+	}
+	source, target := bson.D{}, bson.D{}
+	if err := bson.Unmarshal(sourceRaw, &source); err != nil {
+		return nil, err
+	}
+	if err := bson.Unmarshal(targetRaw, &target); err != nil {
+		return nil, err
+	}
+	return diffDocuments("", removeFields(source, bd.ignored), removeFields(target, bd.ignored), nil)
+}
+
+func (bd *BSONDiff) writeDocumentDiff(namespace, diffType string, key []byte, fields []FieldDiff) error {
+	keyDoc := bson.D{}
+	if err := bson.Unmarshal(key, &keyDoc); err != nil {
+		return err
+	}
+	keyJSON, err := marshalValue(keyDoc)
+	if err != nil {
+		return fmt.Errorf("error converting key to JSON: %v", err)
+	}
+	return bd.writeLine(DocumentDiff{
+		Namespace: namespace,
+		Type:      diffType,
+		Key:       keyJSON,
+		Fields:    fields,
+	})
+}
+
+// writeLine writes a line of JSON to the report.
+func (bd *BSONDiff) writeLine(line interface{}) error {
+	data, err := json.Marshal(line)
+	if err != nil {
+		return fmt.Errorf("error converting report to JSON: %v", err)
+	}
+	if _, err = bd.report.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("error writing report: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,215 @@
+package bsondiff
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/json"
+	"github.com/mongodb/mongo-tools/common/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// writeBSONFile writes documents to a BSON file.
+func writeBSONFile(path string, docs ...bson.D) {
+	buf := &bytes.Buffer{}
+	for _, doc := range docs {
+		raw, err := bson.Marshal(doc)
+		So(err, ShouldBeNil)
+		buf.Write(raw)
+	}
+	So(os.MkdirAll(filepath.Dir(path), 0755), ShouldBeNil)
+	So(ioutil.WriteFile(path, buf.Bytes(), 0644), ShouldBeNil)
+}
+
+// runDiff compares source and target with the options, returning the lines of the report.
+func runDiff(opts *DiffOptions, source, target string) (int64, []map[string]interface{}) {
+	if opts.Key == "" {
+		opts.Key = "_id"
+	}
+	if opts.SortBufferSize == 0 {
+		opts.SortBufferSize = 1
+	}
+	out := &bytes.Buffer{}
+	bd := &BSONDiff{DiffOptions: opts, OutputWriter: out}
+	So(bd.ValidateOptions([]string{source, target}), ShouldBeNil)
+	differences, err := bd.Run()
+	So(err, ShouldBeNil)
+
+	lines := []map[string]interface{}{}
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		decoded := map[string]interface{}{}
+		So(json.Unmarshal([]byte(line), &decoded), ShouldBeNil)
+		lines = append(lines, decoded)
+	}
+	return differences, lines
+}
+
+func TestExternalSorter(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+
+	Convey("Records should come out sorted, keeping the order of equal keys", t, func() {
+		for _, bufferSize := range []int{1 << 20, 100} {
+			sorter := newExternalSorter(bufferSize, "")
+			for i := 0; i < 100; i++ {
+				key, err := bson.Marshal(bson.D{{"_id", (i * 37) % 50}})
+				So(err, ShouldBeNil)
+				doc, err := bson.Marshal(bson.D{{"i", i}})
+				So(err, ShouldBeNil)
+				So(sorter.Add(key, doc), ShouldBeNil)
+			}
+			if bufferSize == 100 {
+				So(len(sorter.runs), ShouldBeGreaterThan, 1)
+			}
+			iter, err := sorter.Iterator()
+			So(err, ShouldBeNil)
+
+			var previous struct{ ID, I int }
+			count := 0
+			for record, ok := iter.Next(); ok; record, ok = iter.Next() {
+				var key struct {
+					ID int `bson:"_id"`
+				}
+				var doc struct{ I int }
+				So(bson.Unmarshal(record.key, &key), ShouldBeNil)
+				So(bson.Unmarshal(record.doc, &doc), ShouldBeNil)
+				if count > 0 {
+					So(key.ID, ShouldBeGreaterThanOrEqualTo, previous.ID)
+					if key.ID == previous.ID {
+						So(doc.I, ShouldBeGreaterThan, previous.I)
+					}
+				}
+				previous.ID, previous.I = key.ID, doc.I
+				count++
+			}
+			So(iter.Err(), ShouldBeNil)
+			So(count, ShouldEqual, 100)
+			iter.Close()
+			sorter.Close()
+		}
+	})
+}
+
+func TestDiffDocuments(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+
+	Convey("Differences should be found in nested documents and arrays", t, func() {
+		source := bson.D{
+			{"a", 1},
+			{"b", bson.D{{"c", "x"}, {"d", 2}}},
+			{"list", []interface{}{1, 2, 3}},
+			{"gone", true},
+		}
+		target := bson.D{
+			{"a", int64(1)},
+			{"b", bson.D{{"c", "x"}, {"d", 3}}},
+			{"list", []interface{}{1, 2}},
+			{"new", nil},
+		}
+		diffs, err := diffDocuments("", source, target, nil)
+		So(err, ShouldBeNil)
+		paths := []string{}
+		for _, diff := range diffs {
+			paths = append(paths, diff.Path)
+		}
+		So(paths, ShouldResemble, []string{"a", "b.d", "list.2", "gone", "new"})
+		So(string(*diffs[0].Source), ShouldEqual, `{"$numberInt":"1"}`)
+		So(string(*diffs[0].Target), ShouldEqual, `{"$numberLong":"1"}`)
+		So(diffs[2].Target, ShouldBeNil)
+		So(diffs[4].Source, ShouldBeNil)
+		So(string(*diffs[4].Target), ShouldEqual, "null")
+	})
+
+	Convey("Ignored fields should be removed, also from documents in arrays", t, func() {
+		doc := bson.D{
+			{"a", 1},
+			{"meta", bson.D{{"version", 2}, {"owner", "x"}}},
+			{"list", []interface{}{bson.D{{"at", 1}, {"v", 1}}}},
+		}
+		ignored, err := splitFields("a, meta.version,list.at")
+		So(err, ShouldBeNil)
+		So(removeFields(doc, ignored), ShouldResemble, bson.D{
+			{"meta", bson.D{{"owner", "x"}}},
+			{"list", []interface{}{bson.D{{"v", 1}}}},
+		})
+	})
+}
+
+func TestBSONDiff(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+
+	Convey("With two dump directories", t, func() {
+		dir, err := ioutil.TempDir("", "bsondiff-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		source, target := filepath.Join(dir, "source"), filepath.Join(dir, "target")
+
+		sourceDocs, targetDocs := []bson.D{}, []bson.D{}
+		for i := 0; i < 200; i++ {
+			sourceDocs = append(sourceDocs, bson.D{{"_id", i}, {"name", "doc"}, {"updatedAt", i}})
+			switch {
+			case i == 10:
+				// missing from the target
+			case i == 20:
+				targetDocs = append(targetDocs, bson.D{{"_id", i}, {"name", "changed"}, {"updatedAt", 0}})
+			default:
+				targetDocs = append(targetDocs, bson.D{{"_id", i}, {"name", "doc"}, {"updatedAt", 0}})
+			}
+		}
+		targetDocs = append(targetDocs, bson.D{{"_id", "extra"}})
+		// the target is in a different order, which the sort should make irrelevant
+		for i, j := 0, len(targetDocs)-1; i < j; i, j = i+1, j-1 {
+			targetDocs[i], targetDocs[j] = targetDocs[j], targetDocs[i]
+		}
+		writeBSONFile(filepath.Join(source, "db", "coll.bson"), sourceDocs...)
+		writeBSONFile(filepath.Join(target, "db", "coll.bson"), targetDocs...)
+		writeBSONFile(filepath.Join(source, "db", "old.bson"), bson.D{{"_id", 1}})
+		So(ioutil.WriteFile(filepath.Join(source, "db", "coll.metadata.json"), []byte("{}"), 0644), ShouldBeNil)
+
+		Convey("documents on one side and changed fields should be reported", func() {
+			differences, lines := runDiff(&DiffOptions{IgnoreFields: "updatedAt"}, source, target)
+			So(differences, ShouldEqual, 4)
+			So(len(lines), ShouldEqual, 5)
+
+			// the differences come in the order of the encoded keys, followed by the summary
+			byType := map[string]map[string]interface{}{}
+			for _, line := range lines[:3] {
+				So(line["ns"], ShouldEqual, "db.coll")
+				byType[line["type"].(string)] = line
+			}
+			So(byType[OnlyInTarget]["key"], ShouldResemble, map[string]interface{}{"_id": "extra"})
+			So(byType[OnlyInSource]["key"], ShouldResemble, map[string]interface{}{"_id": map[string]interface{}{"$numberInt": "10"}})
+			So(byType[Changed]["key"], ShouldResemble, map[string]interface{}{"_id": map[string]interface{}{"$numberInt": "20"}})
+			So(byType[Changed]["fields"], ShouldResemble, []interface{}{map[string]interface{}{
+				"path": "name", "source": "doc", "target": "changed",
+			}})
+
+			So(lines[3]["type"], ShouldEqual, Summary)
+			So(lines[3]["matched"], ShouldEqual, 198)
+			So(lines[3]["sourceDocuments"], ShouldEqual, 200)
+			So(lines[3]["targetDocuments"], ShouldEqual, 200)
+			So(lines[4], ShouldResemble, map[string]interface{}{"ns": "db.old", "type": CollectionOnlyInSource})
+		})
+
+		Convey("without --ignoreFields, the volatile field should make every document differ", func() {
+			_, lines := runDiff(&DiffOptions{}, filepath.Join(source, "db", "coll.bson"), filepath.Join(target, "db", "coll.bson"))
+			summary := lines[len(lines)-1]
+			So(summary["ns"], ShouldEqual, "coll")
+			So(summary["changed"], ShouldEqual, 198)
+		})
+
+		Convey("documents should be matched on --key", func() {
+			_, lines := runDiff(&DiffOptions{Key: "name", IgnoreFields: "_id,updatedAt"},
+				filepath.Join(source, "db", "coll.bson"), filepath.Join(target, "db", "coll.bson"))
+			summary := lines[len(lines)-1]
+			So(summary["matched"], ShouldEqual, 198)
+			// documents without the key field are matched on null
+			So(summary["onlyInSource"], ShouldEqual, 2)
+			So(summary["onlyInTarget"], ShouldEqual, 2)
+		})
+	})
+}
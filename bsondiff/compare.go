@@ -0,0 +1,216 @@
+package bsondiff
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/bsonutil"
+	"github.com/mongodb/mongo-tools/common/json"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// FieldDiff is a field that differs between two matched documents. Source or
+// Target is left out when the field is missing on that side.
+type FieldDiff struct {
+	Path   string           `json:"path"`
+	Source *json.RawMessage `json:"source,omitempty"`
+	Target *json.RawMessage `json:"target,omitempty"`
+}
+
+// splitFields splits a comma separated list of dotted field names.
+func splitFields(fields string) ([][]string, error) {
+	paths := [][]string{}
+	for _, field := range strings.Split(fields, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			return nil, fmt.Errorf("field names must not be empty")
+		}
+		paths = append(paths, strings.Split(field, "."))
+	}
+	return paths, nil
+}
+
+// lookupField returns the value of a dotted field in a document, and whether it exists.
+func lookupField(doc bson.D, path []string) (interface{}, bool) {
+	for _, elem := range doc {
+		if elem.Name != path[0] {
+			continue
+		}
+		if len(path) == 1 {
+			return elem.Value, true
+		}
+		if sub, ok := elem.Value.(bson.D); ok {
+			return lookupField(sub, path[1:])
+		}
+		return nil, false
+	}
+	return nil, false
+}
+
+// extractKey returns the BSON encoding of the key fields of a document. A
+// missing key field is treated as null.
+func extractKey(doc bson.D, key [][]string) ([]byte, error) {
+	keyDoc := make(bson.D, 0, len(key))
+	for _, path := range key {
+		value, _ := lookupField(doc, path)
+		keyDoc = append(keyDoc, bson.DocElem{Name: strings.Join(path, "."), Value: value})
+	}
+	return bson.Marshal(keyDoc)
+}
+
+// removeFields returns the document without the ignored fields. Fields are
+// removed from every document of an array as well, like dotted paths in queries.
+func removeFields(doc bson.D, ignored [][]string) bson.D {
+	if len(ignored) == 0 {
+		return doc
+	}
+	kept := make(bson.D, 0, len(doc))
+	for _, elem := range doc {
+		var nested [][]string
+		removed := false
+		for _, path := range ignored {
+			if path[0] != elem.Name {
+				continue
+			}
+			if len(path) == 1 {
+				removed = true
+				break
+			}
+			nested = append(nested, path[1:])
+		}
+		if removed {
+			continue
+		}
+		if len(nested) > 0 {
+			elem.Value = removeNestedFields(elem.Value, nested)
+		}
+		kept = append(kept, elem)
+	}
+	return kept
+}
+
+func removeNestedFields(value interface{}, ignored [][]string) interface{} {
+	switch v := value.(type) {
+	case bson.D:
+		return removeFields(v, ignored)
+	case []interface{}:
+		array := make([]interface{}, len(v))
+		for i, element := range v {
+			array[i] = removeNestedFields(element, ignored)
+		}
+		return array
+	}
+	return value
+}
+
+// marshalValue returns the canonical Extended JSON of a value, which keeps
+// differences between numeric types visible in the report.
+func marshalValue(value interface{}) (*json.RawMessage, error) {
+	data, err := bsonutil.MarshalExtJSON(value, json.Canonical)
+	if err != nil {
+		return nil, err
+	}
+	raw := json.RawMessage(data)
+	return &raw, nil
+}
+
+// valuesEqual compares two decoded BSON values, including their types.
+func valuesEqual(a, b interface{}) bool {
+	switch av := a.(type) {
+	case float64:
+		// NaN is only different from other numbers
+		if bv, ok := b.(float64); ok && math.IsNaN(av) && math.IsNaN(bv) {
+			return true
+		}
+	case time.Time:
+		if bv, ok := b.(time.Time); ok {
+			return av.Equal(bv)
+		}
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// diffValues appends the differences between two values at a field path to diffs.
+// Documents and arrays are compared field by field and element by element.
+func diffValues(path string, source, target interface{}, diffs []FieldDiff) ([]FieldDiff, error) {
+	switch s := source.(type) {
+	case bson.D:
+		if t, ok := target.(bson.D); ok {
+			return diffDocuments(path+".", s, t, diffs)
+		}
+	case []interface{}:
+		if t, ok := target.([]interface{}); ok {
+			var err error
+			for i := 0; i < len(s) || i < len(t); i++ {
+				elementPath := path + "." + strconv.Itoa(i)
+				switch {
+				case i >= len(t):
+					diffs, err = appendDiff(diffs, elementPath, s[i], true, nil, false)
+				case i >= len(s):
+					diffs, err = appendDiff(diffs, elementPath, nil, false, t[i], true)
+				default:
+					diffs, err = diffValues(elementPath, s[i], t[i], diffs)
+				}
+				if err != nil {
+					return nil, err
+				}
+			}
+			return diffs, nil
+		}
+	}
+	if valuesEqual(source, target) {
+		return diffs, nil
+	}
+	return appendDiff(diffs, path, source, true, target, true)
+}
+
+// diffDocuments appends the differences between two documents to diffs, in
+// the order of the fields of the source document and then the target document.
+func diffDocuments(prefix string, source, target bson.D, diffs []FieldDiff) ([]FieldDiff, error) {
+	targetFields := make(map[string]interface{}, len(target))
+	for _, elem := range target {
+		targetFields[elem.Name] = elem.Value
+	}
+	sourceFields := make(map[string]bool, len(source))
+	var err error
+	for _, elem := range source {
+		sourceFields[elem.Name] = true
+		if targetValue, ok := targetFields[elem.Name]; ok {
+			diffs, err = diffValues(prefix+elem.Name, elem.Value, targetValue, diffs)
+		} else {
+			diffs, err = appendDiff(diffs, prefix+elem.Name, elem.Value, true, nil, false)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, elem := range target {
+		if !sourceFields[elem.Name] {
+			if diffs, err = appendDiff(diffs, prefix+elem.Name, nil, false, elem.Value, true); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return diffs, nil
+}
+
+func appendDiff(diffs []FieldDiff, path string, source interface{}, inSource bool,
+	target interface{}, inTarget bool) ([]FieldDiff, error) {
+	diff := FieldDiff{Path: path}
+	var err error
+	if inSource {
+		if diff.Source, err = marshalValue(source); err != nil {
+			return nil, fmt.Errorf("error converting field %v to JSON: %v", path, err)
+		}
+	}
+	if inTarget {
+		if diff.Target, err = marshalValue(target); err != nil {
+			return nil, fmt.Errorf("error converting field %v to JSON: %v", path, err)
+		}
+	}
+	return append(diffs, diff), nil
+}
@@ -0,0 +1,57 @@
+// Main package for the bsondiff tool.
+package main
+
+import (
+	"github.com/mongodb/mongo-tools/bsondiff"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/options"
+	"github.com/mongodb/mongo-tools/common/signals"
+	"github.com/mongodb/mongo-tools/common/util"
+	"os"
+)
+
+func main() {
+	// initialize command-line opts
+	opts := options.New("bsondiff", bsondiff.Usage, options.EnabledOptions{})
+
+	diffOpts := &bsondiff.DiffOptions{}
+	opts.AddOptions(diffOpts)
+
+	args, err := opts.Parse()
+	if err != nil {
+		log.Logvf(log.Always, "error parsing command line options: %v", err)
+		log.Logvf(log.Always, "try 'bsondiff --help' for more information")
+		os.Exit(util.ExitBadOptions)
+	}
+
+	// print help, if specified
+	if opts.PrintHelp(false) {
+		return
+	}
+
+	// print version, if specified
+	if opts.PrintVersion() {
+		return
+	}
+	log.SetVerbosity(opts.Verbosity)
+	signals.Handle()
+
+	bd := bsondiff.BSONDiff{
+		ToolOptions: opts,
+		DiffOptions: diffOpts,
+	}
+
+	if err := bd.ValidateOptions(args); err != nil {
+		log.Logvf(log.Always, "%v", err)
+		log.Logvf(log.Always, "try 'bsondiff --help' for more information")
+		os.Exit(util.ExitBadOptions)
+	}
+
+	differences, err := bd.Run()
+	if err != nil {
+		log.Logvf(log.Always, "Failed: %v", err)
+		os.Exit(util.ExitError)
+	}
+	log.Logvf(log.Always, "%v %v found", differences,
+		util.Pluralize(int(differences), "difference", "differences"))
+}
@@ -0,0 +1,24 @@
+package bsondiff
+
+var Usage = `<options> <source> <target>
+
+Compare two .bson files, or two dump directories created with mongodump, without a running server.
+
+Documents are matched on their _id, or on the fields given with --key, and every
+difference is written as a line of JSON: documents found on only one side, and the
+fields that differ between matched documents, as Extended JSON. The report ends with
+a summary line for every collection. Files ending in .gz are decompressed automatically.`
+
+// DiffOptions defines the set of options for comparing BSON files.
+type DiffOptions struct {
+	Key            string `long:"key" value-name:"<field>[,<field>]*" default:"_id" default-mask:"-" description:"comma separated list of fields that identify a document (defaults to '_id')"`
+	IgnoreFields   string `long:"ignoreFields" value-name:"<field>[,<field>]*" description:"comma separated list of fields to leave out of the comparison, e.g. --ignoreFields updatedAt,meta.version"`
+	Out            string `long:"out" value-name:"<filename>" short:"o" description:"output file for the JSON report; '-' writes to stdout (defaults to stdout)"`
+	SortBufferSize int    `long:"sortBufferSize" value-name:"<megabytes>" default:"256" default-mask:"-" description:"memory to use for sorting each collection by key before spilling to temporary files, in megabytes (defaults to 256)"`
+	TempDir        string `long:"tempDir" value-name:"<directory>" description:"directory for the temporary files of large sorts (defaults to the system temporary directory)"`
+}
+
+// Name returns a human-readable group name for diff options.
+func (*DiffOptions) Name() string {
+	return "diff"
+}
@@ -0,0 +1,248 @@
+package bsondiff
+
+import (
+	"bufio"
+	"bytes"
+	"container/heap"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/util"
+)
+
+// sortRecord is a document along with the BSON encoding of its key.
+type sortRecord struct {
+	key []byte
+	doc []byte
+}
+
+// recordIterator returns records in the order of their keys.
+type recordIterator interface {
+	// Next returns the next record, or false once there are no more records.
+	Next() (sortRecord, bool)
+	Err() error
+	Close()
+}
+
+// externalSorter sorts records by the bytes of their keys, which is enough to
+// bring documents with equal keys together. Records are sorted in memory until
+// they exceed the buffer size, and are then written to sorted runs in temporary
+// files, which are merged when iterating.
+type externalSorter struct {
+	bufferSize int
+	tempDir    string
+
+	records []sortRecord
+	size    int
+	runs    []string
+}
+
+func newExternalSorter(bufferSize int, tempDir string) *externalSorter {
+	return &externalSorter{bufferSize: bufferSize, tempDir: tempDir}
+}
+
+// Add adds a record to the sort, copying its key and document.
+func (s *externalSorter) Add(key, doc []byte) error {
+	record := sortRecord{
+		key: append([]byte{}, key...),
+		doc: append([]byte{}, doc...),
+	}
+	s.records = append(s.records, record)
+	s.size += len(key) + len(doc)
+	if s.size >= s.bufferSize {
+		return s.spill()
+	}
+	return nil
+}
+
+// sortRecords sorts records by key, keeping records with equal keys in the
+// order they were added.
+func sortRecords(records []sortRecord) {
+	sort.SliceStable(records, func(i, j int) bool {
+		return bytes.Compare(records[i].key, records[j].key) < 0
+	})
+}
+
+// spill writes the buffered records to a temporary file as a sorted run.
+// Every record is written as the key document followed by the document.
+func (s *externalSorter) spill() error {
+	sortRecords(s.records)
+	file, err := ioutil.TempFile(s.tempDir, "bsondiff-")
+	if err != nil {
+		return fmt.Errorf("error creating temporary file: %v", err)
+	}
+	s.runs = append(s.runs, file.Name())
+	writer := bufio.NewWriter(file)
+	for _, record := range s.records {
+		if _, err = writer.Write(record.key); err != nil {
+			break
+		}
+		if _, err = writer.Write(record.doc); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("error writing temporary file: %v", err)
+	}
+	s.records = nil
+	s.size = 0
+	return nil
+}
+
+// Iterator returns the sorted records. The sorter must not be added to afterwards.
+func (s *externalSorter) Iterator() (recordIterator, error) {
+	if len(s.runs) == 0 {
+		sortRecords(s.records)
+		return &sliceIterator{records: s.records}, nil
+	}
+	if len(s.records) > 0 {
+		if err := s.spill(); err != nil {
+			return nil, err
+		}
+	}
+	merger := &runMerger{}
+	for i, name := range s.runs {
+		file, err := os.Open(name)
+		if err != nil {
+			merger.Close()
+			return nil, fmt.Errorf("error opening temporary file: %v", err)
+		}
+		run := &runIterator{
+			index: i,
+			source: db.NewBufferlessBSONSource(&util.WrappedReadCloser{
+				ReadCloser: ioutil.NopCloser(bufio.NewReader(file)),
+				Inner:      file,
+			}),
+		}
+		merger.runs = append(merger.runs, run)
+		if run.advance() {
+			heap.Push(merger, run)
+		} else if run.err != nil {
+			merger.Close()
+			return nil, fmt.Errorf("error reading temporary file: %v", run.err)
+		}
+	}
+	return merger, nil
+}
+
+// Close removes the temporary files of the sort.
+func (s *externalSorter) Close() {
+	for _, name := range s.runs {
+		os.Remove(name)
+	}
+	s.runs = nil
+}
+
+type sliceIterator struct {
+	records []sortRecord
+}
+
+func (it *sliceIterator) Next() (sortRecord, bool) {
+	if len(it.records) == 0 {
+		return sortRecord{}, false
+	}
+	record := it.records[0]
+	it.records = it.records[1:]
+	return record, true
+}
+
+func (it *sliceIterator) Err() error {
+	return nil
+}
+
+func (it *sliceIterator) Close() {}
+
+// runIterator reads the records of a sorted run.
+type runIterator struct {
+	index   int
+	source  *db.BSONSource
+	current sortRecord
+	err     error
+}
+
+// advance reads the next record of the run into current. It returns false at
+// the end of the run, or on an error, which is kept in err.
+func (run *runIterator) advance() bool {
+	key := run.source.LoadNext()
+	if key == nil {
+		run.err = run.source.Err()
+		return false
+	}
+	doc := run.source.LoadNext()
+	if doc == nil {
+		run.err = run.source.Err()
+		if run.err == nil {
+			run.err = fmt.Errorf("record is missing its document")
+		}
+		return false
+	}
+	run.current = sortRecord{key: key, doc: doc}
+	return true
+}
+
+// runMerger merges sorted runs, using a heap of the runs ordered by their current
+// record. Records with equal keys come from the earlier runs first, which keeps
+// the merge stable.
+type runMerger struct {
+	runs   []*runIterator
+	active []*runIterator
+	err    error
+}
+
+func (m *runMerger) Len() int { return len(m.active) }
+
+func (m *runMerger) Less(i, j int) bool {
+	c := bytes.Compare(m.active[i].current.key, m.active[j].current.key)
+	if c == 0 {
+		return m.active[i].index < m.active[j].index
+	}
+	return c < 0
+}
+
+func (m *runMerger) Swap(i, j int) { m.active[i], m.active[j] = m.active[j], m.active[i] }
+
+func (m *runMerger) Push(x interface{}) { m.active = append(m.active, x.(*runIterator)) }
+
+func (m *runMerger) Pop() interface{} {
+	run := m.active[len(m.active)-1]
+	m.active = m.active[:len(m.active)-1]
+	return run
+}
+
+func (m *runMerger) Next() (sortRecord, bool) {
+	if m.err != nil || len(m.active) == 0 {
+		return sortRecord{}, false
+	}
+	run := m.active[0]
+	record := run.current
+	if run.advance() {
+		heap.Fix(m, 0)
+	} else {
+		if run.err != nil {
+			m.err = fmt.Errorf("error reading temporary file: %v", run.err)
+			return sortRecord{}, false
+		}
+		heap.Pop(m)
+	}
+	return record, true
+}
+
+func (m *runMerger) Err() error {
+	return m.err
+}
+
+// Close closes the temporary files of the runs.
+func (m *runMerger) Close() {
+	for _, run := range m.runs {
+		run.source.Close()
+	}
+}
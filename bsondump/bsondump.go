@@ -28,7 +28,7 @@ type BSONDump struct {
 	// File handle for the output data.
 	Out io.WriteCloser
 
-	BSONSource *db.BSONSource
+	BSONSource db.RawDocSource
 }
 
 type ReadNopCloser struct {
@@ -70,26 +70,22 @@ func (bdo *BSONDumpOptions) GetBSONReader() (io.ReadCloser, error) {
 	return ReadNopCloser{os.Stdin}, nil
 }
 
-func formatJSON(doc *bson.Raw, pretty bool) ([]byte, error) {
+func formatJSON(doc *bson.Raw, pretty bool, format json.ExtJSONFormat) ([]byte, error) {
 	decodedDoc := bson.D{}
 	err := bson.Unmarshal(doc.Data, &decodedDoc)
 	if err != nil {
 		return nil, err
 	}
 
-	extendedDoc, err := bsonutil.ConvertBSONValueToJSON(decodedDoc)
+	jsonBytes, err := bsonutil.MarshalExtJSON(decodedDoc, format)
 	if err != nil {
 		return nil, fmt.Errorf("error converting BSON to extended JSON: %v", err)
 	}
-	jsonBytes, err := json.Marshal(extendedDoc)
 	if pretty {
 		var jsonFormatted bytes.Buffer
 		json.Indent(&jsonFormatted, jsonBytes, "", "\t")
 		jsonBytes = jsonFormatted.Bytes()
 	}
-	if err != nil {
-		return nil, fmt.Errorf("error converting doc to JSON: %v", err)
-	}
 	return jsonBytes, nil
 }
 
@@ -105,11 +101,34 @@ func (bd *BSONDump) JSON() (int, error) {
 		panic("Tried to call JSON() before opening file")
 	}
 
+	format, err := json.ParseExtJSONFormat(bd.BSONDumpOptions.JSONFormat)
+	if err != nil {
+		return numFound, err
+	}
+
+	filter, err := bd.BSONDumpOptions.newDocumentFilter()
+	if err != nil {
+		return numFound, err
+	}
+
 	decodedStream := db.NewDecodedBSONSource(bd.BSONSource)
 
 	var result bson.Raw
-	for decodedStream.Next(&result) {
-		if bytes, err := formatJSON(&result, bd.BSONDumpOptions.Pretty); err != nil {
+	for !filter.done() && decodedStream.Next(&result) {
+		doc, err := filter.apply(result.Data)
+		if err != nil {
+			log.Logvf(log.Always, "unable to dump document %v: %v", numFound+1, err)
+			if bd.BSONDumpOptions.ObjCheck {
+				return numFound, err
+			}
+			continue
+		}
+		if doc == nil {
+			continue
+		}
+		result.Data = doc
+
+		if bytes, err := formatJSON(&result, bd.BSONDumpOptions.Pretty, format); err != nil {
 			log.Logvf(log.Always, "unable to dump document %v: %v", numFound+1, err)
 
 			//if objcheck is turned on, stop now. otherwise keep on dumpin'
@@ -143,12 +162,23 @@ func (bd *BSONDump) Debug() (int, error) {
 		panic("Tried to call Debug() before opening file")
 	}
 
+	filter, err := bd.BSONDumpOptions.newDocumentFilter()
+	if err != nil {
+		return numFound, err
+	}
+
 	var result bson.Raw
-	for {
+	for !filter.done() {
 		doc := bd.BSONSource.LoadNext()
 		if doc == nil {
 			break
 		}
+		if doc, err = filter.apply(doc); err != nil {
+			return numFound, err
+		}
+		if doc == nil {
+			continue
+		}
 		result.Data = doc
 
 		if bd.BSONDumpOptions.ObjCheck {
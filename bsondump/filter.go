@@ -0,0 +1,179 @@
+package bsondump
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mongodb/mongo-tools/common/bsonutil"
+	"github.com/mongodb/mongo-tools/common/json"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// fieldTree holds the dotted field paths of a projection. A field mapped to
+// nil is included as a whole; otherwise only the listed subfields are.
+type fieldTree map[string]fieldTree
+
+// add includes a dotted field path in the projection.
+func (tree fieldTree) add(path []string) {
+	sub, ok := tree[path[0]]
+	if ok && sub == nil {
+		// the whole field is already included
+		return
+	}
+	if len(path) == 1 {
+		tree[path[0]] = nil
+		return
+	}
+	if !ok {
+		sub = fieldTree{}
+		tree[path[0]] = sub
+	}
+	sub.add(path[1:])
+}
+
+// documentFilter selects the documents that bsondump outputs with --query,
+// --skip and --limit, and projects them with --fields.
+type documentFilter struct {
+	matcher *bsonutil.Matcher
+	fields  fieldTree
+	skip    int
+	limit   int
+
+	// number of documents matched so far, including the skipped ones
+	matched int
+}
+
+// newDocumentFilter returns a documentFilter for the options, which lets every
+// document through unchanged if none of the filtering options are set.
+func (bdo *BSONDumpOptions) newDocumentFilter() (*documentFilter, error) {
+	if bdo.Skip < 0 {
+		return nil, fmt.Errorf("--skip must not be negative")
+	}
+	if bdo.Limit < 0 {
+		return nil, fmt.Errorf("--limit must not be negative")
+	}
+	filter := &documentFilter{skip: bdo.Skip, limit: bdo.Limit}
+
+	if bdo.Query != "" {
+		query, err := json.UnmarshalBsonD([]byte(bdo.Query))
+		if err != nil {
+			return nil, fmt.Errorf("error parsing --query as JSON: %v", err)
+		}
+		query, err = bsonutil.GetExtendedBsonD(query)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing --query: %v", err)
+		}
+		filter.matcher, err = bsonutil.NewMatcher(query)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing --query: %v", err)
+		}
+	}
+
+	if bdo.Fields != "" {
+		filter.fields = fieldTree{}
+		for _, field := range strings.Split(bdo.Fields, ",") {
+			field = strings.TrimSpace(field)
+			if field == "" {
+				return nil, fmt.Errorf("--fields must not contain empty field names")
+			}
+			filter.fields.add(strings.Split(field, "."))
+		}
+	}
+	return filter, nil
+}
+
+// done returns true once the limit of documents has been output, so that
+// the rest of the input doesn't need to be read.
+func (filter *documentFilter) done() bool {
+	return filter.limit > 0 && filter.matched >= filter.skip+filter.limit
+}
+
+// apply returns the document to output, or nil if the document is filtered out.
+func (filter *documentFilter) apply(doc []byte) ([]byte, error) {
+	if filter.matcher != nil && !filter.matcher.Matches(doc) {
+		return nil, nil
+	}
+	filter.matched++
+	if filter.matched <= filter.skip {
+		return nil, nil
+	}
+	if filter.fields == nil {
+		return doc, nil
+	}
+	projected, err := projectDocument(doc, filter.fields)
+	if err != nil {
+		return nil, fmt.Errorf("error projecting document: %v", err)
+	}
+	return bson.Marshal(projected)
+}
+
+// projectDocument returns the fields of a raw document that are in the projection,
+// in the order of the document.
+func projectDocument(data []byte, fields fieldTree) (bson.RawD, error) {
+	var elems bson.RawD
+	if err := bson.Unmarshal(data, &elems); err != nil {
+		return nil, err
+	}
+	projected := bson.RawD{}
+	for _, elem := range elems {
+		sub, ok := fields[elem.Name]
+		if !ok {
+			continue
+		}
+		if sub == nil {
+			projected = append(projected, elem)
+			continue
+		}
+		value, err := projectValue(elem.Value, sub)
+		if err != nil {
+			return nil, err
+		}
+		if value != nil {
+			projected = append(projected, bson.RawDocElem{Name: elem.Name, Value: *value})
+		}
+	}
+	return projected, nil
+}
+
+// projectValue projects the subfields of a document, or of every document in an
+// array. It returns nil for any other value, which has no subfields.
+func projectValue(value bson.Raw, fields fieldTree) (*bson.Raw, error) {
+	var projected interface{}
+	switch value.Kind {
+	case 0x03: // document
+		doc, err := projectDocument(value.Data, fields)
+		if err != nil {
+			return nil, err
+		}
+		projected = doc
+	case 0x04: // array
+		var elems bson.RawD
+		if err := bson.Unmarshal(value.Data, &elems); err != nil {
+			return nil, err
+		}
+		array := []interface{}{}
+		for _, elem := range elems {
+			element, err := projectValue(elem.Value, fields)
+			if err != nil {
+				return nil, err
+			}
+			if element != nil {
+				array = append(array, *element)
+			}
+		}
+		projected = array
+	default:
+		return nil, nil
+	}
+
+	// re-encode the projected value as a raw value
+	data, err := bson.Marshal(bson.D{{Name: "v", Value: projected}})
+	if err != nil {
+		return nil, err
+	}
+	var wrapper bson.RawD
+	if err = bson.Unmarshal(data, &wrapper); err != nil {
+		return nil, err
+	}
+	return &wrapper[0].Value, nil
+}
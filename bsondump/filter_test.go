@@ -0,0 +1,87 @@
+package bsondump
+
+import (
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// applyFilter runs documents through a filter, returning the output documents.
+func applyFilter(opts *BSONDumpOptions, docs ...bson.D) []bson.D {
+	filter, err := opts.newDocumentFilter()
+	So(err, ShouldBeNil)
+	output := []bson.D{}
+	for _, doc := range docs {
+		if filter.done() {
+			break
+		}
+		raw, err := bson.Marshal(doc)
+		So(err, ShouldBeNil)
+		out, err := filter.apply(raw)
+		So(err, ShouldBeNil)
+		if out != nil {
+			decoded := bson.D{}
+			So(bson.Unmarshal(out, &decoded), ShouldBeNil)
+			output = append(output, decoded)
+		}
+	}
+	return output
+}
+
+func TestDocumentFilter(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+
+	Convey("With a set of documents", t, func() {
+		docs := []bson.D{}
+		for i := 0; i < 10; i++ {
+			docs = append(docs, bson.D{
+				{"_id", i},
+				{"a", bson.D{{"b", i * 10}, {"c", "x"}}},
+				{"list", []interface{}{bson.D{{"d", i}, {"e", 1}}, "scalar"}},
+			})
+		}
+
+		Convey("no options should output every document unchanged", func() {
+			So(applyFilter(&BSONDumpOptions{}, docs...), ShouldResemble, docs)
+		})
+
+		Convey("a query should select the matching documents", func() {
+			out := applyFilter(&BSONDumpOptions{Query: `{"a.b": {"$gte": 50}, "_id": {"$ne": 7}}`}, docs...)
+			So(len(out), ShouldEqual, 4)
+			So(out[0], ShouldResemble, docs[5])
+		})
+
+		Convey("skip and limit should apply to the matching documents", func() {
+			out := applyFilter(&BSONDumpOptions{Query: `{"_id": {"$gt": 2}}`, Skip: 2, Limit: 3}, docs...)
+			So(len(out), ShouldEqual, 3)
+			So(out[0], ShouldResemble, docs[5])
+			So(out[2], ShouldResemble, docs[7])
+		})
+
+		Convey("fields should project dotted paths, also within arrays", func() {
+			out := applyFilter(&BSONDumpOptions{Fields: "list.d,a.c,missing", Limit: 1}, docs...)
+			So(out, ShouldResemble, []bson.D{{
+				{"a", bson.D{{"c", "x"}}},
+				{"list", []interface{}{bson.D{{"d", 0}}}},
+			}})
+		})
+
+		Convey("a field should include all of its subfields", func() {
+			out := applyFilter(&BSONDumpOptions{Fields: "a.b,a,_id", Limit: 1}, docs...)
+			So(out, ShouldResemble, []bson.D{{{"_id", 0}, docs[0][1]}})
+		})
+	})
+
+	Convey("Invalid filtering options should be rejected", t, func() {
+		_, err := (&BSONDumpOptions{Query: `{"a": `}).newDocumentFilter()
+		So(err, ShouldNotBeNil)
+		_, err = (&BSONDumpOptions{Query: `{"a": {"$where": 1}}`}).newDocumentFilter()
+		So(err, ShouldNotBeNil)
+		_, err = (&BSONDumpOptions{Fields: "a,,b"}).newDocumentFilter()
+		So(err, ShouldNotBeNil)
+		_, err = (&BSONDumpOptions{Limit: -1}).newDocumentFilter()
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,158 @@
+package bsondump
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/mongodb/mongo-tools/common/bsonutil"
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/json"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// utf8BOM is the byte order mark some editors write at the start of UTF-8 files.
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// JSONDocumentReader reads Extended JSON documents from a stream that holds
+// either one document after another, usually one per line, or a single array
+// of documents. The layout is detected from the first non-whitespace character.
+type JSONDocumentReader struct {
+	decoder *json.Decoder
+
+	// started is set once the layout of the input has been detected
+	started bool
+	isArray bool
+	ended   bool
+
+	// NumRead is the number of documents read so far.
+	NumRead int
+}
+
+// NewJSONDocumentReader returns a JSONDocumentReader reading from in.
+func NewJSONDocumentReader(in io.Reader) *JSONDocumentReader {
+	return &JSONDocumentReader{decoder: json.NewDecoder(in)}
+}
+
+// peekByte returns the next unread byte of the input without consuming it.
+func (r *JSONDocumentReader) peekByte() (byte, error) {
+	b, err := r.decoder.Peek(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+// skipSeparator consumes the whitespace before the next document and, in an
+// array, the bracket or comma in front of it. It returns io.EOF if there are
+// no more documents.
+func (r *JSONDocumentReader) skipSeparator() error {
+	if !r.started {
+		r.started = true
+		// the byte order mark can only be found at the very start
+		if start, _ := r.decoder.Peek(len(utf8BOM)); bytes.Equal(start, utf8BOM) {
+			r.decoder.Discard(len(utf8BOM))
+		}
+	}
+
+	expectSeparator := r.isArray && r.NumRead > 0
+	for {
+		c, err := r.peekByte()
+		if err == io.EOF {
+			if r.isArray && !r.ended {
+				return fmt.Errorf("bad JSON array format - found no closing bracket '%c'", json.ArrayEnd)
+			}
+			return io.EOF
+		}
+		if err != nil {
+			return err
+		}
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+		case r.ended:
+			return fmt.Errorf("bad JSON array format - found '%c' after '%c'", c, json.ArrayEnd)
+		case c == json.ArrayStart && r.NumRead == 0 && !r.isArray:
+			r.isArray = true
+		case c == json.ArraySep && expectSeparator:
+			expectSeparator = false
+		case c == json.ArrayEnd && r.isArray && (r.NumRead == 0 || expectSeparator):
+			r.ended = true
+		default:
+			if expectSeparator {
+				return fmt.Errorf("bad JSON array format - found '%c' instead of '%c'", c, json.ArraySep)
+			}
+			return nil
+		}
+		r.decoder.Discard(1)
+	}
+}
+
+// Next returns the next document converted to BSON types, or io.EOF once all
+// documents have been read.
+func (r *JSONDocumentReader) Next() (bson.D, error) {
+	if err := r.skipSeparator(); err != nil {
+		return nil, err
+	}
+	data, err := r.decoder.ScanObject()
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, fmt.Errorf("error reading document #%v: %v", r.NumRead+1, err)
+	}
+	r.NumRead++
+
+	document, err := json.UnmarshalBsonD(data)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing document #%v: %v", r.NumRead, err)
+	}
+	// GetExtendedBsonD is the order-preserving ConvertJSONDocumentToBSON
+	document, err = bsonutil.GetExtendedBsonD(document)
+	if err != nil {
+		return nil, fmt.Errorf("error converting document #%v to BSON: %v", r.NumRead, err)
+	}
+	return document, nil
+}
+
+// FromJSON reads Extended JSON documents from in, and writes each one as raw
+// BSON to the output, producing a file that mongorestore can load.
+// It returns the number of documents written and a non-nil error if one is
+// encountered before the end of the input is reached.
+func (bd *BSONDump) FromJSON(in io.Reader) (int, error) {
+	numFound := 0
+
+	filter, err := bd.BSONDumpOptions.newDocumentFilter()
+	if err != nil {
+		return numFound, err
+	}
+
+	reader := NewJSONDocumentReader(in)
+	for !filter.done() {
+		document, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return numFound, err
+		}
+		raw, err := bson.Marshal(document)
+		if err != nil {
+			return numFound, fmt.Errorf("error encoding document #%v: %v", reader.NumRead, err)
+		}
+		if len(raw) > db.MaxBSONSize {
+			return numFound, fmt.Errorf("document #%v is %v bytes, larger than the maximum BSON size of %v bytes",
+				reader.NumRead, len(raw), db.MaxBSONSize)
+		}
+		if raw, err = filter.apply(raw); err != nil {
+			return numFound, err
+		}
+		if raw == nil {
+			continue
+		}
+		if _, err = bd.Out.Write(raw); err != nil {
+			return numFound, err
+		}
+		numFound++
+	}
+	return numFound, nil
+}
@@ -0,0 +1,105 @@
+package bsondump
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/json"
+	"github.com/mongodb/mongo-tools/common/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// convertFromJSON runs bsondump --from=json on the input, returning the documents written.
+func convertFromJSON(input string) ([]bson.D, error) {
+	out := &bytes.Buffer{}
+	bd := BSONDump{
+		BSONDumpOptions: &BSONDumpOptions{},
+		Out:             WriteNopCloser{out},
+	}
+	if _, err := bd.FromJSON(strings.NewReader(input)); err != nil {
+		return nil, err
+	}
+	docs := []bson.D{}
+	source := db.NewBSONSource(ReadNopCloser{out})
+	for raw := source.LoadNext(); raw != nil; raw = source.LoadNext() {
+		doc := bson.D{}
+		So(bson.Unmarshal(raw, &doc), ShouldBeNil)
+		docs = append(docs, doc)
+	}
+	So(source.Err(), ShouldBeNil)
+	return docs, nil
+}
+
+func TestFromJSON(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+
+	Convey("With documents holding typed values", t, func() {
+		decimal, err := bson.ParseDecimal128("1.50")
+		So(err, ShouldBeNil)
+		docs := []bson.D{
+			{
+				{"_id", bson.ObjectIdHex("5a934e000102030405000000")},
+				{"long", int64(1) << 40},
+				{"int", 7},
+				{"double", 2.5},
+				{"date", time.Unix(1496318400, 0)},
+				{"uuid", bson.Binary{Kind: 0x04, Data: []byte("0123456789abcdef")}},
+				{"decimal", decimal},
+			},
+			{
+				{"_id", 2},
+				{"nested", bson.D{{"z", "last"}, {"a", []interface{}{int64(3), "x"}}}},
+			},
+		}
+
+		for _, format := range []json.ExtJSONFormat{json.Legacy, json.Canonical} {
+			lines := []string{}
+			for _, doc := range docs {
+				raw, err := bson.Marshal(doc)
+				So(err, ShouldBeNil)
+				out, err := formatJSON(&bson.Raw{Kind: 0x03, Data: raw}, false, format)
+				So(err, ShouldBeNil)
+				lines = append(lines, string(out))
+			}
+
+			Convey("the "+string(format)+" JSON of bsondump should convert back to the same documents", func() {
+				converted, err := convertFromJSON(strings.Join(lines, "\n") + "\n")
+				So(err, ShouldBeNil)
+				So(converted, ShouldResemble, docs)
+			})
+
+			Convey("the "+string(format)+" JSON should also be read from an array", func() {
+				converted, err := convertFromJSON("[\n" + strings.Join(lines, ",\n") + "\n]\n")
+				So(err, ShouldBeNil)
+				So(converted, ShouldResemble, docs)
+			})
+		}
+	})
+
+	Convey("Empty input and empty arrays should convert to no documents", t, func() {
+		for _, input := range []string{"", " \n", "[]", " [ ] \n", "\xEF\xBB\xBF[]"} {
+			converted, err := convertFromJSON(input)
+			So(err, ShouldBeNil)
+			So(converted, ShouldBeEmpty)
+		}
+	})
+
+	Convey("Malformed input should be rejected", t, func() {
+		for _, input := range []string{
+			`{"a": 1`,
+			`[{"a": 1}`,
+			`[{"a": 1} {"a": 2}]`,
+			`[{"a": 1}] {"a": 2}`,
+			`{"a": 1}, {"a": 2}`,
+			`[1, 2]`,
+			`{"a": {"$numberLong": "x"}}`,
+		} {
+			_, err := convertFromJSON(input)
+			So(err, ShouldNotBeNil)
+		}
+	})
+}
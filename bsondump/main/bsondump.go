@@ -63,7 +63,57 @@ func main() {
 		log.Logvf(log.Always, "Getting BSON Reader Failed: %v", err)
 		os.Exit(util.ExitError)
 	}
-	dumper.BSONSource = db.NewBSONSource(reader)
+	if bsonDumpOpts.RepairOut != "" && !bsonDumpOpts.Repair {
+		log.Logvf(log.Always, "cannot use --repairOut without --repair")
+		os.Exit(util.ExitBadOptions)
+	}
+	if bsonDumpOpts.From == "json" {
+		if bsonDumpOpts.Repair {
+			log.Logvf(log.Always, "cannot use --repair with --from=json")
+			os.Exit(util.ExitBadOptions)
+		}
+		if bsonDumpOpts.Type != "" {
+			log.Logvf(log.Always, "cannot use --type with --from=json")
+			os.Exit(util.ExitBadOptions)
+		}
+		defer reader.Close()
+		writer, err := bsonDumpOpts.GetWriter()
+		if err != nil {
+			log.Logvf(log.Always, "Getting Writer Failed: %v", err)
+			os.Exit(util.ExitError)
+		}
+		dumper.Out = writer
+		numFound, err := dumper.FromJSON(reader)
+		log.Logvf(log.Always, "%v objects converted", numFound)
+		if closeErr := writer.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			log.Logv(log.Always, err.Error())
+			os.Exit(util.ExitError)
+		}
+		return
+	}
+	var repairSource *bsondump.RepairingBSONSource
+	if bsonDumpOpts.Repair {
+		repairSource = bsondump.NewRepairingBSONSource(reader)
+		if bsonDumpOpts.RepairOut != "" {
+			repairOut, err := os.Create(util.ToUniversalPath(bsonDumpOpts.RepairOut))
+			if err != nil {
+				log.Logvf(log.Always, "Creating repair output file failed: %v", err)
+				os.Exit(util.ExitError)
+			}
+			repairSource.Out = repairOut
+			defer func() {
+				if err := repairOut.Close(); err != nil {
+					log.Logvf(log.Always, "error closing repair output file: %v", err)
+				}
+			}()
+		}
+		dumper.BSONSource = repairSource
+	} else {
+		dumper.BSONSource = db.NewBSONSource(reader)
+	}
 	defer dumper.BSONSource.Close()
 
 	writer, err := bsonDumpOpts.GetWriter()
@@ -76,19 +126,31 @@ func main() {
 
 	log.Logvf(log.DebugLow, "running bsondump with --objcheck: %v", bsonDumpOpts.ObjCheck)
 
-	if len(bsonDumpOpts.Type) != 0 && bsonDumpOpts.Type != "debug" && bsonDumpOpts.Type != "json" {
-		log.Logvf(log.Always, "Unsupported output type '%v'. Must be either 'debug' or 'json'", bsonDumpOpts.Type)
+	if len(bsonDumpOpts.Type) != 0 && bsonDumpOpts.Type != "debug" && bsonDumpOpts.Type != "json" && bsonDumpOpts.Type != "stats" {
+		log.Logvf(log.Always, "Unsupported output type '%v'. Must be one of 'debug', 'json' or 'stats'", bsonDumpOpts.Type)
 		os.Exit(util.ExitBadOptions)
 	}
 
 	var numFound int
 	if bsonDumpOpts.Type == "debug" {
 		numFound, err = dumper.Debug()
+	} else if bsonDumpOpts.Type == "stats" {
+		numFound, err = dumper.Stats()
 	} else {
 		numFound, err = dumper.JSON()
 	}
 
+	// --repairOut gets every recovered document, whatever the dump filters
+	// and --limit let through
+	if err == nil && repairSource != nil && repairSource.Out != nil {
+		err = repairSource.Drain()
+	}
+
 	log.Logvf(log.Always, "%v objects found", numFound)
+	if repairSource != nil {
+		log.Logvf(log.Always, "%v objects recovered, %v corrupt bytes skipped in %v ranges",
+			repairSource.Recovered, repairSource.SkippedBytes, repairSource.SkippedRanges)
+	}
 	if err != nil {
 		log.Logv(log.Always, err.Error())
 		os.Exit(util.ExitError)
@@ -8,7 +8,16 @@ See http://docs.mongodb.org/manual/reference/program/bsondump/ for more informat
 
 type BSONDumpOptions struct {
 	// Format to display the BSON data file
-	Type string `long:"type" value-name:"<type>" default:"json" default-mask:"-" description:"type of output: debug, json (default 'json')"`
+	Type string `long:"type" value-name:"<type>" description:"type of output: debug, json, stats (default 'json')"`
+
+	// Format of the input file
+	From string `long:"from" value-name:"<format>" default:"bson" choice:"bson" choice:"json" description:"format of the input: bson, or json to convert Extended JSON documents, one per line or in an array, into a BSON file (defaults to 'bson')"`
+
+	// Format of the --type=stats summary
+	StatsFormat string `long:"statsFormat" value-name:"<format>" default:"text" choice:"text" choice:"json" description:"format of the --type=stats summary: text or json (defaults to 'text')"`
+
+	// Dialect of Extended JSON to display
+	JSONFormat string `long:"jsonFormat" value-name:"<format>" default:"legacy" choice:"canonical" choice:"relaxed" choice:"legacy" description:"Extended JSON format of json output: canonical, relaxed or legacy (defaults to 'legacy')"`
 
 	// Validate each BSON document before displaying
 	ObjCheck bool `long:"objcheck" description:"validate BSON during processing"`
@@ -16,8 +25,26 @@ type BSONDumpOptions struct {
 	// Display JSON data with indents
 	Pretty bool `long:"pretty" description:"output JSON formatted to be human-readable"`
 
+	// Query filter selecting the documents to display
+	Query string `long:"query" short:"q" value-name:"<json>" description:"query filter, as a JSON string, e.g., '{x:{$gt:1}}'"`
+
+	// Fields to display
+	Fields string `long:"fields" short:"f" value-name:"<field>[,<field>]*" description:"comma separated list of field names to display, e.g. -f name,address.city"`
+
+	// Number of matching documents to skip
+	Skip int `long:"skip" value-name:"<count>" description:"number of matching documents to skip"`
+
+	// Maximum number of documents to display
+	Limit int `long:"limit" value-name:"<count>" description:"limit the number of documents to display"`
+
+	// Skip over corrupt data instead of stopping
+	Repair bool `long:"repair" description:"skip over corrupt data, resuming at the next valid document, and report the skipped byte ranges"`
+
+	// Path to write the recovered documents to
+	RepairOut string `long:"repairOut" value-name:"<filename>" description:"with --repair, write every recovered document, whatever --limit and the filters display, to a BSON file that mongorestore can load"`
+
 	// Path to input BSON file
-	BSONFileName string `long:"bsonFile" description:"path to BSON file to dump to JSON; default is stdin"`
+	BSONFileName string `long:"bsonFile" description:"path to BSON file to dump to JSON, or with --from=json, path to JSON file to convert to BSON; default is stdin"`
 
 	// Path to output file
 	OutFileName string `long:"outFile" description:"path to output file to dump BSON to; default is stdout"`
@@ -0,0 +1,282 @@
+package bsondump
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/log"
+)
+
+// RepairingBSONSource is a db.RawDocSource that recovers the documents of a
+// corrupt BSON stream. When the data at the current position isn't a valid
+// document, it scans forward one byte at a time until it finds one, and logs
+// the byte range it skipped. Every recovered document is also written to Out,
+// if it is set, to produce a cleaned BSON file.
+type RepairingBSONSource struct {
+	In  io.ReadCloser
+	Out io.Writer
+
+	reader *bufio.Reader
+	offset int64
+	err    error
+
+	// Recovered, SkippedBytes and SkippedRanges count the results of the repair.
+	Recovered     int64
+	SkippedBytes  int64
+	SkippedRanges int64
+}
+
+// NewRepairingBSONSource returns a RepairingBSONSource reading from in.
+func NewRepairingBSONSource(in io.ReadCloser) *RepairingBSONSource {
+	return &RepairingBSONSource{
+		In:     in,
+		reader: bufio.NewReaderSize(in, db.MaxBSONSize),
+	}
+}
+
+// LoadNext returns the next valid document of the stream, or nil at the end of
+// the stream or on a read error. The returned slice is only valid until the next call.
+func (rs *RepairingBSONSource) LoadNext() []byte {
+	skipStart := int64(-1)
+	for {
+		doc, err := rs.peekDocument(skipStart >= 0)
+		if err == io.EOF {
+			// everything that's left is too short to hold a document
+			remaining, _ := rs.reader.Discard(rs.reader.Buffered())
+			if remaining > 0 && skipStart < 0 {
+				skipStart = rs.offset
+			}
+			rs.offset += int64(remaining)
+			rs.reportSkipped(skipStart)
+			return nil
+		}
+		if err != nil {
+			rs.err = err
+			return nil
+		}
+		if doc != nil {
+			rs.reportSkipped(skipStart)
+			if rs.Out != nil {
+				if _, err = rs.Out.Write(doc); err != nil {
+					rs.err = fmt.Errorf("error writing repaired BSON: %v", err)
+					return nil
+				}
+			}
+			// the document stays in the reader's buffer until the next call
+			rs.reader.Discard(len(doc))
+			rs.offset += int64(len(doc))
+			rs.Recovered++
+			return doc
+		}
+		if skipStart < 0 {
+			skipStart = rs.offset
+			log.Logvf(log.DebugLow, "found corrupt BSON at byte %v, scanning for the next document", rs.offset)
+		}
+		rs.reader.Discard(1)
+		rs.offset++
+	}
+}
+
+// peekDocument returns the document at the current position without consuming it,
+// nil if there isn't a valid document there, or io.EOF at the end of the stream.
+// Empty documents are only accepted before any corruption is found, since they
+// are likely to appear by chance in corrupt data.
+func (rs *RepairingBSONSource) peekDocument(resyncing bool) ([]byte, error) {
+	header, err := rs.reader.Peek(4)
+	if err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+	size := int(int32(binary.LittleEndian.Uint32(header)))
+	if size < 5 || size > db.MaxBSONSize || (resyncing && size == 5) {
+		return nil, nil
+	}
+	doc, err := rs.reader.Peek(size)
+	if err == io.EOF {
+		// a truncated document at the end of the stream
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if validateDocument(doc, 0) != nil {
+		return nil, nil
+	}
+	return doc, nil
+}
+
+func (rs *RepairingBSONSource) reportSkipped(skipStart int64) {
+	if skipStart < 0 {
+		return
+	}
+	rs.SkippedRanges++
+	rs.SkippedBytes += rs.offset - skipStart
+	log.Logvf(log.Always, "skipped corrupt bytes %v to %v (%v bytes)", skipStart, rs.offset, rs.offset-skipStart)
+}
+
+// Drain reads the rest of the stream, so that Out gets every recovered document
+// even when the dump stops before the end of the input, as it does with --limit.
+func (rs *RepairingBSONSource) Drain() error {
+	for rs.LoadNext() != nil {
+	}
+	return rs.Err()
+}
+
+// Close closes the input stream.
+func (rs *RepairingBSONSource) Close() error {
+	return rs.In.Close()
+}
+
+// Err returns the error that stopped the repair, if any.
+func (rs *RepairingBSONSource) Err() error {
+	return rs.err
+}
+
+// maxNestingDepth is the deepest nesting of documents the server allows.
+const maxNestingDepth = 100
+
+var errInvalidBSON = fmt.Errorf("invalid BSON")
+
+// validateDocument checks that data is exactly one well-formed BSON document:
+// the length matches, every element has a known type and a value that fits,
+// and the document ends with a null byte.
+func validateDocument(data []byte, depth int) error {
+	if depth > maxNestingDepth || len(data) < 5 ||
+		int(int32(binary.LittleEndian.Uint32(data))) != len(data) || data[len(data)-1] != 0 {
+		return errInvalidBSON
+	}
+	pos := 4
+	end := len(data) - 1
+	for pos < end {
+		kind := data[pos]
+		pos++
+		name := cstringLength(data[pos:end])
+		if name < 0 {
+			return errInvalidBSON
+		}
+		pos += name + 1
+		size, err := valueSize(kind, data[pos:end], depth)
+		if err != nil {
+			return err
+		}
+		pos += size
+	}
+	if pos != end {
+		return errInvalidBSON
+	}
+	return nil
+}
+
+// cstringLength returns the length of the null terminated string at the start
+// of data, without the null byte, or -1 if there isn't one.
+func cstringLength(data []byte) int {
+	for i, b := range data {
+		if b == 0 {
+			return i
+		}
+	}
+	return -1
+}
+
+// stringSize returns the size of the BSON string at the start of data.
+func stringSize(data []byte) (int, error) {
+	if len(data) < 5 {
+		return 0, errInvalidBSON
+	}
+	length := int(int32(binary.LittleEndian.Uint32(data)))
+	if length < 1 || length > len(data)-4 || data[4+length-1] != 0 {
+		return 0, errInvalidBSON
+	}
+	return 4 + length, nil
+}
+
+// documentSize returns the size of the valid embedded document at the start of data.
+func documentSize(data []byte, depth int) (int, error) {
+	if len(data) < 5 {
+		return 0, errInvalidBSON
+	}
+	length := int(int32(binary.LittleEndian.Uint32(data)))
+	if length < 5 || length > len(data) {
+		return 0, errInvalidBSON
+	}
+	return length, validateDocument(data[:length], depth+1)
+}
+
+// valueSize returns the size of the valid value of the given BSON type at the start of data.
+func valueSize(kind byte, data []byte, depth int) (int, error) {
+	fixed := -1
+	switch kind {
+	case 0x06, 0x0A, 0x7F, 0xFF: // undefined, null, max key, min key
+		fixed = 0
+	case 0x08: // boolean
+		if len(data) < 1 || data[0] > 1 {
+			return 0, errInvalidBSON
+		}
+		fixed = 1
+	case 0x10: // int32
+		fixed = 4
+	case 0x01, 0x09, 0x11, 0x12: // double, date, timestamp, int64
+		fixed = 8
+	case 0x07: // ObjectId
+		fixed = 12
+	case 0x13: // decimal128
+		fixed = 16
+	case 0x02, 0x0D, 0x0E: // string, JavaScript, symbol
+		return stringSize(data)
+	case 0x03, 0x04: // document, array
+		return documentSize(data, depth)
+	case 0x05: // binary
+		if len(data) < 5 {
+			return 0, errInvalidBSON
+		}
+		length := int(int32(binary.LittleEndian.Uint32(data)))
+		if length < 0 || length > len(data)-5 {
+			return 0, errInvalidBSON
+		}
+		return 5 + length, nil
+	case 0x0B: // regular expression
+		pattern := cstringLength(data)
+		if pattern < 0 {
+			return 0, errInvalidBSON
+		}
+		options := cstringLength(data[pattern+1:])
+		if options < 0 {
+			return 0, errInvalidBSON
+		}
+		return pattern + options + 2, nil
+	case 0x0C: // DBPointer
+		size, err := stringSize(data)
+		if err != nil || size+12 > len(data) {
+			return 0, errInvalidBSON
+		}
+		return size + 12, nil
+	case 0x0F: // JavaScript with scope
+		if len(data) < 4 {
+			return 0, errInvalidBSON
+		}
+		length := int(int32(binary.LittleEndian.Uint32(data)))
+		if length < 14 || length > len(data) {
+			return 0, errInvalidBSON
+		}
+		code, err := stringSize(data[4:length])
+		if err != nil {
+			return 0, err
+		}
+		scope, err := documentSize(data[4+code:length], depth)
+		if err != nil || 4+code+scope != length {
+			return 0, errInvalidBSON
+		}
+		return length, nil
+	default:
+		return 0, errInvalidBSON
+	}
+	if fixed > len(data) {
+		return 0, errInvalidBSON
+	}
+	return fixed, nil
+}
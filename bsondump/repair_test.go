@@ -0,0 +1,108 @@
+package bsondump
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// repair reads a stream with a RepairingBSONSource, returning the recovered
+// documents and the cleaned output.
+func repair(data []byte) (*RepairingBSONSource, []bson.M, []byte) {
+	out := &bytes.Buffer{}
+	source := NewRepairingBSONSource(ioutil.NopCloser(bytes.NewReader(data)))
+	source.Out = out
+	docs := []bson.M{}
+	for doc := source.LoadNext(); doc != nil; doc = source.LoadNext() {
+		decoded := bson.M{}
+		So(bson.Unmarshal(doc, &decoded), ShouldBeNil)
+		docs = append(docs, decoded)
+	}
+	So(source.Err(), ShouldBeNil)
+	return source, docs, out.Bytes()
+}
+
+func TestRepairingBSONSource(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+
+	Convey("With a stream of BSON documents", t, func() {
+		var docs [][]byte
+		var stream []byte
+		for i := 0; i < 5; i++ {
+			doc, err := bson.Marshal(bson.M{"_id": i, "name": "document", "n": float64(i)})
+			So(err, ShouldBeNil)
+			docs = append(docs, doc)
+			stream = append(stream, doc...)
+		}
+
+		Convey("an intact stream should be read as is", func() {
+			source, recovered, out := repair(stream)
+			So(len(recovered), ShouldEqual, 5)
+			So(source.SkippedRanges, ShouldEqual, 0)
+			So(out, ShouldResemble, stream)
+		})
+
+		Convey("draining should write the documents that weren't read to the output", func() {
+			out := &bytes.Buffer{}
+			source := NewRepairingBSONSource(ioutil.NopCloser(bytes.NewReader(stream)))
+			source.Out = out
+			So(source.LoadNext(), ShouldNotBeNil)
+			So(source.Drain(), ShouldBeNil)
+			So(source.Recovered, ShouldEqual, 5)
+			So(out.Bytes(), ShouldResemble, stream)
+		})
+
+		Convey("garbage between documents should be skipped", func() {
+			garbage := bytes.Repeat([]byte{0xde, 0xad, 0xbe, 0xef}, 10)
+			corrupt := append(append(append([]byte{}, stream[:len(docs[0])]...), garbage...), stream[len(docs[0]):]...)
+			source, recovered, out := repair(corrupt)
+			So(len(recovered), ShouldEqual, 5)
+			So(source.SkippedRanges, ShouldEqual, 1)
+			So(source.SkippedBytes, ShouldEqual, len(garbage))
+			So(out, ShouldResemble, stream)
+		})
+
+		Convey("a document with a corrupt element should be skipped", func() {
+			corrupt := append([]byte{}, stream...)
+			// the type of the first element of the second document
+			corrupt[len(docs[0])+4] = 0x42
+			source, recovered, _ := repair(corrupt)
+			So(len(recovered), ShouldEqual, 4)
+			So(recovered[1]["_id"], ShouldEqual, 2)
+			So(source.SkippedBytes, ShouldEqual, len(docs[1]))
+		})
+
+		Convey("a truncated last document should be skipped", func() {
+			source, recovered, out := repair(stream[:len(stream)-3])
+			So(len(recovered), ShouldEqual, 4)
+			So(source.SkippedBytes, ShouldEqual, len(docs[4])-3)
+			So(out, ShouldResemble, stream[:len(stream)-len(docs[4])])
+		})
+	})
+
+	Convey("Documents holding every BSON type should be valid", t, func() {
+		decimal, err := bson.ParseDecimal128("1.5")
+		So(err, ShouldBeNil)
+		doc, err := bson.Marshal(bson.D{
+			{"double", 1.5}, {"string", "s"}, {"doc", bson.D{{"a", 1}}}, {"array", []interface{}{1, "a"}},
+			{"binary", []byte{1, 2}}, {"undefined", bson.Undefined}, {"oid", bson.NewObjectId()},
+			{"bool", true}, {"date", time.Now()}, {"null", nil}, {"regex", bson.RegEx{Pattern: "a", Options: "i"}},
+			{"dbPointer", bson.DBPointer{Namespace: "db.c", Id: bson.NewObjectId()}},
+			{"code", bson.JavaScript{Code: "f()"}}, {"symbol", bson.Symbol("s")},
+			{"codeWithScope", bson.JavaScript{Code: "f()", Scope: bson.M{"x": 1}}},
+			{"int32", int32(1)}, {"timestamp", bson.MongoTimestamp(1)}, {"int64", int64(1)},
+			{"decimal", decimal}, {"minKey", bson.MinKey}, {"maxKey", bson.MaxKey},
+		})
+		So(err, ShouldBeNil)
+		So(validateDocument(doc, 0), ShouldBeNil)
+
+		Convey("but not with a wrong length", func() {
+			So(validateDocument(doc[:len(doc)-1], 0), ShouldNotBeNil)
+		})
+	})
+}
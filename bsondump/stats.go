@@ -0,0 +1,363 @@
+package bsondump
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/mongodb/mongo-tools/common/json"
+	"github.com/mongodb/mongo-tools/common/text"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// maxStatsPaths limits the number of field paths that statistics are kept for,
+// so that documents with generated field names don't use up all the memory.
+const maxStatsPaths = 10000
+
+// statsPercentiles are the document size percentiles in the statistics.
+var statsPercentiles = []int{50, 90, 95, 99}
+
+// bsonTypeNames are the names of BSON types, as used by the $type query operator.
+var bsonTypeNames = map[byte]string{
+	0x01: "double",
+	0x02: "string",
+	0x03: "object",
+	0x04: "array",
+	0x05: "binData",
+	0x06: "undefined",
+	0x07: "objectId",
+	0x08: "bool",
+	0x09: "date",
+	0x0A: "null",
+	0x0B: "regex",
+	0x0C: "dbPointer",
+	0x0D: "javascript",
+	0x0E: "symbol",
+	0x0F: "javascriptWithScope",
+	0x10: "int",
+	0x11: "timestamp",
+	0x12: "long",
+	0x13: "decimal",
+	0x7F: "maxKey",
+	0xFF: "minKey",
+}
+
+// SizeStats summarizes a set of sizes or lengths.
+type SizeStats struct {
+	Min         int64            `json:"min"`
+	Avg         float64          `json:"avg"`
+	Max         int64            `json:"max"`
+	Percentiles map[string]int64 `json:"percentiles,omitempty"`
+}
+
+// FieldStats summarizes the values found at a field path.
+type FieldStats struct {
+	Path string `json:"path"`
+	// Documents is the number of documents that have the field, and Ratio
+	// the fraction of all documents that do.
+	Documents int64   `json:"documents"`
+	Ratio     float64 `json:"ratio"`
+	// Types counts the values of the field by BSON type.
+	Types map[string]int64 `json:"types"`
+	// ArrayLengths summarizes the lengths of the arrays found at the field path.
+	ArrayLengths *SizeStats `json:"arrayLengths,omitempty"`
+}
+
+// Stats is the statistics summary of a BSON file.
+type Stats struct {
+	Documents int64        `json:"documents"`
+	Size      *SizeStats   `json:"size,omitempty"`
+	MaxDepth  int          `json:"maxDepth"`
+	Fields    []FieldStats `json:"fields"`
+	// Truncated is set when fields were left out because there were too many field paths.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// sizeCounter collects sizes, counting each distinct size, which keeps the
+// percentiles exact with little memory.
+type sizeCounter struct {
+	count  int64
+	total  int64
+	counts map[int64]int64
+}
+
+func (sc *sizeCounter) add(size int64) {
+	if sc.counts == nil {
+		sc.counts = map[int64]int64{}
+	}
+	sc.count++
+	sc.total += size
+	sc.counts[size]++
+}
+
+func (sc *sizeCounter) stats(percentiles []int) *SizeStats {
+	if sc.count == 0 {
+		return nil
+	}
+	sizes := make([]int64, 0, len(sc.counts))
+	for size := range sc.counts {
+		sizes = append(sizes, size)
+	}
+	sort.Slice(sizes, func(i, j int) bool { return sizes[i] < sizes[j] })
+
+	stats := &SizeStats{
+		Min: sizes[0],
+		Avg: float64(sc.total) / float64(sc.count),
+		Max: sizes[len(sizes)-1],
+	}
+	if len(percentiles) > 0 {
+		stats.Percentiles = map[string]int64{}
+		// walk the sizes in order, using the nearest-rank method
+		seen, i := int64(0), 0
+		for _, p := range percentiles {
+			rank := (int64(p)*sc.count + 99) / 100
+			for seen+sc.counts[sizes[i]] < rank {
+				seen += sc.counts[sizes[i]]
+				i++
+			}
+			stats.Percentiles[fmt.Sprintf("p%v", p)] = sizes[i]
+		}
+	}
+	return stats
+}
+
+// fieldCounter collects the statistics of a field path.
+type fieldCounter struct {
+	path         string
+	documents    int64
+	lastDocument int64
+	types        map[string]int64
+	arrayLengths sizeCounter
+}
+
+// statsCollector collects the statistics of a stream of raw BSON documents.
+type statsCollector struct {
+	documents int64
+	sizes     sizeCounter
+	maxDepth  int
+	fields    map[string]*fieldCounter
+	// order of first appearance of the field paths
+	paths     []string
+	truncated bool
+}
+
+func newStatsCollector() *statsCollector {
+	return &statsCollector{fields: map[string]*fieldCounter{}}
+}
+
+// add collects the statistics of a document.
+func (sc *statsCollector) add(doc []byte) error {
+	sc.documents++
+	sc.sizes.add(int64(len(doc)))
+	return sc.addDocument(doc, "", 1)
+}
+
+func (sc *statsCollector) addDocument(data []byte, prefix string, depth int) error {
+	if depth > sc.maxDepth {
+		sc.maxDepth = depth
+	}
+	var elems bson.RawD
+	if err := bson.Unmarshal(data, &elems); err != nil {
+		return err
+	}
+	for _, elem := range elems {
+		if err := sc.addValue(prefix+elem.Name, elem.Value, depth); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (sc *statsCollector) addValue(path string, value bson.Raw, depth int) error {
+	field := sc.field(path)
+	if field != nil {
+		if field.lastDocument != sc.documents {
+			field.lastDocument = sc.documents
+			field.documents++
+		}
+		field.types[bsonTypeNames[value.Kind]]++
+	}
+
+	switch value.Kind {
+	case 0x03: // document
+		return sc.addDocument(value.Data, path+".", depth+1)
+	case 0x04: // array
+		var elems bson.RawD
+		if err := bson.Unmarshal(value.Data, &elems); err != nil {
+			return err
+		}
+		if field != nil {
+			field.arrayLengths.add(int64(len(elems)))
+		}
+		if depth+1 > sc.maxDepth {
+			sc.maxDepth = depth + 1
+		}
+		// the fields of documents in arrays are counted under the array's path,
+		// like dotted paths in queries
+		for _, elem := range elems {
+			if elem.Value.Kind == 0x03 {
+				if err := sc.addDocument(elem.Value.Data, path+".", depth+2); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// field returns the counter for a field path, or nil if there are too many paths.
+func (sc *statsCollector) field(path string) *fieldCounter {
+	field, ok := sc.fields[path]
+	if !ok {
+		if len(sc.paths) >= maxStatsPaths {
+			sc.truncated = true
+			return nil
+		}
+		field = &fieldCounter{path: path, types: map[string]int64{}}
+		sc.fields[path] = field
+		sc.paths = append(sc.paths, path)
+	}
+	return field
+}
+
+// stats returns the collected statistics.
+func (sc *statsCollector) stats() *Stats {
+	stats := &Stats{
+		Documents: sc.documents,
+		Size:      sc.sizes.stats(statsPercentiles),
+		MaxDepth:  sc.maxDepth,
+		Fields:    []FieldStats{},
+		Truncated: sc.truncated,
+	}
+	for _, path := range sc.paths {
+		field := sc.fields[path]
+		stats.Fields = append(stats.Fields, FieldStats{
+			Path:         path,
+			Documents:    field.documents,
+			Ratio:        float64(field.documents) / float64(sc.documents),
+			Types:        field.types,
+			ArrayLengths: field.arrayLengths.stats(nil),
+		})
+	}
+	return stats
+}
+
+// Stats iterates through the BSON file and writes a summary of its documents:
+// their count and sizes, the deepest nesting level, and for every field path,
+// how often it occurs, the types of its values and the lengths of its arrays.
+// It returns the number of documents processed and a non-nil error if one is
+// encountered before the end of the file is reached.
+func (bd *BSONDump) Stats() (int, error) {
+	numFound := 0
+
+	if bd.BSONSource == nil {
+		panic("Tried to call Stats() before opening file")
+	}
+
+	filter, err := bd.BSONDumpOptions.newDocumentFilter()
+	if err != nil {
+		return numFound, err
+	}
+
+	collector := newStatsCollector()
+	for !filter.done() {
+		doc := bd.BSONSource.LoadNext()
+		if doc == nil {
+			break
+		}
+		if doc, err = filter.apply(doc); err != nil {
+			return numFound, err
+		}
+		if doc == nil {
+			continue
+		}
+		if err = collector.add(doc); err != nil {
+			return numFound, fmt.Errorf("error reading document %v: %v", numFound+1, err)
+		}
+		numFound++
+	}
+	if err := bd.BSONSource.Err(); err != nil {
+		return numFound, err
+	}
+
+	stats := collector.stats()
+	if bd.BSONDumpOptions.StatsFormat == "json" {
+		return numFound, writeStatsJSON(stats, bd.BSONDumpOptions.Pretty, bd.Out)
+	}
+	writeStatsText(stats, bd.Out)
+	return numFound, nil
+}
+
+func writeStatsJSON(stats *Stats, pretty bool, out io.Writer) error {
+	var jsonBytes []byte
+	var err error
+	if pretty {
+		jsonBytes, err = json.MarshalIndent(stats, "", "\t")
+	} else {
+		jsonBytes, err = json.Marshal(stats)
+	}
+	if err != nil {
+		return fmt.Errorf("error converting statistics to JSON: %v", err)
+	}
+	_, err = out.Write(append(jsonBytes, '\n'))
+	return err
+}
+
+// formatTypes returns the type distribution of a field, most common type first.
+func formatTypes(types map[string]int64) string {
+	names := make([]string, 0, len(types))
+	for name := range types {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if types[names[i]] != types[names[j]] {
+			return types[names[i]] > types[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		parts = append(parts, fmt.Sprintf("%v(%v)", name, types[name]))
+	}
+	return strings.Join(parts, " ")
+}
+
+func writeStatsText(stats *Stats, out io.Writer) {
+	gw := &text.GridWriter{ColumnPadding: 2}
+	gw.WriteCells("documents:", fmt.Sprintf("%v", stats.Documents))
+	gw.EndRow()
+	if stats.Size != nil {
+		gw.WriteCells("size min/avg/max:", fmt.Sprintf("%v / %.1f / %v",
+			stats.Size.Min, stats.Size.Avg, stats.Size.Max))
+		gw.EndRow()
+		percentiles := make([]string, 0, len(statsPercentiles))
+		for _, p := range statsPercentiles {
+			key := fmt.Sprintf("p%v", p)
+			percentiles = append(percentiles, fmt.Sprintf("%v=%v", key, stats.Size.Percentiles[key]))
+		}
+		gw.WriteCells("size percentiles:", strings.Join(percentiles, " "))
+		gw.EndRow()
+	}
+	gw.WriteCells("max depth:", fmt.Sprintf("%v", stats.MaxDepth))
+	gw.EndRow()
+	gw.Flush(out)
+	fmt.Fprintln(out)
+
+	gw = &text.GridWriter{ColumnPadding: 2}
+	gw.WriteCells("field", "ratio", "types", "array lengths (min/avg/max)")
+	gw.EndRow()
+	for _, field := range stats.Fields {
+		arrays := ""
+		if field.ArrayLengths != nil {
+			arrays = fmt.Sprintf("%v / %.1f / %v",
+				field.ArrayLengths.Min, field.ArrayLengths.Avg, field.ArrayLengths.Max)
+		}
+		gw.WriteCells(field.Path, fmt.Sprintf("%.3f", field.Ratio), formatTypes(field.Types), arrays)
+		gw.EndRow()
+	}
+	gw.Flush(out)
+	if stats.Truncated {
+		fmt.Fprintf(out, "\nonly the first %v field paths are shown\n", maxStatsPaths)
+	}
+}
@@ -0,0 +1,75 @@
+package bsondump
+
+import (
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestStatsCollector(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+
+	Convey("With statistics collected from a set of documents", t, func() {
+		collector := newStatsCollector()
+		for i := 0; i < 10; i++ {
+			doc := bson.D{{"_id", i}}
+			if i%2 == 0 {
+				doc = append(doc, bson.DocElem{"a", "even"})
+			} else {
+				doc = append(doc, bson.DocElem{"a", int64(i)})
+			}
+			if i < 4 {
+				list := []interface{}{}
+				for j := 0; j <= i; j++ {
+					list = append(list, bson.D{{"b", bson.D{{"c", j}}}})
+				}
+				doc = append(doc, bson.DocElem{"list", list})
+			}
+			raw, err := bson.Marshal(doc)
+			So(err, ShouldBeNil)
+			So(collector.add(raw), ShouldBeNil)
+		}
+		stats := collector.stats()
+
+		Convey("the document count and sizes should be summarized", func() {
+			So(stats.Documents, ShouldEqual, 10)
+			So(stats.Size.Min, ShouldBeLessThan, stats.Size.Max)
+			So(stats.Size.Percentiles["p50"], ShouldBeBetweenOrEqual, stats.Size.Min, stats.Size.Percentiles["p90"])
+			So(stats.Size.Percentiles["p99"], ShouldEqual, stats.Size.Max)
+		})
+
+		Convey("field paths should be listed in order of appearance", func() {
+			paths := []string{}
+			for _, field := range stats.Fields {
+				paths = append(paths, field.Path)
+			}
+			So(paths, ShouldResemble, []string{"_id", "a", "list", "list.b", "list.b.c"})
+		})
+
+		Convey("fields should have their occurrence ratio and types", func() {
+			So(stats.Fields[0].Ratio, ShouldEqual, 1)
+			So(stats.Fields[1].Types, ShouldResemble, map[string]int64{"string": 5, "long": 5})
+			So(stats.Fields[2].Ratio, ShouldEqual, 0.4)
+			So(stats.Fields[3].Documents, ShouldEqual, 4)
+			So(stats.Fields[3].Types, ShouldResemble, map[string]int64{"object": 10})
+		})
+
+		Convey("array lengths and the nesting depth should be summarized", func() {
+			So(*stats.Fields[2].ArrayLengths, ShouldResemble, SizeStats{Min: 1, Avg: 2.5, Max: 4})
+			So(stats.Fields[1].ArrayLengths, ShouldBeNil)
+			So(stats.MaxDepth, ShouldEqual, 4)
+		})
+	})
+
+	Convey("Percentiles should use the nearest rank", t, func() {
+		sizes := sizeCounter{}
+		for i := int64(1); i <= 200; i++ {
+			sizes.add(i)
+		}
+		stats := sizes.stats(statsPercentiles)
+		So(stats.Percentiles, ShouldResemble, map[string]int64{"p50": 100, "p90": 180, "p95": 190, "p99": 198})
+		So(stats.Avg, ShouldEqual, 100.5)
+	})
+}
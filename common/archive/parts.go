@@ -0,0 +1,279 @@
+package archive
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// PartMagicNumber is four bytes that are found at the beginning of every part of a
+// multi-volume archive. It is followed by a PartHeader, and then by the next slice
+// of the archive byte stream, which starts with the archive MagicNumber in the first part.
+const PartMagicNumber uint32 = 0x8199e26e
+
+// PartHeader is a data structure that, as BSON, is found immediately after the part magic number.
+// It links every part to the set of parts making up a single archive. Its size doesn't depend on
+// its values, so that the header of the last part can be rewritten once the archive is complete.
+type PartHeader struct {
+	SetID bson.ObjectId `bson:"set"`
+	Part  int32         `bson:"part"`
+	Last  bool          `bson:"last"`
+}
+
+// PartName returns the name of a part of a multi-volume archive, e.g. out.archive.000
+func PartName(path string, part int) string {
+	return fmt.Sprintf("%v.%03d", path, part)
+}
+
+func writePartHeader(out io.Writer, header *PartHeader) (int, error) {
+	buf, err := bson.Marshal(header)
+	if err != nil {
+		return 0, err
+	}
+	magicNumberBytes := make([]byte, 4)
+	for i := range magicNumberBytes {
+		magicNumberBytes[i] = byte(PartMagicNumber >> uint(i*8))
+	}
+	return out.Write(append(magicNumberBytes, buf...))
+}
+
+// PartWriter implements io.WriteCloser. It writes an archive as a series of files,
+// named by PartName, which are each at most PartSize bytes long, headers included.
+type PartWriter struct {
+	Path     string
+	PartSize int64
+
+	setID   bson.ObjectId
+	part    int
+	file    *os.File
+	written int64
+}
+
+// NewPartWriter creates a PartWriter for a new set of parts. The first part is
+// only created once something is written.
+func NewPartWriter(path string, partSize int64) (*PartWriter, error) {
+	// each part must at least hold its own header and a byte of the archive
+	headerSize, err := writePartHeader(ioutil.Discard, &PartHeader{SetID: bson.NewObjectId()})
+	if err != nil {
+		return nil, err
+	}
+	if partSize <= int64(headerSize) {
+		return nil, fmt.Errorf("archive part size must be larger than %v bytes", headerSize)
+	}
+	return &PartWriter{Path: path, PartSize: partSize, setID: bson.NewObjectId(), part: -1}, nil
+}
+
+// nextPart closes the current part, and creates the next one with its header.
+func (w *PartWriter) nextPart() error {
+	if w.file != nil {
+		if err := w.file.Close(); err != nil {
+			return fmt.Errorf("error closing archive part %v: %v", PartName(w.Path, w.part), err)
+		}
+	}
+	w.part++
+	name := PartName(w.Path, w.part)
+	file, err := os.Create(name)
+	if err != nil {
+		return fmt.Errorf("error creating archive part %v: %v", name, err)
+	}
+	w.file = file
+	w.written, err = w.writeHeader(false)
+	return err
+}
+
+func (w *PartWriter) writeHeader(last bool) (int64, error) {
+	n, err := writePartHeader(w.file, &PartHeader{SetID: w.setID, Part: int32(w.part), Last: last})
+	if err != nil {
+		return 0, fmt.Errorf("error writing header of archive part %v: %v", PartName(w.Path, w.part), err)
+	}
+	return int64(n), nil
+}
+
+// Write is part of the io.Writer interface. It moves on to a new part
+// whenever the current one is full.
+func (w *PartWriter) Write(buf []byte) (int, error) {
+	total := 0
+	for len(buf) > 0 {
+		if w.file == nil || w.written >= w.PartSize {
+			if err := w.nextPart(); err != nil {
+				return total, err
+			}
+		}
+		chunk := buf
+		if room := w.PartSize - w.written; int64(len(chunk)) > room {
+			chunk = chunk[:room]
+		}
+		n, err := w.file.Write(chunk)
+		total += n
+		w.written += int64(n)
+		if err != nil {
+			return total, fmt.Errorf("error writing archive part %v: %v", PartName(w.Path, w.part), err)
+		}
+		buf = buf[n:]
+	}
+	return total, nil
+}
+
+// Close is part of the io.Closer interface. It marks the current part as the last
+// one of the set by rewriting its header, and closes it.
+func (w *PartWriter) Close() error {
+	if w.file == nil {
+		// nothing was written, but the set still needs a part
+		if err := w.nextPart(); err != nil {
+			return err
+		}
+	}
+	if _, err := w.file.Seek(0, io.SeekStart); err != nil {
+		w.file.Close()
+		return fmt.Errorf("error finishing archive part %v: %v", PartName(w.Path, w.part), err)
+	}
+	if _, err := w.writeHeader(true); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
+
+// PartReader implements io.ReadCloser. It reads the parts of a multi-volume
+// archive one after the other, checking that each part follows the previous one.
+type PartReader struct {
+	basePath string
+	setID    bson.ObjectId
+	part     int
+	last     bool
+	file     *os.File
+}
+
+// readPartHeader reads the header of a part whose magic number was already consumed.
+func readPartHeader(in io.Reader, name string) (*PartHeader, error) {
+	sizeBuf := make([]byte, 4)
+	if _, err := io.ReadFull(in, sizeBuf); err != nil {
+		return nil, fmt.Errorf("error reading header of archive part %v: %v", name, err)
+	}
+	size := int32(sizeBuf[0]) | int32(sizeBuf[1])<<8 | int32(sizeBuf[2])<<16 | int32(sizeBuf[3])<<24
+	if size < minBSONSize || size > 1024 {
+		return nil, fmt.Errorf("archive part %v has a corrupt header", name)
+	}
+	buf := make([]byte, size)
+	copy(buf, sizeBuf)
+	if _, err := io.ReadFull(in, buf[4:]); err != nil {
+		return nil, fmt.Errorf("error reading header of archive part %v: %v", name, err)
+	}
+	header := &PartHeader{}
+	if err := bson.Unmarshal(buf, header); err != nil {
+		return nil, fmt.Errorf("archive part %v has a corrupt header: %v", name, err)
+	}
+	return header, nil
+}
+
+func readMagicNumber(in io.Reader) (uint32, error) {
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(in, buf); err != nil {
+		return 0, err
+	}
+	return uint32(buf[0]) | uint32(buf[1])<<8 | uint32(buf[2])<<16 | uint32(buf[3])<<24, nil
+}
+
+// openNextPart opens the part following the current one and checks its header.
+func (r *PartReader) openNextPart() error {
+	r.file.Close()
+	r.part++
+	name := PartName(r.basePath, r.part)
+	file, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("archive part %v is missing", name)
+	}
+	if err != nil {
+		return fmt.Errorf("error opening archive part %v: %v", name, err)
+	}
+	r.file = file
+	if magic, err := readMagicNumber(file); err != nil || magic != PartMagicNumber {
+		return fmt.Errorf("%v is not an archive part", name)
+	}
+	header, err := readPartHeader(file, name)
+	if err != nil {
+		return err
+	}
+	if header.SetID != r.setID {
+		return fmt.Errorf("archive part %v belongs to a different archive than %v",
+			name, PartName(r.basePath, 0))
+	}
+	if int(header.Part) != r.part {
+		return fmt.Errorf("archive part %v is out of order: it holds part %v of the archive",
+			name, header.Part)
+	}
+	r.last = header.Last
+	return nil
+}
+
+// Read is part of the io.Reader interface. It moves on to the next part at the end of
+// every part but the last, so a missing part is reported rather than a short archive.
+func (r *PartReader) Read(buf []byte) (int, error) {
+	for {
+		n, err := r.file.Read(buf)
+		if err != io.EOF {
+			return n, err
+		}
+		if n > 0 {
+			return n, nil
+		}
+		if r.last {
+			return 0, io.EOF
+		}
+		if err = r.openNextPart(); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// Close is part of the io.Closer interface.
+func (r *PartReader) Close() error {
+	return r.file.Close()
+}
+
+// OpenFile opens an archive file for reading. When the file is the first part of a
+// multi-volume archive, the returned ReadCloser reads all of the parts in order.
+// If path doesn't exist but its first part does, the parts are read instead.
+func OpenFile(path string) (io.ReadCloser, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		if _, partErr := os.Stat(PartName(path, 0)); partErr == nil {
+			path = PartName(path, 0)
+			file, err = os.Open(path)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	magic, err := readMagicNumber(file)
+	if err != nil || magic != PartMagicNumber {
+		// not a part, so the file is read as is
+		if _, err = file.Seek(0, io.SeekStart); err != nil {
+			file.Close()
+			return nil, err
+		}
+		return file, nil
+	}
+
+	header, err := readPartHeader(file, path)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if header.Part != 0 {
+		file.Close()
+		return nil, fmt.Errorf("archive part %v holds part %v of the archive; "+
+			"the archive must be read starting from its first part", path, header.Part)
+	}
+	if !strings.HasSuffix(path, ".000") {
+		file.Close()
+		return nil, fmt.Errorf("archive part %v holds the first part of the archive, "+
+			"but isn't named like a first part (e.g. %v)", path, PartName("out.archive", 0))
+	}
+	basePath := strings.TrimSuffix(path, ".000")
+	return &PartReader{basePath: basePath, setID: header.SetID, last: header.Last, file: file}, nil
+}
@@ -0,0 +1,142 @@
+package archive
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func writeParts(path string, partSize int64, data []byte) {
+	w, err := NewPartWriter(path, partSize)
+	So(err, ShouldBeNil)
+	// write in uneven chunks so that writes straddle parts
+	for len(data) > 0 {
+		n := 77
+		if n > len(data) {
+			n = len(data)
+		}
+		written, err := w.Write(data[:n])
+		So(err, ShouldBeNil)
+		So(written, ShouldEqual, n)
+		data = data[n:]
+	}
+	So(w.Close(), ShouldBeNil)
+}
+
+func TestParts(t *testing.T) {
+	Convey("With an archive written in parts", t, func() {
+		tmp, err := ioutil.TempDir("", "archive_parts")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(tmp)
+
+		data := bytes.Repeat([]byte("0123456789abcdef"), 100)
+		path := filepath.Join(tmp, "out.archive")
+		writeParts(path, 200, data)
+
+		Convey("every part should be within the part size", func() {
+			parts, err := filepath.Glob(path + ".*")
+			So(err, ShouldBeNil)
+			So(len(parts), ShouldBeGreaterThan, 1)
+			for _, part := range parts {
+				info, err := os.Stat(part)
+				So(err, ShouldBeNil)
+				So(info.Size(), ShouldBeLessThanOrEqualTo, 200)
+			}
+		})
+
+		Convey("reading from the first part should give back the archive", func() {
+			in, err := OpenFile(PartName(path, 0))
+			So(err, ShouldBeNil)
+			defer in.Close()
+			read, err := ioutil.ReadAll(in)
+			So(err, ShouldBeNil)
+			So(read, ShouldResemble, data)
+		})
+
+		Convey("reading from the archive name should find the parts", func() {
+			in, err := OpenFile(path)
+			So(err, ShouldBeNil)
+			defer in.Close()
+			read, err := ioutil.ReadAll(in)
+			So(err, ShouldBeNil)
+			So(read, ShouldResemble, data)
+		})
+
+		Convey("a missing part should be reported", func() {
+			So(os.Remove(PartName(path, 2)), ShouldBeNil)
+			in, err := OpenFile(PartName(path, 0))
+			So(err, ShouldBeNil)
+			defer in.Close()
+			_, err = ioutil.ReadAll(in)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "is missing")
+		})
+
+		Convey("a missing last part should be reported", func() {
+			parts, err := filepath.Glob(path + ".*")
+			So(err, ShouldBeNil)
+			So(os.Remove(PartName(path, len(parts)-1)), ShouldBeNil)
+			in, err := OpenFile(PartName(path, 0))
+			So(err, ShouldBeNil)
+			defer in.Close()
+			_, err = ioutil.ReadAll(in)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "is missing")
+		})
+
+		Convey("parts out of order should be reported", func() {
+			So(os.Rename(PartName(path, 1), filepath.Join(tmp, "swap")), ShouldBeNil)
+			So(os.Rename(PartName(path, 2), PartName(path, 1)), ShouldBeNil)
+			So(os.Rename(filepath.Join(tmp, "swap"), PartName(path, 2)), ShouldBeNil)
+			in, err := OpenFile(PartName(path, 0))
+			So(err, ShouldBeNil)
+			defer in.Close()
+			_, err = ioutil.ReadAll(in)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "out of order")
+		})
+
+		Convey("a part from another archive should be reported", func() {
+			other := filepath.Join(tmp, "other.archive")
+			writeParts(other, 200, data)
+			So(os.Rename(PartName(other, 1), PartName(path, 1)), ShouldBeNil)
+			in, err := OpenFile(PartName(path, 0))
+			So(err, ShouldBeNil)
+			defer in.Close()
+			_, err = ioutil.ReadAll(in)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "different archive")
+		})
+
+		Convey("reading from a later part should be rejected", func() {
+			_, err := OpenFile(PartName(path, 1))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "first part")
+		})
+	})
+
+	Convey("A part size too small for the part header should be rejected", t, func() {
+		_, err := NewPartWriter("out.archive", 10)
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("A file that isn't a part should be read as is", t, func() {
+		file, err := ioutil.TempFile("", "archive_parts")
+		So(err, ShouldBeNil)
+		defer os.Remove(file.Name())
+		_, err = file.Write([]byte("not a part"))
+		So(err, ShouldBeNil)
+		So(file.Close(), ShouldBeNil)
+
+		in, err := OpenFile(file.Name())
+		So(err, ShouldBeNil)
+		defer in.Close()
+		read, err := ioutil.ReadAll(in)
+		So(err, ShouldBeNil)
+		So(string(read), ShouldEqual, "not a part")
+	})
+}
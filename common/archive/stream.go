@@ -0,0 +1,560 @@
+package archive
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/crc64"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/log"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// An archive stream carries an archive from mongodump to mongorestore over a network
+// connection. After the handshake, mongodump sends the archive in data frames,
+// interspersed with checksum frames, and finishes with an end frame. mongorestore
+// answers with a single frame once the restore is over: an ack when it succeeded,
+// or an error frame when it failed. Either side sends an error frame when it fails,
+// so that a failure on one end stops the other. Only connecting is retried: a stream
+// that loses its connection can't be resumed, and both ends fail.
+//
+// Every frame is a one-byte type, a four-byte little-endian payload length, and the payload.
+
+// StreamMagicNumber is four bytes that are sent by mongodump at the beginning of an archive
+// stream, followed by a StreamHello.
+const StreamMagicNumber uint32 = 0x8199e26f
+
+const streamProtocolVersion = "1"
+
+// StreamHello is a data structure that, as BSON, is sent by mongodump when connecting to mongorestore.
+type StreamHello struct {
+	ProtocolVersion string `bson:"version"`
+	ToolVersion     string `bson:"tool_version"`
+}
+
+// StreamHelloReply is a data structure that, as BSON, is sent back by mongorestore
+// in answer to a StreamHello.
+type StreamHelloReply struct {
+	OK     bool   `bson:"ok"`
+	ErrMsg string `bson:"errmsg,omitempty"`
+}
+
+const (
+	dataFrame     byte = 'D'
+	checksumFrame byte = 'C'
+	endFrame      byte = 'E'
+	ackFrame      byte = 'A'
+	errorFrame    byte = 'X'
+)
+
+const (
+	// maxDataFrameSize is the largest payload of a data frame
+	maxDataFrameSize = 64 * 1024
+	// maxFrameSize bounds the payload of any frame that is received
+	maxFrameSize = 1024 * 1024
+	// streamChecksumInterval is the number of bytes sent between checksum frames
+	streamChecksumInterval = 16 * 1024 * 1024
+	// streamDialTimeout is how long mongodump keeps retrying to connect to mongorestore
+	streamDialTimeout = time.Minute
+	// streamDrainTimeout is how long the side sending an error frame waits for the
+	// other side to hang up, so that the frame isn't lost to a connection reset
+	streamDrainTimeout = 10 * time.Second
+)
+
+func writeFrame(out io.Writer, frameType byte, payload []byte) error {
+	header := make([]byte, 5)
+	header[0] = frameType
+	binary.LittleEndian.PutUint32(header[1:], uint32(len(payload)))
+	if _, err := out.Write(header); err != nil {
+		return err
+	}
+	_, err := out.Write(payload)
+	return err
+}
+
+func readFrame(in io.Reader) (byte, []byte, error) {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(in, header); err != nil {
+		return 0, nil, err
+	}
+	size := binary.LittleEndian.Uint32(header[1:])
+	if size > maxFrameSize {
+		return 0, nil, fmt.Errorf("archive stream frame of %v bytes is too large", size)
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(in, payload); err != nil {
+		return 0, nil, err
+	}
+	return header[0], payload, nil
+}
+
+// checksumPayload encodes a running checksum along with the number of bytes it covers.
+func checksumPayload(sum uint64, count int64) []byte {
+	payload := make([]byte, 16)
+	binary.LittleEndian.PutUint64(payload, sum)
+	binary.LittleEndian.PutUint64(payload[8:], uint64(count))
+	return payload
+}
+
+// ParseStreamAddress returns the host:port of a tcp://host:port archive stream address.
+func ParseStreamAddress(address string) (string, error) {
+	if !strings.HasPrefix(address, "tcp://") {
+		return "", fmt.Errorf("archive stream address '%v' must be of the form tcp://host:port", address)
+	}
+	hostPort := strings.TrimPrefix(address, "tcp://")
+	if _, _, err := net.SplitHostPort(hostPort); err != nil {
+		return "", fmt.Errorf("invalid archive stream address '%v': %v", address, err)
+	}
+	return hostPort, nil
+}
+
+// ClientTLSConfig returns the TLS configuration mongodump uses to connect to mongorestore.
+// The certificate of mongorestore is verified against caFile, or against the system
+// roots when caFile is empty.
+func ClientTLSConfig(caFile string) (*tls.Config, error) {
+	config := &tls.Config{}
+	if caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading CA file: %v", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA file %v", caFile)
+		}
+	}
+	return config, nil
+}
+
+// ServerTLSConfig returns the TLS configuration mongorestore uses to accept mongodump connections.
+func ServerTLSConfig(certFile, keyFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("error loading certificate: %v", err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}}, nil
+}
+
+// StreamWriter implements io.WriteCloser. It sends an archive to mongorestore.
+type StreamWriter struct {
+	conn net.Conn
+	out  *bufio.Writer
+
+	writeLock     sync.Mutex
+	frame         []byte
+	hash          hash.Hash64
+	count         int64
+	sinceChecksum int64
+	finished      bool
+
+	// response is closed once mongorestore answered, or the connection was lost
+	response    chan struct{}
+	responseErr error
+}
+
+// DialStream connects to a mongorestore listening at address (host:port), retrying for
+// a while when mongorestore isn't listening yet, and performs the handshake.
+// The connection uses TLS when tlsConfig isn't nil. Once connected, nothing is
+// retried: if the connection is lost, the dump fails.
+func DialStream(address string, tlsConfig *tls.Config, toolVersion string) (*StreamWriter, error) {
+	var conn net.Conn
+	var err error
+	deadline := time.Now().Add(streamDialTimeout)
+	for {
+		conn, err = net.DialTimeout("tcp", address, streamDialTimeout)
+		if err == nil || time.Now().After(deadline) {
+			break
+		}
+		log.Logvf(log.Always, "could not connect to mongorestore at %v, retrying: %v", address, err)
+		time.Sleep(time.Second)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to mongorestore at %v: %v", address, err)
+	}
+	if tlsConfig != nil {
+		config := tlsConfig.Clone()
+		if config.ServerName == "" {
+			config.ServerName, _, _ = net.SplitHostPort(address)
+		}
+		conn = tls.Client(conn, config)
+	}
+
+	hello, err := bson.Marshal(&StreamHello{ProtocolVersion: streamProtocolVersion, ToolVersion: toolVersion})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	magicNumberBytes := make([]byte, 4)
+	binary.LittleEndian.PutUint32(magicNumberBytes, StreamMagicNumber)
+	if _, err = conn.Write(append(magicNumberBytes, hello...)); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("error sending handshake to mongorestore: %v", err)
+	}
+	in := bufio.NewReader(conn)
+	reply := &StreamHelloReply{}
+	if err = readBSON(in, reply); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("error reading handshake from mongorestore: %v", err)
+	}
+	if !reply.OK {
+		conn.Close()
+		return nil, fmt.Errorf("mongorestore refused the connection: %v", reply.ErrMsg)
+	}
+	log.Logvf(log.Always, "connected to mongorestore at %v", address)
+
+	w := &StreamWriter{
+		conn:     conn,
+		out:      bufio.NewWriter(conn),
+		frame:    make([]byte, 0, maxDataFrameSize),
+		hash:     crc64.New(crc64.MakeTable(crc64.ECMA)),
+		response: make(chan struct{}),
+	}
+	go w.readResponse(in)
+	return w, nil
+}
+
+func readBSON(in io.Reader, out interface{}) error {
+	sizeBuf := make([]byte, 4)
+	if _, err := io.ReadFull(in, sizeBuf); err != nil {
+		return err
+	}
+	size := int32(binary.LittleEndian.Uint32(sizeBuf))
+	if size < minBSONSize || size > maxFrameSize {
+		return fmt.Errorf("invalid BSON size %v", size)
+	}
+	buf := make([]byte, size)
+	copy(buf, sizeBuf)
+	if _, err := io.ReadFull(in, buf[4:]); err != nil {
+		return err
+	}
+	return bson.Unmarshal(buf, out)
+}
+
+// readResponse waits for the frame mongorestore sends once it is done. An error
+// frame closes the connection, which fails any write in progress.
+func (w *StreamWriter) readResponse(in io.Reader) {
+	frameType, payload, err := readFrame(in)
+	switch {
+	case err != nil:
+		w.responseErr = fmt.Errorf("lost connection to mongorestore: %v", err)
+	case frameType == ackFrame:
+	case frameType == errorFrame:
+		w.responseErr = fmt.Errorf("mongorestore failed: %v", string(payload))
+	default:
+		w.responseErr = fmt.Errorf("unexpected frame type '%c' from mongorestore", frameType)
+	}
+	close(w.response)
+	if w.responseErr != nil {
+		w.conn.Close()
+	}
+}
+
+// remoteErr returns the error sent by mongorestore, if it already answered.
+func (w *StreamWriter) remoteErr() error {
+	select {
+	case <-w.response:
+		return w.responseErr
+	default:
+		return nil
+	}
+}
+
+// sendError prefers reporting the error from mongorestore, which is usually
+// the reason a write to the connection failed.
+func (w *StreamWriter) sendError(err error) error {
+	if remoteErr := w.remoteErr(); remoteErr != nil {
+		return remoteErr
+	}
+	return fmt.Errorf("error sending archive to mongorestore: %v", err)
+}
+
+func (w *StreamWriter) flushFrame() error {
+	if len(w.frame) == 0 {
+		return nil
+	}
+	if err := writeFrame(w.out, dataFrame, w.frame); err != nil {
+		return w.sendError(err)
+	}
+	w.hash.Write(w.frame)
+	w.count += int64(len(w.frame))
+	w.sinceChecksum += int64(len(w.frame))
+	w.frame = w.frame[:0]
+	if w.sinceChecksum >= streamChecksumInterval {
+		if err := writeFrame(w.out, checksumFrame, checksumPayload(w.hash.Sum64(), w.count)); err != nil {
+			return w.sendError(err)
+		}
+		w.sinceChecksum = 0
+	}
+	return nil
+}
+
+// Write is part of the io.Writer interface. It fails as soon as mongorestore reports an error.
+func (w *StreamWriter) Write(buf []byte) (int, error) {
+	w.writeLock.Lock()
+	defer w.writeLock.Unlock()
+	if w.finished {
+		return 0, fmt.Errorf("archive stream is closed")
+	}
+	if err := w.remoteErr(); err != nil {
+		return 0, err
+	}
+	total := 0
+	for len(buf) > 0 {
+		n := cap(w.frame) - len(w.frame)
+		if n > len(buf) {
+			n = len(buf)
+		}
+		w.frame = append(w.frame, buf[:n]...)
+		buf = buf[n:]
+		total += n
+		if len(w.frame) == cap(w.frame) {
+			if err := w.flushFrame(); err != nil {
+				return total, err
+			}
+		}
+	}
+	return total, nil
+}
+
+// Close is part of the io.Closer interface. It ends the archive and waits for
+// mongorestore to finish, returning an error if the restore failed.
+func (w *StreamWriter) Close() error {
+	w.writeLock.Lock()
+	if w.finished {
+		w.writeLock.Unlock()
+		return nil
+	}
+	w.finished = true
+	err := w.flushFrame()
+	if err == nil {
+		if err = writeFrame(w.out, endFrame, checksumPayload(w.hash.Sum64(), w.count)); err == nil {
+			err = w.out.Flush()
+		}
+		if err != nil {
+			err = w.sendError(err)
+		}
+	}
+	w.writeLock.Unlock()
+	if err != nil {
+		w.conn.Close()
+		return err
+	}
+
+	log.Logvf(log.Info, "sent %v bytes to mongorestore, waiting for the restore to finish", w.count)
+	<-w.response
+	w.conn.Close()
+	return w.responseErr
+}
+
+// Abort tells mongorestore that the dump failed with err, and closes the connection.
+func (w *StreamWriter) Abort(err error) {
+	w.writeLock.Lock()
+	defer w.writeLock.Unlock()
+	if w.finished {
+		return
+	}
+	w.finished = true
+	if w.remoteErr() == nil {
+		if writeFrame(w.out, errorFrame, []byte(err.Error())) == nil && w.out.Flush() == nil {
+			closeWrite(w.conn)
+			select {
+			case <-w.response:
+			case <-time.After(streamDrainTimeout):
+			}
+		}
+	}
+	w.conn.Close()
+}
+
+// closeWrite shuts down the sending side of a connection, if it supports it.
+func closeWrite(conn net.Conn) {
+	if cw, ok := conn.(interface {
+		CloseWrite() error
+	}); ok {
+		cw.CloseWrite()
+	}
+}
+
+// StreamListener accepts a single archive stream from mongodump.
+type StreamListener struct {
+	net.Listener
+	tlsConfig *tls.Config
+}
+
+// ListenStream listens for mongodump on address, e.g. ":27100". Connections use TLS when
+// tlsConfig isn't nil.
+func ListenStream(address string, tlsConfig *tls.Config) (*StreamListener, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("error listening for mongodump on %v: %v", address, err)
+	}
+	return &StreamListener{Listener: listener, tlsConfig: tlsConfig}, nil
+}
+
+// Accept waits for mongodump to connect, and stops listening once it has. Connections
+// which fail the handshake are logged and dropped, and waiting continues.
+func (l *StreamListener) Accept() (*StreamReader, error) {
+	defer l.Listener.Close()
+	log.Logvf(log.Always, "waiting for mongodump to connect on %v", l.Addr())
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, fmt.Errorf("error accepting connection from mongodump: %v", err)
+		}
+		if l.tlsConfig != nil {
+			conn = tls.Server(conn, l.tlsConfig)
+		}
+		r, err := acceptStream(conn)
+		if err != nil {
+			log.Logvf(log.Always, "rejected connection from %v: %v", conn.RemoteAddr(), err)
+			conn.Close()
+			continue
+		}
+		log.Logvf(log.Always, "mongodump connected from %v", conn.RemoteAddr())
+		return r, nil
+	}
+}
+
+// acceptStream performs the handshake on a new connection.
+func acceptStream(conn net.Conn) (*StreamReader, error) {
+	conn.SetReadDeadline(time.Now().Add(streamDialTimeout))
+	in := bufio.NewReader(conn)
+	magicNumberBytes := make([]byte, 4)
+	if _, err := io.ReadFull(in, magicNumberBytes); err != nil {
+		return nil, fmt.Errorf("error reading handshake: %v", err)
+	}
+	if binary.LittleEndian.Uint32(magicNumberBytes) != StreamMagicNumber {
+		return nil, fmt.Errorf("connection is not an archive stream")
+	}
+	hello := &StreamHello{}
+	if err := readBSON(in, hello); err != nil {
+		return nil, fmt.Errorf("error reading handshake: %v", err)
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	reply := &StreamHelloReply{OK: true}
+	if hello.ProtocolVersion != streamProtocolVersion {
+		reply = &StreamHelloReply{ErrMsg: fmt.Sprintf("unsupported archive stream version %v, expected %v",
+			hello.ProtocolVersion, streamProtocolVersion)}
+	}
+	buf, err := bson.Marshal(reply)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = conn.Write(buf); err != nil {
+		return nil, fmt.Errorf("error sending handshake: %v", err)
+	}
+	if !reply.OK {
+		return nil, fmt.Errorf("%v", reply.ErrMsg)
+	}
+	log.Logvf(log.DebugLow, `archive stream from mongodump version "%v"`, hello.ToolVersion)
+	return &StreamReader{
+		conn: conn,
+		in:   in,
+		hash: crc64.New(crc64.MakeTable(crc64.ECMA)),
+	}, nil
+}
+
+// StreamReader implements io.ReadCloser. It receives an archive from mongodump,
+// verifying its checksums along the way.
+type StreamReader struct {
+	conn    net.Conn
+	in      *bufio.Reader
+	hash    hash.Hash64
+	count   int64
+	pending []byte
+	ended   bool
+	err     error
+
+	finishOnce sync.Once
+}
+
+// verifyChecksum checks a checksum frame against what was received so far.
+func (r *StreamReader) verifyChecksum(payload []byte) error {
+	if len(payload) != 16 {
+		return fmt.Errorf("invalid checksum frame in archive stream")
+	}
+	sum := binary.LittleEndian.Uint64(payload)
+	count := int64(binary.LittleEndian.Uint64(payload[8:]))
+	if count != r.count {
+		return fmt.Errorf("archive stream lost data: received %v bytes, mongodump sent %v", r.count, count)
+	}
+	if sum != r.hash.Sum64() {
+		return fmt.Errorf("archive stream checksum mismatch after %v bytes", count)
+	}
+	return nil
+}
+
+// Read is part of the io.Reader interface. It returns io.EOF once mongodump ended
+// the archive, and an error when the archive was corrupted or mongodump failed.
+func (r *StreamReader) Read(buf []byte) (int, error) {
+	for len(r.pending) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		if r.ended {
+			return 0, io.EOF
+		}
+		frameType, payload, err := readFrame(r.in)
+		switch {
+		case err == io.EOF || err == io.ErrUnexpectedEOF:
+			r.err = fmt.Errorf("mongodump closed the connection before the end of the archive")
+		case err != nil:
+			r.err = fmt.Errorf("error receiving archive from mongodump: %v", err)
+		case frameType == dataFrame:
+			r.hash.Write(payload)
+			r.count += int64(len(payload))
+			r.pending = payload
+		case frameType == checksumFrame:
+			r.err = r.verifyChecksum(payload)
+		case frameType == endFrame:
+			r.err = r.verifyChecksum(payload)
+			r.ended = true
+			log.Logvf(log.DebugLow, "received %v bytes from mongodump", r.count)
+		case frameType == errorFrame:
+			r.err = fmt.Errorf("mongodump failed: %v", string(payload))
+		default:
+			r.err = fmt.Errorf("unexpected frame type '%c' from mongodump", frameType)
+		}
+	}
+	n := copy(buf, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+// Finish reports the outcome of the restore to mongodump, and closes the connection.
+// A nil err tells mongodump the restore succeeded.
+func (r *StreamReader) Finish(err error) {
+	r.finishOnce.Do(func() {
+		if err == nil && !r.ended {
+			err = fmt.Errorf("restore finished before the end of the archive")
+		}
+		if err == nil {
+			writeFrame(r.conn, ackFrame, nil)
+		} else if writeFrame(r.conn, errorFrame, []byte(err.Error())) == nil {
+			// wait for mongodump to hang up, discarding the rest of the archive
+			closeWrite(r.conn)
+			r.conn.SetReadDeadline(time.Now().Add(streamDrainTimeout))
+			io.Copy(ioutil.Discard, r.in)
+		}
+		r.conn.Close()
+	})
+}
+
+// Close is part of the io.Closer interface. It closes the connection without
+// reporting an outcome, unless Finish was already called.
+func (r *StreamReader) Close() error {
+	r.finishOnce.Do(func() {
+		r.conn.Close()
+	})
+	return nil
+}
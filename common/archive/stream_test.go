@@ -0,0 +1,185 @@
+package archive
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// startStream listens on a local port and connects a StreamWriter to it.
+func startStream(serverTLS, clientTLS *tls.Config) (*StreamWriter, *StreamReader) {
+	listener, err := ListenStream("127.0.0.1:0", serverTLS)
+	So(err, ShouldBeNil)
+	accepted := make(chan *StreamReader)
+	go func() {
+		r, err := listener.Accept()
+		if err != nil {
+			r = nil
+		}
+		accepted <- r
+	}()
+	w, err := DialStream(listener.Addr().String(), clientTLS, "test")
+	So(err, ShouldBeNil)
+	r := <-accepted
+	So(r, ShouldNotBeNil)
+	return w, r
+}
+
+// writeTestCert writes a self-signed certificate for 127.0.0.1 into dir.
+func writeTestCert(dir string) (string, string, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	So(err, ShouldBeNil)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "mongorestore"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	So(err, ShouldBeNil)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	So(err, ShouldBeNil)
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	So(ioutil.WriteFile(certFile, certPEM, 0600), ShouldBeNil)
+	So(ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600), ShouldBeNil)
+	pool := x509.NewCertPool()
+	So(pool.AppendCertsFromPEM(certPEM), ShouldBeTrue)
+	return certFile, keyFile, pool
+}
+
+func TestStream(t *testing.T) {
+	data := bytes.Repeat([]byte("archive stream "), 20000)
+
+	Convey("With an archive stream", t, func() {
+		w, r := startStream(nil, nil)
+
+		Convey("a successful restore should be acknowledged", func() {
+			go func() {
+				received, err := ioutil.ReadAll(r)
+				if err == nil && !bytes.Equal(received, data) {
+					err = fmt.Errorf("received different data")
+				}
+				r.Finish(err)
+			}()
+			n, err := w.Write(data)
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, len(data))
+			So(w.Close(), ShouldBeNil)
+		})
+
+		Convey("a failed restore should stop the dump", func() {
+			go func() {
+				buf := make([]byte, 1000)
+				r.Read(buf)
+				r.Finish(fmt.Errorf("duplicate key"))
+			}()
+			var err error
+			for i := 0; i < 1000 && err == nil; i++ {
+				_, err = w.Write(data)
+			}
+			if err == nil {
+				err = w.Close()
+			}
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "duplicate key")
+		})
+
+		Convey("a failed dump should stop the restore", func() {
+			_, err := w.Write(data[:100])
+			So(err, ShouldBeNil)
+			restoreErr := make(chan error)
+			go func() {
+				_, err := ioutil.ReadAll(r)
+				r.Finish(err)
+				restoreErr <- err
+			}()
+			w.Abort(fmt.Errorf("lost connection to the server"))
+			err = <-restoreErr
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "lost connection to the server")
+		})
+	})
+
+	Convey("With an archive stream over TLS", t, func() {
+		tmp, err := ioutil.TempDir("", "archive_stream")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(tmp)
+		certFile, keyFile, pool := writeTestCert(tmp)
+		serverTLS, err := ServerTLSConfig(certFile, keyFile)
+		So(err, ShouldBeNil)
+
+		w, r := startStream(serverTLS, &tls.Config{RootCAs: pool})
+		go func() {
+			_, err := ioutil.ReadAll(r)
+			r.Finish(err)
+		}()
+		_, err = w.Write(data)
+		So(err, ShouldBeNil)
+		So(w.Close(), ShouldBeNil)
+	})
+
+	Convey("With a connection sending a corrupted archive stream", t, func() {
+		listener, err := ListenStream("127.0.0.1:0", nil)
+		So(err, ShouldBeNil)
+		accepted := make(chan *StreamReader)
+		go func() {
+			r, _ := listener.Accept()
+			accepted <- r
+		}()
+
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		So(err, ShouldBeNil)
+		defer conn.Close()
+		hello, err := bson.Marshal(&StreamHello{ProtocolVersion: streamProtocolVersion})
+		So(err, ShouldBeNil)
+		magicNumberBytes := make([]byte, 4)
+		binary.LittleEndian.PutUint32(magicNumberBytes, StreamMagicNumber)
+		_, err = conn.Write(append(magicNumberBytes, hello...))
+		So(err, ShouldBeNil)
+		r := <-accepted
+		So(r, ShouldNotBeNil)
+		So(readBSON(conn, &StreamHelloReply{}), ShouldBeNil)
+
+		So(writeFrame(conn, dataFrame, []byte("some data")), ShouldBeNil)
+		So(writeFrame(conn, checksumFrame, checksumPayload(12345, 9)), ShouldBeNil)
+		_, err = ioutil.ReadAll(r)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "checksum mismatch")
+		r.Close()
+	})
+
+	Convey("Archive stream addresses should need the tcp scheme and a port", t, func() {
+		address, err := ParseStreamAddress("tcp://example.net:27100")
+		So(err, ShouldBeNil)
+		So(address, ShouldEqual, "example.net:27100")
+		_, err = ParseStreamAddress("example.net:27100")
+		So(err, ShouldNotBeNil)
+		_, err = ParseStreamAddress("tcp://example.net")
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -217,6 +217,77 @@ func ParseSpecialKeys(special interface{}) (interface{}, error) {
 			}
 		}
 
+		if jsonValue, ok := doc["$numberDouble"]; ok {
+			switch v := jsonValue.(type) {
+			case string:
+				// also parses the Infinity, -Infinity and NaN special values
+				return strconv.ParseFloat(v, 64)
+			default:
+				return nil, errors.New("expected $numberDouble field to have string value")
+			}
+		}
+
+		if jsonValue, ok := doc["$binary"]; ok {
+			binaryDoc, ok := asDocument(jsonValue)
+			if !ok {
+				return nil, errors.New("expected $binary field to have document value, or a $type field")
+			}
+			return parseBinary(binaryDoc["base64"], binaryDoc["subType"])
+		}
+
+		if jsonValue, ok := doc["$regularExpression"]; ok {
+			regexDoc, ok := asDocument(jsonValue)
+			if !ok {
+				return nil, errors.New("expected $regularExpression field to have document value")
+			}
+			pattern, ok := regexDoc["pattern"].(string)
+			if !ok {
+				return nil, errors.New("expected $regularExpression to have string 'pattern' field")
+			}
+			options, ok := regexDoc["options"].(string)
+			if !ok {
+				return nil, errors.New("expected $regularExpression to have string 'options' field")
+			}
+			for i := range options {
+				switch o := options[i]; o {
+				default:
+					return nil, fmt.Errorf("invalid regular expression option '%v'", o)
+
+				case 'i', 'l', 'm', 's', 'u', 'x': // allowed
+				}
+			}
+			return bson.RegEx{Pattern: pattern, Options: options}, nil
+		}
+
+		if jsonValue, ok := doc["$symbol"]; ok {
+			switch v := jsonValue.(type) {
+			case string:
+				return bson.Symbol(v), nil
+			default:
+				return nil, errors.New("expected $symbol field to have string value")
+			}
+		}
+
+		if jsonValue, ok := doc["$dbPointer"]; ok {
+			pointerDoc, ok := asDocument(jsonValue)
+			if !ok {
+				return nil, errors.New("expected $dbPointer field to have document value")
+			}
+			namespace, ok := pointerDoc["$ref"].(string)
+			if !ok {
+				return nil, errors.New("expected $dbPointer to have string $ref field")
+			}
+			id, err := ParseJSONValue(pointerDoc["$id"])
+			if err != nil {
+				return nil, fmt.Errorf("error parsing $dbPointer $id field: %v", err)
+			}
+			objectId, ok := id.(bson.ObjectId)
+			if !ok {
+				return nil, errors.New("expected $dbPointer $id field to be an ObjectId")
+			}
+			return bson.DBPointer{Namespace: namespace, Id: objectId}, nil
+		}
+
 		if _, ok := doc["$undefined"]; ok {
 			return bson.Undefined, nil
 		}
@@ -291,37 +362,11 @@ func ParseSpecialKeys(special interface{}) (interface{}, error) {
 		}
 
 		if jsonValue, ok := doc["$binary"]; ok {
-			binary := bson.Binary{}
-
-			switch data := jsonValue.(type) {
-			case string:
-				bytes, err := base64.StdEncoding.DecodeString(data)
-				if err != nil {
-					return nil, err
-				}
-				binary.Data = bytes
-
-			default:
-				return nil, errors.New("expected $binary field to have string value")
-			}
-			if jsonValue, ok = doc["$type"]; !ok {
+			typeValue, ok := doc["$type"]
+			if !ok {
 				return nil, errors.New("expected $type field with $binary field")
 			}
-
-			switch typ := jsonValue.(type) {
-			case string:
-				kind, err := hex.DecodeString(typ)
-				if err != nil {
-					return nil, err
-				} else if len(kind) != 1 {
-					return nil, errors.New("expected single byte (as hexadecimal string) for $type field")
-				}
-				binary.Kind = kind[0]
-
-			default:
-				return nil, errors.New("expected $type field to have string value")
-			}
-			return binary, nil
+			return parseBinary(jsonValue, typeValue)
 		}
 
 		if jsonValue, ok := doc["$ref"]; ok {
@@ -404,6 +449,54 @@ func ParseJSONValue(jsonValue interface{}) (interface{}, error) {
 	}
 }
 
+// asDocument returns the fields of a document value, as parsed by the json package.
+func asDocument(jsonValue interface{}) (map[string]interface{}, bool) {
+	switch v := jsonValue.(type) {
+	case map[string]interface{}:
+		return v, true
+	case bson.D:
+		return v.Map(), true
+	}
+	return nil, false
+}
+
+// parseBinary returns the binary value for base64 encoded data and a subtype that
+// is a hexadecimal string, as found in both the legacy and the v2 Extended JSON formats.
+func parseBinary(dataValue, typeValue interface{}) (bson.Binary, error) {
+	binary := bson.Binary{}
+
+	switch data := dataValue.(type) {
+	case string:
+		bytes, err := base64.StdEncoding.DecodeString(data)
+		if err != nil {
+			return binary, err
+		}
+		binary.Data = bytes
+
+	default:
+		return binary, errors.New("expected $binary data to have string value")
+	}
+
+	switch typ := typeValue.(type) {
+	case string:
+		if len(typ) == 1 {
+			// Extended JSON v2 allows a single hexadecimal digit
+			typ = "0" + typ
+		}
+		kind, err := hex.DecodeString(typ)
+		if err != nil {
+			return binary, err
+		} else if len(kind) != 1 {
+			return binary, errors.New("expected single byte (as hexadecimal string) for $binary subtype")
+		}
+		binary.Kind = kind[0]
+
+	default:
+		return binary, errors.New("expected $binary subtype to have string value")
+	}
+	return binary, nil
+}
+
 func parseNumberLongField(jsonValue interface{}) (int64, error) {
 	switch v := jsonValue.(type) {
 	case string:
@@ -386,3 +386,17 @@ func GetBSONValueAsJSON(x interface{}) (interface{}, error) {
 
 	return nil, fmt.Errorf("conversion of BSON value '%v' of type '%T' not supported", x, x)
 }
+
+// MarshalExtJSON converts a BSON value to Extended JSON in the given format.
+// Any format other than json.Canonical or json.Relaxed is json.Legacy, in which
+// case it mutates its argument like ConvertBSONValueToJSON.
+func MarshalExtJSON(x interface{}, format json.ExtJSONFormat) ([]byte, error) {
+	if format == json.Canonical || format == json.Relaxed {
+		return json.MarshalExtJSON(x, format)
+	}
+	extended, err := ConvertBSONValueToJSON(x)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(extended)
+}
@@ -0,0 +1,98 @@
+package bsonutil
+
+import (
+	"github.com/mongodb/mongo-tools/common/json"
+	"github.com/mongodb/mongo-tools/common/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/mgo.v2/bson"
+	"math"
+	"testing"
+	"time"
+)
+
+// roundTrip converts BSON to Extended JSON and back, the way bsondump/mongoexport
+// and mongoimport do, and returns the resulting BSON.
+func roundTrip(raw []byte, format json.ExtJSONFormat) []byte {
+	decoded := bson.D{}
+	So(bson.Unmarshal(raw, &decoded), ShouldBeNil)
+	extJSON, err := MarshalExtJSON(decoded, format)
+	So(err, ShouldBeNil)
+	parsed, err := json.UnmarshalBsonD(extJSON)
+	So(err, ShouldBeNil)
+	converted, err := GetExtendedBsonD(parsed)
+	So(err, ShouldBeNil)
+	out, err := bson.Marshal(converted)
+	So(err, ShouldBeNil)
+	return out
+}
+
+func TestExtJSONRoundTrip(t *testing.T) {
+
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+
+	Convey("With a document holding every BSON type", t, func() {
+		oid := bson.ObjectIdHex("57e193d7a9cc81b4027498b5")
+		decimal, err := bson.ParseDecimal128("-1.25E-10")
+		So(err, ShouldBeNil)
+		doc := bson.D{
+			{"double", 3.25},
+			{"integralDouble", float64(2)},
+			{"infinity", math.Inf(-1)},
+			{"string", "héllo \"world\"\n"},
+			{"document", bson.D{{"z", 1}, {"a", bson.D{{"b", nil}}}}},
+			{"array", []interface{}{int32(1), "two", bson.D{{"three", 3.0}}}},
+			{"binary", []byte{0, 1, 2, 255}},
+			{"uuid", bson.Binary{Kind: 0x04, Data: []byte("0123456789abcdef")}},
+			{"undefined", bson.Undefined},
+			{"objectId", oid},
+			{"false", false},
+			{"true", true},
+			{"date", time.Unix(1356351330, 501e6)},
+			{"oldDate", time.Unix(-2208988800, 0)},
+			{"null", nil},
+			{"regex", bson.RegEx{Pattern: "^a.*b$", Options: "imsx"}},
+			{"dbPointer", bson.DBPointer{Namespace: "db.c", Id: oid}},
+			{"code", bson.JavaScript{Code: "function() {}"}},
+			{"symbol", bson.Symbol("sym")},
+			{"codeWithScope", bson.JavaScript{Code: "f(x)", Scope: bson.D{{"x", int32(1)}}}},
+			{"int32", int32(-42)},
+			{"timestamp", bson.MongoTimestamp(int64(1356351330)<<32 | 7)},
+			{"int64", int64(1) << 40},
+			{"decimal", decimal},
+			{"minKey", bson.MinKey},
+			{"maxKey", bson.MaxKey},
+		}
+		raw, err := bson.Marshal(doc)
+		So(err, ShouldBeNil)
+
+		Convey("the canonical format should give back the same BSON", func() {
+			So(roundTrip(raw, json.Canonical), ShouldResemble, raw)
+		})
+
+		Convey("the relaxed format should give back the same values", func() {
+			out := roundTrip(raw, json.Relaxed)
+			decoded := bson.D{}
+			So(bson.Unmarshal(out, &decoded), ShouldBeNil)
+			expected := bson.D{}
+			So(bson.Unmarshal(raw, &expected), ShouldBeNil)
+			So(len(decoded), ShouldEqual, len(expected))
+			for i := range expected {
+				So(decoded[i].Name, ShouldEqual, expected[i].Name)
+				switch expected[i].Name {
+				case "date", "oldDate":
+					So(decoded[i].Value.(time.Time).Equal(expected[i].Value.(time.Time)), ShouldBeTrue)
+				default:
+					So(decoded[i].Value, ShouldResemble, expected[i].Value)
+				}
+			}
+		})
+	})
+
+	Convey("Extended JSON v2 binary subtypes may be a single hexadecimal digit", t, func() {
+		value, err := ParseSpecialKeys(map[string]interface{}{
+			"$binary": map[string]interface{}{"base64": "aGk=", "subType": "4"},
+		})
+		So(err, ShouldBeNil)
+		So(value, ShouldResemble, bson.Binary{Kind: 0x04, Data: []byte("hi")})
+	})
+}
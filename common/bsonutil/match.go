@@ -0,0 +1,564 @@
+package bsonutil
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// BSON element kinds used by the matcher, see http://bsonspec.org/spec.html
+const (
+	kindDouble     = 0x01
+	kindString     = 0x02
+	kindDocument   = 0x03
+	kindArray      = 0x04
+	kindUndefined  = 0x06
+	kindObjectId   = 0x07
+	kindBool       = 0x08
+	kindDate       = 0x09
+	kindNull       = 0x0A
+	kindSymbol     = 0x0E
+	kindInt32      = 0x10
+	kindTimestamp  = 0x11
+	kindInt64      = 0x12
+	kindDecimal128 = 0x13
+)
+
+// Matcher tests raw BSON documents against a query filter, without a server.
+// It supports implicit equality, $eq, $ne, $gt, $gte, $lt, $lte, $in, $nin,
+// $exists and $regex on dotted field paths, and the $and, $or and $nor operators.
+// Like the server, a condition on a field holding an array matches when the
+// condition holds for the array itself or for any of its elements.
+type Matcher struct {
+	expr matchExpr
+}
+
+// NewMatcher compiles a query filter, as parsed from Extended JSON, into a Matcher.
+func NewMatcher(query bson.D) (*Matcher, error) {
+	expr, err := compileDocument(query)
+	if err != nil {
+		return nil, err
+	}
+	return &Matcher{expr}, nil
+}
+
+// Matches returns true if the raw BSON document matches the query.
+func (m *Matcher) Matches(doc []byte) bool {
+	return m.expr.matches(bson.Raw{Kind: kindDocument, Data: doc})
+}
+
+// matchExpr is a compiled part of a query filter.
+type matchExpr interface {
+	matches(doc bson.Raw) bool
+}
+
+type andExpr []matchExpr
+
+func (and andExpr) matches(doc bson.Raw) bool {
+	for _, expr := range and {
+		if !expr.matches(doc) {
+			return false
+		}
+	}
+	return true
+}
+
+type orExpr []matchExpr
+
+func (or orExpr) matches(doc bson.Raw) bool {
+	for _, expr := range or {
+		if expr.matches(doc) {
+			return true
+		}
+	}
+	return false
+}
+
+type norExpr []matchExpr
+
+func (nor norExpr) matches(doc bson.Raw) bool {
+	return !orExpr(nor).matches(doc)
+}
+
+// fieldExpr is a condition on the values found at a dotted field path.
+type fieldExpr struct {
+	path      []string
+	condition condition
+}
+
+func (f fieldExpr) matches(doc bson.Raw) bool {
+	return f.condition.matches(lookupPath(doc, f.path))
+}
+
+// condition is a condition on all of the values found at a field path.
+type condition interface {
+	matches(values []bson.Raw) bool
+}
+
+// elementCondition is a condition that holds when any value found at a
+// field path, or any element of an array found there, satisfies the test.
+type elementCondition func(value bson.Raw) bool
+
+func (test elementCondition) matches(values []bson.Raw) bool {
+	for _, value := range values {
+		if test(value) {
+			return true
+		}
+		if value.Kind == kindArray {
+			for _, element := range rawElements(value) {
+				if test(element.Value) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// notCondition negates a condition, like $ne and $nin do for $eq and $in.
+type notCondition struct {
+	condition
+}
+
+func (not notCondition) matches(values []bson.Raw) bool {
+	return !not.condition.matches(values)
+}
+
+// existsCondition holds when a field path is present or absent.
+type existsCondition bool
+
+func (exists existsCondition) matches(values []bson.Raw) bool {
+	return (len(values) > 0) == bool(exists)
+}
+
+// allConditions holds when every condition on the same field path holds.
+type allConditions []condition
+
+func (all allConditions) matches(values []bson.Raw) bool {
+	for _, c := range all {
+		if !c.matches(values) {
+			return false
+		}
+	}
+	return true
+}
+
+// orConditions holds when any of its conditions on the same field path holds.
+type orConditions []condition
+
+func (or orConditions) matches(values []bson.Raw) bool {
+	for _, c := range or {
+		if c.matches(values) {
+			return true
+		}
+	}
+	return false
+}
+
+func compileDocument(query bson.D) (matchExpr, error) {
+	and := andExpr{}
+	for _, elem := range query {
+		var expr matchExpr
+		var err error
+		switch elem.Name {
+		case "$and", "$or", "$nor":
+			expr, err = compileLogical(elem.Name, elem.Value)
+		default:
+			if strings.HasPrefix(elem.Name, "$") {
+				return nil, fmt.Errorf("unsupported query operator %v", elem.Name)
+			}
+			var c condition
+			c, err = compileCondition(elem.Value)
+			expr = fieldExpr{strings.Split(elem.Name, "."), c}
+		}
+		if err != nil {
+			return nil, err
+		}
+		and = append(and, expr)
+	}
+	return and, nil
+}
+
+func compileLogical(operator string, value interface{}) (matchExpr, error) {
+	clauses, ok := value.([]interface{})
+	if !ok || len(clauses) == 0 {
+		return nil, fmt.Errorf("%v must be a non-empty array", operator)
+	}
+	exprs := make([]matchExpr, 0, len(clauses))
+	for _, clause := range clauses {
+		doc, ok := clause.(bson.D)
+		if !ok {
+			return nil, fmt.Errorf("every element of %v must be a document", operator)
+		}
+		expr, err := compileDocument(doc)
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, expr)
+	}
+	switch operator {
+	case "$and":
+		return andExpr(exprs), nil
+	case "$or":
+		return orExpr(exprs), nil
+	default:
+		return norExpr(exprs), nil
+	}
+}
+
+// compileCondition compiles the value given for a field in a query, which
+// is either a document of operators or a value the field must equal.
+func compileCondition(value interface{}) (condition, error) {
+	operators, ok := value.(bson.D)
+	if !ok || len(operators) == 0 || !strings.HasPrefix(operators[0].Name, "$") {
+		return compileEquality(value)
+	}
+
+	all := allConditions{}
+	var regexOptions string
+	for _, elem := range operators {
+		if elem.Name == "$options" {
+			options, ok := elem.Value.(string)
+			if !ok {
+				return nil, fmt.Errorf("$options must be a string")
+			}
+			regexOptions = options
+		}
+	}
+	for _, elem := range operators {
+		var c condition
+		var err error
+		switch elem.Name {
+		case "$eq":
+			c, err = compileEquality(elem.Value)
+		case "$ne":
+			c, err = compileEquality(elem.Value)
+			c = notCondition{c}
+		case "$gt", "$gte", "$lt", "$lte":
+			c, err = compileComparison(elem.Name, elem.Value)
+		case "$in":
+			c, err = compileIn(elem.Value)
+		case "$nin":
+			c, err = compileIn(elem.Value)
+			c = notCondition{c}
+		case "$exists":
+			c = existsCondition(isTrue(elem.Value))
+		case "$regex":
+			c, err = compileRegex(elem.Value, regexOptions)
+		case "$options":
+			continue
+		default:
+			return nil, fmt.Errorf("unsupported query operator %v", elem.Name)
+		}
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, c)
+	}
+	return all, nil
+}
+
+// toRaw returns the raw BSON encoding of a query value.
+func toRaw(value interface{}) (bson.Raw, error) {
+	data, err := bson.Marshal(bson.D{{Name: "v", Value: value}})
+	if err != nil {
+		return bson.Raw{}, err
+	}
+	var doc bson.RawD
+	if err = bson.Unmarshal(data, &doc); err != nil {
+		return bson.Raw{}, err
+	}
+	return doc[0].Value, nil
+}
+
+func compileEquality(value interface{}) (condition, error) {
+	if regex, ok := value.(bson.RegEx); ok {
+		return compileRegex(regex.Pattern, regex.Options)
+	}
+	expected, err := toRaw(value)
+	if err != nil {
+		return nil, err
+	}
+	equals := elementCondition(func(actual bson.Raw) bool {
+		order, ok := compareRaw(actual, expected)
+		return ok && order == 0
+	})
+	if expected.Kind == kindNull {
+		// null matches fields that don't exist too
+		return orConditions{existsCondition(false), equals}, nil
+	}
+	return equals, nil
+}
+
+func compileComparison(operator string, value interface{}) (condition, error) {
+	bound, err := toRaw(value)
+	if err != nil {
+		return nil, err
+	}
+	return elementCondition(func(actual bson.Raw) bool {
+		order, ok := compareRaw(actual, bound)
+		if !ok {
+			return false
+		}
+		switch operator {
+		case "$gt":
+			return order > 0
+		case "$gte":
+			return order >= 0
+		case "$lt":
+			return order < 0
+		default:
+			return order <= 0
+		}
+	}), nil
+}
+
+func compileIn(value interface{}) (condition, error) {
+	values, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("$in and $nin need an array")
+	}
+	or := orConditions{}
+	for _, v := range values {
+		c, err := compileEquality(v)
+		if err != nil {
+			return nil, err
+		}
+		or = append(or, c)
+	}
+	return or, nil
+}
+
+func compileRegex(value interface{}, options string) (condition, error) {
+	var pattern string
+	switch v := value.(type) {
+	case string:
+		pattern = v
+	case bson.RegEx:
+		pattern = v.Pattern
+		options += v.Options
+	default:
+		return nil, fmt.Errorf("$regex must be a string or a regular expression")
+	}
+	flags := ""
+	for _, option := range options {
+		switch option {
+		case 'i', 'm', 's':
+			flags += string(option)
+		default:
+			return nil, fmt.Errorf("unsupported regular expression option '%c'", option)
+		}
+	}
+	if flags != "" {
+		pattern = "(?" + flags + ")" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regular expression: %v", err)
+	}
+	return elementCondition(func(actual bson.Raw) bool {
+		if actual.Kind != kindString && actual.Kind != kindSymbol {
+			return false
+		}
+		return re.MatchString(rawString(actual))
+	}), nil
+}
+
+func isTrue(value interface{}) bool {
+	switch v := value.(type) {
+	case bool:
+		return v
+	case nil:
+		return false
+	case int, int32, int64, float64:
+		f, _ := toFloat(v)
+		return f != 0
+	}
+	return true
+}
+
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// lookupPath returns the values found at a dotted field path of a document.
+// Path components descend into subdocuments, into arrays by index, and into
+// every subdocument of an array otherwise, so a path can have many values.
+func lookupPath(value bson.Raw, path []string) []bson.Raw {
+	if len(path) == 0 {
+		return []bson.Raw{value}
+	}
+	switch value.Kind {
+	case kindDocument:
+		for _, elem := range rawElements(value) {
+			if elem.Name == path[0] {
+				return lookupPath(elem.Value, path[1:])
+			}
+		}
+	case kindArray:
+		elements := rawElements(value)
+		if index, err := strconv.Atoi(path[0]); err == nil {
+			if index >= 0 && index < len(elements) {
+				return lookupPath(elements[index].Value, path[1:])
+			}
+			return nil
+		}
+		var values []bson.Raw
+		for _, elem := range elements {
+			if elem.Value.Kind == kindDocument {
+				values = append(values, lookupPath(elem.Value, path)...)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// rawElements returns the elements of a raw document or array, or nil if it is corrupt.
+func rawElements(value bson.Raw) bson.RawD {
+	var elements bson.RawD
+	if err := bson.Unmarshal(value.Data, &elements); err != nil {
+		return nil
+	}
+	return elements
+}
+
+func rawString(value bson.Raw) string {
+	// a BSON string is an int32 length, the bytes and a terminating null
+	if len(value.Data) < 5 {
+		return ""
+	}
+	return string(value.Data[4 : len(value.Data)-1])
+}
+
+func rawNumber(value bson.Raw) (float64, bool) {
+	switch value.Kind {
+	case kindDouble:
+		return math.Float64frombits(binary.LittleEndian.Uint64(value.Data)), true
+	case kindInt32:
+		return float64(int32(binary.LittleEndian.Uint32(value.Data))), true
+	case kindInt64:
+		return float64(int64(binary.LittleEndian.Uint64(value.Data))), true
+	case kindDecimal128:
+		var d bson.Decimal128
+		if err := value.Unmarshal(&d); err != nil {
+			return 0, false
+		}
+		f, err := strconv.ParseFloat(d.String(), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// rawInt returns the value of a raw BSON int32 or int64.
+func rawInt(value bson.Raw) (int64, bool) {
+	switch value.Kind {
+	case kindInt32:
+		return int64(int32(binary.LittleEndian.Uint32(value.Data))), true
+	case kindInt64:
+		return int64(binary.LittleEndian.Uint64(value.Data)), true
+	}
+	return 0, false
+}
+
+func compareInts(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func compareFloats(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// compareRaw orders two raw BSON values of comparable types: numbers of any
+// type, strings and symbols, documents, arrays, and values of the same kind.
+// It returns false if the values aren't comparable.
+func compareRaw(a, b bson.Raw) (int, bool) {
+	// integers are compared exactly, since doubles can't hold those above 2^53
+	if x, ok := rawInt(a); ok {
+		if y, ok := rawInt(b); ok {
+			return compareInts(x, y), true
+		}
+	}
+	if x, ok := rawNumber(a); ok {
+		y, ok := rawNumber(b)
+		if !ok || math.IsNaN(x) || math.IsNaN(y) {
+			return 0, false
+		}
+		return compareFloats(x, y), true
+	}
+	if a.Kind == kindString || a.Kind == kindSymbol {
+		if b.Kind != kindString && b.Kind != kindSymbol {
+			return 0, false
+		}
+		return strings.Compare(rawString(a), rawString(b)), true
+	}
+	if a.Kind != b.Kind {
+		return 0, false
+	}
+	switch a.Kind {
+	case kindDocument, kindArray:
+		x, y := rawElements(a), rawElements(b)
+		for i := 0; i < len(x) && i < len(y); i++ {
+			if a.Kind == kindDocument && x[i].Name != y[i].Name {
+				return strings.Compare(x[i].Name, y[i].Name), true
+			}
+			order, ok := compareRaw(x[i].Value, y[i].Value)
+			if !ok {
+				// values of different types only compare as unequal
+				return compareInts(int64(x[i].Value.Kind), int64(y[i].Value.Kind)), true
+			}
+			if order != 0 {
+				return order, true
+			}
+		}
+		return compareInts(int64(len(x)), int64(len(y))), true
+	case kindDate:
+		return compareInts(int64(binary.LittleEndian.Uint64(a.Data)), int64(binary.LittleEndian.Uint64(b.Data))), true
+	case kindTimestamp:
+		x, y := binary.LittleEndian.Uint64(a.Data), binary.LittleEndian.Uint64(b.Data)
+		switch {
+		case x < y:
+			return -1, true
+		case x > y:
+			return 1, true
+		}
+		return 0, true
+	case kindObjectId, kindBool:
+		return bytes.Compare(a.Data, b.Data), true
+	case kindNull, kindUndefined, 0x7F, 0xFF:
+		return 0, true
+	}
+	// other types, like binary data and regular expressions, can only be equal
+	if bytes.Equal(a.Data, b.Data) {
+		return 0, true
+	}
+	return 0, false
+}
@@ -0,0 +1,123 @@
+package bsonutil
+
+import (
+	"github.com/mongodb/mongo-tools/common/json"
+	"github.com/mongodb/mongo-tools/common/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/mgo.v2/bson"
+	"testing"
+)
+
+// matches parses an Extended JSON query and tests a document against it.
+func matches(query string, doc bson.D) bool {
+	parsed, err := json.UnmarshalBsonD([]byte(query))
+	So(err, ShouldBeNil)
+	parsed, err = GetExtendedBsonD(parsed)
+	So(err, ShouldBeNil)
+	matcher, err := NewMatcher(parsed)
+	So(err, ShouldBeNil)
+	raw, err := bson.Marshal(doc)
+	So(err, ShouldBeNil)
+	return matcher.Matches(raw)
+}
+
+func TestMatcher(t *testing.T) {
+
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+
+	Convey("With a document", t, func() {
+		oid := bson.ObjectIdHex("57e193d7a9cc81b4027498b5")
+		doc := bson.D{
+			{"_id", oid},
+			{"n", 5},
+			{"f", 2.5},
+			{"l", int64(1) << 40},
+			{"big", int64(1)<<53 + 1},
+			{"s", "hello"},
+			{"b", true},
+			{"null", nil},
+			{"tags", []interface{}{"red", "blue"}},
+			{"sub", bson.D{{"x", 1}, {"y", bson.D{{"z", "deep"}}}}},
+			{"items", []interface{}{bson.D{{"qty", 3}}, bson.D{{"qty", 10}}}},
+		}
+
+		Convey("equality should match any numeric type", func() {
+			So(matches(`{"n": 5}`, doc), ShouldBeTrue)
+			So(matches(`{"n": 5.0}`, doc), ShouldBeTrue)
+			So(matches(`{"n": {"$eq": NumberLong(5)}}`, doc), ShouldBeTrue)
+			So(matches(`{"n": 6}`, doc), ShouldBeFalse)
+			So(matches(`{"n": "5"}`, doc), ShouldBeFalse)
+			So(matches(`{"_id": {"$oid": "57e193d7a9cc81b4027498b5"}}`, doc), ShouldBeTrue)
+		})
+
+		Convey("comparisons should only match values of the same type", func() {
+			So(matches(`{"n": {"$gt": 4, "$lte": 5}}`, doc), ShouldBeTrue)
+			So(matches(`{"n": {"$lt": 5}}`, doc), ShouldBeFalse)
+			So(matches(`{"f": {"$gte": 2.5}}`, doc), ShouldBeTrue)
+			So(matches(`{"l": {"$gt": 1000000}}`, doc), ShouldBeTrue)
+			So(matches(`{"s": {"$gt": "hell"}}`, doc), ShouldBeTrue)
+			So(matches(`{"s": {"$gt": 1}}`, doc), ShouldBeFalse)
+		})
+
+		Convey("integers above 2^53 should be compared exactly", func() {
+			So(matches(`{"big": NumberLong(9007199254740993)}`, doc), ShouldBeTrue)
+			So(matches(`{"big": NumberLong(9007199254740992)}`, doc), ShouldBeFalse)
+			So(matches(`{"big": {"$gt": NumberLong(9007199254740992)}}`, doc), ShouldBeTrue)
+			So(matches(`{"big": {"$lt": NumberLong(9007199254740994)}}`, doc), ShouldBeTrue)
+		})
+
+		Convey("dotted paths should descend into documents and arrays", func() {
+			So(matches(`{"sub.x": 1}`, doc), ShouldBeTrue)
+			So(matches(`{"sub.y.z": "deep"}`, doc), ShouldBeTrue)
+			So(matches(`{"sub": {"x": 1, "y": {"z": "deep"}}}`, doc), ShouldBeTrue)
+			So(matches(`{"sub": {"x": 1}}`, doc), ShouldBeFalse)
+			So(matches(`{"items.qty": {"$gt": 5}}`, doc), ShouldBeTrue)
+			So(matches(`{"items.qty": {"$gt": 50}}`, doc), ShouldBeFalse)
+			So(matches(`{"items.0.qty": 3}`, doc), ShouldBeTrue)
+			So(matches(`{"tags.1": "blue"}`, doc), ShouldBeTrue)
+		})
+
+		Convey("conditions on arrays should match the array or any element", func() {
+			So(matches(`{"tags": "red"}`, doc), ShouldBeTrue)
+			So(matches(`{"tags": ["red", "blue"]}`, doc), ShouldBeTrue)
+			So(matches(`{"tags": ["blue", "red"]}`, doc), ShouldBeFalse)
+			So(matches(`{"tags": {"$in": ["green", "blue"]}}`, doc), ShouldBeTrue)
+			So(matches(`{"tags": {"$nin": ["green", "blue"]}}`, doc), ShouldBeFalse)
+			So(matches(`{"tags": {"$ne": "green"}}`, doc), ShouldBeTrue)
+		})
+
+		Convey("$exists and null should handle missing fields", func() {
+			So(matches(`{"sub.x": {"$exists": true}}`, doc), ShouldBeTrue)
+			So(matches(`{"missing": {"$exists": false}}`, doc), ShouldBeTrue)
+			So(matches(`{"missing": {"$exists": true}}`, doc), ShouldBeFalse)
+			So(matches(`{"missing": null}`, doc), ShouldBeTrue)
+			So(matches(`{"null": null}`, doc), ShouldBeTrue)
+			So(matches(`{"s": null}`, doc), ShouldBeFalse)
+		})
+
+		Convey("regular expressions should match strings", func() {
+			So(matches(`{"s": {"$regex": "^he"}}`, doc), ShouldBeTrue)
+			So(matches(`{"s": {"$regex": "^HE", "$options": "i"}}`, doc), ShouldBeTrue)
+			So(matches(`{"s": /^HE/i}`, doc), ShouldBeTrue)
+			So(matches(`{"tags": {"$regex": "^bl"}}`, doc), ShouldBeTrue)
+			So(matches(`{"n": {"$regex": "5"}}`, doc), ShouldBeFalse)
+		})
+
+		Convey("logical operators should combine conditions", func() {
+			So(matches(`{"$and": [{"n": 5}, {"s": "hello"}]}`, doc), ShouldBeTrue)
+			So(matches(`{"$or": [{"n": 6}, {"s": "hello"}]}`, doc), ShouldBeTrue)
+			So(matches(`{"$or": [{"n": 6}, {"s": "bye"}]}`, doc), ShouldBeFalse)
+			So(matches(`{"$nor": [{"n": 6}, {"s": "bye"}]}`, doc), ShouldBeTrue)
+			So(matches(`{"n": 5, "b": false}`, doc), ShouldBeFalse)
+		})
+	})
+
+	Convey("Unsupported operators should be rejected", t, func() {
+		_, err := NewMatcher(bson.D{{"n", bson.D{{"$where", "true"}}}})
+		So(err, ShouldNotBeNil)
+		_, err = NewMatcher(bson.D{{"$text", bson.D{{"$search", "x"}}}})
+		So(err, ShouldNotBeNil)
+		_, err = NewMatcher(bson.D{{"$or", []interface{}{}}})
+		So(err, ShouldNotBeNil)
+	})
+}
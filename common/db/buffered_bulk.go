@@ -20,6 +20,16 @@ type BufferedBulkInserter struct {
 	unordered       bool
 }
 
+// EncodingError is returned by Insert for a document that can't be encoded as
+// BSON. The document is not buffered.
+type EncodingError struct {
+	Err error
+}
+
+func (e *EncodingError) Error() string {
+	return fmt.Sprintf("bson encoding error: %v", e.Err)
+}
+
 // NewBufferedBulkInserter returns an initialized BufferedBulkInserter
 // for writing.
 func NewBufferedBulkInserter(collection *mgo.Collection, docLimit int,
@@ -53,7 +63,7 @@ func (bb *BufferedBulkInserter) resetBulk() {
 func (bb *BufferedBulkInserter) Insert(doc interface{}) error {
 	rawBytes, err := bson.Marshal(doc)
 	if err != nil {
-		return fmt.Errorf("bson encoding error: %v", err)
+		return &EncodingError{err}
 	}
 	// flush if we are full
 	if bb.docCount >= bb.docLimit || bb.byteCount+len(rawBytes) > MaxBSONSize {
@@ -66,6 +76,11 @@ func (bb *BufferedBulkInserter) Insert(doc interface{}) error {
 	return err
 }
 
+// Buffered returns the number of documents waiting to be inserted.
+func (bb *BufferedBulkInserter) Buffered() int {
+	return bb.docCount
+}
+
 // Flush writes all buffered documents in one bulk insert then resets the buffer.
 func (bb *BufferedBulkInserter) Flush() error {
 	if bb.docCount == 0 {
@@ -58,6 +58,11 @@ type Intent struct {
 	// File/collection size, for some prioritizer implementations.
 	// Units don't matter as long as they are consistent for a given use case.
 	Size int64
+
+	// Document count and index keys, for the cost model prioritizer.
+	// DocCount is 0 when the number of documents isn't known.
+	DocCount  int64
+	IndexKeys []bson.D
 }
 
 func (it *Intent) Namespace() string {
@@ -447,8 +452,7 @@ func (manager *Manager) AuthVersion() *Intent {
 	return manager.versionIntent
 }
 
-// Finalize processes the intents for prioritization. Currently only two
-// kinds of prioritizers are supported. No more "Put" operations may be done
+// Finalize processes the intents for prioritization. No more "Put" operations may be done
 // after finalize is called.
 func (manager *Manager) Finalize(pType PriorityType) {
 	switch pType {
@@ -461,6 +465,9 @@ func (manager *Manager) Finalize(pType PriorityType) {
 	case MultiDatabaseLTF:
 		log.Logv(log.DebugHigh, "finalizing intent manager with multi-database longest task first prioritizer")
 		manager.prioritizer = NewMultiDatabaseLTFPrioritizer(manager.intentsByDiscoveryOrder)
+	case CostModel:
+		log.Logv(log.DebugHigh, "finalizing intent manager with cost model prioritizer")
+		manager.prioritizer = NewCostPrioritizer(manager.intentsByDiscoveryOrder)
 	default:
 		panic("cannot initialize IntentPrioritizer with unknown type")
 	}
@@ -472,3 +479,10 @@ func (manager *Manager) Finalize(pType PriorityType) {
 func (manager *Manager) UsePrioritizer(prioritizer IntentPrioritizer) {
 	manager.prioritizer = prioritizer
 }
+
+// LimitIntentsPerDB caps the number of intents of any single database that
+// are in progress at a time. It must be called after Finalize or UsePrioritizer.
+func (manager *Manager) LimitIntentsPerDB(limit int) {
+	log.Logvf(log.DebugHigh, "limiting intents in progress to %v per database", limit)
+	manager.prioritizer = NewDBLimitPrioritizer(manager.prioritizer, limit)
+}
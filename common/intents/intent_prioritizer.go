@@ -4,6 +4,8 @@ import (
 	"container/heap"
 	"sort"
 	"sync"
+
+	"gopkg.in/mgo.v2/bson"
 )
 
 type PriorityType int
@@ -12,6 +14,7 @@ const (
 	Legacy PriorityType = iota
 	LongestTaskFirst
 	MultiDatabaseLTF
+	CostModel
 )
 
 // IntentPrioritizer encapsulates the logic of scheduling intents
@@ -239,3 +242,171 @@ func (dbh *DBHeap) Pop() interface{} {
 	*dbh = old[0 : n-1]
 	return toPop
 }
+
+//===== Cost Model =====
+
+// The cost model estimates the work of restoring a collection in units of bytes
+// written: the size of its data, plus a fixed overhead for every document inserted,
+// plus the work of adding every document to each of its indexes.
+const (
+	// costPerDocument is the overhead of inserting a document
+	costPerDocument = 200
+	// costPerIndexEntry is the work of adding a document to a single field index
+	costPerIndexEntry = 100
+	// costPerCompoundField is the extra work for every additional field of a compound index
+	costPerCompoundField = 25
+	// assumedDocumentSize is used to estimate document counts that aren't known
+	assumedDocumentSize = 1024
+)
+
+// indexTypeWeights holds how much more work it is to add a document to special
+// index types than to a regular index. Text indexes add an entry for every
+// distinct word of a document, and geo indexes compute cells for every shape.
+var indexTypeWeights = map[string]float64{
+	"text":        10,
+	"2dsphere":    4,
+	"2d":          2,
+	"geoHaystack": 2,
+	"hashed":      1.2,
+}
+
+// indexEntryCost returns the work of adding one document to the index with the given key.
+func indexEntryCost(key bson.D) float64 {
+	weight := 1.0
+	for _, field := range key {
+		if indexType, ok := field.Value.(string); ok && indexTypeWeights[indexType] > weight {
+			weight = indexTypeWeights[indexType]
+		}
+	}
+	cost := float64(costPerIndexEntry)
+	if len(key) > 1 {
+		cost += float64(costPerCompoundField * (len(key) - 1))
+	}
+	return cost * weight
+}
+
+// EstimateCost returns the estimated work of restoring an intent, based on its Size
+// in bytes, its DocCount and its IndexKeys. When the document count isn't known,
+// it is estimated from the size.
+func EstimateCost(intent *Intent) int64 {
+	docs := intent.DocCount
+	if docs == 0 {
+		docs = intent.Size / assumedDocumentSize
+	}
+	perDocument := float64(costPerDocument)
+	for _, key := range intent.IndexKeys {
+		perDocument += indexEntryCost(key)
+	}
+	return intent.Size + int64(float64(docs)*perDocument)
+}
+
+// costPrioritizer returns intents in the order of most -> least estimated work. It
+// is a longest task first prioritizer that doesn't consider the size alone, so that
+// collections with many indexes or many small documents don't start last.
+type costPrioritizer struct {
+	sync.Mutex
+	queue []*Intent
+}
+
+// NewCostPrioritizer returns an initialized cost model prioritizer
+func NewCostPrioritizer(intents []*Intent) *costPrioritizer {
+	sort.Stable(ByCost(intents))
+	return &costPrioritizer{
+		queue: intents,
+	}
+}
+
+func (cost *costPrioritizer) Get() *Intent {
+	cost.Lock()
+	defer cost.Unlock()
+
+	if len(cost.queue) == 0 {
+		return nil
+	}
+
+	var intent *Intent
+	intent, cost.queue = cost.queue[0], cost.queue[1:]
+	return intent
+}
+
+func (cost *costPrioritizer) Finish(*Intent) {
+	// no-op
+	return
+}
+
+// For sorting intents from most to least estimated work
+type ByCost []*Intent
+
+func (s ByCost) Len() int           { return len(s) }
+func (s ByCost) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s ByCost) Less(i, j int) bool { return EstimateCost(s[i]) > EstimateCost(s[j]) }
+
+//===== Database Limit =====
+
+// dbLimitPrioritizer wraps another prioritizer, so that no more than a given number
+// of intents of the same database are in progress at a time. Intents of a database
+// at its limit are held back, and are returned before any later intent as soon
+// as the database is below its limit. Get blocks while every remaining intent
+// belongs to a database at its limit.
+type dbLimitPrioritizer struct {
+	sync.Mutex
+	available *sync.Cond
+	inner     IntentPrioritizer
+	limit     int
+	active    map[string]int
+	held      []*Intent
+	innerDone bool
+}
+
+// NewDBLimitPrioritizer returns a prioritizer that keeps the order of inner,
+// but only allows limit intents of any database in progress at a time.
+func NewDBLimitPrioritizer(inner IntentPrioritizer, limit int) *dbLimitPrioritizer {
+	prioritizer := &dbLimitPrioritizer{
+		inner:  inner,
+		limit:  limit,
+		active: map[string]int{},
+	}
+	prioritizer.available = sync.NewCond(prioritizer)
+	return prioritizer
+}
+
+func (dbl *dbLimitPrioritizer) Get() *Intent {
+	dbl.Lock()
+	defer dbl.Unlock()
+
+	for {
+		for i, intent := range dbl.held {
+			if dbl.active[intent.DB] < dbl.limit {
+				dbl.held = append(dbl.held[:i], dbl.held[i+1:]...)
+				dbl.active[intent.DB]++
+				return intent
+			}
+		}
+		for !dbl.innerDone {
+			intent := dbl.inner.Get()
+			if intent == nil {
+				dbl.innerDone = true
+				break
+			}
+			if dbl.active[intent.DB] < dbl.limit {
+				dbl.active[intent.DB]++
+				return intent
+			}
+			dbl.held = append(dbl.held, intent)
+		}
+		if len(dbl.held) == 0 {
+			return nil
+		}
+		// wait for an intent of a held back database to finish
+		dbl.available.Wait()
+	}
+}
+
+func (dbl *dbLimitPrioritizer) Finish(intent *Intent) {
+	dbl.Lock()
+	defer dbl.Unlock()
+
+	dbl.active[intent.DB]--
+	dbl.inner.Finish(intent)
+	dbl.available.Broadcast()
+}
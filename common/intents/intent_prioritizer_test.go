@@ -4,7 +4,9 @@ import (
 	"container/heap"
 	"github.com/mongodb/mongo-tools/common/testutil"
 	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/mgo.v2/bson"
 	"testing"
+	"time"
 )
 
 func TestLegacyPrioritizer(t *testing.T) {
@@ -172,3 +174,78 @@ func TestSimulatedMultiDBJob(t *testing.T) {
 		})
 	})
 }
+
+func TestCostPrioritizer(t *testing.T) {
+
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+
+	Convey("With intents of the same size", t, func() {
+		idIndex := bson.D{{"_id", 1}}
+		textIndex := bson.D{{"_fts", "text"}, {"_ftsx", 1}}
+		testList := []*Intent{
+			&Intent{C: "plain", Size: 1 << 20, DocCount: 1000, IndexKeys: []bson.D{idIndex}},
+			&Intent{C: "text", Size: 1 << 20, DocCount: 1000, IndexKeys: []bson.D{idIndex, textIndex}},
+			&Intent{C: "manyDocs", Size: 1 << 20, DocCount: 100000, IndexKeys: []bson.D{idIndex}},
+			&Intent{C: "noIndexes", Size: 1 << 20, DocCount: 1000},
+		}
+
+		Convey("more documents and costlier indexes should mean more work", func() {
+			So(EstimateCost(testList[1]), ShouldBeGreaterThan, EstimateCost(testList[0]))
+			So(EstimateCost(testList[2]), ShouldBeGreaterThan, EstimateCost(testList[1]))
+			So(EstimateCost(testList[0]), ShouldBeGreaterThan, EstimateCost(testList[3]))
+		})
+
+		Convey("an unknown document count should be estimated from the size", func() {
+			So(EstimateCost(&Intent{Size: 1 << 20}), ShouldBeGreaterThan, 1<<20)
+		})
+
+		Convey("the cost prioritizer should return the most work first", func() {
+			prioritizer := NewCostPrioritizer(testList)
+			So(prioritizer.Get().C, ShouldEqual, "manyDocs")
+			So(prioritizer.Get().C, ShouldEqual, "text")
+			So(prioritizer.Get().C, ShouldEqual, "plain")
+			So(prioritizer.Get().C, ShouldEqual, "noIndexes")
+			So(prioritizer.Get(), ShouldBeNil)
+		})
+	})
+}
+
+func TestDBLimitPrioritizer(t *testing.T) {
+
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+
+	Convey("With a limit of one intent per database", t, func() {
+		testList := []*Intent{
+			&Intent{DB: "db1", C: "a"},
+			&Intent{DB: "db1", C: "b"},
+			&Intent{DB: "db2", C: "c"},
+		}
+		prioritizer := NewDBLimitPrioritizer(NewLegacyPrioritizer(testList), 1)
+
+		Convey("intents of a busy database should be held back", func() {
+			i0 := prioritizer.Get()
+			So(i0.C, ShouldEqual, "a")
+			i1 := prioritizer.Get()
+			So(i1.C, ShouldEqual, "c")
+
+			Convey("until an intent of that database finishes", func() {
+				got := make(chan *Intent)
+				go func() {
+					got <- prioritizer.Get()
+				}()
+				select {
+				case <-got:
+					t.Fatal("Get returned while db1 was at its limit")
+				case <-time.After(50 * time.Millisecond):
+				}
+				prioritizer.Finish(i0)
+				i2 := <-got
+				So(i2.C, ShouldEqual, "b")
+
+				prioritizer.Finish(i1)
+				prioritizer.Finish(i2)
+				So(prioritizer.Get(), ShouldBeNil)
+			})
+		})
+	})
+}
@@ -0,0 +1,283 @@
+package json
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// ExtJSONFormat is a dialect of MongoDB Extended JSON.
+type ExtJSONFormat string
+
+const (
+	// Legacy is the dialect the tools have always written, e.g. { "$binary": "...", "$type": "00" }.
+	// It is produced by converting BSON values with the bsonutil package and calling Marshal.
+	Legacy ExtJSONFormat = "legacy"
+
+	// Canonical is Extended JSON v2 that preserves the type of every BSON value.
+	Canonical ExtJSONFormat = "canonical"
+
+	// Relaxed is Extended JSON v2 that writes numbers and common dates as plain
+	// JSON values, which is easier to read but loses some type information.
+	Relaxed ExtJSONFormat = "relaxed"
+)
+
+// ParseExtJSONFormat returns the ExtJSONFormat named by format. An empty
+// format is Legacy, which the tools write by default.
+func ParseExtJSONFormat(format string) (ExtJSONFormat, error) {
+	switch f := ExtJSONFormat(format); f {
+	case "":
+		return Legacy, nil
+	case Legacy, Canonical, Relaxed:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown Extended JSON format '%v', expected canonical, relaxed or legacy", format)
+}
+
+// MarshalExtJSON returns the Extended JSON v2 encoding of a value decoded by the
+// bson package, in the Canonical or Relaxed format. Documents may be bson.D, bson.M
+// or map[string]interface{}; bson.D keeps the order of its fields.
+func MarshalExtJSON(value interface{}, format ExtJSONFormat) ([]byte, error) {
+	if format != Canonical && format != Relaxed {
+		return nil, fmt.Errorf("MarshalExtJSON can't produce the %v Extended JSON format", format)
+	}
+	e := &extJSONEncoder{relaxed: format == Relaxed}
+	if err := e.encode(value); err != nil {
+		return nil, err
+	}
+	return e.Bytes(), nil
+}
+
+type extJSONEncoder struct {
+	bytes.Buffer
+	relaxed bool
+}
+
+// wrap writes a value as a single field document, e.g. { "$oid": "..." }.
+func (e *extJSONEncoder) wrap(key string, value string) {
+	fmt.Fprintf(e, `{"%v":%v}`, key, value)
+}
+
+func (e *extJSONEncoder) quote(s string) error {
+	quoted, err := Marshal(s)
+	if err != nil {
+		return err
+	}
+	e.Write(quoted)
+	return nil
+}
+
+func (e *extJSONEncoder) encode(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		e.WriteString("null")
+	case bool:
+		e.WriteString(strconv.FormatBool(v))
+	case string:
+		return e.quote(v)
+	case int:
+		// the bson package decodes 32-bit integers as int
+		if v >= math.MinInt32 && v <= math.MaxInt32 {
+			e.encodeInt32(int32(v))
+		} else {
+			e.encodeInt64(int64(v))
+		}
+	case int32:
+		e.encodeInt32(v)
+	case int64:
+		e.encodeInt64(v)
+	case float32:
+		e.encodeDouble(float64(v))
+	case float64:
+		e.encodeDouble(v)
+	case bson.D:
+		return e.encodeD(v)
+	case bson.M:
+		return e.encodeMap(v)
+	case map[string]interface{}:
+		return e.encodeMap(v)
+	case []interface{}:
+		return e.encodeArray(v)
+	case bson.ObjectId:
+		e.wrap("$oid", strconv.Quote(v.Hex()))
+	case time.Time:
+		e.encodeDate(v)
+	case []byte:
+		e.encodeBinary(0x00, v)
+	case bson.Binary:
+		e.encodeBinary(v.Kind, v.Data)
+	case bson.RegEx:
+		// the options of a regular expression must be sorted
+		options := []byte(v.Options)
+		sort.Slice(options, func(i, j int) bool { return options[i] < options[j] })
+		e.WriteString(`{"$regularExpression":{"pattern":`)
+		if err := e.quote(v.Pattern); err != nil {
+			return err
+		}
+		fmt.Fprintf(e, `,"options":%q}}`, string(options))
+	case bson.JavaScript:
+		e.WriteString(`{"$code":`)
+		if err := e.quote(v.Code); err != nil {
+			return err
+		}
+		if v.Scope != nil {
+			e.WriteString(`,"$scope":`)
+			if err := e.encode(v.Scope); err != nil {
+				return err
+			}
+		}
+		e.WriteString("}")
+	case bson.Symbol:
+		e.WriteString(`{"$symbol":`)
+		if err := e.quote(string(v)); err != nil {
+			return err
+		}
+		e.WriteString("}")
+	case bson.DBPointer:
+		e.WriteString(`{"$dbPointer":{"$ref":`)
+		if err := e.quote(v.Namespace); err != nil {
+			return err
+		}
+		fmt.Fprintf(e, `,"$id":{"$oid":"%v"}}}`, v.Id.Hex())
+	case bson.MongoTimestamp:
+		// see BSON spec for details on the bit fiddling here
+		fmt.Fprintf(e, `{"$timestamp":{"t":%v,"i":%v}}`, uint32(uint64(v)>>32), uint32(v))
+	case bson.Decimal128:
+		e.wrap("$numberDecimal", strconv.Quote(formatDecimal(v)))
+	default:
+		// the undefined, min key and max key values have unexported types
+		switch value {
+		case bson.Undefined:
+			e.WriteString(`{"$undefined":true}`)
+		case bson.MinKey:
+			e.WriteString(`{"$minKey":1}`)
+		case bson.MaxKey:
+			e.WriteString(`{"$maxKey":1}`)
+		default:
+			return fmt.Errorf("conversion of BSON value '%v' of type '%T' not supported", v, v)
+		}
+	}
+	return nil
+}
+
+func (e *extJSONEncoder) encodeInt32(n int32) {
+	if e.relaxed {
+		e.WriteString(strconv.FormatInt(int64(n), 10))
+		return
+	}
+	e.wrap("$numberInt", strconv.Quote(strconv.FormatInt(int64(n), 10)))
+}
+
+func (e *extJSONEncoder) encodeInt64(n int64) {
+	if e.relaxed {
+		e.WriteString(strconv.FormatInt(n, 10))
+		return
+	}
+	e.wrap("$numberLong", strconv.Quote(strconv.FormatInt(n, 10)))
+}
+
+// formatDecimal returns the string of a decimal, with the infinities that
+// mgo writes as Inf and -Inf spelled as Extended JSON requires.
+func formatDecimal(d bson.Decimal128) string {
+	switch s := d.String(); s {
+	case "Inf":
+		return "Infinity"
+	case "-Inf":
+		return "-Infinity"
+	default:
+		return s
+	}
+}
+
+// formatDouble returns the shortest representation of f that parses back to f,
+// always with a decimal point or an exponent so that it isn't read as an integer.
+func formatDouble(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "Infinity"
+	case math.IsInf(f, -1):
+		return "-Infinity"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	s := strconv.FormatFloat(f, 'G', -1, 64)
+	if !bytes.ContainsAny([]byte(s), ".E") {
+		s += ".0"
+	}
+	return s
+}
+
+func (e *extJSONEncoder) encodeDouble(f float64) {
+	s := formatDouble(f)
+	if e.relaxed && !math.IsInf(f, 0) && !math.IsNaN(f) {
+		e.WriteString(s)
+		return
+	}
+	e.wrap("$numberDouble", strconv.Quote(s))
+}
+
+func (e *extJSONEncoder) encodeDate(t time.Time) {
+	ms := t.Unix()*1e3 + int64(t.Nanosecond()/1e6)
+	if e.relaxed && t.Year() >= 1970 && t.Year() <= 9999 {
+		e.wrap("$date", strconv.Quote(t.UTC().Format(JSON_DATE_FORMAT)))
+		return
+	}
+	e.wrap("$date", fmt.Sprintf(`{"$numberLong":"%v"}`, ms))
+}
+
+func (e *extJSONEncoder) encodeBinary(kind byte, data []byte) {
+	fmt.Fprintf(e, `{"$binary":{"base64":"%v","subType":"%02x"}}`,
+		base64.StdEncoding.EncodeToString(data), kind)
+}
+
+func (e *extJSONEncoder) encodeD(doc bson.D) error {
+	e.WriteString("{")
+	for i, elem := range doc {
+		if i > 0 {
+			e.WriteString(",")
+		}
+		if err := e.quote(elem.Name); err != nil {
+			return err
+		}
+		e.WriteString(":")
+		if err := e.encode(elem.Value); err != nil {
+			return err
+		}
+	}
+	e.WriteString("}")
+	return nil
+}
+
+func (e *extJSONEncoder) encodeMap(doc map[string]interface{}) error {
+	// sort the keys so the output doesn't depend on map iteration order
+	keys := make([]string, 0, len(doc))
+	for key := range doc {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	d := make(bson.D, 0, len(doc))
+	for _, key := range keys {
+		d = append(d, bson.DocElem{Name: key, Value: doc[key]})
+	}
+	return e.encodeD(d)
+}
+
+func (e *extJSONEncoder) encodeArray(array []interface{}) error {
+	e.WriteString("[")
+	for i, value := range array {
+		if i > 0 {
+			e.WriteString(",")
+		}
+		if err := e.encode(value); err != nil {
+			return err
+		}
+	}
+	e.WriteString("]")
+	return nil
+}
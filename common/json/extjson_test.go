@@ -0,0 +1,95 @@
+package json
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/mgo.v2/bson"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestMarshalExtJSON(t *testing.T) {
+
+	Convey("When marshalling BSON values to Extended JSON v2", t, func() {
+		oid := bson.ObjectIdHex("57e193d7a9cc81b4027498b5")
+		decimal, err := bson.ParseDecimal128("1.5")
+		So(err, ShouldBeNil)
+		decimalInf, _ := bson.ParseDecimal128("Inf")
+		decimalNegInf, _ := bson.ParseDecimal128("-Inf")
+		decimalNaN, _ := bson.ParseDecimal128("NaN")
+		date := time.Unix(1356351330, 501e6)
+		oldDate := time.Unix(-2208988800, 0)
+
+		tests := []struct {
+			value     interface{}
+			canonical string
+			relaxed   string
+		}{
+			{int32(42), `{"$numberInt":"42"}`, `42`},
+			{int(-7), `{"$numberInt":"-7"}`, `-7`},
+			{int64(1) << 40, `{"$numberLong":"1099511627776"}`, `1099511627776`},
+			{float64(1), `{"$numberDouble":"1.0"}`, `1.0`},
+			{float64(-0.5), `{"$numberDouble":"-0.5"}`, `-0.5`},
+			{1e300, `{"$numberDouble":"1E+300"}`, `1E+300`},
+			{math.Copysign(0, -1), `{"$numberDouble":"-0.0"}`, `-0.0`},
+			{math.Inf(1), `{"$numberDouble":"Infinity"}`, `{"$numberDouble":"Infinity"}`},
+			{math.Inf(-1), `{"$numberDouble":"-Infinity"}`, `{"$numberDouble":"-Infinity"}`},
+			{math.NaN(), `{"$numberDouble":"NaN"}`, `{"$numberDouble":"NaN"}`},
+			{"a\"b", `"a\"b"`, `"a\"b"`},
+			{true, `true`, `true`},
+			{nil, `null`, `null`},
+			{oid, `{"$oid":"57e193d7a9cc81b4027498b5"}`, `{"$oid":"57e193d7a9cc81b4027498b5"}`},
+			{date, `{"$date":{"$numberLong":"1356351330501"}}`, `{"$date":"2012-12-24T12:15:30.501Z"}`},
+			{oldDate, `{"$date":{"$numberLong":"-2208988800000"}}`, `{"$date":{"$numberLong":"-2208988800000"}}`},
+			{[]byte("hi"), `{"$binary":{"base64":"aGk=","subType":"00"}}`, `{"$binary":{"base64":"aGk=","subType":"00"}}`},
+			{bson.Binary{Kind: 0x80, Data: []byte("hi")}, `{"$binary":{"base64":"aGk=","subType":"80"}}`, `{"$binary":{"base64":"aGk=","subType":"80"}}`},
+			{bson.RegEx{Pattern: "^a/", Options: "mi"}, `{"$regularExpression":{"pattern":"^a/","options":"im"}}`, `{"$regularExpression":{"pattern":"^a/","options":"im"}}`},
+			{bson.JavaScript{Code: "f()"}, `{"$code":"f()"}`, `{"$code":"f()"}`},
+			{bson.JavaScript{Code: "f(x)", Scope: bson.M{"x": 1}}, `{"$code":"f(x)","$scope":{"x":{"$numberInt":"1"}}}`, `{"$code":"f(x)","$scope":{"x":1}}`},
+			{bson.Symbol("sym"), `{"$symbol":"sym"}`, `{"$symbol":"sym"}`},
+			{bson.DBPointer{Namespace: "db.c", Id: oid}, `{"$dbPointer":{"$ref":"db.c","$id":{"$oid":"57e193d7a9cc81b4027498b5"}}}`, `{"$dbPointer":{"$ref":"db.c","$id":{"$oid":"57e193d7a9cc81b4027498b5"}}}`},
+			{bson.MongoTimestamp(int64(123)<<32 | 4), `{"$timestamp":{"t":123,"i":4}}`, `{"$timestamp":{"t":123,"i":4}}`},
+			{decimal, `{"$numberDecimal":"1.5"}`, `{"$numberDecimal":"1.5"}`},
+			{decimalInf, `{"$numberDecimal":"Infinity"}`, `{"$numberDecimal":"Infinity"}`},
+			{decimalNegInf, `{"$numberDecimal":"-Infinity"}`, `{"$numberDecimal":"-Infinity"}`},
+			{decimalNaN, `{"$numberDecimal":"NaN"}`, `{"$numberDecimal":"NaN"}`},
+			{bson.MinKey, `{"$minKey":1}`, `{"$minKey":1}`},
+			{bson.MaxKey, `{"$maxKey":1}`, `{"$maxKey":1}`},
+			{bson.Undefined, `{"$undefined":true}`, `{"$undefined":true}`},
+			{[]interface{}{1, "a"}, `[{"$numberInt":"1"},"a"]`, `[1,"a"]`},
+			{bson.D{{"b", 1}, {"a", bson.D{}}}, `{"b":{"$numberInt":"1"},"a":{}}`, `{"b":1,"a":{}}`},
+		}
+
+		Convey("the canonical format should keep every type", func() {
+			for _, test := range tests {
+				out, err := MarshalExtJSON(test.value, Canonical)
+				So(err, ShouldBeNil)
+				So(string(out), ShouldEqual, test.canonical)
+			}
+		})
+
+		Convey("the relaxed format should use plain JSON numbers and dates", func() {
+			for _, test := range tests {
+				out, err := MarshalExtJSON(test.value, Relaxed)
+				So(err, ShouldBeNil)
+				So(string(out), ShouldEqual, test.relaxed)
+			}
+		})
+
+		Convey("the legacy format should be rejected", func() {
+			_, err := MarshalExtJSON(bson.D{}, Legacy)
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("When parsing Extended JSON format names", t, func() {
+		format, err := ParseExtJSONFormat("relaxed")
+		So(err, ShouldBeNil)
+		So(format, ShouldEqual, Relaxed)
+		format, err = ParseExtJSONFormat("")
+		So(err, ShouldBeNil)
+		So(format, ShouldEqual, Legacy)
+		_, err = ParseExtJSONFormat("strict")
+		So(err, ShouldNotBeNil)
+	})
+}
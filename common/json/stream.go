@@ -107,6 +107,33 @@ func (dec *Decoder) Buffered() io.Reader {
 	return bytes.NewReader(dec.Buf)
 }
 
+// Peek returns the next n bytes of the input without consuming them, reading
+// more of the input if fewer are buffered. It returns fewer than n bytes only
+// along with the error that stopped the read, io.EOF at the end of the input.
+// The bytes are valid until the next call to the Decoder.
+func (dec *Decoder) Peek(n int) ([]byte, error) {
+	for len(dec.Buf) < n {
+		const minRead = 512
+		if cap(dec.Buf)-len(dec.Buf) < minRead {
+			newBuf := make([]byte, len(dec.Buf), 2*cap(dec.Buf)+minRead)
+			copy(newBuf, dec.Buf)
+			dec.Buf = newBuf
+		}
+		read, err := dec.R.Read(dec.Buf[len(dec.Buf):cap(dec.Buf)])
+		dec.Buf = dec.Buf[0 : len(dec.Buf)+read]
+		if err != nil && len(dec.Buf) < n {
+			return dec.Buf, err
+		}
+	}
+	return dec.Buf[0:n], nil
+}
+
+// Discard skips the next n bytes of the input, which must have been returned
+// by Peek.
+func (dec *Decoder) Discard(n int) {
+	dec.Buf = dec.Buf[n:]
+}
+
 // readValue reads a JSON value into dec.Buf.
 // It returns the length of the encoding.
 func (dec *Decoder) readValue() (int, error) {
@@ -6,6 +6,7 @@ package json
 
 import (
 	"bytes"
+	"io"
 	"io/ioutil"
 	"net"
 	"reflect"
@@ -121,6 +122,29 @@ func nlines(s string, n int) string {
 	return s
 }
 
+func TestDecoderPeek(t *testing.T) {
+	d := NewDecoder(strings.NewReader(`[{"a": 1}]`))
+	b, err := d.Peek(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "[" {
+		t.Errorf("Peek = %q; want %q", b, "[")
+	}
+	d.Discard(1)
+	obj, err := d.ScanObject()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g, w := string(obj), `{"a": 1}`; g != w {
+		t.Errorf("ScanObject = %q; want %q", g, w)
+	}
+	b, err = d.Peek(2)
+	if err != io.EOF || string(b) != "]" {
+		t.Errorf("Peek = %q, %v; want %q, EOF", b, err, "]")
+	}
+}
+
 func TestRawMessage(t *testing.T) {
 	// TODO(rsc): Should not need the * in *RawMessage
 	var data struct {
@@ -3,6 +3,8 @@ package text
 import (
 	"fmt"
 	"math"
+	"strconv"
+	"strings"
 )
 
 const (
@@ -36,6 +38,31 @@ func FormatBits(size int64) string {
 	return formatUnitAmount(decimal, size, 3, shortBitUnits)
 }
 
+// ParseByteAmount parses a size in bytes with an optional unit, using the
+// same binary units as FormatByteAmount, and returns the number of bytes.
+//  e.g. 512, 64KB, 1.5G, 50GB, 2TB
+func ParseByteAmount(amount string) (int64, error) {
+	number := strings.ToUpper(strings.TrimSpace(amount))
+	number = strings.TrimSuffix(number, "B")
+	multiplier := float64(1)
+	for i, unit := range []string{"K", "M", "G", "T"} {
+		if strings.HasSuffix(number, unit) {
+			number = strings.TrimSuffix(number, unit)
+			multiplier = math.Pow(binary, float64(i+1))
+			break
+		}
+	}
+	size, err := strconv.ParseFloat(strings.TrimSpace(number), 64)
+	if err != nil || size < 0 || math.IsInf(size, 0) || math.IsNaN(size) {
+		return 0, fmt.Errorf("invalid size '%v'", amount)
+	}
+	size *= multiplier
+	if size >= math.MaxInt64 {
+		return 0, fmt.Errorf("size '%v' is too large", amount)
+	}
+	return int64(size), nil
+}
+
 // formatUnitAmount formats the size using the units and at least minDigits
 // numbers, unless the number is already less than the base, where no decimal
 // will be added
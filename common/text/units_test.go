@@ -100,3 +100,36 @@ func TestBitFormatPrecision(t *testing.T) {
 		})
 	})
 }
+
+func TestParseByteAmount(t *testing.T) {
+	Convey("With some sample byte amounts", t, func() {
+		Convey("plain numbers should be bytes", func() {
+			size, err := ParseByteAmount("512")
+			So(err, ShouldBeNil)
+			So(size, ShouldEqual, 512)
+			size, err = ParseByteAmount("512B")
+			So(err, ShouldBeNil)
+			So(size, ShouldEqual, 512)
+		})
+		Convey("units should be binary and case insensitive", func() {
+			size, err := ParseByteAmount("64KB")
+			So(err, ShouldBeNil)
+			So(size, ShouldEqual, 64*1024)
+			size, err = ParseByteAmount("1.5g")
+			So(err, ShouldBeNil)
+			So(size, ShouldEqual, 1536*1024*1024)
+			size, err = ParseByteAmount("50GB")
+			So(err, ShouldBeNil)
+			So(size, ShouldEqual, 50*1024*1024*1024)
+			size, err = ParseByteAmount("2TB")
+			So(err, ShouldBeNil)
+			So(size, ShouldEqual, 2*1024*1024*1024*1024)
+		})
+		Convey("invalid amounts should be rejected", func() {
+			for _, amount := range []string{"", "GB", "-1MB", "ten", "5PB"} {
+				_, err := ParseByteAmount(amount)
+				So(err, ShouldNotBeNil)
+			}
+		})
+	})
+}
@@ -0,0 +1,255 @@
+package mongoarchive
+
+import (
+	"fmt"
+
+	"github.com/mongodb/mongo-tools/common/archive"
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/options"
+	"github.com/mongodb/mongo-tools/common/util"
+)
+
+// muxOut implements DemuxOut. It forwards the documents of one namespace
+// of an input archive to a MuxIn of the output archive. The MuxIn is only
+// opened once the namespace is first seen, since every open MuxIn holds
+// a buffer the size of the largest possible BSON document.
+type muxOut struct {
+	checksum
+	muxIn  *archive.MuxIn
+	opened bool
+	err    error
+}
+
+// Write is part of the DemuxOut interface.
+func (out *muxOut) Write(buf []byte) (int, error) {
+	if err := out.open(); err != nil {
+		return 0, err
+	}
+	out.hash.Write(buf)
+	return out.muxIn.Write(buf)
+}
+
+// Close is part of the DemuxOut interface. The demultiplexer ignores
+// the error returned here, so it is also kept for copyArchive to check.
+func (out *muxOut) Close() error {
+	if out.err = out.open(); out.err != nil {
+		return out.err
+	}
+	out.err = out.muxIn.Close()
+	return out.err
+}
+
+func (out *muxOut) open() error {
+	if out.opened {
+		return nil
+	}
+	out.opened = true
+	return out.muxIn.Open()
+}
+
+// copyArchive multiplexes the namespaces of an opened archive for which include returns
+// true into mux, reading through and verifying the remaining namespaces.
+func copyArchive(input *archiveInput, mux *archive.Multiplexer,
+	include func(*archive.CollectionMetadata) bool) error {
+
+	demux := archive.CreateDemux(input.Prelude.NamespaceMetadatas, input.In)
+	outs := []*muxOut{}
+	for _, cm := range input.Prelude.NamespaceMetadatas {
+		namespace := cm.Database + "." + cm.Collection
+		if !include(cm) {
+			demux.Open(namespace, &archive.MutedCollection{})
+			continue
+		}
+		log.Logvf(log.Info, "copying %v from %v", displayName(cm.Database, cm.Collection), input.Name)
+		out := &muxOut{
+			checksum: newChecksum(),
+			muxIn: &archive.MuxIn{
+				Intent: &intents.Intent{DB: cm.Database, C: cm.Collection},
+				Mux:    mux,
+			},
+		}
+		demux.Open(namespace, out)
+		outs = append(outs, out)
+	}
+	if err := demux.Run(); err != nil {
+		return fmt.Errorf("error reading archive %v: %v", input.Name, err)
+	}
+	for _, out := range outs {
+		if out.err != nil {
+			return fmt.Errorf("error writing %v to archive: %v", out.muxIn.Intent.Namespace(), out.err)
+		}
+	}
+	return nil
+}
+
+// writeArchive creates the output archive, writes the prelude, and runs write with
+// a multiplexer writing into the archive. The shutdown chan passed to write is
+// closed if the multiplexer fails, after which there is no point in writing more.
+func (ma *MongoArchive) writeArchive(prelude *archive.Prelude,
+	write func(mux *archive.Multiplexer, shutdown <-chan struct{}) error) (err error) {
+
+	out, err := ma.createArchive()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		closeErr := out.Close()
+		if err == nil && closeErr != nil {
+			err = fmt.Errorf("error writing archive: %v", closeErr)
+		}
+	}()
+	if err = prelude.Write(out); err != nil {
+		return fmt.Errorf("error writing metadata into archive: %v", err)
+	}
+
+	shutdown := newNotifier()
+	mux := archive.NewMultiplexer(&nopCloseWriter{out}, shutdown)
+	go mux.Run()
+	err = write(mux, shutdown.notified)
+	close(mux.Control)
+	muxErr := <-mux.Completed
+	if err != nil {
+		return err
+	}
+	if muxErr != nil {
+		return fmt.Errorf("archive writer: %v", muxErr)
+	}
+	return nil
+}
+
+// Filter copies an archive, keeping only the namespaces selected with
+// --nsInclude and --nsExclude.
+func (ma *MongoArchive) Filter() error {
+	input, err := ma.openArchive(ma.Inputs[0])
+	if err != nil {
+		return err
+	}
+	defer input.In.Close()
+
+	header := *input.Prelude.Header
+	header.ToolVersion = options.VersionStr
+	prelude := &archive.Prelude{Header: &header}
+	for _, cm := range input.Prelude.NamespaceMetadatas {
+		if ma.selected(cm.Database, cm.Collection) {
+			prelude.AddMetadata(cm)
+		}
+	}
+
+	err = ma.writeArchive(prelude, func(mux *archive.Multiplexer, _ <-chan struct{}) error {
+		return copyArchive(input, mux, func(cm *archive.CollectionMetadata) bool {
+			return ma.selected(cm.Database, cm.Collection)
+		})
+	})
+	if err != nil {
+		return err
+	}
+	log.Logvf(log.Always, "wrote %v %v to %v", len(prelude.NamespaceMetadatas),
+		namespacePlural(len(prelude.NamespaceMetadatas)), ma.outputName())
+	return nil
+}
+
+// mergePhase returns in which pass of a merge a namespace is copied.
+// mongorestore expects the special collections to come first in an archive,
+// and the oplog to come last, which merge preserves by reading each of its
+// inputs once for every phase the input has namespaces in.
+func mergePhase(cm *archive.CollectionMetadata) int {
+	intent := &intents.Intent{DB: cm.Database, C: cm.Collection}
+	switch {
+	case intent.IsSpecialCollection():
+		return 0
+	case intent.IsOplog():
+		return 2
+	}
+	return 1
+}
+
+const mergePhases = 3
+
+// Merge combines the selected namespaces of several archives into one archive.
+// A namespace may only be found in one of the archives.
+func (ma *MongoArchive) Merge() error {
+	prelude := &archive.Prelude{}
+	sources := map[string]string{}
+	// phases[i] holds the inputs that have namespaces to copy in phase i
+	phases := make([][]string, mergePhases)
+	for _, name := range ma.Inputs {
+		input, err := ma.openArchive(name)
+		if err != nil {
+			return err
+		}
+		input.In.Close()
+
+		header := input.Prelude.Header
+		if prelude.Header == nil {
+			merged := *header
+			merged.ToolVersion = options.VersionStr
+			prelude.Header = &merged
+		} else {
+			if header.ServerVersion != prelude.Header.ServerVersion {
+				log.Logvf(log.Always, "warning, archive %v was dumped from server version %v, "+
+					"the merged archive will record server version %v",
+					name, header.ServerVersion, prelude.Header.ServerVersion)
+			}
+			if header.ConcurrentCollections > prelude.Header.ConcurrentCollections {
+				prelude.Header.ConcurrentCollections = header.ConcurrentCollections
+			}
+		}
+
+		inPhase := make([]bool, mergePhases)
+		for _, cm := range input.Prelude.NamespaceMetadatas {
+			if !ma.selected(cm.Database, cm.Collection) {
+				continue
+			}
+			namespace := displayName(cm.Database, cm.Collection)
+			if source, ok := sources[namespace]; ok {
+				return fmt.Errorf("%v is found in both archive %v and archive %v", namespace, source, name)
+			}
+			sources[namespace] = name
+			prelude.AddMetadata(cm)
+			inPhase[mergePhase(cm)] = true
+		}
+		for phase := range phases {
+			if inPhase[phase] {
+				phases[phase] = append(phases[phase], name)
+			}
+		}
+	}
+
+	err := ma.writeArchive(prelude, func(mux *archive.Multiplexer, shutdown <-chan struct{}) error {
+		for phase, names := range phases {
+			for _, name := range names {
+				select {
+				case <-shutdown:
+					// the multiplexer failed; writeArchive reports its error
+					return nil
+				default:
+				}
+				input, err := ma.openArchive(name)
+				if err != nil {
+					return err
+				}
+				err = copyArchive(input, mux, func(cm *archive.CollectionMetadata) bool {
+					return mergePhase(cm) == phase && ma.selected(cm.Database, cm.Collection)
+				})
+				input.In.Close()
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	log.Logvf(log.Always, "merged %v %v from %v archives into %v", len(prelude.NamespaceMetadatas),
+		namespacePlural(len(prelude.NamespaceMetadatas)), len(ma.Inputs), ma.outputName())
+	return nil
+}
+
+// namespacePlural returns "namespace" or "namespaces" depending on the
+// count of namespaces passed in.
+func namespacePlural(count int) string {
+	return util.Pluralize(count, "namespace", "namespaces")
+}
@@ -0,0 +1,198 @@
+package mongoarchive
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/mongodb/mongo-tools/common/archive"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/util"
+)
+
+// extractedFile implements DemuxOut. It writes the documents of one namespace
+// to a .bson file in the dump directory. The file is only created once the
+// namespace is first seen, so that at most as many files are open as there
+// are interleaved namespaces in the archive.
+type extractedFile struct {
+	checksum
+	path      string
+	gzip      bool
+	namespace string
+	file      *os.File
+	out       io.WriteCloser
+	documents int64
+	err       error
+}
+
+// open creates the file on disk, and any directories needed.
+func (f *extractedFile) open() error {
+	err := os.MkdirAll(filepath.Dir(f.path), os.ModeDir|os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("error creating directory for BSON file %v: %v", filepath.Dir(f.path), err)
+	}
+	f.file, err = os.Create(f.path)
+	if err != nil {
+		return fmt.Errorf("error creating BSON file %v: %v", f.path, err)
+	}
+	if f.gzip {
+		f.out = gzip.NewWriter(f.file)
+	} else {
+		f.out = &closableBufioWriter{bufio.NewWriter(f.file)}
+	}
+	log.Logvf(log.Always, "writing %v to %v", f.namespace, f.path)
+	return nil
+}
+
+// Write is part of the DemuxOut interface.
+func (f *extractedFile) Write(buf []byte) (int, error) {
+	if f.file == nil {
+		if err := f.open(); err != nil {
+			return 0, err
+		}
+	}
+	f.hash.Write(buf)
+	f.documents++
+	return f.out.Write(buf)
+}
+
+// Close is part of the DemuxOut interface. The demultiplexer ignores
+// the error returned here, so it is also kept for Extract to check.
+func (f *extractedFile) Close() error {
+	if f.file == nil {
+		if f.err = f.open(); f.err != nil {
+			return f.err
+		}
+	}
+	if f.err = f.out.Close(); f.err == nil {
+		f.err = f.file.Close()
+	} else {
+		f.file.Close()
+	}
+	if f.err != nil {
+		f.err = fmt.Errorf("error writing BSON file %v: %v", f.path, f.err)
+		return f.err
+	}
+	log.Logvf(log.Always, "done extracting %v (%v %v)", f.namespace, f.documents, docPlural(f.documents))
+	return nil
+}
+
+type closableBufioWriter struct {
+	*bufio.Writer
+}
+
+func (w *closableBufioWriter) Close() error {
+	return w.Flush()
+}
+
+// extractPath creates a path in the dump directory for a namespace (sans file extension),
+// using the same layout as mongodump.
+func (ma *MongoArchive) extractPath(db, collection string) string {
+	root := util.ToUniversalPath(ma.OutputOptions.Out)
+	if root == "" {
+		root = "dump"
+	}
+	if db == "" {
+		return filepath.Join(root, collection)
+	}
+	return filepath.Join(root, db, collection)
+}
+
+// nameGz adds a .gz extension when extracting with --gzip.
+func (ma *MongoArchive) nameGz(path string) string {
+	if ma.OutputOptions.Gzip {
+		return path + ".gz"
+	}
+	return path
+}
+
+// writeMetadataFile writes the metadata of a namespace from the prelude into the dump directory.
+func (ma *MongoArchive) writeMetadataFile(path, metadata string) error {
+	err := os.MkdirAll(filepath.Dir(path), os.ModeDir|os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("error creating directory for metadata file %v: %v", filepath.Dir(path), err)
+	}
+	if !ma.OutputOptions.Gzip {
+		err = ioutil.WriteFile(path, []byte(metadata), 0644)
+		if err != nil {
+			return fmt.Errorf("error writing metadata file %v: %v", path, err)
+		}
+		return nil
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("error creating metadata file %v: %v", path, err)
+	}
+	defer file.Close()
+	gzipWriter := gzip.NewWriter(file)
+	if _, err = io.WriteString(gzipWriter, metadata); err == nil {
+		err = gzipWriter.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("error writing metadata file %v: %v", path, err)
+	}
+	return nil
+}
+
+// Extract writes the selected namespaces of an archive into a dump directory
+// that mongorestore can restore from.
+func (ma *MongoArchive) Extract() error {
+	input, err := ma.openArchive(ma.Inputs[0])
+	if err != nil {
+		return err
+	}
+	defer input.In.Close()
+
+	demux := archive.CreateDemux(input.Prelude.NamespaceMetadatas, input.In)
+	files := []*extractedFile{}
+	for _, cm := range input.Prelude.NamespaceMetadatas {
+		namespace := cm.Database + "." + cm.Collection
+		if !ma.selected(cm.Database, cm.Collection) {
+			log.Logvf(log.DebugLow, "skipping %v", namespace)
+			demux.Open(namespace, &archive.MutedCollection{})
+			continue
+		}
+		var c rune
+		if checkStringForPathSeparator(cm.Collection, &c) || checkStringForPathSeparator(cm.Database, &c) {
+			return fmt.Errorf(`"%v" contains a path separator '%c' `+
+				`and can't be extracted to the filesystem`, namespace, c)
+		}
+		if cm.Metadata != "" {
+			path := ma.nameGz(ma.extractPath(cm.Database, cm.Collection+".metadata.json"))
+			if err = ma.writeMetadataFile(path, cm.Metadata); err != nil {
+				return err
+			}
+		}
+		file := &extractedFile{
+			checksum:  newChecksum(),
+			path:      ma.nameGz(ma.extractPath(cm.Database, cm.Collection) + ".bson"),
+			gzip:      ma.OutputOptions.Gzip,
+			namespace: displayName(cm.Database, cm.Collection),
+		}
+		demux.Open(namespace, file)
+		files = append(files, file)
+	}
+
+	if err = demux.Run(); err != nil {
+		return fmt.Errorf("error reading archive %v: %v", input.Name, err)
+	}
+	for _, file := range files {
+		if file.err != nil {
+			return file.err
+		}
+	}
+	return nil
+}
+
+func checkStringForPathSeparator(s string, c *rune) bool {
+	for _, *c = range s {
+		if os.IsPathSeparator(uint8(*c)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,135 @@
+package mongoarchive
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/mongodb/mongo-tools/common/archive"
+	"github.com/mongodb/mongo-tools/common/text"
+)
+
+// namespaceStats implements DemuxOut. It tallies the documents and bytes
+// the demultiplexer finds for a single namespace.
+type namespaceStats struct {
+	checksum
+	Database    string
+	Collection  string
+	HasMetadata bool
+	Documents   int64
+	Bytes       int64
+}
+
+// Write is part of the DemuxOut interface.
+func (stats *namespaceStats) Write(buf []byte) (int, error) {
+	stats.hash.Write(buf)
+	stats.Documents++
+	stats.Bytes += int64(len(buf))
+	return len(buf), nil
+}
+
+// Close is part of the DemuxOut interface, and does nothing
+func (*namespaceStats) Close() error {
+	return nil
+}
+
+// displayName returns the namespace, or just the collection name for
+// collections that don't belong to a database, such as the oplog.
+func displayName(db, collection string) string {
+	if db == "" {
+		return collection
+	}
+	return db + "." + collection
+}
+
+// List reads through an entire archive, verifying the checksum of every namespace,
+// and writes the archive versions along with the document count and size of
+// every selected namespace.
+func (ma *MongoArchive) List() error {
+	input, err := ma.openArchive(ma.Inputs[0])
+	if err != nil {
+		return err
+	}
+	defer input.In.Close()
+
+	allStats, err := ma.readStats(input)
+	if err != nil {
+		return err
+	}
+
+	header := input.Prelude.Header
+	gw := &text.GridWriter{ColumnPadding: 2}
+	gw.WriteCells("format version:", header.FormatVersion)
+	gw.EndRow()
+	gw.WriteCells("server version:", header.ServerVersion)
+	gw.EndRow()
+	gw.WriteCells("tool version:", header.ToolVersion)
+	gw.EndRow()
+	gw.WriteCells("concurrent collections:", fmt.Sprintf("%v", header.ConcurrentCollections))
+	gw.EndRow()
+	gw.Flush(ma.OutputWriter)
+	fmt.Fprintf(ma.OutputWriter, "\n\n")
+
+	var totalDocuments, totalBytes int64
+	gw = &text.GridWriter{ColumnPadding: 2}
+	gw.WriteCells("namespace", "documents", "bytes", "metadata")
+	gw.EndRow()
+	for _, stats := range allStats {
+		metadata := "no"
+		if stats.HasMetadata {
+			metadata = "yes"
+		}
+		gw.WriteCells(
+			displayName(stats.Database, stats.Collection),
+			fmt.Sprintf("%v", stats.Documents),
+			fmt.Sprintf("%v", stats.Bytes),
+			metadata,
+		)
+		gw.EndRow()
+		totalDocuments += stats.Documents
+		totalBytes += stats.Bytes
+	}
+	gw.WriteCells("total", fmt.Sprintf("%v", totalDocuments), fmt.Sprintf("%v", totalBytes))
+	gw.EndRow()
+	gw.Flush(ma.OutputWriter)
+	fmt.Fprintf(ma.OutputWriter, "\n")
+	return nil
+}
+
+// byNamespace sorts namespaceStats by database and then collection name.
+type byNamespace []*namespaceStats
+
+func (s byNamespace) Len() int      { return len(s) }
+func (s byNamespace) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byNamespace) Less(i, j int) bool {
+	if s[i].Database != s[j].Database {
+		return s[i].Database < s[j].Database
+	}
+	return s[i].Collection < s[j].Collection
+}
+
+// readStats demultiplexes an opened archive and returns the statistics of
+// every selected namespace, sorted by namespace.
+func (ma *MongoArchive) readStats(input *archiveInput) ([]*namespaceStats, error) {
+	demux := archive.CreateDemux(input.Prelude.NamespaceMetadatas, input.In)
+	allStats := []*namespaceStats{}
+	for _, cm := range input.Prelude.NamespaceMetadatas {
+		namespace := cm.Database + "." + cm.Collection
+		if !ma.selected(cm.Database, cm.Collection) {
+			demux.Open(namespace, &archive.MutedCollection{})
+			continue
+		}
+		stats := &namespaceStats{
+			checksum:    newChecksum(),
+			Database:    cm.Database,
+			Collection:  cm.Collection,
+			HasMetadata: cm.Metadata != "",
+		}
+		demux.Open(namespace, stats)
+		allStats = append(allStats, stats)
+	}
+	if err := demux.Run(); err != nil {
+		return nil, fmt.Errorf("error reading archive %v: %v", input.Name, err)
+	}
+	sort.Sort(byNamespace(allStats))
+	return allStats, nil
+}
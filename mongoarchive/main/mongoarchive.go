@@ -0,0 +1,57 @@
+// Main package for the mongoarchive tool.
+package main
+
+import (
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/options"
+	"github.com/mongodb/mongo-tools/common/signals"
+	"github.com/mongodb/mongo-tools/common/util"
+	"github.com/mongodb/mongo-tools/mongoarchive"
+	"os"
+)
+
+func main() {
+	// initialize command-line opts
+	opts := options.New("mongoarchive", mongoarchive.Usage, options.EnabledOptions{})
+
+	outputOpts := &mongoarchive.OutputOptions{}
+	opts.AddOptions(outputOpts)
+	nsOpts := &mongoarchive.NSOptions{}
+	opts.AddOptions(nsOpts)
+
+	args, err := opts.Parse()
+	if err != nil {
+		log.Logvf(log.Always, "error parsing command line options: %v", err)
+		log.Logvf(log.Always, "try 'mongoarchive --help' for more information")
+		os.Exit(util.ExitBadOptions)
+	}
+
+	// print help, if specified
+	if opts.PrintHelp(false) {
+		return
+	}
+
+	// print version, if specified
+	if opts.PrintVersion() {
+		return
+	}
+	log.SetVerbosity(opts.Verbosity)
+	signals.Handle()
+
+	ma := mongoarchive.MongoArchive{
+		ToolOptions:   opts,
+		OutputOptions: outputOpts,
+		NSOptions:     nsOpts,
+	}
+
+	if err := ma.ValidateCommand(args); err != nil {
+		log.Logvf(log.Always, "%v", err)
+		log.Logvf(log.Always, "try 'mongoarchive --help' for more information")
+		os.Exit(util.ExitBadOptions)
+	}
+
+	if err := ma.Run(); err != nil {
+		log.Logvf(log.Always, "Failed: %v", err)
+		os.Exit(util.ExitError)
+	}
+}
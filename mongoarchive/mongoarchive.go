@@ -0,0 +1,260 @@
+// Package mongoarchive inspects and manipulates mongodump archives without a running server.
+package mongoarchive
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"hash"
+	"hash/crc64"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/mongodb/mongo-tools/common/archive"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/options"
+	"github.com/mongodb/mongo-tools/common/util"
+	"github.com/mongodb/mongo-tools/mongorestore/ns"
+)
+
+// List of possible commands for mongoarchive.
+const (
+	List    = "list"
+	Extract = "extract"
+	Pack    = "pack"
+	Filter  = "filter"
+	Merge   = "merge"
+)
+
+// MongoArchive is a container for the user-specified options and
+// internal state used for running mongoarchive.
+type MongoArchive struct {
+	// generic mongo tool options
+	ToolOptions *options.ToolOptions
+
+	// mongoarchive-specific output options
+	OutputOptions *OutputOptions
+
+	// mongoarchive-specific namespace options
+	NSOptions *NSOptions
+
+	// command to run
+	Command string
+
+	// archives, or the dump directory for pack, given as positional arguments
+	Inputs []string
+
+	// Reader to take care of archive input when reading from stdin.
+	// This is initialized to os.Stdin if unset.
+	InputReader io.Reader
+
+	// Writer to take care of archive output when writing to stdout.
+	// This is initialized to os.Stdout if unset.
+	OutputWriter io.Writer
+
+	includer *ns.Matcher
+	excluder *ns.Matcher
+}
+
+// ValidateCommand ensures the arguments supplied are valid.
+func (ma *MongoArchive) ValidateCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("no command specified")
+	}
+
+	inputs := args[1:]
+	switch args[0] {
+	case List, Extract, Filter:
+		if len(inputs) > 1 {
+			return fmt.Errorf("too many positional arguments")
+		}
+		if len(inputs) == 0 {
+			inputs = []string{"-"}
+		}
+	case Pack:
+		if len(inputs) != 1 || inputs[0] == "" {
+			return fmt.Errorf("'%v' requires a dump directory", args[0])
+		}
+	case Merge:
+		if len(inputs) < 2 {
+			return fmt.Errorf("'%v' requires at least two archives", args[0])
+		}
+		for _, input := range inputs {
+			if input == "-" {
+				return fmt.Errorf("'%v' can not read archives from stdin", args[0])
+			}
+		}
+	default:
+		return fmt.Errorf("'%v' is not a valid command", args[0])
+	}
+
+	if args[0] == Extract && ma.OutputOptions.Out == "-" {
+		return fmt.Errorf("'%v' can not write a dump directory to stdout", args[0])
+	}
+	if args[0] == List && ma.OutputOptions.Out != "" {
+		return fmt.Errorf("--out is not allowed with '%v'", args[0])
+	}
+
+	includes := ma.NSOptions.NSInclude
+	if len(includes) == 0 {
+		includes = []string{"*"}
+	}
+	var err error
+	ma.includer, err = ns.NewMatcher(includes)
+	if err != nil {
+		return fmt.Errorf("invalid includes: %v", err)
+	}
+	ma.excluder, err = ns.NewMatcher(ma.NSOptions.NSExclude)
+	if err != nil {
+		return fmt.Errorf("invalid excludes: %v", err)
+	}
+
+	// set the mongoarchive command and inputs
+	ma.Command = args[0]
+	ma.Inputs = inputs
+	return nil
+}
+
+// Run executes the command that was set by ValidateCommand.
+func (ma *MongoArchive) Run() error {
+	if ma.InputReader == nil {
+		ma.InputReader = os.Stdin
+	}
+	if ma.OutputWriter == nil {
+		ma.OutputWriter = os.Stdout
+	}
+
+	switch ma.Command {
+	case List:
+		return ma.List()
+	case Extract:
+		return ma.Extract()
+	case Pack:
+		return ma.Pack()
+	case Filter:
+		return ma.Filter()
+	case Merge:
+		return ma.Merge()
+	}
+	return fmt.Errorf("'%v' is not a valid command", ma.Command)
+}
+
+// selected returns true when a namespace passes the --nsInclude and --nsExclude filters.
+func (ma *MongoArchive) selected(db, collection string) bool {
+	namespace := db + "." + collection
+	return ma.includer.Has(namespace) && !ma.excluder.Has(namespace)
+}
+
+// archiveInput is an opened archive whose prelude has already been read.
+type archiveInput struct {
+	Name    string
+	In      io.ReadCloser
+	Prelude *archive.Prelude
+}
+
+// openArchive opens an archive, or stdin for '-', and reads its prelude.
+// Gzipped and multi-volume archives are read transparently.
+func (ma *MongoArchive) openArchive(name string) (*archiveInput, error) {
+	var rc io.ReadCloser
+	if name == "-" {
+		rc = ioutil.NopCloser(ma.InputReader)
+	} else {
+		file, err := archive.OpenFile(util.ToUniversalPath(name))
+		if err != nil {
+			return nil, fmt.Errorf("couldn't open archive: %v", err)
+		}
+		rc = file
+	}
+
+	// the archive magic number never starts with the gzip magic bytes,
+	// so peeking at the first two bytes is enough to tell them apart
+	buffered := bufio.NewReader(rc)
+	in := io.ReadCloser(&util.WrappedReadCloser{ReadCloser: ioutil.NopCloser(buffered), Inner: rc})
+	if magic, err := buffered.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gzipReader, err := gzip.NewReader(buffered)
+		if err != nil {
+			rc.Close()
+			return nil, fmt.Errorf("error decompressing archive %v: %v", name, err)
+		}
+		log.Logvf(log.DebugLow, "archive %v is gzipped", name)
+		in = &util.WrappedReadCloser{ReadCloser: gzipReader, Inner: rc}
+	}
+
+	prelude := &archive.Prelude{}
+	if err := prelude.Read(in); err != nil {
+		in.Close()
+		return nil, fmt.Errorf("error reading archive %v: %v", name, err)
+	}
+	log.Logvf(log.DebugLow, `archive format version "%v"`, prelude.Header.FormatVersion)
+	log.Logvf(log.DebugLow, `archive server version "%v"`, prelude.Header.ServerVersion)
+	log.Logvf(log.DebugLow, `archive tool version "%v"`, prelude.Header.ToolVersion)
+	return &archiveInput{Name: name, In: in, Prelude: prelude}, nil
+}
+
+// nopCloseWriter implements io.WriteCloser. It wraps up a io.Writer, and adds a no-op Close
+type nopCloseWriter struct {
+	io.Writer
+}
+
+// Close does nothing on nopCloseWriters
+func (*nopCloseWriter) Close() error {
+	return nil
+}
+
+// createArchive opens the --out archive for writing, or stdout if it is unset or '-'.
+func (ma *MongoArchive) createArchive() (io.WriteCloser, error) {
+	var out io.WriteCloser
+	if ma.OutputOptions.Out == "" || ma.OutputOptions.Out == "-" {
+		out = &nopCloseWriter{ma.OutputWriter}
+	} else {
+		file, err := os.Create(util.ToUniversalPath(ma.OutputOptions.Out))
+		if err != nil {
+			return nil, fmt.Errorf("couldn't create archive: %v", err)
+		}
+		out = file
+	}
+	if ma.OutputOptions.Gzip {
+		return &util.WrappedWriteCloser{WriteCloser: gzip.NewWriter(out), Inner: out}, nil
+	}
+	return out, nil
+}
+
+// outputName returns a human-readable name for the --out archive.
+func (ma *MongoArchive) outputName() string {
+	if ma.OutputOptions.Out == "" || ma.OutputOptions.Out == "-" {
+		return "stdout"
+	}
+	return fmt.Sprintf("'%v'", ma.OutputOptions.Out)
+}
+
+// checksum computes the same CRC that the multiplexer records in the EOF
+// header of every namespace, so that DemuxOuts can have it verified.
+type checksum struct {
+	hash hash.Hash64
+}
+
+func newChecksum() checksum {
+	return checksum{hash: crc64.New(crc64.MakeTable(crc64.ECMA))}
+}
+
+// Sum64 is part of the DemuxOut interface.
+func (c checksum) Sum64() (uint64, bool) {
+	return c.hash.Sum64(), true
+}
+
+type notifier struct {
+	notified chan struct{}
+	once     sync.Once
+}
+
+func (n *notifier) Notify() { n.once.Do(func() { close(n.notified) }) }
+
+func newNotifier() *notifier { return &notifier{notified: make(chan struct{})} }
+
+// docPlural returns "document" or "documents" depending on the
+// count of documents passed in.
+func docPlural(count int64) string {
+	return util.Pluralize(int(count), "document", "documents")
+}
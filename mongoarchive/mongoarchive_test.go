@@ -0,0 +1,194 @@
+package mongoarchive
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/options"
+	"github.com/mongodb/mongo-tools/common/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const testMetadata = `{"options":{},"indexes":[{"v":2,"key":{"_id":1},"name":"_id_","ns":"db1.c1"}]}`
+
+// newMongoArchive validates a command the same way the mongoarchive binary does.
+func newMongoArchive(out string, gzip bool, exclude []string, args ...string) (*MongoArchive, error) {
+	ma := &MongoArchive{
+		ToolOptions:   &options.ToolOptions{},
+		OutputOptions: &OutputOptions{Out: out, Gzip: gzip},
+		NSOptions:     &NSOptions{NSExclude: exclude},
+		OutputWriter:  &bytes.Buffer{},
+	}
+	return ma, ma.ValidateCommand(args)
+}
+
+// runCommand runs a mongoarchive command, returning what it wrote to stdout.
+func runCommand(out string, gzip bool, exclude []string, args ...string) (string, error) {
+	ma, err := newMongoArchive(out, gzip, exclude, args...)
+	if err != nil {
+		return "", err
+	}
+	err = ma.Run()
+	return ma.OutputWriter.(*bytes.Buffer).String(), err
+}
+
+// listedDocuments returns the document count that list shows for a namespace.
+func listedDocuments(output, namespace string) string {
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 1 && fields[0] == namespace {
+			return fields[1]
+		}
+	}
+	return ""
+}
+
+func writeBSONFile(path string, docs ...bson.M) []byte {
+	buf := &bytes.Buffer{}
+	for _, doc := range docs {
+		raw, err := bson.Marshal(doc)
+		So(err, ShouldBeNil)
+		buf.Write(raw)
+	}
+	So(os.MkdirAll(filepath.Dir(path), 0755), ShouldBeNil)
+	So(ioutil.WriteFile(path, buf.Bytes(), 0644), ShouldBeNil)
+	return buf.Bytes()
+}
+
+func TestValidateCommand(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+
+	Convey("With a MongoArchive", t, func() {
+		Convey("list, extract and filter should default to stdin", func() {
+			for _, command := range []string{List, Extract, Filter} {
+				ma, err := newMongoArchive("", false, nil, command)
+				So(err, ShouldBeNil)
+				So(ma.Inputs, ShouldResemble, []string{"-"})
+			}
+		})
+
+		Convey("pack should require a dump directory", func() {
+			_, err := newMongoArchive("", false, nil, Pack)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("merge should require two archives, none of them stdin", func() {
+			_, err := newMongoArchive("", false, nil, Merge, "a.archive")
+			So(err, ShouldNotBeNil)
+			_, err = newMongoArchive("", false, nil, Merge, "a.archive", "-")
+			So(err, ShouldNotBeNil)
+			_, err = newMongoArchive("", false, nil, Merge, "a.archive", "b.archive")
+			So(err, ShouldBeNil)
+		})
+
+		Convey("extract should not write to stdout", func() {
+			_, err := newMongoArchive("-", false, nil, Extract, "a.archive")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("unknown commands and extra arguments should be rejected", func() {
+			_, err := newMongoArchive("", false, nil, "unpack", "a.archive")
+			So(err, ShouldNotBeNil)
+			_, err = newMongoArchive("", false, nil, List, "a.archive", "b.archive")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestArchiveRoundTrip(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+
+	Convey("With a dump directory", t, func() {
+		tmp, err := ioutil.TempDir("", "mongoarchive")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(tmp)
+
+		dump := filepath.Join(tmp, "dump")
+		c1 := writeBSONFile(filepath.Join(dump, "db1", "c1.bson"),
+			bson.M{"_id": 1, "a": "x"}, bson.M{"_id": 2, "a": "y"}, bson.M{"_id": 3})
+		So(ioutil.WriteFile(filepath.Join(dump, "db1", "c1.metadata.json"), []byte(testMetadata), 0644), ShouldBeNil)
+		c2 := writeBSONFile(filepath.Join(dump, "db2", "c2.bson"), bson.M{"_id": "a"})
+		packed := filepath.Join(tmp, "packed.archive")
+
+		_, err = runCommand(packed, false, nil, Pack, dump)
+		So(err, ShouldBeNil)
+
+		Convey("listing it should show every namespace", func() {
+			output, err := runCommand("", false, nil, List, packed)
+			So(err, ShouldBeNil)
+			So(listedDocuments(output, "db1.c1"), ShouldEqual, "3")
+			So(listedDocuments(output, "db2.c2"), ShouldEqual, "1")
+			So(listedDocuments(output, "total"), ShouldEqual, "4")
+		})
+
+		Convey("extracting it should give back the same files", func() {
+			extracted := filepath.Join(tmp, "extracted")
+			_, err := runCommand(extracted, false, nil, Extract, packed)
+			So(err, ShouldBeNil)
+
+			content, err := ioutil.ReadFile(filepath.Join(extracted, "db1", "c1.bson"))
+			So(err, ShouldBeNil)
+			So(content, ShouldResemble, c1)
+			content, err = ioutil.ReadFile(filepath.Join(extracted, "db2", "c2.bson"))
+			So(err, ShouldBeNil)
+			So(content, ShouldResemble, c2)
+			content, err = ioutil.ReadFile(filepath.Join(extracted, "db1", "c1.metadata.json"))
+			So(err, ShouldBeNil)
+			So(string(content), ShouldEqual, testMetadata)
+		})
+
+		Convey("filtering it should drop the excluded namespaces", func() {
+			filtered := filepath.Join(tmp, "filtered.archive")
+			_, err := runCommand(filtered, false, []string{"db2.*"}, Filter, packed)
+			So(err, ShouldBeNil)
+			output, err := runCommand("", false, nil, List, filtered)
+			So(err, ShouldBeNil)
+			So(output, ShouldContainSubstring, "db1.c1")
+			So(output, ShouldNotContainSubstring, "db2.c2")
+
+			Convey("and merging it with the rest should restore every namespace", func() {
+				rest := filepath.Join(tmp, "rest.archive")
+				_, err := runCommand(rest, false, []string{"db1.*"}, Filter, packed)
+				So(err, ShouldBeNil)
+				merged := filepath.Join(tmp, "merged.archive")
+				_, err = runCommand(merged, false, nil, Merge, filtered, rest)
+				So(err, ShouldBeNil)
+				output, err := runCommand("", false, nil, List, merged)
+				So(err, ShouldBeNil)
+				So(listedDocuments(output, "db1.c1"), ShouldEqual, "3")
+				So(listedDocuments(output, "db2.c2"), ShouldEqual, "1")
+			})
+
+			Convey("but merging it with itself should fail", func() {
+				_, err := runCommand(filepath.Join(tmp, "merged.archive"), false, nil, Merge, filtered, filtered)
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("compressing it should be transparent to list", func() {
+			gzipped := filepath.Join(tmp, "packed.archive.gz")
+			_, err := runCommand(gzipped, true, nil, Filter, packed)
+			So(err, ShouldBeNil)
+			content, err := ioutil.ReadFile(gzipped)
+			So(err, ShouldBeNil)
+			So(content[:2], ShouldResemble, []byte{0x1f, 0x8b})
+			output, err := runCommand("", false, nil, List, gzipped)
+			So(err, ShouldBeNil)
+			So(listedDocuments(output, "db1.c1"), ShouldEqual, "3")
+		})
+
+		Convey("a truncated archive should fail to list", func() {
+			content, err := ioutil.ReadFile(packed)
+			So(err, ShouldBeNil)
+			truncated := filepath.Join(tmp, "truncated.archive")
+			So(ioutil.WriteFile(truncated, content[:len(content)-20], 0644), ShouldBeNil)
+			_, err = runCommand("", false, nil, List, truncated)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
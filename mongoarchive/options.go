@@ -0,0 +1,38 @@
+package mongoarchive
+
+var Usage = `<options> <command> <archive(s) or directory>
+
+Inspect and manipulate archives created with mongodump --archive, without a running server.
+
+Possible commands include:
+	list    - list the namespaces of an archive along with their document counts and sizes
+	extract - extract an archive into a dump directory
+	pack    - create an archive from a dump directory
+	filter  - copy an archive, keeping only the namespaces selected with --nsInclude/--nsExclude
+	merge   - combine several archives into a single archive
+
+Archives are read from stdin when no archive is given or the archive is '-'.
+Gzipped archives are detected and decompressed automatically. Using filter without
+--nsInclude or --nsExclude converts an archive between its compressed and uncompressed forms.`
+
+// OutputOptions defines the set of options for writing archives and dump directories.
+type OutputOptions struct {
+	Out  string `long:"out" value-name:"<path>" short:"o" description:"output archive, or output directory for extract; '-' writes the archive to stdout (defaults to stdout, or 'dump' for extract)"`
+	Gzip bool   `long:"gzip" description:"compress the output archive, or the files of an extracted dump directory, with Gzip"`
+}
+
+// Name returns a human-readable group name for output options.
+func (*OutputOptions) Name() string {
+	return "output"
+}
+
+// NSOptions defines the set of options for selecting the namespaces to process.
+type NSOptions struct {
+	NSExclude []string `long:"nsExclude" value-name:"<namespace-pattern>" description:"exclude matching namespaces"`
+	NSInclude []string `long:"nsInclude" value-name:"<namespace-pattern>" description:"include matching namespaces"`
+}
+
+// Name returns a human-readable group name for namespace options.
+func (*NSOptions) Name() string {
+	return "namespace"
+}
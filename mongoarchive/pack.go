@@ -0,0 +1,282 @@
+package mongoarchive
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mongodb/mongo-tools/common/archive"
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/util"
+)
+
+// errorWriter adds a Write() method to the files read by pack, allowing
+// them to be an intent.file ( a ReadWriteOpenCloser )
+type errorWriter struct{}
+
+func (errorWriter) Write([]byte) (int, error) {
+	return 0, os.ErrInvalid
+}
+
+// packedBSONFile implements the intents.file interface. It reads a .bson or
+// .bson.gz file of a dump directory.
+type packedBSONFile struct {
+	io.ReadCloser
+	errorWriter
+	path string
+	gzip bool
+}
+
+// Open is part of the intents.file interface.
+func (f *packedBSONFile) Open() error {
+	file, err := os.Open(f.path)
+	if err != nil {
+		return fmt.Errorf("error reading BSON file %v: %v", f.path, err)
+	}
+	if !f.gzip {
+		f.ReadCloser = file
+		return nil
+	}
+	gzipReader, err := gzip.NewReader(file)
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("error decompressing BSON file %v: %v", f.path, err)
+	}
+	f.ReadCloser = &util.WrappedReadCloser{ReadCloser: gzipReader, Inner: file}
+	return nil
+}
+
+// Pos is part of the intents.file interface. Positions aren't tracked by pack.
+func (*packedBSONFile) Pos() int64 {
+	return -1
+}
+
+// dumpFileType describes the files found in a dump directory.
+type dumpFileType int
+
+const (
+	unknownDumpFile dumpFileType = iota
+	bsonDumpFile
+	metadataDumpFile
+)
+
+// dumpFileInfo returns the collection name, the type of a file in a dump
+// directory and whether it is gzipped.
+func dumpFileInfo(filename string) (string, dumpFileType, bool) {
+	isGzip := strings.HasSuffix(filename, ".gz")
+	filename = strings.TrimSuffix(filename, ".gz")
+	switch {
+	case strings.HasSuffix(filename, ".metadata.json"):
+		return strings.TrimSuffix(filename, ".metadata.json"), metadataDumpFile, isGzip
+	case strings.HasSuffix(filename, ".bson"):
+		return strings.TrimSuffix(filename, ".bson"), bsonDumpFile, isGzip
+	}
+	return "", unknownDumpFile, isGzip
+}
+
+// readMetadataFile reads a metadata.json file, decompressing it if needed.
+func readMetadataFile(path string, isGzip bool) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("error reading metadata file %v: %v", path, err)
+	}
+	defer file.Close()
+	var in io.Reader = file
+	if isGzip {
+		gzipReader, err := gzip.NewReader(file)
+		if err != nil {
+			return "", fmt.Errorf("error decompressing metadata file %v: %v", path, err)
+		}
+		defer gzipReader.Close()
+		in = gzipReader
+	}
+	content, err := ioutil.ReadAll(in)
+	if err != nil {
+		return "", fmt.Errorf("error reading metadata file %v: %v", path, err)
+	}
+	return string(content), nil
+}
+
+// packIntents walks a dump directory, as laid out by mongodump, and creates an intent
+// for every selected collection it finds. Top-level files are only accepted for the oplog.
+func (ma *MongoArchive) packIntents(dir string) (*intents.Manager, error) {
+	manager := intents.NewIntentManager()
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading dump directory: %v", err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			if err = util.ValidateDBName(entry.Name()); err != nil {
+				return nil, fmt.Errorf("invalid database name '%v': %v", entry.Name(), err)
+			}
+			err = ma.packIntentsForDB(manager, entry.Name(), filepath.Join(dir, entry.Name()))
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		collection, fileType, isGzip := dumpFileInfo(entry.Name())
+		if fileType != bsonDumpFile || collection != "oplog" {
+			log.Logvf(log.Always, "don't know what to do with file \"%v\", skipping...",
+				filepath.Join(dir, entry.Name()))
+			continue
+		}
+		if !ma.selected("", collection) {
+			continue
+		}
+		manager.Put(&intents.Intent{
+			C:        collection,
+			Location: filepath.Join(dir, entry.Name()),
+			BSONFile: &packedBSONFile{path: filepath.Join(dir, entry.Name()), gzip: isGzip},
+		})
+	}
+	return manager, nil
+}
+
+// packIntentsForDB creates intents for the selected collections of one database directory.
+func (ma *MongoArchive) packIntentsForDB(manager *intents.Manager, dbName, dir string) error {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("error reading database directory %v: %v", dir, err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			log.Logvf(log.Always, "don't know what to do with subdirectory \"%v\", skipping...",
+				filepath.Join(dir, entry.Name()))
+			continue
+		}
+		collection, fileType, isGzip := dumpFileInfo(entry.Name())
+		if fileType == unknownDumpFile {
+			log.Logvf(log.Always, "don't know what to do with file \"%v\", skipping...",
+				filepath.Join(dir, entry.Name()))
+			continue
+		}
+		if !ma.selected(dbName, collection) {
+			log.Logvf(log.DebugLow, "skipping %v.%v", dbName, collection)
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		intent := &intents.Intent{DB: dbName, C: collection}
+		if fileType == bsonDumpFile {
+			intent.Location = path
+			intent.BSONFile = &packedBSONFile{path: path, gzip: isGzip}
+		} else {
+			metadata, err := readMetadataFile(path, isGzip)
+			if err != nil {
+				return err
+			}
+			intent.MetadataLocation = path
+			intent.MetadataFile = &archive.MetadataFile{
+				Intent: intent,
+				Buffer: bytes.NewBufferString(metadata),
+			}
+		}
+		manager.Put(intent)
+	}
+	return nil
+}
+
+// Pack creates an archive from a dump directory. Like mongodump, it writes the
+// special collections first and the oplog last, so that mongorestore can
+// restore the archive.
+func (ma *MongoArchive) Pack() error {
+	dir := util.ToUniversalPath(ma.Inputs[0])
+	manager, err := ma.packIntents(dir)
+	if err != nil {
+		return err
+	}
+
+	prelude, err := archive.NewPrelude(manager, 1, "unknown")
+	if err != nil {
+		return fmt.Errorf("creating archive prelude: %v", err)
+	}
+
+	// intents are written in the same order mongodump uses
+	ordered := []*intents.Intent{}
+	indexDBs := manager.SystemIndexDBs()
+	sort.Strings(indexDBs)
+	for _, dbName := range indexDBs {
+		ordered = append(ordered, manager.SystemIndexes(dbName))
+	}
+	for _, intent := range []*intents.Intent{manager.Users(), manager.Roles(), manager.AuthVersion()} {
+		if intent != nil {
+			ordered = append(ordered, intent)
+		}
+	}
+	manager.Finalize(intents.Legacy)
+	for intent := manager.Pop(); intent != nil; intent = manager.Pop() {
+		ordered = append(ordered, intent)
+	}
+	if manager.Oplog() != nil {
+		ordered = append(ordered, manager.Oplog())
+	}
+
+	err = ma.writeArchive(prelude, func(mux *archive.Multiplexer, shutdown <-chan struct{}) error {
+		for _, intent := range ordered {
+			select {
+			case <-shutdown:
+				// the multiplexer failed; writeArchive reports its error
+				return nil
+			default:
+			}
+			if err := packIntent(intent, mux); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	log.Logvf(log.Always, "packed %v %v into %v",
+		len(ordered), util.Pluralize(len(ordered), "collection", "collections"), ma.outputName())
+	return nil
+}
+
+// packIntent multiplexes the documents of one intent into the archive.
+// Intents without a BSON file, such as views, are written as empty collections.
+func packIntent(intent *intents.Intent, mux *archive.Multiplexer) (err error) {
+	muxIn := &archive.MuxIn{Intent: intent, Mux: mux}
+	if err = muxIn.Open(); err != nil {
+		return err
+	}
+	defer func() {
+		closeErr := muxIn.Close()
+		if err == nil && closeErr != nil {
+			err = fmt.Errorf("error writing %v to archive: %v", intent.Namespace(), closeErr)
+		}
+	}()
+	if intent.BSONFile == nil {
+		log.Logvf(log.Always, "writing %v (no data)", displayName(intent.DB, intent.C))
+		return nil
+	}
+
+	log.Logvf(log.Always, "writing %v from %v", displayName(intent.DB, intent.C), intent.Location)
+	if err = intent.BSONFile.Open(); err != nil {
+		return err
+	}
+	source := db.NewBSONSource(intent.BSONFile)
+	defer source.Close()
+
+	var count int64
+	for doc := source.LoadNext(); doc != nil; doc = source.LoadNext() {
+		if _, err = muxIn.Write(doc); err != nil {
+			return fmt.Errorf("error writing %v to archive: %v", intent.Namespace(), err)
+		}
+		count++
+	}
+	if err = source.Err(); err != nil {
+		return fmt.Errorf("error reading %v: %v", intent.Location, err)
+	}
+	log.Logvf(log.Info, "done writing %v (%v %v)", displayName(intent.DB, intent.C), count, docPlural(count))
+	return nil
+}
@@ -12,12 +12,14 @@ import (
 	"github.com/mongodb/mongo-tools/common/log"
 	"github.com/mongodb/mongo-tools/common/options"
 	"github.com/mongodb/mongo-tools/common/progress"
+	"github.com/mongodb/mongo-tools/common/text"
 	"github.com/mongodb/mongo-tools/common/util"
 	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 
 	"bufio"
 	"compress/gzip"
+	"crypto/tls"
 	"fmt"
 	"io"
 	"os"
@@ -50,6 +52,8 @@ type MongoDump struct {
 	isMongos        bool
 	authVersion     int
 	archive         *archive.Writer
+	archivePartSize int64
+	archiveStream   *archive.StreamWriter
 	// shutdownIntentsNotifier is provided to the multiplexer
 	// as well as the signal handler, and allows them to notify
 	// the intent dumpers that they should shutdown
@@ -109,6 +113,30 @@ func (dump *MongoDump) ValidateOptions() error {
 		return fmt.Errorf("compression can't be used when dumping a single collection to standard output")
 	case dump.OutputOptions.NumParallelCollections <= 0:
 		return fmt.Errorf("numParallelCollections must be positive")
+	case dump.OutputOptions.ArchiveTo != "" && dump.OutputOptions.Archive != "":
+		return fmt.Errorf("--archive not allowed when --archiveTo is specified")
+	case dump.OutputOptions.ArchiveTo != "" && dump.OutputOptions.Out != "":
+		return fmt.Errorf("--out not allowed when --archiveTo is specified")
+	case dump.OutputOptions.ArchiveTLSCAFile != "" && !dump.OutputOptions.ArchiveTLS:
+		return fmt.Errorf("--archiveTLSCAFile requires --archiveTLS")
+	case dump.OutputOptions.ArchiveTLS && dump.OutputOptions.ArchiveTo == "":
+		return fmt.Errorf("--archiveTLS requires --archiveTo")
+	case dump.OutputOptions.ArchivePartSize != "" && dump.OutputOptions.Archive == "":
+		return fmt.Errorf("--archivePartSize requires --archive")
+	case dump.OutputOptions.ArchivePartSize != "" && dump.OutputOptions.Archive == "-":
+		return fmt.Errorf("--archivePartSize can't be used when writing the archive to standard output")
+	}
+	if dump.OutputOptions.ArchiveTo != "" {
+		if _, err := archive.ParseStreamAddress(dump.OutputOptions.ArchiveTo); err != nil {
+			return err
+		}
+	}
+	if dump.OutputOptions.ArchivePartSize != "" {
+		partSize, err := text.ParseByteAmount(dump.OutputOptions.ArchivePartSize)
+		if err != nil {
+			return fmt.Errorf("invalid --archivePartSize: %v", err)
+		}
+		dump.archivePartSize = partSize
 	}
 	return nil
 }
@@ -219,7 +247,7 @@ func (dump *MongoDump) Dump() (err error) {
 		}
 	}
 
-	if dump.OutputOptions.Archive != "" {
+	if dump.archiveTarget() != "" {
 		//getArchiveOut gives us a WriteCloser to which we should write the archive
 		var archiveOut io.WriteCloser
 		archiveOut, err = dump.getArchiveOut()
@@ -230,14 +258,28 @@ func (dump *MongoDump) Dump() (err error) {
 			// The archive.Writer needs its own copy of archiveOut because things
 			// like the prelude are not written by the multiplexer.
 			Out: archiveOut,
-			Mux: archive.NewMultiplexer(archiveOut, dump.shutdownIntentsNotifier),
+			// archiveOut is closed once the multiplexer completes, so that
+			// errors finishing the archive aren't lost.
+			Mux: archive.NewMultiplexer(&nopCloseWriter{archiveOut}, dump.shutdownIntentsNotifier),
 		}
 		go dump.archive.Mux.Run()
 		defer func() {
 			// The Mux runs until its Control is closed
 			close(dump.archive.Mux.Control)
 			muxErr := <-dump.archive.Mux.Completed
-			archiveOut.Close()
+			if dump.archiveStream != nil && (err != nil || muxErr != nil) {
+				// let mongorestore know it won't get the rest of the archive
+				abortErr := err
+				if abortErr == nil {
+					abortErr = muxErr
+				}
+				dump.archiveStream.Abort(abortErr)
+			}
+			closeErr := archiveOut.Close()
+			if err == nil && muxErr == nil && closeErr != nil {
+				// the last part of a multi-volume archive is only marked as such on close
+				muxErr = fmt.Errorf("error closing archive: %v", closeErr)
+			}
 			if muxErr != nil {
 				if err != nil {
 					err = fmt.Errorf("archive writer: %v / %v", err, muxErr)
@@ -305,7 +347,7 @@ func (dump *MongoDump) Dump() (err error) {
 		return fmt.Errorf("error dumping metadata: %v", err)
 	}
 
-	if dump.OutputOptions.Archive != "" {
+	if dump.archiveTarget() != "" {
 		session, err := dump.SessionProvider.GetSession()
 		if err != nil {
 			return err
@@ -448,7 +490,7 @@ func (w closableBufioWriter) Close() error {
 }
 
 func (dump *MongoDump) getResettableOutputBuffer() resettableOutputBuffer {
-	if dump.OutputOptions.Archive != "" {
+	if dump.archiveTarget() != "" {
 		return nil
 	} else if dump.OutputOptions.Gzip {
 		return gzip.NewWriter(nil)
@@ -547,11 +589,11 @@ func (dump *MongoDump) DumpIntent(intent *intents.Intent, buffer resettableOutpu
 	}
 
 	// set where the intent will be written to
-	if dump.OutputOptions.Archive != "" {
-		if dump.OutputOptions.Archive == "-" {
+	if dump.archiveTarget() != "" {
+		if dump.archiveTarget() == "-" {
 			intent.Location = "archive on stdout"
 		} else {
-			intent.Location = fmt.Sprintf("archive '%v'", dump.OutputOptions.Archive)
+			intent.Location = fmt.Sprintf("archive '%v'", dump.archiveTarget())
 		}
 	}
 
@@ -786,26 +828,56 @@ func (*nopCloseWriter) Close() error {
 	return nil
 }
 
+// archiveTarget returns where the archive is written: a file path, '-' for stdout,
+// or the address of a listening mongorestore. It is empty when not dumping an archive.
+func (dump *MongoDump) archiveTarget() string {
+	if dump.OutputOptions.ArchiveTo != "" {
+		return dump.OutputOptions.ArchiveTo
+	}
+	return dump.OutputOptions.Archive
+}
+
+// getArchiveStream connects to the mongorestore given by --archiveTo.
+func (dump *MongoDump) getArchiveStream() (*archive.StreamWriter, error) {
+	address, err := archive.ParseStreamAddress(dump.OutputOptions.ArchiveTo)
+	if err != nil {
+		return nil, err
+	}
+	var tlsConfig *tls.Config
+	if dump.OutputOptions.ArchiveTLS {
+		tlsConfig, err = archive.ClientTLSConfig(dump.OutputOptions.ArchiveTLSCAFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return archive.DialStream(address, tlsConfig, options.VersionStr)
+}
+
 func (dump *MongoDump) getArchiveOut() (out io.WriteCloser, err error) {
-	if dump.OutputOptions.Archive == "-" {
+	if dump.OutputOptions.ArchiveTo != "" {
+		dump.archiveStream, err = dump.getArchiveStream()
+		if err != nil {
+			return nil, err
+		}
+		out = dump.archiveStream
+	} else if dump.OutputOptions.Archive == "-" {
 		out = &nopCloseWriter{dump.OutputWriter}
 	} else {
-		targetStat, err := os.Stat(dump.OutputOptions.Archive)
+		archiveFilePath := dump.OutputOptions.Archive
+		targetStat, err := os.Stat(archiveFilePath)
 		if err == nil && targetStat.IsDir() {
-			defaultArchiveFilePath :=
-				filepath.Join(dump.OutputOptions.Archive, "archive")
+			archiveFilePath = filepath.Join(dump.OutputOptions.Archive, "archive")
 			if dump.OutputOptions.Gzip {
-				defaultArchiveFilePath = defaultArchiveFilePath + ".gz"
-			}
-			out, err = os.Create(defaultArchiveFilePath)
-			if err != nil {
-				return nil, err
+				archiveFilePath = archiveFilePath + ".gz"
 			}
+		}
+		if dump.archivePartSize > 0 {
+			out, err = archive.NewPartWriter(archiveFilePath, dump.archivePartSize)
 		} else {
-			out, err = os.Create(dump.OutputOptions.Archive)
-			if err != nil {
-				return nil, err
-			}
+			out, err = os.Create(archiveFilePath)
+		}
+		if err != nil {
+			return nil, err
 		}
 	}
 	if dump.OutputOptions.Gzip {
@@ -50,6 +50,10 @@ type OutputOptions struct {
 	Repair                     bool     `long:"repair" description:"try to recover documents from damaged data files (not supported by all storage engines)"`
 	Oplog                      bool     `long:"oplog" description:"use oplog for taking a point-in-time snapshot"`
 	Archive                    string   `long:"archive" value-name:"<file-path>" optional:"true" optional-value:"-" description:"dump as an archive to the specified path. If flag is specified without a value, archive is written to stdout"`
+	ArchiveTo                  string   `long:"archiveTo" value-name:"tcp://<host>:<port>" description:"stream the archive to a mongorestore started with --archiveListen, which stops the dump if the restore fails; connecting is retried for a minute, but a stream whose connection is lost is not resumed and both the dump and the restore fail"`
+	ArchiveTLS                 bool     `long:"archiveTLS" description:"use TLS for the --archiveTo connection"`
+	ArchiveTLSCAFile           string   `long:"archiveTLSCAFile" value-name:"<filename>" description:"CA file to verify the certificate of mongorestore with (defaults to the system CAs)"`
+	ArchivePartSize            string   `long:"archivePartSize" value-name:"<size>" description:"split the archive into parts of at most the given size (e.g. 500MB, 50GB), written to <file-path>.000, <file-path>.001, ..."`
 	DumpDBUsersAndRoles        bool     `long:"dumpDbUsersAndRoles" description:"dump user and role definitions for the specified database"`
 	ExcludedCollections        []string `long:"excludeCollection" value-name:"<collection-name>" description:"collection to exclude from the dump (may be specified multiple times to exclude additional collections)"`
 	ExcludedCollectionPrefixes []string `long:"excludeCollectionsWithPrefix" value-name:"<collection-prefix>" description:"exclude all collections from the dump that have the given prefix (may be specified multiple times to exclude additional prefixes)"`
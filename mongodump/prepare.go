@@ -188,7 +188,7 @@ func (dump *MongoDump) NewIntent(dbName, colName string) (*intents.Intent, error
 	if dump.OutputOptions.Out == "-" {
 		intent.BSONFile = &stdoutFile{Writer: dump.OutputWriter}
 	} else {
-		if dump.OutputOptions.Archive != "" {
+		if dump.archiveTarget() != "" {
 			intent.BSONFile = &archive.MuxIn{Intent: intent, Mux: dump.archive.Mux}
 		} else {
 			var c rune
@@ -200,7 +200,7 @@ func (dump *MongoDump) NewIntent(dbName, colName string) (*intents.Intent, error
 			intent.BSONFile = &realBSONFile{path: path, intent: intent}
 		}
 		if !intent.IsSystemIndexes() {
-			if dump.OutputOptions.Archive != "" {
+			if dump.archiveTarget() != "" {
 				intent.MetadataFile = &archive.MetadataFile{
 					Intent: intent,
 					Buffer: &bytes.Buffer{},
@@ -238,7 +238,7 @@ func (dump *MongoDump) CreateOplogIntents() error {
 		DB: "",
 		C:  "oplog",
 	}
-	if dump.OutputOptions.Archive != "" {
+	if dump.archiveTarget() != "" {
 		oplogIntent.BSONFile = &archive.MuxIn{Mux: dump.archive.Mux, Intent: oplogIntent}
 	} else {
 		oplogIntent.BSONFile = &realBSONFile{path: dump.outputPath("oplog.bson", ""), intent: oplogIntent}
@@ -266,7 +266,7 @@ func (dump *MongoDump) CreateUsersRolesVersionIntentsForDB(db string) error {
 		DB: db,
 		C:  "$admin.system.version",
 	}
-	if dump.OutputOptions.Archive != "" {
+	if dump.archiveTarget() != "" {
 		usersIntent.BSONFile = &archive.MuxIn{Intent: usersIntent, Mux: dump.archive.Mux}
 		rolesIntent.BSONFile = &archive.MuxIn{Intent: rolesIntent, Mux: dump.archive.Mux}
 		versionIntent.BSONFile = &archive.MuxIn{Intent: versionIntent, Mux: dump.archive.Mux}
@@ -333,7 +333,7 @@ func (dump *MongoDump) createIntentFromOptions(dbName string, ci *collectionInfo
 	} else if ci.IsView() {
 		log.Logvf(log.DebugLow, "not dumping data for %v.%v because it is a view", dbName, ci.Name)
 		// only write a bson file if using archive
-		if dump.OutputOptions.Archive == "" {
+		if dump.archiveTarget() == "" {
 			intent.BSONFile = nil
 		}
 	}
@@ -0,0 +1,554 @@
+package mongoexport
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"math/big"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/mongodb/mongo-tools/common/bsonutil"
+	"github.com/mongodb/mongo-tools/common/json"
+	"github.com/mongodb/mongo-tools/common/log"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Compression codecs of Avro blocks.
+const (
+	AvroCodecNull    = "null"
+	AvroCodecDeflate = "deflate"
+	AvroCodecSnappy  = "snappy"
+)
+
+// avroBlockSize is the size of the encoded records after which a block is written.
+const avroBlockSize = 1024 * 1024
+
+// avroMagic starts every Avro object container file.
+var avroMagic = []byte{'O', 'b', 'j', 1}
+
+// AvroExportOutput is an implementation of ExportOutput that writes documents
+// to an Avro object container file, in compressed blocks of records.
+type AvroExportOutput struct {
+	// SampleSize is the number of documents to infer the schema from, if it
+	// is not set. The sampled documents are held back until the schema is known.
+	SampleSize int
+	// RecordName is the name of the inferred record type.
+	RecordName string
+	// ObjectIDType is how ObjectIds are written when inferring the schema:
+	// as strings or as 12 byte fixed values.
+	ObjectIDType string
+	// Codec is the compression codec of the blocks.
+	Codec       string
+	NumExported int64
+
+	out    io.Writer
+	schema *avroType
+	sync   [16]byte
+
+	sample        []bson.D
+	headerWritten bool
+	block         bytes.Buffer
+	blockCount    int64
+}
+
+// NewAvroExportOutput returns an AvroExportOutput configured to write an Avro
+// object container file to the given io.Writer, with blocks compressed by codec.
+func NewAvroExportOutput(codec string, out io.Writer) *AvroExportOutput {
+	return &AvroExportOutput{
+		SampleSize:   1000,
+		RecordName:   "Document",
+		ObjectIDType: AvroObjectIDString,
+		Codec:        codec,
+		out:          out,
+	}
+}
+
+// SetSchema sets the schema of the records, instead of inferring it.
+func (avroExporter *AvroExportOutput) SetSchema(schema *avroType) {
+	avroExporter.schema = schema
+}
+
+// WriteHeader writes the header of the file, which holds the schema. If the
+// schema is inferred, the header is written once the documents have been sampled.
+func (avroExporter *AvroExportOutput) WriteHeader() error {
+	if avroExporter.schema == nil {
+		return nil
+	}
+	return avroExporter.writeFileHeader()
+}
+
+func (avroExporter *AvroExportOutput) writeFileHeader() error {
+	if avroExporter.headerWritten {
+		return nil
+	}
+	schemaJSON, err := avroExporter.schema.JSON()
+	if err != nil {
+		return fmt.Errorf("error writing Avro schema: %v", err)
+	}
+	if _, err = rand.Read(avroExporter.sync[:]); err != nil {
+		return err
+	}
+
+	header := &bytes.Buffer{}
+	header.Write(avroMagic)
+	writeAvroLong(header, 2)
+	writeAvroBytes(header, []byte("avro.schema"))
+	writeAvroBytes(header, schemaJSON)
+	writeAvroBytes(header, []byte("avro.codec"))
+	writeAvroBytes(header, []byte(avroExporter.Codec))
+	writeAvroLong(header, 0)
+	header.Write(avroExporter.sync[:])
+	if _, err = avroExporter.out.Write(header.Bytes()); err != nil {
+		return err
+	}
+	avroExporter.headerWritten = true
+	return nil
+}
+
+// endSampling infers the schema from the sampled documents, and writes the
+// header and the sampled documents.
+func (avroExporter *AvroExportOutput) endSampling() error {
+	avroExporter.schema = inferAvroSchema(avroExporter.sample, avroExporter.RecordName, avroExporter.ObjectIDType)
+	log.Logvf(log.Info, "inferred Avro schema from %v sampled documents", len(avroExporter.sample))
+	if err := avroExporter.writeFileHeader(); err != nil {
+		return err
+	}
+	sample := avroExporter.sample
+	avroExporter.sample = nil
+	for _, document := range sample {
+		if err := avroExporter.ExportDocument(document); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ExportDocument encodes a document as a record of the current block, and
+// writes the block once it is full.
+func (avroExporter *AvroExportOutput) ExportDocument(document bson.D) error {
+	if avroExporter.schema == nil {
+		// the caller may reuse the document, so hold back a copy
+		avroExporter.sample = append(avroExporter.sample, append(bson.D{}, document...))
+		if len(avroExporter.sample) >= avroExporter.SampleSize {
+			return avroExporter.endSampling()
+		}
+		return nil
+	}
+
+	if err := avroExporter.schema.encode(&avroExporter.block, document); err != nil {
+		return fmt.Errorf("error converting document #%v to Avro: %v", avroExporter.NumExported+1, err)
+	}
+	avroExporter.blockCount++
+	avroExporter.NumExported++
+	if avroExporter.block.Len() >= avroBlockSize {
+		return avroExporter.writeBlock()
+	}
+	return nil
+}
+
+// writeBlock writes the records of the current block, compressed by the codec.
+func (avroExporter *AvroExportOutput) writeBlock() error {
+	if avroExporter.blockCount == 0 {
+		return nil
+	}
+	data := avroExporter.block.Bytes()
+	switch avroExporter.Codec {
+	case AvroCodecDeflate:
+		compressed := &bytes.Buffer{}
+		writer, err := flate.NewWriter(compressed, flate.DefaultCompression)
+		if err != nil {
+			return err
+		}
+		if _, err = writer.Write(data); err != nil {
+			return err
+		}
+		if err = writer.Close(); err != nil {
+			return err
+		}
+		data = compressed.Bytes()
+	case AvroCodecSnappy:
+		// snappy blocks are followed by the CRC-32 of the uncompressed data
+		checksum := crc32.ChecksumIEEE(data)
+		data = snappy.Encode(nil, data)
+		data = append(data, 0, 0, 0, 0)
+		binary.BigEndian.PutUint32(data[len(data)-4:], checksum)
+	}
+
+	header := &bytes.Buffer{}
+	writeAvroLong(header, avroExporter.blockCount)
+	writeAvroLong(header, int64(len(data)))
+	if _, err := avroExporter.out.Write(header.Bytes()); err != nil {
+		return err
+	}
+	if _, err := avroExporter.out.Write(data); err != nil {
+		return err
+	}
+	if _, err := avroExporter.out.Write(avroExporter.sync[:]); err != nil {
+		return err
+	}
+	avroExporter.block.Reset()
+	avroExporter.blockCount = 0
+	return nil
+}
+
+// WriteFooter writes out the documents held back to infer the schema, if
+// there were fewer of them than the sample size, and the last block.
+func (avroExporter *AvroExportOutput) WriteFooter() error {
+	if avroExporter.schema == nil {
+		if err := avroExporter.endSampling(); err != nil {
+			return err
+		}
+	}
+	return avroExporter.writeBlock()
+}
+
+// buffered returns the size of the records of the current block, before they
+// are compressed.
+func (avroExporter *AvroExportOutput) buffered() int {
+	return avroExporter.block.Len()
+}
+
+// Flush writes the current block, if it has any records.
+func (avroExporter *AvroExportOutput) Flush() error {
+	if !avroExporter.headerWritten {
+		return nil
+	}
+	return avroExporter.writeBlock()
+}
+
+// writeAvroLong writes a zig-zag encoded variable length integer.
+func writeAvroLong(buf *bytes.Buffer, n int64) {
+	var encoded [binary.MaxVarintLen64]byte
+	buf.Write(encoded[:binary.PutVarint(encoded[:], n)])
+}
+
+// writeAvroBytes writes the length of the bytes followed by the bytes.
+func writeAvroBytes(buf *bytes.Buffer, data []byte) {
+	writeAvroLong(buf, int64(len(data)))
+	buf.Write(data)
+}
+
+// lookupAvroField returns the value of a field of a document, or nil if it is missing.
+func lookupAvroField(doc bson.D, key string) interface{} {
+	for _, elem := range doc {
+		if elem.Name == key {
+			return elem.Value
+		}
+	}
+	return nil
+}
+
+// avroInteger returns the value of an integer.
+func avroInteger(value interface{}) (int64, bool) {
+	switch v := value.(type) {
+	case int:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	case bson.MongoTimestamp:
+		return int64(v), true
+	}
+	return 0, false
+}
+
+// accepts returns true if a value can be written as the type. Unions write
+// a value as the first of their types that accepts it.
+func (t *avroType) accepts(value interface{}) bool {
+	switch t.Type {
+	case avroNull:
+		return value == nil || value == bson.Undefined
+	case avroBoolean:
+		_, ok := value.(bool)
+		return ok
+	case avroInt:
+		n, ok := avroInteger(value)
+		return ok && n >= math.MinInt32 && n <= math.MaxInt32
+	case avroLong:
+		_, isDate := value.(time.Time)
+		if t.LogicalType == avroTimestampMillis || t.LogicalType == avroTimestampMicros {
+			return isDate
+		}
+		_, ok := avroInteger(value)
+		return ok
+	case avroFloat, avroDouble:
+		_, isInteger := avroInteger(value)
+		_, isFloat := value.(float64)
+		return isInteger || isFloat
+	case avroString:
+		switch value.(type) {
+		case string, bson.Symbol, bson.ObjectId:
+			return true
+		}
+	case avroBytes, avroFixed:
+		switch v := value.(type) {
+		case bson.Decimal128:
+			if t.LogicalType != avroDecimal {
+				return false
+			}
+			_, err := t.decimalBytes(v)
+			return err == nil
+		case bson.ObjectId:
+			return t.Type == avroFixed && t.Size == len(v)
+		case []byte:
+			return t.LogicalType != avroDecimal && (t.Type == avroBytes || t.Size == len(v))
+		case bson.Binary:
+			return t.LogicalType != avroDecimal && (t.Type == avroBytes || t.Size == len(v.Data))
+		}
+	case avroEnum:
+		if s, ok := value.(string); ok {
+			for _, symbol := range t.Symbols {
+				if symbol == s {
+					return true
+				}
+			}
+		}
+	case avroRecord, avroMap:
+		_, ok := value.(bson.D)
+		return ok
+	case avroArray:
+		_, ok := value.([]interface{})
+		return ok
+	}
+	return false
+}
+
+// avroMismatchError is returned by encode, before anything is written, for a
+// value that the type does not accept.
+type avroMismatchError string
+
+func (err avroMismatchError) Error() string {
+	return string(err)
+}
+
+// encodeElement writes the value of a field, a map value or an array element.
+// Values that a nullable type does not accept, like those of types that were
+// not in the documents a schema was inferred from, are logged and written as
+// null rather than fail the export.
+func (t *avroType) encodeElement(buf *bytes.Buffer, value interface{}, name string) error {
+	err := t.encode(buf, value)
+	if _, mismatch := err.(avroMismatchError); mismatch && t.nullable() {
+		log.Logvf(log.Info, "%v: %v, written as null", name, err)
+		return t.encode(buf, nil)
+	}
+	return err
+}
+
+// encode writes a value in the Avro binary encoding of the type.
+func (t *avroType) encode(buf *bytes.Buffer, value interface{}) error {
+	switch t.Type {
+	case avroUnion:
+		for i, branch := range t.Branches {
+			if branch.accepts(value) {
+				writeAvroLong(buf, int64(i))
+				return branch.encode(buf, value)
+			}
+		}
+		// values without an Avro equivalent can be written as Extended JSON
+		if value != nil && value != bson.Undefined {
+			for i, branch := range t.Branches {
+				if branch.Type == avroString {
+					writeAvroLong(buf, int64(i))
+					return branch.encode(buf, value)
+				}
+			}
+		}
+		return avroMismatchError(fmt.Sprintf("value %v does not match any type of the union", value))
+	case avroRecord:
+		doc, ok := value.(bson.D)
+		if !ok {
+			return avroMismatchError(fmt.Sprintf("expected a document, not %v", value))
+		}
+		for _, field := range t.Fields {
+			name := fmt.Sprintf("field '%v'", field.Key)
+			if err := field.Type.encodeElement(buf, lookupAvroField(doc, field.Key), name); err != nil {
+				return fmt.Errorf("%v: %v", name, err)
+			}
+		}
+		return nil
+	case avroMap:
+		doc, ok := value.(bson.D)
+		if !ok {
+			return avroMismatchError(fmt.Sprintf("expected a document, not %v", value))
+		}
+		if len(doc) > 0 {
+			writeAvroLong(buf, int64(len(doc)))
+			for _, elem := range doc {
+				writeAvroBytes(buf, []byte(elem.Name))
+				name := fmt.Sprintf("field '%v'", elem.Name)
+				if err := t.Items.encodeElement(buf, elem.Value, name); err != nil {
+					return fmt.Errorf("%v: %v", name, err)
+				}
+			}
+		}
+		writeAvroLong(buf, 0)
+		return nil
+	case avroArray:
+		array, ok := value.([]interface{})
+		if !ok {
+			return avroMismatchError(fmt.Sprintf("expected an array, not %v", value))
+		}
+		if len(array) > 0 {
+			writeAvroLong(buf, int64(len(array)))
+			for i, element := range array {
+				name := fmt.Sprintf("element %v", i)
+				if err := t.Items.encodeElement(buf, element, name); err != nil {
+					return fmt.Errorf("%v: %v", name, err)
+				}
+			}
+		}
+		writeAvroLong(buf, 0)
+		return nil
+	case avroString:
+		return t.encodeString(buf, value)
+	case avroEnum:
+		s, _ := value.(string)
+		for i, symbol := range t.Symbols {
+			if symbol == s {
+				writeAvroLong(buf, int64(i))
+				return nil
+			}
+		}
+		return avroMismatchError(fmt.Sprintf("value %v is not a symbol of enum '%v'", value, t.Name))
+	}
+
+	if !t.accepts(value) {
+		if d, ok := value.(bson.Decimal128); ok && t.LogicalType == avroDecimal {
+			_, err := t.decimalBytes(d)
+			return avroMismatchError(err.Error())
+		}
+		if value == nil {
+			return avroMismatchError(fmt.Sprintf("missing or null value for a non-null type %v", t.Type))
+		}
+		return avroMismatchError(fmt.Sprintf("cannot write %v (%T) as %v", value, value, t.Type))
+	}
+	switch t.Type {
+	case avroNull:
+	case avroBoolean:
+		if value.(bool) {
+			buf.WriteByte(1)
+		} else {
+			buf.WriteByte(0)
+		}
+	case avroInt, avroLong:
+		if date, ok := value.(time.Time); ok {
+			if t.LogicalType == avroTimestampMicros {
+				writeAvroLong(buf, date.Unix()*1e6+int64(date.Nanosecond()/1e3))
+			} else {
+				writeAvroLong(buf, date.Unix()*1e3+int64(date.Nanosecond()/1e6))
+			}
+			return nil
+		}
+		n, _ := avroInteger(value)
+		writeAvroLong(buf, n)
+	case avroFloat, avroDouble:
+		f, isFloat := value.(float64)
+		if !isFloat {
+			n, _ := avroInteger(value)
+			f = float64(n)
+		}
+		if t.Type == avroFloat {
+			var encoded [4]byte
+			binary.LittleEndian.PutUint32(encoded[:], math.Float32bits(float32(f)))
+			buf.Write(encoded[:])
+		} else {
+			var encoded [8]byte
+			binary.LittleEndian.PutUint64(encoded[:], math.Float64bits(f))
+			buf.Write(encoded[:])
+		}
+	case avroBytes, avroFixed:
+		var data []byte
+		switch v := value.(type) {
+		case bson.Decimal128:
+			var err error
+			if data, err = t.decimalBytes(v); err != nil {
+				return err
+			}
+		case bson.ObjectId:
+			data = []byte(v)
+		case []byte:
+			data = v
+		case bson.Binary:
+			data = v.Data
+		}
+		if t.Type == avroBytes {
+			writeAvroBytes(buf, data)
+		} else {
+			buf.Write(data)
+		}
+	}
+	return nil
+}
+
+// encodeString writes strings, ObjectIds as hex strings, and any other
+// value as canonical Extended JSON.
+func (t *avroType) encodeString(buf *bytes.Buffer, value interface{}) error {
+	switch v := value.(type) {
+	case string:
+		writeAvroBytes(buf, []byte(v))
+	case bson.Symbol:
+		writeAvroBytes(buf, []byte(v))
+	case bson.ObjectId:
+		writeAvroBytes(buf, []byte(v.Hex()))
+	case nil:
+		return fmt.Errorf("missing or null value for a non-null type %v", t.Type)
+	default:
+		data, err := bsonutil.MarshalExtJSON(value, json.Canonical)
+		if err != nil {
+			return err
+		}
+		writeAvroBytes(buf, data)
+	}
+	return nil
+}
+
+// decimalBytes returns the unscaled value of a decimal at the scale of the type,
+// as a big-endian two's complement integer, sized for fixed types.
+func (t *avroType) decimalBytes(d bson.Decimal128) ([]byte, error) {
+	unscaled, scale, err := decimalParts(d)
+	if err != nil {
+		return nil, err
+	}
+	if scale > t.Scale {
+		return nil, fmt.Errorf("decimal %v has more than %v decimal places", d, t.Scale)
+	}
+	unscaled.Mul(unscaled, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(t.Scale-scale)), nil))
+	if decimalDigits(unscaled) > t.Precision {
+		return nil, fmt.Errorf("decimal %v has more than %v digits", d, t.Precision)
+	}
+
+	// two's complement, in as few bytes as hold the value and its sign bit:
+	// negative numbers are 2^(8*size) + value
+	magnitude := unscaled
+	if unscaled.Sign() < 0 {
+		magnitude = new(big.Int).Sub(new(big.Int).Neg(unscaled), big.NewInt(1))
+	}
+	size := (magnitude.BitLen() + 8) / 8
+	if t.Type == avroFixed {
+		size = t.Size
+	}
+	value := unscaled
+	if unscaled.Sign() < 0 {
+		value = new(big.Int).Add(new(big.Int).Lsh(big.NewInt(1), uint(size*8)), unscaled)
+	}
+	encoded := value.Bytes()
+	if len(encoded) > size || (t.Type == avroFixed && !fitsTwosComplement(unscaled, size)) {
+		return nil, fmt.Errorf("decimal %v does not fit in %v bytes", d, size)
+	}
+	data := make([]byte, size)
+	copy(data[size-len(encoded):], encoded)
+	return data, nil
+}
+
+// fitsTwosComplement returns true if n fits in a two's complement integer of size bytes.
+func fitsTwosComplement(n *big.Int, size int) bool {
+	limit := new(big.Int).Lsh(big.NewInt(1), uint(size*8-1))
+	return n.Cmp(limit) < 0 && n.Cmp(new(big.Int).Neg(limit)) >= 0
+}
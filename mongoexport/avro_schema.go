@@ -0,0 +1,574 @@
+package mongoexport
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/bsonutil"
+	"github.com/mongodb/mongo-tools/common/json"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Avro types.
+const (
+	avroNull    = "null"
+	avroBoolean = "boolean"
+	avroInt     = "int"
+	avroLong    = "long"
+	avroFloat   = "float"
+	avroDouble  = "double"
+	avroBytes   = "bytes"
+	avroString  = "string"
+	avroRecord  = "record"
+	avroEnum    = "enum"
+	avroArray   = "array"
+	avroMap     = "map"
+	avroFixed   = "fixed"
+	avroUnion   = "union"
+)
+
+// Avro logical types.
+const (
+	avroTimestampMillis = "timestamp-millis"
+	avroTimestampMicros = "timestamp-micros"
+	avroDecimal         = "decimal"
+)
+
+// Ways of writing ObjectIds to Avro.
+const (
+	AvroObjectIDString = "string"
+	AvroObjectIDFixed  = "fixed"
+)
+
+// avroDecimalPrecision is the number of significant digits of a Decimal128.
+const avroDecimalPrecision = 34
+
+// avroMaxDecimalPrecision is the most digits of the inferred decimal types,
+// the most that readers like Hive and Spark support.
+const avroMaxDecimalPrecision = 38
+
+// avroType is a type of an Avro schema.
+type avroType struct {
+	Type        string
+	LogicalType string
+
+	// Name names records, enums and fixed types.
+	Name string
+	// Fields are the fields of a record.
+	Fields []avroField
+	// Items is the type of the items of an array or the values of a map.
+	Items *avroType
+	// Branches are the types of a union.
+	Branches []*avroType
+	// Symbols are the symbols of an enum.
+	Symbols []string
+	// Size is the size of a fixed type.
+	Size int
+	// Precision and Scale describe decimals.
+	Precision, Scale int
+}
+
+// avroField is a field of an Avro record.
+type avroField struct {
+	Name string
+	// Key is the name of the field in the BSON documents, which can be
+	// different from Name, since Avro names are limited to [A-Za-z0-9_].
+	Key  string
+	Type *avroType
+}
+
+// nullable returns true if the type accepts null.
+func (t *avroType) nullable() bool {
+	if t.Type == avroUnion {
+		for _, branch := range t.Branches {
+			if branch.Type == avroNull {
+				return true
+			}
+		}
+	}
+	return t.Type == avroNull
+}
+
+// JSON returns the JSON representation of the schema.
+func (t *avroType) JSON() ([]byte, error) {
+	return json.Marshal(t.schema(map[string]bool{}))
+}
+
+// schema returns the type as a value to marshal to JSON. Named types are
+// defined the first time they appear and referred to by name afterwards.
+func (t *avroType) schema(defined map[string]bool) interface{} {
+	switch t.Type {
+	case avroUnion:
+		branches := make([]interface{}, 0, len(t.Branches))
+		for _, branch := range t.Branches {
+			branches = append(branches, branch.schema(defined))
+		}
+		return branches
+	case avroRecord, avroEnum, avroFixed:
+		if defined[t.Name] {
+			return t.Name
+		}
+		defined[t.Name] = true
+	}
+
+	schema := bsonutil.MarshalD{{Name: "type", Value: t.Type}}
+	switch t.Type {
+	case avroRecord:
+		fields := make([]interface{}, 0, len(t.Fields))
+		for _, field := range t.Fields {
+			fieldSchema := bsonutil.MarshalD{
+				{Name: "name", Value: field.Name},
+				{Name: "type", Value: field.Type.schema(defined)},
+			}
+			if field.Type.Type == avroUnion && field.Type.Branches[0].Type == avroNull {
+				fieldSchema = append(fieldSchema, bson.DocElem{Name: "default", Value: nil})
+			}
+			fields = append(fields, fieldSchema)
+		}
+		schema = append(schema, bson.DocElem{Name: "name", Value: t.Name}, bson.DocElem{Name: "fields", Value: fields})
+	case avroEnum:
+		schema = append(schema, bson.DocElem{Name: "name", Value: t.Name}, bson.DocElem{Name: "symbols", Value: t.Symbols})
+	case avroFixed:
+		schema = append(schema, bson.DocElem{Name: "name", Value: t.Name}, bson.DocElem{Name: "size", Value: t.Size})
+	case avroArray:
+		schema = append(schema, bson.DocElem{Name: "items", Value: t.Items.schema(defined)})
+	case avroMap:
+		schema = append(schema, bson.DocElem{Name: "values", Value: t.Items.schema(defined)})
+	}
+	if t.LogicalType != "" {
+		schema = append(schema, bson.DocElem{Name: "logicalType", Value: t.LogicalType})
+		if t.LogicalType == avroDecimal {
+			schema = append(schema, bson.DocElem{Name: "precision", Value: t.Precision},
+				bson.DocElem{Name: "scale", Value: t.Scale})
+		}
+	}
+	if len(schema) == 1 && t.Type != avroRecord {
+		// a primitive type without attributes is written as just its name
+		return t.Type
+	}
+	return schema
+}
+
+// avroInference collects the types of the values seen at one place in the
+// sampled documents.
+type avroInference struct {
+	// kinds are the types of the values seen, other than documents and
+	// arrays, in the order they were first seen
+	kinds []string
+	// scale is the most decimal places of the Decimal128 values seen
+	scale int
+
+	record *recordInference
+	array  *avroInference
+}
+
+// recordInference collects the fields of the documents seen at one place
+// in the sampled documents.
+type recordInference struct {
+	keys   []string
+	fields map[string]*avroInference
+}
+
+// Kinds of values collected by avroInference, besides Avro types.
+const (
+	kindTimestamp = "timestamp"
+	kindDecimal   = "decimal"
+	kindObjectID  = "objectId"
+)
+
+func (inference *avroInference) addKind(kind string) {
+	for _, seen := range inference.kinds {
+		if seen == kind {
+			return
+		}
+	}
+	inference.kinds = append(inference.kinds, kind)
+}
+
+// add adds the type of a value to the inference.
+func (inference *avroInference) add(value interface{}) {
+	switch v := value.(type) {
+	case nil:
+		inference.addKind(avroNull)
+	case bool:
+		inference.addKind(avroBoolean)
+	case int, int32:
+		inference.addKind(avroInt)
+	case int64, bson.MongoTimestamp:
+		inference.addKind(avroLong)
+	case float64:
+		inference.addKind(avroDouble)
+	case []byte, bson.Binary:
+		inference.addKind(avroBytes)
+	case time.Time:
+		inference.addKind(kindTimestamp)
+	case bson.ObjectId:
+		inference.addKind(kindObjectID)
+	case bson.Decimal128:
+		unscaled, scale, err := decimalParts(v)
+		if err != nil || scale > avroMaxDecimalPrecision || decimalDigits(unscaled) > avroMaxDecimalPrecision {
+			// NaN, infinities and decimals too precise for a decimal type
+			// are written as Extended JSON
+			inference.addKind(avroString)
+			break
+		}
+		inference.addKind(kindDecimal)
+		if scale > inference.scale {
+			inference.scale = scale
+		}
+	case bson.D:
+		if inference.record == nil {
+			inference.record = &recordInference{fields: map[string]*avroInference{}}
+		}
+		inference.record.add(v)
+	case []interface{}:
+		if inference.array == nil {
+			inference.array = &avroInference{}
+		}
+		for _, element := range v {
+			inference.array.add(element)
+		}
+	default:
+		if value == bson.Undefined {
+			inference.addKind(avroNull)
+		} else {
+			// strings, and values without an Avro equivalent, which are written as Extended JSON
+			inference.addKind(avroString)
+		}
+	}
+}
+
+func (record *recordInference) add(doc bson.D) {
+	for _, elem := range doc {
+		field, ok := record.fields[elem.Name]
+		if !ok {
+			field = &avroInference{}
+			record.fields[elem.Name] = field
+			record.keys = append(record.keys, elem.Name)
+		}
+		field.add(elem.Value)
+	}
+}
+
+// avroSchemaBuilder builds Avro types from inferences, keeping their names unique.
+type avroSchemaBuilder struct {
+	objectIDType string
+	names        map[string]bool
+}
+
+// inferAvroSchema returns a record type for the documents, named name.
+// ObjectIds are written as strings or as 12 byte fixed values, depending on objectIDType.
+func inferAvroSchema(documents []bson.D, name, objectIDType string) *avroType {
+	record := &recordInference{fields: map[string]*avroInference{}}
+	for _, doc := range documents {
+		record.add(doc)
+	}
+	builder := &avroSchemaBuilder{objectIDType: objectIDType, names: map[string]bool{}}
+	return builder.record(record, avroName(name))
+}
+
+// uniqueName returns the name, with a number appended if it is already used.
+func uniqueName(name string, used map[string]bool) string {
+	unique := name
+	for i := 2; used[unique]; i++ {
+		unique = name + "_" + strconv.Itoa(i)
+	}
+	used[unique] = true
+	return unique
+}
+
+func (builder *avroSchemaBuilder) record(record *recordInference, name string) *avroType {
+	t := &avroType{Type: avroRecord, Name: uniqueName(name, builder.names)}
+	fieldNames := map[string]bool{}
+	for _, key := range record.keys {
+		inference := record.fields[key]
+		fieldName := uniqueName(avroName(key), fieldNames)
+		t.Fields = append(t.Fields, avroField{
+			Name: fieldName,
+			Key:  key,
+			Type: builder.build(inference, t.Name+"_"+fieldName),
+		})
+	}
+	return t
+}
+
+// build returns the type for the values of an inference: a union of null
+// and the types of the values seen. Every inferred type is nullable, so that
+// values of other types after the sample can be written as null.
+//
+// A union can't have two branches of the same type, so dates seen along with
+// longs, and decimals seen along with binary data, are written as Extended
+// JSON strings rather than with their logical types.
+func (builder *avroSchemaBuilder) build(inference *avroInference, name string) *avroType {
+	branches := []*avroType{}
+	hasLong, hasBytes := inference.hasKind(avroLong), inference.hasKind(avroBytes)
+	hasString := inference.hasKind(avroString)
+	// addString adds a string branch for values written as strings, unless
+	// strings were seen too
+	addString := func() {
+		if !hasString {
+			branches = append(branches, &avroType{Type: avroString})
+			hasString = true
+		}
+	}
+	for _, kind := range inference.kinds {
+		switch kind {
+		case avroNull:
+		case avroInt:
+			// ints are written as longs if both were seen
+			if !hasLong {
+				branches = append(branches, &avroType{Type: avroInt})
+			}
+		case kindTimestamp:
+			if hasLong {
+				addString()
+				break
+			}
+			branches = append(branches, &avroType{Type: avroLong, LogicalType: avroTimestampMillis})
+		case kindDecimal:
+			if hasBytes {
+				addString()
+				break
+			}
+			precision := avroDecimalPrecision + inference.scale
+			if precision > avroMaxDecimalPrecision {
+				precision = avroMaxDecimalPrecision
+			}
+			branches = append(branches, &avroType{Type: avroBytes, LogicalType: avroDecimal,
+				Precision: precision, Scale: inference.scale})
+		case kindObjectID:
+			if builder.objectIDType == AvroObjectIDFixed {
+				branches = append(branches, &avroType{Type: avroFixed, Name: "ObjectId", Size: 12})
+			} else {
+				addString()
+			}
+		default:
+			branches = append(branches, &avroType{Type: kind})
+		}
+	}
+	if inference.record != nil {
+		branches = append(branches, builder.record(inference.record, name))
+	}
+	if inference.array != nil {
+		branches = append(branches, &avroType{Type: avroArray, Items: builder.build(inference.array, name+"_item")})
+	}
+	if len(branches) == 0 {
+		// only nulls or empty arrays were seen
+		return &avroType{Type: avroNull}
+	}
+	return &avroType{Type: avroUnion, Branches: append([]*avroType{{Type: avroNull}}, branches...)}
+}
+
+func (inference *avroInference) hasKind(kind string) bool {
+	for _, seen := range inference.kinds {
+		if seen == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// avroName returns the name with the characters Avro does not allow in names
+// replaced by '_', and prefixed by '_' if it starts with a digit.
+func avroName(name string) string {
+	runes := []rune(name)
+	for i, r := range runes {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_') {
+			runes[i] = '_'
+		}
+	}
+	if len(runes) == 0 || runes[0] >= '0' && runes[0] <= '9' {
+		return "_" + string(runes)
+	}
+	return string(runes)
+}
+
+// ParseAvroSchema parses an Avro schema in JSON, as found in .avsc files.
+// The schema must be a record, whose fields are matched with the fields of the
+// exported documents by name.
+func ParseAvroSchema(data []byte) (*avroType, error) {
+	var schema interface{}
+	if err := json.Unmarshal(data, &schema); err != nil {
+		return nil, fmt.Errorf("error parsing Avro schema: %v", err)
+	}
+	t, err := parseAvroType(schema, map[string]*avroType{})
+	if err != nil {
+		return nil, fmt.Errorf("invalid Avro schema: %v", err)
+	}
+	if t.Type != avroRecord {
+		return nil, fmt.Errorf("invalid Avro schema: the schema must be a record, not %v", t.Type)
+	}
+	return t, nil
+}
+
+func parseAvroType(schema interface{}, named map[string]*avroType) (*avroType, error) {
+	switch s := schema.(type) {
+	case string:
+		switch s {
+		case avroNull, avroBoolean, avroInt, avroLong, avroFloat, avroDouble, avroBytes, avroString:
+			return &avroType{Type: s}, nil
+		}
+		if t, ok := named[s]; ok {
+			return t, nil
+		}
+		return nil, fmt.Errorf("unknown type '%v'", s)
+	case []interface{}:
+		t := &avroType{Type: avroUnion}
+		for _, branchSchema := range s {
+			branch, err := parseAvroType(branchSchema, named)
+			if err != nil {
+				return nil, err
+			}
+			if branch.Type == avroUnion {
+				return nil, fmt.Errorf("unions must not contain unions")
+			}
+			t.Branches = append(t.Branches, branch)
+		}
+		if len(t.Branches) == 0 {
+			return nil, fmt.Errorf("unions must not be empty")
+		}
+		return t, nil
+	case map[string]interface{}:
+		return parseComplexAvroType(s, named)
+	}
+	return nil, fmt.Errorf("unexpected schema %v", schema)
+}
+
+func parseComplexAvroType(s map[string]interface{}, named map[string]*avroType) (*avroType, error) {
+	typeName, ok := s["type"].(string)
+	if !ok {
+		// a type definition can be nested in "type"
+		if s["type"] == nil {
+			return nil, fmt.Errorf("missing type in %v", s)
+		}
+		return parseAvroType(s["type"], named)
+	}
+	t := &avroType{Type: typeName}
+	t.LogicalType, _ = s["logicalType"].(string)
+
+	switch typeName {
+	case avroRecord, "error", avroEnum, avroFixed:
+		t.Name, _ = s["name"].(string)
+		if t.Name == "" {
+			return nil, fmt.Errorf("%v types must have a name", typeName)
+		}
+		if namespace, _ := s["namespace"].(string); namespace != "" && !strings.Contains(t.Name, ".") {
+			named[namespace+"."+t.Name] = t
+		}
+		named[t.Name] = t
+	}
+
+	switch typeName {
+	case avroNull, avroBoolean, avroInt, avroLong, avroFloat, avroDouble, avroBytes, avroString:
+	case avroRecord, "error":
+		t.Type = avroRecord
+		fields, ok := s["fields"].([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("record '%v' must have fields", t.Name)
+		}
+		for _, fieldSchema := range fields {
+			field, ok := fieldSchema.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("invalid field %v of record '%v'", fieldSchema, t.Name)
+			}
+			name, _ := field["name"].(string)
+			if name == "" {
+				return nil, fmt.Errorf("fields of record '%v' must have a name", t.Name)
+			}
+			fieldType, err := parseAvroType(field["type"], named)
+			if err != nil {
+				return nil, fmt.Errorf("field '%v': %v", name, err)
+			}
+			t.Fields = append(t.Fields, avroField{Name: name, Key: name, Type: fieldType})
+		}
+	case avroEnum:
+		symbols, _ := s["symbols"].([]interface{})
+		for _, symbol := range symbols {
+			name, ok := symbol.(string)
+			if !ok {
+				return nil, fmt.Errorf("invalid symbol %v of enum '%v'", symbol, t.Name)
+			}
+			t.Symbols = append(t.Symbols, name)
+		}
+	case avroArray, avroMap:
+		key := "items"
+		if typeName == avroMap {
+			key = "values"
+		}
+		items, err := parseAvroType(s[key], named)
+		if err != nil {
+			return nil, err
+		}
+		t.Items = items
+	case avroFixed:
+		size, ok := schemaInt(s["size"])
+		if !ok || size < 0 {
+			return nil, fmt.Errorf("fixed type '%v' must have a size", t.Name)
+		}
+		t.Size = size
+	default:
+		return nil, fmt.Errorf("unknown type '%v'", typeName)
+	}
+
+	if t.LogicalType == avroDecimal {
+		precision, _ := schemaInt(s["precision"])
+		scale, _ := schemaInt(s["scale"])
+		if precision <= 0 || scale < 0 || scale > precision {
+			return nil, fmt.Errorf("decimal types must have a precision and a scale no greater than the precision")
+		}
+		t.Precision, t.Scale = precision, scale
+	}
+	return t, nil
+}
+
+// schemaInt returns the value of a number in a parsed schema.
+func schemaInt(value interface{}) (int, bool) {
+	switch v := value.(type) {
+	case int:
+		return v, true
+	case int32:
+		return int(v), true
+	case int64:
+		return int(v), true
+	case float64:
+		return int(v), v == float64(int(v))
+	}
+	return 0, false
+}
+
+// decimalParts returns the unscaled value and the scale of a Decimal128,
+// such that its value is unscaled * 10^-scale, with a scale of at least 0.
+func decimalParts(d bson.Decimal128) (*big.Int, int, error) {
+	s := d.String()
+	exponent := 0
+	if i := strings.IndexByte(s, 'E'); i != -1 {
+		var err error
+		if exponent, err = strconv.Atoi(s[i+1:]); err != nil {
+			return nil, 0, fmt.Errorf("invalid decimal %v", s)
+		}
+		s = s[:i]
+	}
+	scale := 0
+	if i := strings.IndexByte(s, '.'); i != -1 {
+		scale = len(s) - i - 1
+		s = s[:i] + s[i+1:]
+	}
+	unscaled, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		return nil, 0, fmt.Errorf("cannot write decimal %v to Avro", d)
+	}
+	scale -= exponent
+	if scale < 0 {
+		unscaled.Mul(unscaled, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(-scale)), nil))
+		scale = 0
+	}
+	return unscaled, scale, nil
+}
+
+// decimalDigits returns the number of digits of an unscaled decimal value.
+func decimalDigits(unscaled *big.Int) int {
+	return len(new(big.Int).Abs(unscaled).String())
+}
@@ -0,0 +1,284 @@
+package mongoexport
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// readAvroLong reads a zig-zag encoded variable length integer.
+func readAvroLong(r *bytes.Reader) int64 {
+	n, err := binary.ReadVarint(r)
+	So(err, ShouldBeNil)
+	return n
+}
+
+func readAvroBytes(r *bytes.Reader) []byte {
+	data := make([]byte, readAvroLong(r))
+	_, err := r.Read(data)
+	So(err, ShouldBeNil)
+	return data
+}
+
+// readAvroFile returns the metadata of an Avro object container file, and the
+// records of its blocks, decompressed.
+func readAvroFile(data []byte) (map[string]string, int64, []byte) {
+	So(data[:4], ShouldResemble, avroMagic)
+	r := bytes.NewReader(data[4:])
+	meta := map[string]string{}
+	for count := readAvroLong(r); count != 0; count = readAvroLong(r) {
+		for i := int64(0); i < count; i++ {
+			key := string(readAvroBytes(r))
+			meta[key] = string(readAvroBytes(r))
+		}
+	}
+	sync := make([]byte, 16)
+	r.Read(sync)
+
+	records := int64(0)
+	decoded := &bytes.Buffer{}
+	for r.Len() > 0 {
+		records += readAvroLong(r)
+		block := readAvroBytes(r)
+		if meta["avro.codec"] == AvroCodecDeflate {
+			var err error
+			block, err = ioutil.ReadAll(flate.NewReader(bytes.NewReader(block)))
+			So(err, ShouldBeNil)
+		}
+		decoded.Write(block)
+		blockSync := make([]byte, 16)
+		r.Read(blockSync)
+		So(blockSync, ShouldResemble, sync)
+	}
+	return meta, records, decoded.Bytes()
+}
+
+func TestAvroEncoding(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+
+	Convey("Longs should be zig-zag encoded", t, func() {
+		for n, expected := range map[int64][]byte{0: {0}, -1: {1}, 1: {2}, 64: {0x80, 0x01}, -65: {0x81, 0x01}} {
+			buf := &bytes.Buffer{}
+			writeAvroLong(buf, n)
+			So(buf.Bytes(), ShouldResemble, expected)
+		}
+	})
+
+	Convey("Decimals should be written at the scale of the schema", t, func() {
+		t := &avroType{Type: avroBytes, LogicalType: avroDecimal, Precision: 10, Scale: 2}
+		for value, expected := range map[string][]byte{"1.5": {0x00, 0x96}, "-1.5": {0xff, 0x6a}, "2E+1": {0x07, 0xd0}} {
+			d, err := bson.ParseDecimal128(value)
+			So(err, ShouldBeNil)
+			buf := &bytes.Buffer{}
+			So(t.encode(buf, d), ShouldBeNil)
+			So(buf.Bytes(), ShouldResemble, append([]byte{4}, expected...))
+		}
+		d, _ := bson.ParseDecimal128("1.125")
+		So(t.encode(&bytes.Buffer{}, d), ShouldNotBeNil)
+	})
+
+	Convey("Values should be written as the first type of a union that accepts them", t, func() {
+		t := &avroType{Type: avroUnion, Branches: []*avroType{
+			{Type: avroNull}, {Type: avroLong}, {Type: avroString},
+		}}
+		for _, test := range []struct {
+			value    interface{}
+			expected []byte
+		}{
+			{nil, []byte{0}},
+			{3, []byte{2, 6}},
+			{"a", []byte{4, 2, 'a'}},
+			// without an Avro equivalent, as Extended JSON
+			{true, []byte{4, 8, 't', 'r', 'u', 'e'}},
+		} {
+			buf := &bytes.Buffer{}
+			So(t.encode(buf, test.value), ShouldBeNil)
+			So(buf.Bytes(), ShouldResemble, test.expected)
+		}
+	})
+}
+
+func TestAvroSchema(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+
+	Convey("Schemas should be inferred from sampled documents", t, func() {
+		id := bson.ObjectIdHex("5a1b2c3d4e5f60718293a4b5")
+		price, _ := bson.ParseDecimal128("9.99")
+		docs := []bson.D{
+			{{"_id", id}, {"n", 1}, {"at", time.Unix(0, 0)}, {"price", price},
+				{"address", bson.D{{"city", "NYC"}}}, {"tags", []interface{}{"a"}}},
+			{{"_id", id}, {"n", "one"}, {"first-name", "x"}, {"tags", []interface{}{}}},
+		}
+		schema, err := inferAvroSchema(docs, "my.coll", AvroObjectIDString).JSON()
+		So(err, ShouldBeNil)
+		So(string(schema), ShouldEqual, `{"type":"record","name":"my_coll","fields":[`+
+			`{"name":"_id","type":["null","string"],"default":null},`+
+			`{"name":"n","type":["null","int","string"],"default":null},`+
+			`{"name":"at","type":["null",{"type":"long","logicalType":"timestamp-millis"}],"default":null},`+
+			`{"name":"price","type":["null",{"type":"bytes","logicalType":"decimal","precision":36,"scale":2}],"default":null},`+
+			`{"name":"address","type":["null",{"type":"record","name":"my_coll_address","fields":[{"name":"city","type":["null","string"],"default":null}]}],"default":null},`+
+			`{"name":"tags","type":["null",{"type":"array","items":["null","string"]}],"default":null},`+
+			`{"name":"first_name","type":["null","string"],"default":null}]}`)
+
+		Convey("with ObjectIds as fixed values defined once", func() {
+			docs := []bson.D{{{"_id", id}, {"ref", id}}}
+			schema, err := inferAvroSchema(docs, "coll", AvroObjectIDFixed).JSON()
+			So(err, ShouldBeNil)
+			So(string(schema), ShouldEqual, `{"type":"record","name":"coll","fields":[`+
+				`{"name":"_id","type":["null",{"type":"fixed","name":"ObjectId","size":12}],"default":null},`+
+				`{"name":"ref","type":["null","ObjectId"],"default":null}]}`)
+		})
+	})
+
+	Convey("Inferred decimal types should be capped, with other decimals written as strings", t, func() {
+		var docs []bson.D
+		for _, value := range []string{"1.0000000001", "NaN", "-Inf", "1E-6000"} {
+			d, err := bson.ParseDecimal128(value)
+			So(err, ShouldBeNil)
+			docs = append(docs, bson.D{{"d", d}})
+		}
+		schema := inferAvroSchema(docs, "coll", AvroObjectIDString)
+		schemaJSON, err := schema.JSON()
+		So(err, ShouldBeNil)
+		So(string(schemaJSON), ShouldEqual, `{"type":"record","name":"coll","fields":[`+
+			`{"name":"d","type":["null",{"type":"bytes","logicalType":"decimal","precision":38,"scale":10},"string"],"default":null}]}`)
+		for _, doc := range docs[1:] {
+			buf := &bytes.Buffer{}
+			So(schema.encode(buf, doc), ShouldBeNil)
+			So(buf.Bytes()[0], ShouldEqual, 4)
+		}
+	})
+
+	Convey("Unions should not have two branches of the same type", t, func() {
+		price, _ := bson.ParseDecimal128("9.99")
+		at := time.Unix(0, 0)
+		encode := func(schema *avroType, doc bson.D) []byte {
+			buf := &bytes.Buffer{}
+			So(schema.encode(buf, doc), ShouldBeNil)
+			return buf.Bytes()
+		}
+
+		Convey("dates with longs should be written as strings", func() {
+			docs := []bson.D{{{"v", at}}, {{"v", int64(7)}}, {{"v", int32(3)}}}
+			schema := inferAvroSchema(docs, "coll", AvroObjectIDString)
+			schemaJSON, err := schema.JSON()
+			So(err, ShouldBeNil)
+			So(string(schemaJSON), ShouldEqual, `{"type":"record","name":"coll","fields":[`+
+				`{"name":"v","type":["null","string","long"],"default":null}]}`)
+			So(encode(schema, docs[0])[0], ShouldEqual, 2)
+			So(encode(schema, docs[1]), ShouldResemble, []byte{4, 14})
+			So(encode(schema, docs[2]), ShouldResemble, []byte{4, 6})
+		})
+
+		Convey("dates with ints should keep their type, and not take the ints", func() {
+			docs := []bson.D{{{"v", at}}, {{"v", 3}}}
+			schema := inferAvroSchema(docs, "coll", AvroObjectIDString)
+			schemaJSON, err := schema.JSON()
+			So(err, ShouldBeNil)
+			So(string(schemaJSON), ShouldEqual, `{"type":"record","name":"coll","fields":[`+
+				`{"name":"v","type":["null",{"type":"long","logicalType":"timestamp-millis"},"int"],"default":null}]}`)
+			So(encode(schema, docs[0]), ShouldResemble, []byte{2, 0})
+			So(encode(schema, docs[1]), ShouldResemble, []byte{4, 6})
+		})
+
+		Convey("decimals with binary data should be written as strings", func() {
+			docs := []bson.D{{{"v", price}}, {{"v", []byte{1}}}}
+			schema := inferAvroSchema(docs, "coll", AvroObjectIDString)
+			schemaJSON, err := schema.JSON()
+			So(err, ShouldBeNil)
+			So(string(schemaJSON), ShouldEqual, `{"type":"record","name":"coll","fields":[`+
+				`{"name":"v","type":["null","string","bytes"],"default":null}]}`)
+			So(encode(schema, docs[0])[0], ShouldEqual, 2)
+			So(encode(schema, docs[1]), ShouldResemble, []byte{4, 2, 1})
+		})
+
+		Convey("binary data should not be taken by a decimal branch", func() {
+			schema := &avroType{Type: avroBytes, LogicalType: avroDecimal, Precision: 10, Scale: 2}
+			So(schema.accepts([]byte{1}), ShouldBeFalse)
+			So(schema.accepts(bson.Binary{Data: []byte{1}}), ShouldBeFalse)
+		})
+	})
+
+	Convey("Schemas should be parsed from .avsc files", t, func() {
+		schema, err := ParseAvroSchema([]byte(`{"type": "record", "name": "Doc", "fields": [
+			{"name": "_id", "type": {"type": "fixed", "name": "ObjectId", "size": 12}},
+			{"name": "parent", "type": ["null", "ObjectId"]},
+			{"name": "amount", "type": {"type": "bytes", "logicalType": "decimal", "precision": 10, "scale": 2}},
+			{"name": "status", "type": {"type": "enum", "name": "Status", "symbols": ["A", "B"]}},
+			{"name": "attrs", "type": {"type": "map", "values": "long"}}
+		]}`))
+		So(err, ShouldBeNil)
+		So(len(schema.Fields), ShouldEqual, 5)
+		So(schema.Fields[1].Type.Branches[1], ShouldEqual, schema.Fields[0].Type)
+		So(schema.Fields[2].Type.Scale, ShouldEqual, 2)
+		So(schema.Fields[4].Type.Items.Type, ShouldEqual, avroLong)
+
+		_, err = ParseAvroSchema([]byte(`{"type": "record", "name": "Doc", "fields": [{"name": "a", "type": "Unknown"}]}`))
+		So(err, ShouldNotBeNil)
+		_, err = ParseAvroSchema([]byte(`"string"`))
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestWriteAvro(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+
+	Convey("With an Avro export output", t, func() {
+		out := &bytes.Buffer{}
+		docs := []bson.D{{{"_id", 1}, {"name", "a"}}, {{"_id", 2}}}
+		export := func(avroExporter *AvroExportOutput) {
+			So(avroExporter.WriteHeader(), ShouldBeNil)
+			for _, doc := range docs {
+				So(avroExporter.ExportDocument(doc), ShouldBeNil)
+			}
+			So(avroExporter.WriteFooter(), ShouldBeNil)
+			So(avroExporter.Flush(), ShouldBeNil)
+		}
+		// _id 1 and name in their second branch; _id 2, name null
+		expectedRecords := []byte{2, 2, 2, 2, 'a', 2, 4, 0}
+
+		for _, codec := range []string{AvroCodecNull, AvroCodecDeflate} {
+			Convey("the schema should be inferred and the records written with codec "+codec, func() {
+				export(NewAvroExportOutput(codec, out))
+				meta, records, data := readAvroFile(out.Bytes())
+				So(meta["avro.codec"], ShouldEqual, codec)
+				So(meta["avro.schema"], ShouldEqual, `{"type":"record","name":"Document","fields":[`+
+					`{"name":"_id","type":["null","int"],"default":null},{"name":"name","type":["null","string"],"default":null}]}`)
+				So(records, ShouldEqual, 2)
+				So(data, ShouldResemble, expectedRecords)
+			})
+		}
+
+		Convey("values of types not in the sample should be written as null", func() {
+			avroExporter := NewAvroExportOutput(AvroCodecNull, out)
+			avroExporter.SampleSize = 1
+			So(avroExporter.WriteHeader(), ShouldBeNil)
+			So(avroExporter.ExportDocument(bson.D{{"_id", 1}, {"tags", []interface{}{1}}}), ShouldBeNil)
+			So(avroExporter.ExportDocument(bson.D{{"_id", "two"}, {"tags", []interface{}{"a", 2}}}), ShouldBeNil)
+			So(avroExporter.WriteFooter(), ShouldBeNil)
+			_, records, data := readAvroFile(out.Bytes())
+			So(records, ShouldEqual, 2)
+			So(data, ShouldResemble, []byte{2, 2, 2, 2, 2, 2, 0, 0, 2, 4, 0, 2, 4, 0})
+		})
+
+		Convey("documents that do not match a given schema should fail", func() {
+			schema, err := ParseAvroSchema([]byte(`{"type": "record", "name": "Doc", "fields": [
+				{"name": "_id", "type": "long"}, {"name": "name", "type": "string"}]}`))
+			So(err, ShouldBeNil)
+			avroExporter := NewAvroExportOutput(AvroCodecNull, out)
+			avroExporter.SetSchema(schema)
+			So(avroExporter.WriteHeader(), ShouldBeNil)
+			So(avroExporter.ExportDocument(docs[0]), ShouldBeNil)
+			err = avroExporter.ExportDocument(docs[1])
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "field 'name'")
+		})
+	})
+}
@@ -1,15 +1,20 @@
 package mongoexport
 
 import (
+	"bufio"
+	"encoding/base64"
 	"encoding/csv"
 	"fmt"
 	"github.com/mongodb/mongo-tools/common/bsonutil"
 	"github.com/mongodb/mongo-tools/common/json"
+	"github.com/mongodb/mongo-tools/common/log"
 	"gopkg.in/mgo.v2/bson"
 	"io"
+	"math"
 	"reflect"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // type for reflect code
@@ -28,43 +33,211 @@ type CSVExportOutput struct {
 	// NoHeaderLine, if set, will export CSV data without a list of field names at the first line
 	NoHeaderLine bool
 
+	// AutoFields, if greater than zero, is the number of documents to sample to
+	// discover the Fields to export. The sampled documents are held back until
+	// the fields are known, and fields that first appear after them are not exported.
+	AutoFields int
+
+	// FlattenArrays controls how arrays are discovered with AutoFields and how
+	// they are written to a cell.
+	FlattenArrays ArrayFlattening
+
+	// Format controls how dates, ObjectIds, binary data and nulls are written to cells.
+	Format CSVFormat
+
 	csvWriter *csv.Writer
+	// out is the buffer of csvWriter, which uses it as it is
+	out *bufio.Writer
+
+	// documents held back while discovering the fields
+	sample     []bson.D
+	discovered bool
+}
+
+// Ways of flattening arrays in CSV output.
+const (
+	// FlattenJSON writes an array as a JSON array in a single cell.
+	FlattenJSON = "json"
+	// FlattenIndex gives every element of an array a column of its own, e.g. tags.0 and tags.1.
+	FlattenIndex = "index"
+	// FlattenJoin writes the elements of an array to a single cell, joined by a separator.
+	FlattenJoin = "join"
+)
+
+// ArrayFlattening is a way of flattening arrays in CSV output, and the
+// separator to join elements with for FlattenJoin.
+type ArrayFlattening struct {
+	Mode      string
+	Separator string
+}
+
+// ParseArrayFlattening parses the value of --flattenArrays: index, join:<sep> or json.
+// An empty value is the default, json.
+func ParseArrayFlattening(value string) (ArrayFlattening, error) {
+	switch {
+	case value == "" || value == FlattenJSON:
+		return ArrayFlattening{Mode: FlattenJSON}, nil
+	case value == FlattenIndex:
+		return ArrayFlattening{Mode: FlattenIndex}, nil
+	case strings.HasPrefix(value, FlattenJoin+":") && len(value) > len(FlattenJoin)+1:
+		return ArrayFlattening{Mode: FlattenJoin, Separator: value[len(FlattenJoin)+1:]}, nil
+	}
+	return ArrayFlattening{}, fmt.Errorf("invalid --flattenArrays value '%v', choose 'index', 'join:<separator>' or 'json'", value)
+}
+
+// Ways of writing ObjectIds and binary data to CSV cells.
+const (
+	// CSVObjectIDWrapped writes an ObjectId like ObjectId(5a1b2c3d4e5f60718293a4b5).
+	CSVObjectIDWrapped = "wrapped"
+	// CSVObjectIDHex writes an ObjectId as its 24 hex digits.
+	CSVObjectIDHex = "hex"
+	// CSVBinaryHex writes binary data as uppercase hex digits.
+	CSVBinaryHex = "hex"
+	// CSVBinaryBase64 writes binary data as standard base64.
+	CSVBinaryBase64 = "base64"
+)
+
+// CSVFormat controls how values are written to CSV cells. The zero value
+// writes dates in UTC as ISO-8601, ObjectIds wrapped, binary data as hex,
+// nulls as empty cells and numbers as Go formats them.
+type CSVFormat struct {
+	// DateLayout is the Go time layout of dates, e.g. "2006-01-02 15:04:05".
+	DateLayout string
+
+	// Location is the time zone dates are written in, UTC if nil.
+	Location *time.Location
+
+	// ObjectIDFormat is CSVObjectIDWrapped or CSVObjectIDHex.
+	ObjectIDFormat string
+
+	// BinaryFormat is CSVBinaryHex or CSVBinaryBase64.
+	BinaryFormat string
+
+	// NullValue is written for null and missing fields.
+	NullValue string
+
+	// PlainNumbers writes doubles and decimals in decimal notation, without
+	// an exponent unless they are very large or small.
+	PlainNumbers bool
 }
 
 // NewCSVExportOutput returns a CSVExportOutput configured to write output to the
 // given io.Writer, extracting the specified fields only.
 func NewCSVExportOutput(fields []string, noHeaderLine bool, out io.Writer) *CSVExportOutput {
+	buffered := bufio.NewWriter(out)
 	return &CSVExportOutput{
-		fields,
-		0,
-		noHeaderLine,
-		csv.NewWriter(out),
+		Fields:        fields,
+		NoHeaderLine:  noHeaderLine,
+		FlattenArrays: ArrayFlattening{Mode: FlattenJSON},
+		csvWriter:     csv.NewWriter(buffered),
+		out:           buffered,
 	}
 }
 
+// sampling returns true while the fields are being discovered with AutoFields.
+func (csvExporter *CSVExportOutput) sampling() bool {
+	return csvExporter.AutoFields > 0 && !csvExporter.discovered
+}
+
 // WriteHeader writes a comma-delimited list of fields as the output header row.
+// With AutoFields, the header is written once the fields have been discovered.
 func (csvExporter *CSVExportOutput) WriteHeader() error {
-	if !csvExporter.NoHeaderLine {
+	if !csvExporter.NoHeaderLine && !csvExporter.sampling() {
 		csvExporter.csvWriter.Write(csvExporter.Fields)
 		return csvExporter.csvWriter.Error()
 	}
 	return nil
 }
 
-// WriteFooter is a no-op for CSV export formats.
+// WriteFooter writes out the documents held back for AutoFields, if there
+// were fewer of them than the sample size. There is no CSV footer.
 func (csvExporter *CSVExportOutput) WriteFooter() error {
-	// no CSV footer
+	if csvExporter.sampling() {
+		return csvExporter.endSampling()
+	}
+	return nil
+}
+
+// endSampling sets the fields to the flattened fields of the sampled documents,
+// and writes the header and the sampled documents.
+func (csvExporter *CSVExportOutput) endSampling() error {
+	csvExporter.discovered = true
+	csvExporter.Fields = discoverFields(csvExporter.sample, csvExporter.FlattenArrays.Mode == FlattenIndex)
+	log.Logvf(log.Info, "discovered %v fields in %v sampled documents",
+		len(csvExporter.Fields), len(csvExporter.sample))
+	if len(csvExporter.Fields) > 0 {
+		if err := csvExporter.WriteHeader(); err != nil {
+			return err
+		}
+	}
+	for _, document := range csvExporter.sample {
+		if err := csvExporter.ExportDocument(document); err != nil {
+			return err
+		}
+	}
+	csvExporter.sample = nil
 	return nil
 }
 
+// discoverFields returns the union of the dotted paths of the values in the
+// documents, in the order they first appear. Documents are flattened into their
+// fields, and if indexArrays is set, arrays are flattened into their elements.
+func discoverFields(documents []bson.D, indexArrays bool) []string {
+	fields := []string{}
+	seen := map[string]bool{}
+	var addPaths func(prefix string, value interface{})
+	addPaths = func(prefix string, value interface{}) {
+		switch v := value.(type) {
+		case bson.D:
+			if len(v) > 0 {
+				for _, elem := range v {
+					addPaths(prefix+"."+elem.Name, elem.Value)
+				}
+				return
+			}
+		case []interface{}:
+			if indexArrays && len(v) > 0 {
+				for i, element := range v {
+					addPaths(prefix+"."+strconv.Itoa(i), element)
+				}
+				return
+			}
+		}
+		if !seen[prefix] {
+			seen[prefix] = true
+			fields = append(fields, prefix)
+		}
+	}
+	for _, document := range documents {
+		for _, elem := range document {
+			addPaths(elem.Name, elem.Value)
+		}
+	}
+	return fields
+}
+
 // Flush writes any pending data to the underlying I/O stream.
 func (csvExporter *CSVExportOutput) Flush() error {
 	csvExporter.csvWriter.Flush()
 	return csvExporter.csvWriter.Error()
 }
 
+// buffered returns the number of bytes written but not flushed yet.
+func (csvExporter *CSVExportOutput) buffered() int {
+	return csvExporter.out.Buffered()
+}
+
 // ExportDocument writes a line to output with the CSV representation of a document.
 func (csvExporter *CSVExportOutput) ExportDocument(document bson.D) error {
+	if csvExporter.sampling() {
+		// the caller may reuse the document, so hold back a copy
+		csvExporter.sample = append(csvExporter.sample, append(bson.D{}, document...))
+		if len(csvExporter.sample) >= csvExporter.AutoFields {
+			return csvExporter.endSampling()
+		}
+		return nil
+	}
+
 	rowOut := make([]string, 0, len(csvExporter.Fields))
 	extendedDoc, err := bsonutil.ConvertBSONValueToJSON(document)
 	if err != nil {
@@ -72,9 +245,11 @@ func (csvExporter *CSVExportOutput) ExportDocument(document bson.D) error {
 	}
 
 	for _, fieldName := range csvExporter.Fields {
-		fieldVal := extractFieldByName(fieldName, extendedDoc)
-		if fieldVal == nil {
-			rowOut = append(rowOut, "")
+		fieldVal, found := findFieldByName(fieldName, extendedDoc)
+		if array, ok := fieldVal.([]interface{}); ok && csvExporter.FlattenArrays.Mode == FlattenJoin {
+			rowOut = append(rowOut, csvExporter.Format.joinArray(array, csvExporter.FlattenArrays.Separator))
+		} else if !found || fieldVal == nil {
+			rowOut = append(rowOut, csvExporter.Format.NullValue)
 		} else if reflect.TypeOf(fieldVal) == reflect.TypeOf(bson.M{}) ||
 			reflect.TypeOf(fieldVal) == reflect.TypeOf(bson.D{}) ||
 			reflect.TypeOf(fieldVal) == marshalDType ||
@@ -86,7 +261,7 @@ func (csvExporter *CSVExportOutput) ExportDocument(document bson.D) error {
 				rowOut = append(rowOut, string(buf))
 			}
 		} else {
-			rowOut = append(rowOut, fmt.Sprintf("%v", fieldVal))
+			rowOut = append(rowOut, csvExporter.Format.cell(fieldVal))
 		}
 	}
 	csvExporter.csvWriter.Write(rowOut)
@@ -94,24 +269,152 @@ func (csvExporter *CSVExportOutput) ExportDocument(document bson.D) error {
 	return csvExporter.csvWriter.Error()
 }
 
+// cell returns the text of a value that is not a document, array or null.
+func (format CSVFormat) cell(value interface{}) string {
+	switch v := value.(type) {
+	case json.Date:
+		if int64(v) >= int64(32535215999000) {
+			// out of the range of ISO-8601 dates
+			return v.String()
+		}
+		date := time.Unix(int64(v)/1e3, int64(v)%1e3*1e6).UTC()
+		if format.Location != nil {
+			date = date.In(format.Location)
+		}
+		switch {
+		case format.DateLayout != "":
+			return date.Format(format.DateLayout)
+		case format.Location != nil:
+			return date.Format("2006-01-02T15:04:05.000Z07:00")
+		}
+		return date.Format(json.CSV_DATE_FORMAT)
+	case json.ObjectId:
+		if format.ObjectIDFormat == CSVObjectIDHex {
+			return string(v)
+		}
+		return v.String()
+	case json.BinData:
+		if format.BinaryFormat == CSVBinaryBase64 {
+			data, err := base64.StdEncoding.DecodeString(v.Base64)
+			if err != nil {
+				return ""
+			}
+			if v.Type == 0x02 && len(data) >= 4 {
+				data = data[4:] // skip the length of the old binary subtype
+			}
+			return base64.StdEncoding.EncodeToString(data)
+		}
+		return v.String()
+	case json.NumberFloat:
+		if format.PlainNumbers {
+			return formatPlainFloat(float64(v))
+		}
+	case json.Decimal128:
+		if format.PlainNumbers {
+			return formatPlainDecimal(v.Decimal128)
+		}
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+// formatPlainFloat formats a float in decimal notation, without an exponent
+// unless the number is very large or small.
+func formatPlainFloat(f float64) string {
+	switch {
+	case math.IsNaN(f):
+		return "NaN"
+	case math.IsInf(f, 1):
+		return "Infinity"
+	case math.IsInf(f, -1):
+		return "-Infinity"
+	case f == 0 || (math.Abs(f) >= 1e-6 && math.Abs(f) < 1e21):
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// formatPlainDecimal formats a decimal in decimal notation, without an exponent
+// unless the number would need more than 100 digits.
+func formatPlainDecimal(d bson.Decimal128) string {
+	s := d.String()
+	switch s {
+	case "NaN":
+		return s
+	case "Inf":
+		return "Infinity"
+	case "-Inf":
+		return "-Infinity"
+	}
+	unscaled, scale, err := decimalParts(d)
+	if err != nil || scale > 100 || len(unscaled.String()) > 100 {
+		return s
+	}
+	digits := unscaled.String()
+	sign := ""
+	if strings.HasPrefix(digits, "-") {
+		sign, digits = "-", digits[1:]
+	} else if strings.HasPrefix(s, "-") {
+		sign = "-"
+	}
+	if scale == 0 {
+		return sign + digits
+	}
+	if len(digits) <= scale {
+		digits = strings.Repeat("0", scale-len(digits)+1) + digits
+	}
+	return sign + digits[:len(digits)-scale] + "." + digits[len(digits)-scale:]
+}
+
+// joinArray returns the elements of an array joined by a separator, writing
+// documents and arrays nested in the array as JSON.
+func (format CSVFormat) joinArray(array []interface{}, separator string) string {
+	elements := make([]string, 0, len(array))
+	for _, element := range array {
+		switch element.(type) {
+		case nil:
+			elements = append(elements, "")
+		case bson.M, bson.D, bsonutil.MarshalD, []interface{}:
+			buf, err := json.Marshal(element)
+			if err != nil {
+				elements = append(elements, "")
+			} else {
+				elements = append(elements, string(buf))
+			}
+		default:
+			elements = append(elements, format.cell(element))
+		}
+	}
+	return strings.Join(elements, separator)
+}
+
 // extractFieldByName takes a field name and document, and returns a value representing
 // the value of that field in the document in a format that can be printed as a string.
 // It will also handle dot-delimited field names for nested arrays or documents.
 func extractFieldByName(fieldName string, document interface{}) interface{} {
+	value, found := findFieldByName(fieldName, document)
+	if !found {
+		return ""
+	}
+	return value
+}
+
+// findFieldByName returns the value of a field of a document, like
+// extractFieldByName, and whether the field exists.
+func findFieldByName(fieldName string, document interface{}) (interface{}, bool) {
 	dotParts := strings.Split(fieldName, ".")
 	var subdoc interface{} = document
 
 	for _, path := range dotParts {
 		docValue := reflect.ValueOf(subdoc)
 		if !docValue.IsValid() {
-			return ""
+			return nil, false
 		}
 		docType := docValue.Type()
 		docKind := docType.Kind()
 		if docKind == reflect.Map {
 			subdocVal := docValue.MapIndex(reflect.ValueOf(path))
 			if subdocVal.Kind() == reflect.Invalid {
-				return ""
+				return nil, false
 			}
 			subdoc = subdocVal.Interface()
 		} else if docKind == reflect.Slice {
@@ -121,28 +424,28 @@ func extractFieldByName(fieldName string, document interface{}) interface{} {
 				var err error
 				subdoc, err = bsonutil.FindValueByKey(path, &asD)
 				if err != nil {
-					return ""
+					return nil, false
 				}
 			} else {
 				//  check that the path can be converted to int
 				arrayIndex, err := strconv.Atoi(path)
 				if err != nil {
-					return ""
+					return nil, false
 				}
 				// bounds check for slice
 				if arrayIndex < 0 || arrayIndex >= docValue.Len() {
-					return ""
+					return nil, false
 				}
 				subdocVal := docValue.Index(arrayIndex)
 				if subdocVal.Kind() == reflect.Invalid {
-					return ""
+					return nil, false
 				}
 				subdoc = subdocVal.Interface()
 			}
 		} else {
 			// trying to index into a non-compound type - just return blank.
-			return ""
+			return nil, false
 		}
 	}
-	return subdoc
+	return subdoc, true
 }
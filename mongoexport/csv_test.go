@@ -4,11 +4,13 @@ import (
 	"bytes"
 	"encoding/csv"
 	"github.com/mongodb/mongo-tools/common/bsonutil"
+	"github.com/mongodb/mongo-tools/common/options"
 	"github.com/mongodb/mongo-tools/common/testutil"
 	. "github.com/smartystreets/goconvey/convey"
 	"gopkg.in/mgo.v2/bson"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestWriteCSV(t *testing.T) {
@@ -70,6 +72,76 @@ func TestWriteCSV(t *testing.T) {
 	})
 }
 
+func TestAutoFields(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+
+	Convey("With a CSV export output discovering its fields", t, func() {
+		out := &bytes.Buffer{}
+		docs := []bson.D{
+			{{"_id", 1}, {"name", "a"}, {"tags", []interface{}{"x", "y"}}},
+			{{"_id", 2}, {"address", bson.D{{"city", "NYC"}, {"zip", "10001"}}}, {"tags", []interface{}{"z"}}},
+			{{"_id", 3}, {"late", true}},
+		}
+		export := func(csvExporter *CSVExportOutput) [][]string {
+			So(csvExporter.WriteHeader(), ShouldBeNil)
+			for _, doc := range docs {
+				So(csvExporter.ExportDocument(doc), ShouldBeNil)
+			}
+			So(csvExporter.WriteFooter(), ShouldBeNil)
+			So(csvExporter.Flush(), ShouldBeNil)
+			records, err := csv.NewReader(strings.NewReader(out.String())).ReadAll()
+			So(err, ShouldBeNil)
+			return records
+		}
+
+		Convey("the fields of the sampled documents should be flattened", func() {
+			csvExporter := NewCSVExportOutput(nil, false, out)
+			csvExporter.AutoFields = 2
+			So(export(csvExporter), ShouldResemble, [][]string{
+				{"_id", "name", "tags", "address.city", "address.zip"},
+				{"1", "a", `["x","y"]`, "", ""},
+				{"2", "", `["z"]`, "NYC", "10001"},
+				{"3", "", "", "", ""},
+			})
+		})
+
+		Convey("arrays should get a column per element with index flattening", func() {
+			csvExporter := NewCSVExportOutput(nil, false, out)
+			csvExporter.AutoFields = 1000
+			csvExporter.FlattenArrays = ArrayFlattening{Mode: FlattenIndex}
+			So(export(csvExporter), ShouldResemble, [][]string{
+				{"_id", "name", "tags.0", "tags.1", "address.city", "address.zip", "late"},
+				{"1", "a", "x", "y", "", "", ""},
+				{"2", "", "z", "", "NYC", "10001", ""},
+				{"3", "", "", "", "", "", "true"},
+			})
+		})
+
+		Convey("arrays should be joined with join flattening", func() {
+			csvExporter := NewCSVExportOutput([]string{"_id", "tags"}, true, out)
+			csvExporter.FlattenArrays = ArrayFlattening{Mode: FlattenJoin, Separator: "|"}
+			So(export(csvExporter), ShouldResemble, [][]string{
+				{"1", "x|y"},
+				{"2", "z"},
+				{"3", ""},
+			})
+		})
+	})
+
+	Convey("--flattenArrays values should be parsed", t, func() {
+		flattening, err := ParseArrayFlattening("")
+		So(err, ShouldBeNil)
+		So(flattening, ShouldResemble, ArrayFlattening{Mode: FlattenJSON})
+		flattening, err = ParseArrayFlattening("join:; ")
+		So(err, ShouldBeNil)
+		So(flattening, ShouldResemble, ArrayFlattening{Mode: FlattenJoin, Separator: "; "})
+		_, err = ParseArrayFlattening("join:")
+		So(err, ShouldNotBeNil)
+		_, err = ParseArrayFlattening("rows")
+		So(err, ShouldNotBeNil)
+	})
+}
+
 func TestExtractDField(t *testing.T) {
 	Convey("With a test bson.D", t, func() {
 		b := []interface{}{"inner", bsonutil.MarshalD{{"inner2", 1}}}
@@ -137,3 +209,83 @@ func TestExtractDField(t *testing.T) {
 		So(val, ShouldEqual, "")
 	})
 }
+
+func TestCSVFormat(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+
+	decimal, _ := bson.ParseDecimal128("-1.50E-3")
+	bigDecimal, _ := bson.ParseDecimal128("1.5E+3")
+	document := bson.D{
+		{"_id", bson.ObjectIdHex("5a1b2c3d4e5f60718293a4b5")},
+		{"when", time.Date(2017, 3, 4, 23, 30, 0, 0, time.UTC)},
+		{"data", []byte{0xfb, 0xff}},
+		{"none", nil},
+		{"big", 1e6},
+		{"small", 0.25},
+		{"decimal", decimal},
+		{"bigDecimal", bigDecimal},
+	}
+	fields := []string{"_id", "when", "data", "none", "missing", "big", "small", "decimal", "bigDecimal"}
+	export := func(format CSVFormat) []string {
+		out := &bytes.Buffer{}
+		csvExporter := NewCSVExportOutput(fields, true, out)
+		csvExporter.Format = format
+		// the document is converted in place
+		So(csvExporter.ExportDocument(append(bson.D{}, document...)), ShouldBeNil)
+		So(csvExporter.Flush(), ShouldBeNil)
+		rec, err := csv.NewReader(strings.NewReader(out.String())).Read()
+		So(err, ShouldBeNil)
+		return rec
+	}
+
+	Convey("With the default CSV format", t, func() {
+		Convey("values should be written as before", func() {
+			So(export(CSVFormat{}), ShouldResemble, []string{"ObjectId(5a1b2c3d4e5f60718293a4b5)",
+				"2017-03-04T23:30:00.000Z", "FBFF", "", "", "1e+06", "0.25", "-0.00150", "1.5E+3"})
+		})
+	})
+
+	Convey("With a configured CSV format", t, func() {
+		paris, err := time.LoadLocation("Europe/Paris")
+		So(err, ShouldBeNil)
+
+		Convey("dates, ObjectIds, binary data and nulls should use it", func() {
+			rec := export(CSVFormat{
+				DateLayout:     "02/01/2006 15:04",
+				Location:       paris,
+				ObjectIDFormat: CSVObjectIDHex,
+				BinaryFormat:   CSVBinaryBase64,
+				NullValue:      "NULL",
+			})
+			So(rec[:5], ShouldResemble, []string{"5a1b2c3d4e5f60718293a4b5", "05/03/2017 00:30", "+/8=", "NULL", "NULL"})
+		})
+
+		Convey("numbers should be written in plain notation if asked", func() {
+			So(export(CSVFormat{PlainNumbers: true})[5:], ShouldResemble, []string{"1000000", "0.25", "-0.00150", "1500"})
+		})
+
+		Convey("a time zone without a layout should keep ISO-8601 with an offset", func() {
+			So(export(CSVFormat{Location: paris})[1], ShouldEqual, "2017-03-05T00:30:00.000+01:00")
+		})
+	})
+
+	Convey("Floats should only use an exponent when very large or small", t, func() {
+		So(formatPlainFloat(1.5e20), ShouldEqual, "150000000000000000000")
+		So(formatPlainFloat(1e21), ShouldEqual, "1e+21")
+		So(formatPlainFloat(1e-7), ShouldEqual, "1e-07")
+		So(formatPlainFloat(-0.000125), ShouldEqual, "-0.000125")
+	})
+
+	Convey("--csvPlainNumbers should only be used with --type=csv", t, func() {
+		newExport := func(outputType string) *MongoExport {
+			exp := &MongoExport{
+				OutputOpts: &OutputFormatOptions{Type: outputType, Fields: "a", CSVPlainNumbers: true},
+				InputOpts:  &InputOptions{},
+			}
+			exp.ToolOptions.Namespace = &options.Namespace{DB: "db", Collection: "c"}
+			return exp
+		}
+		So(newExport(CSV).ValidateSettings(), ShouldBeNil)
+		So(newExport(JSON).ValidateSettings(), ShouldNotBeNil)
+	})
+}
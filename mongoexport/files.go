@@ -0,0 +1,220 @@
+package mongoexport
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/util"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// WritesMultipleFiles returns true if the export is split into multiple files,
+// either by partitioning the collection or by starting new files as they fill up.
+func (exp *MongoExport) WritesMultipleFiles() bool {
+	return exp.OutputOpts.NumParallelPartitions > 1 ||
+		exp.OutputOpts.MaxFileSize > 0 || exp.OutputOpts.MaxRecordsPerFile > 0
+}
+
+// ExportFiles exports to the multiple files named by the --out pattern. It
+// returns the count of documents exported to all the files.
+func (exp *MongoExport) ExportFiles() (int64, error) {
+	if exp.OutputOpts.NumParallelPartitions > 1 {
+		return exp.exportPartitions()
+	}
+	output := &rotatingOutput{
+		pattern:    exp.OutputOpts.OutputFile,
+		maxBytes:   int64(exp.OutputOpts.MaxFileSize) * 1024 * 1024,
+		maxRecords: int64(exp.OutputOpts.MaxRecordsPerFile),
+		newOutput:  exp.getExportOutput,
+	}
+	defer output.Close()
+	return exp.exportTo(output)
+}
+
+// validateOutputPattern returns an error unless the pattern makes a different
+// file name for every number, like 'part-%03d.json'.
+func validateOutputPattern(pattern string) error {
+	if pattern == "" {
+		return fmt.Errorf("--out is required to write multiple files")
+	}
+	first, second := fmt.Sprintf(pattern, 0), fmt.Sprintf(pattern, 1)
+	if strings.Contains(first, "%!") || first == second {
+		return fmt.Errorf("--out '%v' must contain one number verb, like 'part-%%03d.json', "+
+			"to name multiple files", pattern)
+	}
+	return nil
+}
+
+// createOutputFile creates the numbered file of the --out pattern, along with its directory.
+func createOutputFile(pattern string, index int) (*os.File, error) {
+	name := util.ToUniversalPath(fmt.Sprintf(pattern, index))
+	if err := os.MkdirAll(filepath.Dir(name), 0750); err != nil {
+		return nil, err
+	}
+	return os.Create(name)
+}
+
+// countingWriter is an io.Writer that counts the bytes written through it.
+type countingWriter struct {
+	io.Writer
+	written int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	w.written += int64(n)
+	return n, err
+}
+
+// bufferingOutput is an ExportOutput that holds back some of what it writes
+// until it is flushed.
+type bufferingOutput interface {
+	buffered() int
+}
+
+// rotatingOutput is an ExportOutput that writes to a numbered sequence of files,
+// starting a new file once the current one reaches the maximum size or number
+// of records. Each file gets the header and footer of its own ExportOutput, so
+// that every file can be loaded by itself. The size of a file is what was
+// written to it and what its output holds back, so that the output is only
+// flushed when the file is finished. A file can exceed the maximum size by the
+// last record written to it, and by the documents sampled to infer the fields,
+// schema or columns of the first file, which are only written once sampled.
+type rotatingOutput struct {
+	pattern    string
+	maxBytes   int64
+	maxRecords int64
+	newOutput  func(io.Writer) (ExportOutput, error)
+
+	index   int
+	file    *os.File
+	counter *countingWriter
+	output  ExportOutput
+	records int64
+
+	// the CSV fields discovered, the Avro schema and the SQL columns inferred
+	// in the first file are used for the following files
+	fields  []string
+	schema  *avroType
+	columns []sqlColumn
+}
+
+// open creates the next file and writes its header.
+func (r *rotatingOutput) open() error {
+	file, err := createOutputFile(r.pattern, r.index)
+	if err != nil {
+		return err
+	}
+	r.index++
+	r.file = file
+	r.counter = &countingWriter{Writer: file}
+	r.records = 0
+	if r.output, err = r.newOutput(r.counter); err != nil {
+		return err
+	}
+	switch output := r.output.(type) {
+	case *CSVExportOutput:
+		if r.fields != nil {
+			output.Fields = r.fields
+			output.AutoFields = 0
+		}
+	case *AvroExportOutput:
+		if r.schema != nil {
+			output.SetSchema(r.schema)
+		}
+	case *SQLExportOutput:
+		if r.columns != nil {
+			output.setColumns(r.columns)
+		}
+	}
+	return r.output.WriteHeader()
+}
+
+// finish writes the footer of the current file and closes it.
+func (r *rotatingOutput) finish() error {
+	if err := r.output.WriteFooter(); err != nil {
+		return err
+	}
+	if err := r.output.Flush(); err != nil {
+		return err
+	}
+	switch output := r.output.(type) {
+	case *CSVExportOutput:
+		r.fields = output.Fields
+	case *AvroExportOutput:
+		r.schema = output.schema
+	case *SQLExportOutput:
+		if output.columns != nil {
+			r.columns = output.columns
+		}
+	}
+	log.Logvf(log.Info, "exported %v %v to %v", r.records,
+		util.Pluralize(int(r.records), "record", "records"), r.file.Name())
+	r.output = nil
+	return r.closeFile()
+}
+
+// closeFile closes the current file, if there is one.
+func (r *rotatingOutput) closeFile() error {
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
+
+// WriteHeader creates the first file, so that there is one even if there are no documents.
+func (r *rotatingOutput) WriteHeader() error {
+	return r.open()
+}
+
+// ExportDocument writes the document to the current file, creating a new file
+// first if the previous one is full.
+func (r *rotatingOutput) ExportDocument(document bson.D) error {
+	if r.output == nil {
+		if err := r.open(); err != nil {
+			return err
+		}
+	}
+	if err := r.output.ExportDocument(document); err != nil {
+		return err
+	}
+	r.records++
+	if (r.maxRecords > 0 && r.records >= r.maxRecords) ||
+		(r.maxBytes > 0 && r.size() >= r.maxBytes) {
+		return r.finish()
+	}
+	return nil
+}
+
+// size returns the size the current file will have once its output is flushed.
+func (r *rotatingOutput) size() int64 {
+	size := r.counter.written
+	if output, ok := r.output.(bufferingOutput); ok {
+		size += int64(output.buffered())
+	}
+	return size
+}
+
+// WriteFooter finishes the current file.
+func (r *rotatingOutput) WriteFooter() error {
+	if r.output == nil {
+		return nil
+	}
+	return r.finish()
+}
+
+// Flush is a no-op, since files are flushed as they are finished.
+func (r *rotatingOutput) Flush() error {
+	return nil
+}
+
+// Close closes the current file if the export stopped before finishing it.
+func (r *rotatingOutput) Close() {
+	r.closeFile()
+}
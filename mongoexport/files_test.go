@@ -0,0 +1,159 @@
+package mongoexport
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// flushCountingOutput is a CSVExportOutput that counts how often it is flushed.
+type flushCountingOutput struct {
+	*CSVExportOutput
+	flushes *int
+}
+
+func (output *flushCountingOutput) Flush() error {
+	*output.flushes++
+	return output.CSVExportOutput.Flush()
+}
+
+func TestRotatingOutput(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+
+	Convey("With a rotating output", t, func() {
+		dir, err := ioutil.TempDir("", "mongoexport-files-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		pattern := filepath.Join(dir, "out", "part-%03d.json")
+
+		export := func(output *rotatingOutput, count int) []string {
+			defer output.Close()
+			So(output.WriteHeader(), ShouldBeNil)
+			for i := 0; i < count; i++ {
+				So(output.ExportDocument(bson.D{{"_id", i}, {"name", "document"}}), ShouldBeNil)
+			}
+			So(output.WriteFooter(), ShouldBeNil)
+			So(output.Flush(), ShouldBeNil)
+
+			names, err := filepath.Glob(filepath.Join(dir, "out", "part-*.json"))
+			So(err, ShouldBeNil)
+			contents := []string{}
+			for _, name := range names {
+				content, err := ioutil.ReadFile(name)
+				So(err, ShouldBeNil)
+				contents = append(contents, string(content))
+			}
+			return contents
+		}
+
+		Convey("a new file should be started after the maximum number of records", func() {
+			output := &rotatingOutput{
+				pattern:    pattern,
+				maxRecords: 2,
+				newOutput: func(out io.Writer) (ExportOutput, error) {
+					return NewJSONExportOutput(true, false, out), nil
+				},
+			}
+			contents := export(output, 5)
+			So(len(contents), ShouldEqual, 3)
+			So(strings.Count(contents[0], "_id"), ShouldEqual, 2)
+			So(strings.Count(contents[2], "_id"), ShouldEqual, 1)
+			// every file should be a complete JSON array
+			for _, content := range contents {
+				So(content, ShouldStartWith, "[")
+				So(strings.TrimSpace(content), ShouldEndWith, "]")
+			}
+		})
+
+		Convey("a new file should be started once a file reaches the maximum size", func() {
+			flushes := 0
+			output := &rotatingOutput{
+				pattern:  pattern,
+				maxBytes: 50,
+				newOutput: func(out io.Writer) (ExportOutput, error) {
+					return &flushCountingOutput{NewCSVExportOutput([]string{"_id", "name"}, false, out), &flushes}, nil
+				},
+			}
+			contents := export(output, 10)
+			So(len(contents), ShouldEqual, 3)
+			// the output should only be flushed when its file is finished
+			So(flushes, ShouldEqual, 3)
+			for _, content := range contents {
+				So(content, ShouldStartWith, "_id,name\n")
+			}
+			So(contents[0], ShouldEqual, "_id,name\n0,document\n1,document\n2,document\n3,document\n")
+		})
+
+		Convey("fields discovered in the first file should be used for the following files", func() {
+			output := &rotatingOutput{
+				pattern:    pattern,
+				maxRecords: 1,
+				newOutput: func(out io.Writer) (ExportOutput, error) {
+					csvOutput := NewCSVExportOutput(nil, false, out)
+					csvOutput.AutoFields = 10
+					return csvOutput, nil
+				},
+			}
+			So(export(output, 2), ShouldResemble, []string{
+				"_id,name\n0,document\n",
+				"_id,name\n1,document\n",
+			})
+		})
+
+		Convey("a file should be written even if there are no documents", func() {
+			output := &rotatingOutput{
+				pattern:    pattern,
+				maxRecords: 2,
+				newOutput: func(out io.Writer) (ExportOutput, error) {
+					return NewJSONExportOutput(true, false, out), nil
+				},
+			}
+			contents := export(output, 0)
+			So(len(contents), ShouldEqual, 1)
+			So(strings.TrimSpace(contents[0]), ShouldEqual, "[]")
+		})
+	})
+
+	Convey("Output patterns should need a number verb", t, func() {
+		So(validateOutputPattern("part-%03d.json"), ShouldBeNil)
+		So(validateOutputPattern("dir/%d/out.csv"), ShouldBeNil)
+		So(validateOutputPattern(""), ShouldNotBeNil)
+		So(validateOutputPattern("out.json"), ShouldNotBeNil)
+		So(validateOutputPattern("part-%s.json"), ShouldNotBeNil)
+		So(validateOutputPattern("part-%d-%d.json"), ShouldNotBeNil)
+	})
+}
+
+func TestPartitions(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+
+	Convey("Partitions should cover the _id ranges between the bounds", t, func() {
+		partitions := makePartitions([]interface{}{10, 20})
+		So(len(partitions), ShouldEqual, 3)
+		So(partitions[0].restrict(nil), ShouldResemble, map[string]interface{}{"_id": bson.M{"$lt": 10}})
+		So(partitions[1].restrict(nil), ShouldResemble, map[string]interface{}{"_id": bson.M{"$gte": 10, "$lt": 20}})
+		So(partitions[2].restrict(map[string]interface{}{"x": 1}), ShouldResemble, map[string]interface{}{
+			"$and": []interface{}{map[string]interface{}{"x": 1}, bson.M{"_id": bson.M{"$gte": 20}}},
+		})
+
+		Convey("and a single partition should export everything", func() {
+			partitions := makePartitions(nil)
+			So(len(partitions), ShouldEqual, 1)
+			So(partitions[0].restrict(nil), ShouldBeNil)
+		})
+	})
+
+	Convey("_id values should be classed by how ranges compare them", t, func() {
+		So(idTypeClass(1), ShouldEqual, idTypeClass(2.5))
+		So(idTypeClass(int64(1)), ShouldEqual, "number")
+		So(idTypeClass("a"), ShouldEqual, "string")
+		So(idTypeClass(bson.NewObjectId()), ShouldNotEqual, idTypeClass("a"))
+	})
+}
@@ -0,0 +1,587 @@
+package mongoexport
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/bsonutil"
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/util"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Ways of following a collection.
+const (
+	// FollowCapped tails a capped collection with a tailable cursor.
+	FollowCapped = "capped"
+	// FollowOplog tails the oplog of a replica set for the operations on the collection.
+	FollowOplog = "oplog"
+)
+
+const (
+	// followAwaitTime is how long a tailable cursor waits for new data, and
+	// so how often an interrupt is noticed.
+	followAwaitTime = time.Second
+	// resumeSaveInterval is how often the resume position is written while following.
+	resumeSaveInterval = time.Second
+)
+
+// Operations of the events exported when following the oplog.
+const (
+	followInsert = "insert"
+	followUpdate = "update"
+	followDelete = "delete"
+)
+
+// followPosition is the position of a follow, kept in the resume file: the
+// timestamp of the last oplog entry exported, or the _id of the last document
+// exported from a capped collection.
+type followPosition struct {
+	Namespace string
+	Mode      string
+	Timestamp bson.MongoTimestamp
+	ID        interface{}
+}
+
+// readFollowPosition reads the resume file. It returns nil if the file does not exist yet.
+func readFollowPosition(path string) (*followPosition, error) {
+	doc, err := readEJSONFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading resumeFile: %v", err)
+	}
+	if doc == nil {
+		return nil, nil
+	}
+	position := &followPosition{}
+	for _, elem := range doc {
+		switch elem.Name {
+		case "namespace":
+			position.Namespace, _ = elem.Value.(string)
+		case "mode":
+			position.Mode, _ = elem.Value.(string)
+		case "ts":
+			position.Timestamp, _ = elem.Value.(bson.MongoTimestamp)
+		case "_id":
+			position.ID = elem.Value
+		}
+	}
+	switch {
+	case position.Namespace == "":
+	case position.Mode == FollowOplog && position.Timestamp != 0:
+		return position, nil
+	case position.Mode == FollowCapped && position.ID != nil:
+		return position, nil
+	}
+	return nil, fmt.Errorf("resumeFile %v must have a namespace, and the ts of an oplog "+
+		"or the _id of a capped collection", path)
+}
+
+// writeFollowPosition writes the resume file atomically.
+func writeFollowPosition(path string, position *followPosition) error {
+	doc := bson.D{{Name: "namespace", Value: position.Namespace}, {Name: "mode", Value: position.Mode}}
+	if position.Mode == FollowOplog {
+		doc = append(doc, bson.DocElem{Name: "ts", Value: position.Timestamp})
+	} else {
+		doc = append(doc, bson.DocElem{Name: "_id", Value: position.ID})
+	}
+	if err := writeEJSONFile(path, doc); err != nil {
+		return fmt.Errorf("error writing resumeFile: %v", err)
+	}
+	return nil
+}
+
+// HandleInterrupt stops following the collection, after the documents read so
+// far are exported and the resume position is saved.
+func (exp *MongoExport) HandleInterrupt() {
+	stop := exp.followStopChan()
+	exp.followStopOnce.Do(func() { close(stop) })
+}
+
+func (exp *MongoExport) followStopChan() chan struct{} {
+	exp.followStopInit.Do(func() { exp.followStop = make(chan struct{}) })
+	return exp.followStop
+}
+
+// followStopped returns true once the follow has been interrupted.
+func (exp *MongoExport) followStopped() bool {
+	select {
+	case <-exp.followStopChan():
+		return true
+	default:
+		return false
+	}
+}
+
+// getFollowMode returns how the collection can be followed: with a tailable
+// cursor if it is capped, or else through the oplog if connected to a replica set.
+func (exp *MongoExport) getFollowMode() (string, error) {
+	session, err := exp.SessionProvider.GetSession()
+	if err != nil {
+		return "", err
+	}
+	defer session.Close()
+	opts, err := db.GetCollectionOptions(session.DB(exp.ToolOptions.Namespace.DB).C(exp.ToolOptions.Namespace.Collection))
+	if err != nil {
+		return "", err
+	}
+	if opts != nil {
+		if capped, _ := bsonutil.FindValueByKey("capped", opts); capped == true {
+			return FollowCapped, nil
+		}
+	}
+	isReplicaSet, err := exp.SessionProvider.IsReplicaSet()
+	if err != nil {
+		return "", err
+	}
+	if !isReplicaSet {
+		return "", fmt.Errorf("--follow requires a capped collection, or a replica set to follow the oplog of")
+	}
+	return FollowOplog, nil
+}
+
+// follow exports the documents of the collection and then keeps exporting
+// new documents, until interrupted. A capped collection is followed with a
+// tailable cursor, exporting new documents as they are inserted. Other
+// collections are followed through the oplog, exporting an event for every
+// insert, update and delete, with the document as it is after the operation.
+// The position is saved to the resume file, and a follow that finds a resume
+// file continues from its position instead of exporting the collection again.
+func (exp *MongoExport) follow(exportOutput ExportOutput) (int64, error) {
+	mode, err := exp.getFollowMode()
+	if err != nil {
+		return 0, err
+	}
+	var position *followPosition
+	if exp.InputOpts.ResumeFile != "" {
+		if position, err = readFollowPosition(exp.InputOpts.ResumeFile); err != nil {
+			return 0, err
+		}
+	}
+	namespace := exp.ToolOptions.Namespace.DB + "." + exp.ToolOptions.Namespace.Collection
+	if position != nil && (position.Namespace != namespace || position.Mode != mode) {
+		return 0, fmt.Errorf("resumeFile %v is for following %v of %v, not %v of %v",
+			exp.InputOpts.ResumeFile, position.Mode, position.Namespace, mode, namespace)
+	}
+	if position == nil {
+		position = &followPosition{Namespace: namespace, Mode: mode}
+	} else {
+		log.Logvf(log.Always, "resuming from %v", exp.InputOpts.ResumeFile)
+	}
+	exp.logConnection()
+
+	if err = exportOutput.WriteHeader(); err != nil {
+		return 0, err
+	}
+	follower := &follower{exp: exp, output: exportOutput, position: position, lastSave: time.Now()}
+	if mode == FollowCapped {
+		err = follower.followCapped()
+	} else {
+		err = follower.followOplog()
+	}
+	// save how far the follow got, even if it failed
+	if saveErr := follower.savePosition(); err == nil {
+		err = saveErr
+	}
+	if err != nil {
+		return follower.count, err
+	}
+	if err = exportOutput.WriteFooter(); err != nil {
+		return follower.count, err
+	}
+	return follower.count, exportOutput.Flush()
+}
+
+// follower exports the documents of a follow, and keeps its position.
+type follower struct {
+	exp      *MongoExport
+	output   ExportOutput
+	position *followPosition
+	count    int64
+
+	changed  bool
+	lastSave time.Time
+}
+
+// exported moves the position past the count documents or events just
+// exported, and saves the position every resumeSaveInterval.
+func (f *follower) exported(count int64) error {
+	f.count += count
+	f.changed = true
+	if time.Since(f.lastSave) >= resumeSaveInterval {
+		return f.savePosition()
+	}
+	return nil
+}
+
+// savePosition writes the position to the resume file, once the output has
+// been flushed, so that a resumed follow does not skip documents.
+func (f *follower) savePosition() error {
+	if !f.changed || f.exp.InputOpts.ResumeFile == "" {
+		return nil
+	}
+	if err := f.output.Flush(); err != nil {
+		return err
+	}
+	if err := writeFollowPosition(f.exp.InputOpts.ResumeFile, f.position); err != nil {
+		return err
+	}
+	f.changed = false
+	f.lastSave = time.Now()
+	return nil
+}
+
+// idle is called whenever a tailable cursor is out of data. It saves the
+// position, and returns true once the follow has been interrupted.
+func (f *follower) idle() (bool, error) {
+	if err := f.savePosition(); err != nil {
+		return false, err
+	}
+	return f.exp.followStopped(), nil
+}
+
+// followCapped exports the documents of a capped collection in insertion
+// order with a tailable cursor, starting after the _id of the position, if any.
+func (f *follower) followCapped() error {
+	session, err := f.exp.SessionProvider.GetSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	collection := session.DB(f.exp.ToolOptions.Namespace.DB).C(f.exp.ToolOptions.Namespace.Collection)
+
+	query := map[string]interface{}{}
+	if f.exp.InputOpts.HasQuery() {
+		content, err := f.exp.InputOpts.GetQuery()
+		if err != nil {
+			return err
+		}
+		if query, err = getObjectFromByteArg(content); err != nil {
+			return err
+		}
+	}
+
+	var result bson.D
+	for {
+		// documents of a capped collection are in insertion order, which
+		// their _ids normally follow
+		cursorQuery := query
+		if f.position.ID != nil {
+			after := bson.M{"_id": bson.M{"$gt": f.position.ID}}
+			if len(query) == 0 {
+				cursorQuery = after
+			} else {
+				cursorQuery = map[string]interface{}{"$and": []interface{}{query, after}}
+			}
+		}
+		q := collection.Find(cursorQuery)
+		if len(f.exp.OutputOpts.Fields) > 0 {
+			q.Select(makeFieldSelector(f.exp.OutputOpts.Fields))
+		}
+		iter := q.Tail(followAwaitTime)
+		for {
+			for iter.Next(&result) {
+				id, _ := lookupDottedField(result, "_id")
+				if err = f.output.ExportDocument(result); err != nil {
+					iter.Close()
+					return err
+				}
+				f.position.ID = id
+				if err = f.exported(1); err != nil {
+					iter.Close()
+					return err
+				}
+				if f.exp.followStopped() {
+					iter.Close()
+					return f.savePosition()
+				}
+			}
+			if err = iter.Err(); err != nil {
+				iter.Close()
+				return err
+			}
+			stopped, err := f.idle()
+			if err != nil || stopped {
+				iter.Close()
+				return err
+			}
+			if !iter.Timeout() {
+				// the cursor is dead, as it is for an empty collection; start a new one
+				break
+			}
+		}
+		iter.Close()
+		time.Sleep(followAwaitTime)
+	}
+}
+
+// followOplog exports an insert event for every document of the collection,
+// and then an event for every operation on the collection in the oplog after
+// the position. Without a position, the
+// oplog is followed from before the collection is exported, so that no
+// operation is missed; operations during the export may be exported twice.
+func (f *follower) followOplog() error {
+	session, err := f.exp.SessionProvider.GetSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	oplog := session.DB("local").C("oplog.rs")
+
+	if f.position.Timestamp == 0 {
+		latest := db.Oplog{}
+		if err = oplog.Find(nil).Sort("-$natural").One(&latest); err != nil {
+			return fmt.Errorf("error reading the latest oplog entry: %v", err)
+		}
+		cursor, cursorSession, err := f.exp.getCursor(nil)
+		if err != nil {
+			if cursorSession != nil {
+				cursorSession.Close()
+			}
+			return err
+		}
+		// the documents are exported as insert events, like those of the oplog
+		snapshot := &snapshotOutput{ExportOutput: f.output, namespace: f.position.Namespace, timestamp: latest.Timestamp}
+		count, err := exportDocuments(cursor, snapshot, f.exp.followStopped)
+		cursor.Close()
+		cursorSession.Close()
+		f.count += count
+		if err != nil {
+			return err
+		}
+		if f.exp.followStopped() {
+			// no position is saved, so the next follow exports the
+			// collection again
+			log.Logvf(log.Always, "interrupted after exporting %v %v, before following the oplog",
+				count, util.Pluralize(int(count), "record", "records"))
+			return nil
+		}
+		log.Logvf(log.Always, "exported %v %v, following the oplog", count,
+			util.Pluralize(int(count), "record", "records"))
+		f.position.Timestamp = latest.Timestamp
+		f.changed = true
+		if err = f.savePosition(); err != nil {
+			return err
+		}
+	} else {
+		oldest := db.Oplog{}
+		if err = oplog.Find(nil).Sort("$natural").One(&oldest); err != nil {
+			return fmt.Errorf("error reading the oldest oplog entry: %v", err)
+		}
+		if oldest.Timestamp > f.position.Timestamp {
+			return fmt.Errorf("the oplog no longer holds the resume position %v, "+
+				"remove the resumeFile to export the collection again", f.position.Timestamp)
+		}
+	}
+
+	var entry db.Oplog
+	for {
+		query := bson.M{
+			"ts": bson.M{"$gt": f.position.Timestamp},
+			"$or": []bson.M{
+				{"ns": f.position.Namespace, "op": bson.M{"$in": []string{"i", "u", "d"}}},
+				// the operations of transactions are in applyOps commands
+				{"op": "c", "o.applyOps.ns": f.position.Namespace},
+			},
+		}
+		iter := oplog.Find(query).LogReplay().Tail(followAwaitTime)
+		for {
+			for iter.Next(&entry) {
+				if err = f.exportOplogEntry(session, &entry); err != nil {
+					iter.Close()
+					return err
+				}
+				if f.exp.followStopped() {
+					iter.Close()
+					return f.savePosition()
+				}
+			}
+			if err = iter.Err(); err != nil {
+				iter.Close()
+				return err
+			}
+			stopped, err := f.idle()
+			if err != nil || stopped {
+				iter.Close()
+				return err
+			}
+			if !iter.Timeout() {
+				break
+			}
+		}
+		iter.Close()
+		time.Sleep(followAwaitTime)
+	}
+}
+
+// exportOplogEntry exports the events of an oplog entry, which is either an
+// operation on the collection or a transaction, and moves the position past it.
+func (f *follower) exportOplogEntry(session *mgo.Session, entry *db.Oplog) error {
+	operations := []*db.Oplog{entry}
+	if entry.Operation == "c" {
+		var err error
+		if operations, err = f.transactionOperations(entry); err != nil {
+			return err
+		}
+	}
+	count := int64(0)
+	for _, operation := range operations {
+		exported, err := f.exportOperation(session, operation)
+		if err != nil {
+			return err
+		}
+		if exported {
+			count++
+		}
+	}
+	f.position.Timestamp = entry.Timestamp
+	return f.exported(count)
+}
+
+// transactionOperations returns the inserts, updates and deletes of the
+// collection in the applyOps command of a transaction, with the timestamp of
+// the transaction. Prepared transactions and those written to several oplog
+// entries can't be followed, since their operations are only committed by a
+// later entry.
+func (f *follower) transactionOperations(entry *db.Oplog) ([]*db.Oplog, error) {
+	for _, elem := range entry.Object {
+		if (elem.Name == "prepare" || elem.Name == "partialTxn") && elem.Value == true {
+			return nil, fmt.Errorf("can not follow the transaction at %v: prepared transactions "+
+				"and transactions written to several oplog entries are not supported", entry.Timestamp)
+		}
+	}
+	value, _ := lookupDottedField(entry.Object, "applyOps")
+	applyOps, _ := value.([]interface{})
+	var operations []*db.Oplog
+	for _, op := range applyOps {
+		data, err := bson.Marshal(op)
+		if err != nil {
+			return nil, err
+		}
+		operation := &db.Oplog{}
+		if err = bson.Unmarshal(data, operation); err != nil {
+			return nil, fmt.Errorf("error reading the transaction at %v: %v", entry.Timestamp, err)
+		}
+		if operation.Namespace != f.position.Namespace {
+			continue
+		}
+		switch operation.Operation {
+		case "i", "u", "d":
+			operation.Timestamp = entry.Timestamp
+			operations = append(operations, operation)
+		}
+	}
+	return operations, nil
+}
+
+// exportOperation exports the event of an operation on the collection: its
+// operation, its timestamp, the _id of the document and, for inserts and
+// updates, the document as it is now. With a query, inserts and updates are
+// only exported if the document matches it. It returns false if the event
+// was not exported.
+func (f *follower) exportOperation(session *mgo.Session, entry *db.Oplog) (bool, error) {
+	var operation string
+	var id interface{}
+	switch entry.Operation {
+	case "i":
+		operation = followInsert
+		id, _ = lookupDottedField(entry.Object, "_id")
+	case "u":
+		operation = followUpdate
+		id, _ = lookupDottedField(entry.Query, "_id")
+	case "d":
+		operation = followDelete
+		id, _ = lookupDottedField(entry.Object, "_id")
+	}
+
+	event := bson.D{
+		{Name: "op", Value: operation},
+		{Name: "ns", Value: entry.Namespace},
+		{Name: "ts", Value: entry.Timestamp},
+		{Name: "_id", Value: id},
+	}
+	if operation != followDelete {
+		document, err := f.postImage(session, entry, id)
+		if err != nil {
+			return false, err
+		}
+		if document == nil && f.exp.InputOpts.HasQuery() {
+			// the document does not match the query
+			return false, nil
+		}
+		event = append(event, bson.DocElem{Name: "document", Value: document})
+	}
+	return true, f.output.ExportDocument(event)
+}
+
+// snapshotOutput is an ExportOutput that writes the documents exported before
+// following the oplog as insert events at the timestamp the oplog is followed
+// from, so that the output only has events.
+type snapshotOutput struct {
+	ExportOutput
+	namespace string
+	timestamp bson.MongoTimestamp
+}
+
+func (s *snapshotOutput) ExportDocument(document bson.D) error {
+	id, _ := lookupDottedField(document, "_id")
+	return s.ExportOutput.ExportDocument(bson.D{
+		{Name: "op", Value: followInsert},
+		{Name: "ns", Value: s.namespace},
+		{Name: "ts", Value: s.timestamp},
+		{Name: "_id", Value: id},
+		{Name: "document", Value: document},
+	})
+}
+
+// postImage returns the document of an oplog entry as it is now, or nil if it
+// has been deleted since, or doesn't match the query. Without a query or
+// projection, inserted documents are taken from the oplog entry.
+func (f *follower) postImage(session *mgo.Session, entry *db.Oplog, id interface{}) (interface{}, error) {
+	if entry.Operation == "i" && !f.exp.InputOpts.HasQuery() && f.exp.OutputOpts.Fields == "" {
+		return entry.Object, nil
+	}
+	query := map[string]interface{}{"_id": id}
+	if f.exp.InputOpts.HasQuery() {
+		content, err := f.exp.InputOpts.GetQuery()
+		if err != nil {
+			return nil, err
+		}
+		filter, err := getObjectFromByteArg(content)
+		if err != nil {
+			return nil, err
+		}
+		query = map[string]interface{}{"$and": []interface{}{filter, query}}
+	}
+	q := session.DB(f.exp.ToolOptions.Namespace.DB).C(f.exp.ToolOptions.Namespace.Collection).Find(query)
+	if len(f.exp.OutputOpts.Fields) > 0 {
+		q.Select(makeFieldSelector(f.exp.OutputOpts.Fields))
+	}
+	var document bson.D
+	err := q.One(&document)
+	if err == mgo.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error fetching document %v: %v", id, err)
+	}
+	return document, nil
+}
+
+// exportDocuments writes the documents of the cursor to the ExportOutput,
+// without a header or footer, until stopped returns true.
+func exportDocuments(cursor *mgo.Iter, exportOutput ExportOutput, stopped func() bool) (int64, error) {
+	var result bson.D
+	count := int64(0)
+	for cursor.Next(&result) {
+		if err := exportOutput.ExportDocument(result); err != nil {
+			return count, err
+		}
+		count++
+		if stopped() {
+			return count, nil
+		}
+	}
+	return count, cursor.Err()
+}
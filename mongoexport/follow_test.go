@@ -0,0 +1,158 @@
+package mongoexport
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/options"
+	"github.com/mongodb/mongo-tools/common/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestFollow(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+
+	newExport := func(input *InputOptions, output *OutputFormatOptions) *MongoExport {
+		return &MongoExport{
+			ToolOptions: options.ToolOptions{Namespace: &options.Namespace{DB: "db", Collection: "coll"}},
+			OutputOpts:  output,
+			InputOpts:   input,
+		}
+	}
+
+	Convey("With a resume file", t, func() {
+		dir, err := ioutil.TempDir("", "mongoexport-follow-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		resumeFile := filepath.Join(dir, "follow", "resume.json")
+
+		Convey("a missing file should mean starting from the beginning", func() {
+			position, err := readFollowPosition(resumeFile)
+			So(err, ShouldBeNil)
+			So(position, ShouldBeNil)
+		})
+
+		Convey("the oplog timestamp should survive a round trip", func() {
+			position := &followPosition{Namespace: "db.coll", Mode: FollowOplog,
+				Timestamp: bson.MongoTimestamp(6000000000000000001)}
+			So(writeFollowPosition(resumeFile, position), ShouldBeNil)
+			read, err := readFollowPosition(resumeFile)
+			So(err, ShouldBeNil)
+			So(read, ShouldResemble, position)
+		})
+
+		Convey("the capped _id should survive a round trip", func() {
+			position := &followPosition{Namespace: "db.coll", Mode: FollowCapped,
+				ID: bson.ObjectIdHex("5a1b2c3d4e5f60718293a4b5")}
+			So(writeFollowPosition(resumeFile, position), ShouldBeNil)
+			read, err := readFollowPosition(resumeFile)
+			So(err, ShouldBeNil)
+			So(read, ShouldResemble, position)
+		})
+
+		Convey("a file without a position should be rejected", func() {
+			So(os.MkdirAll(filepath.Dir(resumeFile), 0750), ShouldBeNil)
+			So(ioutil.WriteFile(resumeFile, []byte(`{"namespace":"db.coll","mode":"oplog"}`), 0640), ShouldBeNil)
+			_, err := readFollowPosition(resumeFile)
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("When validating --follow", t, func() {
+		Convey("it should require JSON lines", func() {
+			exp := newExport(&InputOptions{Follow: true}, &OutputFormatOptions{Type: CSV, Fields: "a"})
+			So(exp.ValidateSettings(), ShouldNotBeNil)
+			exp = newExport(&InputOptions{Follow: true}, &OutputFormatOptions{Type: JSON, JSONArray: true})
+			So(exp.ValidateSettings(), ShouldNotBeNil)
+			exp = newExport(&InputOptions{Follow: true, ResumeFile: "resume.json"}, &OutputFormatOptions{Type: JSON})
+			So(exp.ValidateSettings(), ShouldBeNil)
+		})
+
+		Convey("it should not be combined with options that end or reorder the export", func() {
+			exp := newExport(&InputOptions{Follow: true, Sort: "{a:1}"}, &OutputFormatOptions{Type: JSON})
+			So(exp.ValidateSettings(), ShouldNotBeNil)
+			exp = newExport(&InputOptions{Follow: true, Limit: 10}, &OutputFormatOptions{Type: JSON})
+			So(exp.ValidateSettings(), ShouldNotBeNil)
+			exp = newExport(&InputOptions{Follow: true}, &OutputFormatOptions{Type: JSON, MaxRecordsPerFile: 10, OutputFile: "out-%d.json"})
+			So(exp.ValidateSettings(), ShouldNotBeNil)
+		})
+
+		Convey("--resumeFile should require --follow", func() {
+			exp := newExport(&InputOptions{ResumeFile: "resume.json"}, &OutputFormatOptions{Type: JSON})
+			So(exp.ValidateSettings(), ShouldNotBeNil)
+		})
+	})
+
+	Convey("When following the oplog", t, func() {
+		exp := newExport(&InputOptions{Follow: true}, &OutputFormatOptions{Type: JSON})
+		out := &bytes.Buffer{}
+		follower := &follower{
+			exp:      exp,
+			output:   NewJSONExportOutput(false, false, out),
+			position: &followPosition{Namespace: "db.coll", Mode: FollowOplog},
+		}
+
+		Convey("an insert should be exported with its document", func() {
+			entry := &db.Oplog{Timestamp: bson.MongoTimestamp(5 << 32), Operation: "i", Namespace: "db.coll",
+				Object: bson.D{{Name: "_id", Value: 1}, {Name: "a", Value: "x"}}}
+			So(follower.exportOplogEntry(nil, entry), ShouldBeNil)
+			So(follower.output.Flush(), ShouldBeNil)
+			So(out.String(), ShouldEqual, `{"op":"insert","ns":"db.coll","ts":{"$timestamp":{"t":5,"i":0}},`+
+				`"_id":1,"document":{"_id":1,"a":"x"}}`+"\n")
+			So(follower.position.Timestamp, ShouldEqual, bson.MongoTimestamp(5<<32))
+			So(follower.count, ShouldEqual, 1)
+		})
+
+		Convey("a delete should be exported without a document", func() {
+			entry := &db.Oplog{Timestamp: bson.MongoTimestamp(6 << 32), Operation: "d", Namespace: "db.coll",
+				Object: bson.D{{Name: "_id", Value: 2}}}
+			So(follower.exportOplogEntry(nil, entry), ShouldBeNil)
+			So(follower.output.Flush(), ShouldBeNil)
+			So(out.String(), ShouldEqual, `{"op":"delete","ns":"db.coll","ts":{"$timestamp":{"t":6,"i":0}},"_id":2}`+"\n")
+		})
+
+		Convey("the operations of a transaction on the collection should be exported", func() {
+			entry := &db.Oplog{Timestamp: bson.MongoTimestamp(7 << 32), Operation: "c", Namespace: "admin.$cmd",
+				Object: bson.D{{Name: "applyOps", Value: []interface{}{
+					bson.D{{Name: "op", Value: "d"}, {Name: "ns", Value: "db.coll"}, {Name: "o", Value: bson.D{{Name: "_id", Value: 3}}}},
+					bson.D{{Name: "op", Value: "d"}, {Name: "ns", Value: "db.other"}, {Name: "o", Value: bson.D{{Name: "_id", Value: 4}}}},
+					bson.D{{Name: "op", Value: "d"}, {Name: "ns", Value: "db.coll"}, {Name: "o", Value: bson.D{{Name: "_id", Value: 5}}}},
+				}}}}
+			So(follower.exportOplogEntry(nil, entry), ShouldBeNil)
+			So(follower.output.Flush(), ShouldBeNil)
+			So(out.String(), ShouldEqual,
+				`{"op":"delete","ns":"db.coll","ts":{"$timestamp":{"t":7,"i":0}},"_id":3}`+"\n"+
+					`{"op":"delete","ns":"db.coll","ts":{"$timestamp":{"t":7,"i":0}},"_id":5}`+"\n")
+			So(follower.position.Timestamp, ShouldEqual, bson.MongoTimestamp(7<<32))
+			So(follower.count, ShouldEqual, 2)
+		})
+
+		Convey("a prepared transaction should stop the follow", func() {
+			entry := &db.Oplog{Timestamp: bson.MongoTimestamp(8 << 32), Operation: "c", Namespace: "admin.$cmd",
+				Object: bson.D{{Name: "applyOps", Value: []interface{}{}}, {Name: "prepare", Value: true}}}
+			So(follower.exportOplogEntry(nil, entry), ShouldNotBeNil)
+			So(follower.count, ShouldEqual, 0)
+		})
+
+		Convey("the documents exported before following should be insert events", func() {
+			snapshot := &snapshotOutput{ExportOutput: follower.output, namespace: "db.coll", timestamp: bson.MongoTimestamp(4 << 32)}
+			So(snapshot.ExportDocument(bson.D{{Name: "_id", Value: 1}, {Name: "a", Value: "x"}}), ShouldBeNil)
+			So(snapshot.Flush(), ShouldBeNil)
+			So(out.String(), ShouldEqual, `{"op":"insert","ns":"db.coll","ts":{"$timestamp":{"t":4,"i":0}},`+
+				`"_id":1,"document":{"_id":1,"a":"x"}}`+"\n")
+		})
+	})
+
+	Convey("An interrupt should stop the follow, even if repeated", t, func() {
+		exp := newExport(&InputOptions{Follow: true}, &OutputFormatOptions{Type: JSON})
+		So(exp.followStopped(), ShouldBeFalse)
+		exp.HandleInterrupt()
+		exp.HandleInterrupt()
+		So(exp.followStopped(), ShouldBeTrue)
+	})
+}
@@ -0,0 +1,251 @@
+package mongoexport
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/mongodb/mongo-tools/common/bsonutil"
+	"github.com/mongodb/mongo-tools/common/json"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/util"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// exportState is the state of an incremental export, kept in the state file
+// between runs: the highest value of the incremental field exported so far.
+type exportState struct {
+	Namespace string
+	Field     string
+	Watermark interface{}
+}
+
+// readEJSONFile reads a document in canonical Extended JSON from a file. It
+// returns nil if the file does not exist.
+func readEJSONFile(path string) (bson.D, error) {
+	content, err := ioutil.ReadFile(util.ToUniversalPath(path))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	doc, err := json.UnmarshalBsonD(content)
+	if err == nil {
+		doc, err = bsonutil.GetExtendedBsonD(doc)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %v: %v", path, err)
+	}
+	return doc, nil
+}
+
+// writeEJSONFile writes a document as canonical Extended JSON to a file
+// atomically, by writing a temporary file next to it and renaming it over the
+// file, so that an interrupted write leaves the previous file in place.
+func writeEJSONFile(path string, doc bson.D) error {
+	content, err := bsonutil.MarshalExtJSON(doc, json.Canonical)
+	if err != nil {
+		return fmt.Errorf("error converting %v to JSON: %v", doc, err)
+	}
+	path = util.ToUniversalPath(path)
+	if err = os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return err
+	}
+	file, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(append(content, '\n'))
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), path)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+	}
+	return err
+}
+
+// readExportState reads the state file. It returns nil if the file does not exist yet.
+func readExportState(path string) (*exportState, error) {
+	doc, err := readEJSONFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading stateFile: %v", err)
+	}
+	if doc == nil {
+		return nil, nil
+	}
+	state := &exportState{}
+	hasWatermark := false
+	for _, elem := range doc {
+		switch elem.Name {
+		case "namespace":
+			state.Namespace, _ = elem.Value.(string)
+		case "field":
+			state.Field, _ = elem.Value.(string)
+		case "watermark":
+			state.Watermark, hasWatermark = elem.Value, true
+		}
+	}
+	if state.Namespace == "" || state.Field == "" || !hasWatermark {
+		return nil, fmt.Errorf("stateFile %v must have a namespace, field and watermark", path)
+	}
+	return state, nil
+}
+
+// writeExportState writes the state file atomically.
+func writeExportState(path string, state *exportState) error {
+	err := writeEJSONFile(path, bson.D{
+		{Name: "namespace", Value: state.Namespace},
+		{Name: "field", Value: state.Field},
+		{Name: "watermark", Value: state.Watermark},
+	})
+	if err != nil {
+		return fmt.Errorf("error writing stateFile: %v", err)
+	}
+	return nil
+}
+
+// loadState reads the state of an incremental export, once, and checks that
+// it belongs to this export.
+func (exp *MongoExport) loadState() (*exportState, error) {
+	if exp.stateLoaded {
+		return exp.state, nil
+	}
+	state, err := readExportState(exp.InputOpts.StateFile)
+	if err != nil {
+		return nil, err
+	}
+	if state != nil {
+		namespace := exp.ToolOptions.Namespace.DB + "." + exp.ToolOptions.Namespace.Collection
+		if state.Namespace != namespace || state.Field != exp.InputOpts.IncrementalField {
+			return nil, fmt.Errorf("stateFile %v is for field '%v' of %v, not field '%v' of %v",
+				exp.InputOpts.StateFile, state.Field, state.Namespace, exp.InputOpts.IncrementalField, namespace)
+		}
+	}
+	exp.state, exp.stateLoaded = state, true
+	return state, nil
+}
+
+// incrementalQuery returns the query restricted to the documents whose
+// incremental field is greater than the watermark of the previous export.
+// The first export, without a state file, exports all the documents.
+func (exp *MongoExport) incrementalQuery(query map[string]interface{}) (map[string]interface{}, error) {
+	state, err := exp.loadState()
+	if err != nil || state == nil {
+		return query, err
+	}
+	log.Logvf(log.Info, "exporting documents with %v greater than %v", state.Field, state.Watermark)
+	filter := bson.M{state.Field: bson.M{"$gt": state.Watermark}}
+	if len(query) == 0 {
+		return filter, nil
+	}
+	return map[string]interface{}{"$and": []interface{}{query, filter}}, nil
+}
+
+// SaveState writes the highest value of the incremental field that was
+// exported to the state file, to be the watermark of the next export. It
+// should only be called once the export has succeeded. If no documents were
+// exported, the state file is left as it is.
+func (exp *MongoExport) SaveState() error {
+	if exp.InputOpts == nil || exp.InputOpts.IncrementalField == "" || !exp.hasWatermark {
+		return nil
+	}
+	state := &exportState{
+		Namespace: exp.ToolOptions.Namespace.DB + "." + exp.ToolOptions.Namespace.Collection,
+		Field:     exp.InputOpts.IncrementalField,
+		Watermark: exp.watermark,
+	}
+	if err := writeExportState(exp.InputOpts.StateFile, state); err != nil {
+		return err
+	}
+	log.Logvf(log.Info, "saved watermark %v to %v", exp.watermark, exp.InputOpts.StateFile)
+	return nil
+}
+
+// lookupDottedField returns the value of a dotted field of a document, and
+// whether it exists. Numeric parts of the field index into arrays, e.g. "tags.0".
+func lookupDottedField(doc bson.D, field string) (interface{}, bool) {
+	var value interface{} = doc
+	for _, part := range strings.Split(field, ".") {
+		found := false
+		switch v := value.(type) {
+		case bson.D:
+			for _, elem := range v {
+				if elem.Name == part {
+					value, found = elem.Value, true
+					break
+				}
+			}
+		case []interface{}:
+			var index int
+			if index, found = parseArrayIndex(part, len(v)); found {
+				value = v[index]
+			}
+		}
+		if !found {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// parseArrayIndex parses a part of a dotted field as an index into an array
+// of the given length, and returns whether it is one.
+func parseArrayIndex(part string, length int) (int, bool) {
+	index, err := strconv.Atoi(part)
+	return index, err == nil && index >= 0 && index < length
+}
+
+// watermarkOutput is an ExportOutput that keeps the value of the incremental
+// field of the last document exported, which is the highest, since the
+// documents are exported in the order of the field.
+type watermarkOutput struct {
+	ExportOutput
+	exp *MongoExport
+
+	// projected is the top-level field that is only projected to read the
+	// watermark, and is removed from the documents before they are exported
+	projected string
+}
+
+func newWatermarkOutput(exportOutput ExportOutput, exp *MongoExport) *watermarkOutput {
+	w := &watermarkOutput{ExportOutput: exportOutput, exp: exp}
+	if exp.OutputOpts.Fields != "" {
+		top := strings.Split(exp.InputOpts.IncrementalField, ".")[0]
+		if _, selected := makeFieldSelector(exp.OutputOpts.Fields)[top]; !selected {
+			w.projected = top
+		}
+	}
+	return w
+}
+
+func (w *watermarkOutput) ExportDocument(document bson.D) error {
+	// look the field up first, since outputs may convert the document in place
+	value, ok := lookupDottedField(document, w.exp.InputOpts.IncrementalField)
+	if w.projected != "" {
+		kept := make(bson.D, 0, len(document))
+		for _, elem := range document {
+			if elem.Name != w.projected {
+				kept = append(kept, elem)
+			}
+		}
+		document = kept
+	}
+	if err := w.ExportOutput.ExportDocument(document); err != nil {
+		return err
+	}
+	if ok && value != nil {
+		w.exp.watermark, w.exp.hasWatermark = value, true
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package mongoexport
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/options"
+	"github.com/mongodb/mongo-tools/common/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestIncrementalExport(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+
+	Convey("With a state file", t, func() {
+		dir, err := ioutil.TempDir("", "mongoexport-state-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		stateFile := filepath.Join(dir, "state", "export.json")
+
+		newExport := func(field string) *MongoExport {
+			return &MongoExport{
+				ToolOptions: options.ToolOptions{Namespace: &options.Namespace{DB: "db", Collection: "coll"}},
+				OutputOpts:  &OutputFormatOptions{},
+				InputOpts:   &InputOptions{IncrementalField: field, StateFile: stateFile},
+			}
+		}
+
+		Convey("the first export should export every document", func() {
+			query, err := newExport("updatedAt").incrementalQuery(nil)
+			So(err, ShouldBeNil)
+			So(query, ShouldBeNil)
+		})
+
+		Convey("watermarks should keep their type across runs", func() {
+			for _, watermark := range []interface{}{
+				time.Unix(1500000000, 123000000).UTC(),
+				bson.ObjectIdHex("5a1b2c3d4e5f60718293a4b5"),
+				int64(1) << 40,
+				"abc",
+			} {
+				exp := newExport("updatedAt")
+				exp.watermark, exp.hasWatermark = watermark, true
+				So(exp.SaveState(), ShouldBeNil)
+
+				state, err := readExportState(stateFile)
+				So(err, ShouldBeNil)
+				if date, ok := watermark.(time.Time); ok {
+					So(state.Watermark.(time.Time).Equal(date), ShouldBeTrue)
+				} else {
+					So(state.Watermark, ShouldResemble, watermark)
+				}
+
+				query, err := newExport("updatedAt").incrementalQuery(map[string]interface{}{"x": 1})
+				So(err, ShouldBeNil)
+				So(query, ShouldResemble, map[string]interface{}{"$and": []interface{}{
+					map[string]interface{}{"x": 1},
+					bson.M{"updatedAt": bson.M{"$gt": state.Watermark}},
+				}})
+			}
+			// only the state file should be left in its directory
+			entries, err := ioutil.ReadDir(filepath.Dir(stateFile))
+			So(err, ShouldBeNil)
+			So(len(entries), ShouldEqual, 1)
+		})
+
+		Convey("the state file should be left as it is if nothing was exported", func() {
+			So(newExport("updatedAt").SaveState(), ShouldBeNil)
+			_, err := os.Stat(stateFile)
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+
+		Convey("a state file of another field should be rejected", func() {
+			exp := newExport("updatedAt")
+			exp.watermark, exp.hasWatermark = 5, true
+			So(exp.SaveState(), ShouldBeNil)
+			_, err := newExport("_id").loadState()
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("the watermark should be the field of the last document exported", func() {
+			exp := newExport("meta.seq")
+			out := &bytes.Buffer{}
+			output := &watermarkOutput{ExportOutput: NewJSONExportOutput(false, false, out), exp: exp}
+			So(output.ExportDocument(bson.D{{"_id", 1}}), ShouldBeNil)
+			So(exp.hasWatermark, ShouldBeFalse)
+			So(output.ExportDocument(bson.D{{"_id", 2}, {"meta", bson.D{{"seq", 7}}}}), ShouldBeNil)
+			So(output.ExportDocument(bson.D{{"_id", 3}, {"meta", bson.D{{"seq", 9}}}}), ShouldBeNil)
+			So(exp.watermark, ShouldEqual, 9)
+		})
+
+		Convey("the field should not be exported if --fields does not select it", func() {
+			exp := newExport("meta.seq")
+			exp.OutputOpts.Fields = "name"
+			out := &bytes.Buffer{}
+			output := newWatermarkOutput(NewJSONExportOutput(false, false, out), exp)
+			So(output.ExportDocument(bson.D{{"_id", 1}, {"name", "a"}, {"meta", bson.D{{"seq", 7}}}}), ShouldBeNil)
+			So(exp.watermark, ShouldEqual, 7)
+			So(out.String(), ShouldEqual, `{"_id":1,"name":"a"}`+"\n")
+
+			exp.OutputOpts.Fields = "name,meta.other"
+			So(newWatermarkOutput(NewJSONExportOutput(false, false, out), exp).projected, ShouldEqual, "")
+		})
+	})
+}
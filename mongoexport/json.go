@@ -17,20 +17,21 @@ type JSONExportOutput struct {
 	ArrayOutput bool
 	// Pretty when set to true indicates that the output will be written in pretty mode.
 	PrettyOutput bool
-	Encoder      *json.Encoder
-	Out          io.Writer
-	NumExported  int64
+	// Format is the dialect of Extended JSON to write, legacy when empty.
+	Format      json.ExtJSONFormat
+	Encoder     *json.Encoder
+	Out         io.Writer
+	NumExported int64
 }
 
 // NewJSONExportOutput creates a new JSONExportOutput in array mode if specified,
 // configured to write data to the given io.Writer.
 func NewJSONExportOutput(arrayOutput bool, prettyOutput bool, out io.Writer) *JSONExportOutput {
 	return &JSONExportOutput{
-		arrayOutput,
-		prettyOutput,
-		json.NewEncoder(out),
-		out,
-		0,
+		ArrayOutput:  arrayOutput,
+		PrettyOutput: prettyOutput,
+		Encoder:      json.NewEncoder(out),
+		Out:          out,
 	}
 }
 
@@ -80,11 +81,7 @@ func (jsonExporter *JSONExportOutput) ExportDocument(document bson.D) error {
 				jsonExporter.Out.Write([]byte("\n"))
 			}
 		}
-		extendedDoc, err := bsonutil.ConvertBSONValueToJSON(document)
-		if err != nil {
-			return err
-		}
-		jsonOut, err := json.Marshal(extendedDoc)
+		jsonOut, err := bsonutil.MarshalExtJSON(document, jsonExporter.Format)
 		if err != nil {
 			return fmt.Errorf("error converting BSON to extended JSON: %v", err)
 		}
@@ -94,6 +91,15 @@ func (jsonExporter *JSONExportOutput) ExportDocument(document bson.D) error {
 			jsonOut = jsonFormatted.Bytes()
 		}
 		jsonExporter.Out.Write(jsonOut)
+	} else if jsonExporter.Format == json.Canonical || jsonExporter.Format == json.Relaxed {
+		jsonOut, err := json.MarshalExtJSON(document, jsonExporter.Format)
+		if err != nil {
+			return fmt.Errorf("error converting BSON to extended JSON: %v", err)
+		}
+		_, err = jsonExporter.Out.Write(append(jsonOut, '\n'))
+		if err != nil {
+			return err
+		}
 	} else {
 		extendedDoc, err := bsonutil.ConvertBSONValueToJSON(document)
 		if err != nil {
@@ -2,6 +2,7 @@
 package main
 
 import (
+	"io"
 	"os"
 	"time"
 
@@ -44,7 +45,6 @@ func main() {
 	}
 
 	log.SetVerbosity(opts.Verbosity)
-	signals.Handle()
 
 	// print help, if specified
 	if opts.PrintHelp(false) {
@@ -129,6 +129,14 @@ func main() {
 		ProgressManager: progressManager,
 	}
 
+	if inputOpts.Follow {
+		// the first interrupt stops following once the position is saved
+		finishedChan := signals.HandleWithInterrupt(exporter.HandleInterrupt)
+		defer close(finishedChan)
+	} else {
+		signals.Handle()
+	}
+
 	err = exporter.ValidateSettings()
 	if err != nil {
 		log.Logvf(log.Always, "error validating settings: %v", err)
@@ -136,19 +144,32 @@ func main() {
 		os.Exit(util.ExitBadOptions)
 	}
 
-	writer, err := exporter.GetOutputWriter()
+	var numDocs int64
+	if exporter.WritesMultipleFiles() {
+		numDocs, err = exporter.ExportFiles()
+	} else {
+		var writer io.WriteCloser
+		writer, err = exporter.GetOutputWriter()
+		if err != nil {
+			log.Logvf(log.Always, "error opening output stream: %v", err)
+			os.Exit(util.ExitError)
+		}
+		if writer == nil {
+			numDocs, err = exporter.Export(os.Stdout)
+		} else {
+			numDocs, err = exporter.Export(writer)
+			// the output must be complete before the watermark is saved
+			if closeErr := writer.Close(); err == nil {
+				err = closeErr
+			}
+		}
+	}
 	if err != nil {
-		log.Logvf(log.Always, "error opening output stream: %v", err)
+		log.Logvf(log.Always, "Failed: %v", err)
 		os.Exit(util.ExitError)
 	}
-	if writer == nil {
-		writer = os.Stdout
-	} else {
-		defer writer.Close()
-	}
 
-	numDocs, err := exporter.Export(writer)
-	if err != nil {
+	if err = exporter.SaveState(); err != nil {
 		log.Logvf(log.Always, "Failed: %v", err)
 		os.Exit(util.ExitError)
 	}
@@ -4,9 +4,12 @@ package mongoexport
 import (
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/mongodb/mongo-tools/common/bsonutil"
 	"github.com/mongodb/mongo-tools/common/db"
@@ -23,6 +26,9 @@ import (
 const (
 	CSV                            = "csv"
 	JSON                           = "json"
+	Avro                           = "avro"
+	SQL                            = "sql"
+	Template                       = "template"
 	watchProgressorUpdateFrequency = 8000
 )
 
@@ -42,6 +48,18 @@ type MongoExport struct {
 	ExportOutput    ExportOutput
 
 	ProgressManager progress.Manager
+
+	// the state of an incremental export, and the highest value of the
+	// incremental field exported
+	state        *exportState
+	stateLoaded  bool
+	watermark    interface{}
+	hasWatermark bool
+
+	// closed to stop following the collection
+	followStop     chan struct{}
+	followStopInit sync.Once
+	followStopOnce sync.Once
 }
 
 // ExportOutput is an interface that specifies how a document should be formatted
@@ -94,8 +112,133 @@ func (exp *MongoExport) ValidateSettings() error {
 		// special error for an empty type value
 		return fmt.Errorf("--type cannot be empty")
 	}
-	if exp.OutputOpts.Type != CSV && exp.OutputOpts.Type != JSON {
-		return fmt.Errorf("invalid output type '%v', choose 'json' or 'csv'", exp.OutputOpts.Type)
+	switch exp.OutputOpts.Type {
+	case CSV, JSON, Avro, SQL, Template:
+	default:
+		return fmt.Errorf("invalid output type '%v', choose 'json', 'csv', 'avro', 'sql' or 'template'", exp.OutputOpts.Type)
+	}
+
+	if exp.OutputOpts.AvroSchemaFile != "" {
+		if exp.OutputOpts.Type != Avro {
+			return fmt.Errorf("--avroSchemaFile can only be used with --type=avro")
+		}
+		if _, err = exp.getAvroSchema(); err != nil {
+			return err
+		}
+	}
+	if exp.OutputOpts.Type == Avro && exp.OutputOpts.AvroSampleSize <= 0 {
+		return fmt.Errorf("--avroSampleSize must be greater than 0")
+	}
+
+	if exp.OutputOpts.Type == SQL {
+		if exp.OutputOpts.SQLDialect != Postgres && exp.OutputOpts.SQLDialect != MySQL {
+			return fmt.Errorf("invalid --sqlDialect '%v', choose 'postgres' or 'mysql'", exp.OutputOpts.SQLDialect)
+		}
+		if exp.OutputOpts.SQLCopy && exp.OutputOpts.SQLDialect != Postgres {
+			return fmt.Errorf("--sqlCopy can only be used with --sqlDialect=postgres")
+		}
+		if exp.OutputOpts.SQLSampleSize <= 0 || exp.OutputOpts.SQLBatchSize <= 0 {
+			return fmt.Errorf("--sqlSampleSize and --sqlBatchSize must be greater than 0")
+		}
+		if exp.OutputOpts.NumParallelPartitions > 1 {
+			// every partition would create the table, with its own column types
+			return fmt.Errorf("cannot use --numParallelPartitions with --type=sql")
+		}
+	} else if exp.OutputOpts.Table != "" || exp.OutputOpts.SQLCopy {
+		return fmt.Errorf("--table and --sqlCopy can only be used with --type=sql")
+	}
+
+	if exp.OutputOpts.Type == CSV {
+		if _, err = exp.getCSVFormat(); err != nil {
+			return err
+		}
+	} else if exp.OutputOpts.CSVDateFormat != "" || exp.OutputOpts.Timezone != "" || exp.OutputOpts.CSVObjectIDFormat != "" ||
+		exp.OutputOpts.CSVBinaryFormat != "" || exp.OutputOpts.CSVNullValue != "" {
+		return fmt.Errorf("--csvDateFormat, --timezone, --csvObjectIdFormat, --csvBinaryFormat and --csvNullValue " +
+			"can only be used with --type=csv")
+	}
+
+	if exp.OutputOpts.Type == Template {
+		if exp.OutputOpts.Template == "" {
+			return fmt.Errorf("--type=template requires --template")
+		}
+		if _, err = exp.getTemplateOutput(ioutil.Discard); err != nil {
+			return err
+		}
+	} else if exp.OutputOpts.Template != "" || exp.OutputOpts.TemplateHeader != "" || exp.OutputOpts.TemplateFooter != "" {
+		return fmt.Errorf("--template, --templateHeader and --templateFooter can only be used with --type=template")
+	}
+
+	if exp.OutputOpts.AutoFields != 0 {
+		if exp.OutputOpts.Type != CSV {
+			return fmt.Errorf("--autoFields can only be used with --type=csv")
+		}
+		if exp.OutputOpts.AutoFields < 0 {
+			return fmt.Errorf("--autoFields sample size must be greater than 0")
+		}
+		if exp.OutputOpts.Fields != "" || exp.OutputOpts.FieldFile != "" {
+			return fmt.Errorf("cannot use --autoFields with --fields or --fieldFile")
+		}
+	}
+	if exp.OutputOpts.CSVPlainNumbers && exp.OutputOpts.Type != CSV {
+		return fmt.Errorf("--csvPlainNumbers can only be used with --type=csv")
+	}
+	if exp.OutputOpts.FlattenArrays != "" {
+		if exp.OutputOpts.Type != CSV {
+			return fmt.Errorf("--flattenArrays can only be used with --type=csv")
+		}
+		flattening, err := ParseArrayFlattening(exp.OutputOpts.FlattenArrays)
+		if err != nil {
+			return err
+		}
+		if flattening.Mode == FlattenIndex && exp.OutputOpts.AutoFields == 0 {
+			return fmt.Errorf("--flattenArrays=index requires --autoFields; with --fields, name array elements like 'tags.0'")
+		}
+	}
+
+	if exp.OutputOpts.NumParallelPartitions < 0 || exp.OutputOpts.MaxFileSize < 0 || exp.OutputOpts.MaxRecordsPerFile < 0 {
+		return fmt.Errorf("--numParallelPartitions, --maxFileSize and --maxRecordsPerFile must not be negative")
+	}
+	if exp.WritesMultipleFiles() {
+		if err = validateOutputPattern(exp.OutputOpts.OutputFile); err != nil {
+			return err
+		}
+	}
+	if exp.OutputOpts.NumParallelPartitions > 1 {
+		if exp.OutputOpts.MaxFileSize > 0 || exp.OutputOpts.MaxRecordsPerFile > 0 {
+			return fmt.Errorf("cannot use --maxFileSize or --maxRecordsPerFile with --numParallelPartitions")
+		}
+		if exp.InputOpts != nil && (exp.InputOpts.HasPipeline() || exp.InputOpts.Sort != "" ||
+			exp.InputOpts.Skip != 0 || exp.InputOpts.Limit != 0) {
+			return fmt.Errorf("cannot use --pipeline, --pipelineFile, --sort, --skip or --limit with --numParallelPartitions")
+		}
+	}
+
+	if exp.InputOpts != nil && (exp.InputOpts.IncrementalField != "" || exp.InputOpts.StateFile != "") {
+		if exp.InputOpts.IncrementalField == "" || exp.InputOpts.StateFile == "" {
+			return fmt.Errorf("--incrementalField and --stateFile must be used together")
+		}
+		if exp.InputOpts.HasPipeline() || exp.InputOpts.Sort != "" || exp.InputOpts.Skip != 0 ||
+			exp.OutputOpts.NumParallelPartitions > 1 {
+			return fmt.Errorf("cannot use --pipeline, --pipelineFile, --sort, --skip or --numParallelPartitions " +
+				"with --incrementalField, which exports documents in the order of the field")
+		}
+		if _, err = exp.loadState(); err != nil {
+			return err
+		}
+	}
+
+	if exp.InputOpts != nil && exp.InputOpts.Follow {
+		if exp.OutputOpts.Type != JSON || exp.OutputOpts.JSONArray {
+			return fmt.Errorf("--follow can only be used with --type=json, without --jsonArray")
+		}
+		if exp.InputOpts.HasPipeline() || exp.InputOpts.Sort != "" || exp.InputOpts.Skip != 0 ||
+			exp.InputOpts.Limit != 0 || exp.InputOpts.IncrementalField != "" || exp.WritesMultipleFiles() {
+			return fmt.Errorf("cannot use --pipeline, --pipelineFile, --sort, --skip, --limit, --incrementalField, " +
+				"--numParallelPartitions, --maxFileSize or --maxRecordsPerFile with --follow")
+		}
+	} else if exp.InputOpts != nil && exp.InputOpts.ResumeFile != "" {
+		return fmt.Errorf("cannot use --resumeFile without --follow")
 	}
 
 	if exp.InputOpts.Query != "" && exp.InputOpts.ForceTableScan {
@@ -123,6 +266,31 @@ func (exp *MongoExport) ValidateSettings() error {
 			return err
 		}
 	}
+
+	if exp.InputOpts != nil && exp.InputOpts.BatchSize < 0 {
+		return fmt.Errorf("--batchSize must not be negative")
+	}
+
+	if exp.InputOpts != nil && exp.InputOpts.HasPipeline() {
+		if exp.InputOpts.Pipeline != "" && exp.InputOpts.PipelineFile != "" {
+			return fmt.Errorf("either --pipeline or --pipelineFile can be specified as a pipeline option")
+		}
+		// the pipeline's own stages take the place of these options
+		if exp.InputOpts.HasQuery() || exp.InputOpts.Sort != "" ||
+			exp.InputOpts.Skip != 0 || exp.InputOpts.Limit != 0 || exp.InputOpts.ForceTableScan {
+			return fmt.Errorf("cannot use --query, --queryFile, --sort, --skip, --limit or --forceTableScan " +
+				"with an aggregation pipeline; use $match, $sort, $skip and $limit stages instead")
+		}
+		content, err := exp.InputOpts.GetPipeline()
+		if err != nil {
+			return err
+		}
+		if _, err = getPipelineFromArg(content); err != nil {
+			return err
+		}
+	} else if exp.InputOpts != nil && exp.InputOpts.AllowDiskUse {
+		return fmt.Errorf("cannot use --allowDiskUse without --pipeline or --pipelineFile")
+	}
 	return nil
 }
 
@@ -184,7 +352,8 @@ func (exp *MongoExport) getCount() (c int, err error) {
 	if exp.InputOpts != nil && exp.InputOpts.Limit != 0 {
 		return exp.InputOpts.Limit, nil
 	}
-	if exp.InputOpts != nil && exp.InputOpts.Query != "" {
+	if exp.InputOpts != nil && (exp.InputOpts.Query != "" || exp.InputOpts.HasPipeline() ||
+		exp.InputOpts.IncrementalField != "") {
 		return 0, nil
 	}
 	q := session.DB(exp.ToolOptions.Namespace.DB).C(exp.ToolOptions.Namespace.Collection).Find(nil)
@@ -205,9 +374,10 @@ func (exp *MongoExport) getCount() (c int, err error) {
 }
 
 // getCursor returns a cursor that can be iterated over to get all the documents
-// to export, based on the options given to mongoexport. Also returns the
-// associated session, so that it can be closed once the cursor is used up.
-func (exp *MongoExport) getCursor() (*mgo.Iter, *mgo.Session, error) {
+// to export, based on the options given to mongoexport, or only those of a
+// partition if one is given. Also returns the associated session, so that it
+// can be closed once the cursor is used up.
+func (exp *MongoExport) getCursor(partition *exportPartition) (*mgo.Iter, *mgo.Session, error) {
 	sortFields := []string{}
 	if exp.InputOpts != nil && exp.InputOpts.Sort != "" {
 		sortD, err := getSortFromArg(exp.InputOpts.Sort)
@@ -233,6 +403,18 @@ func (exp *MongoExport) getCursor() (*mgo.Iter, *mgo.Session, error) {
 		}
 	}
 
+	if partition != nil {
+		query = partition.restrict(query)
+	}
+
+	if exp.InputOpts != nil && exp.InputOpts.IncrementalField != "" {
+		var err error
+		if query, err = exp.incrementalQuery(query); err != nil {
+			return nil, nil, err
+		}
+		sortFields = []string{exp.InputOpts.IncrementalField}
+	}
+
 	session, err := exp.SessionProvider.GetSession()
 	if err != nil {
 		return nil, nil, err
@@ -255,7 +437,8 @@ func (exp *MongoExport) getCursor() (*mgo.Iter, *mgo.Session, error) {
 	flags := 0
 	// don't snapshot if we've been asked not to,
 	// or if we cannot because  we are querying, sorting, or if the collection is a view
-	if !exp.InputOpts.ForceTableScan && len(query) == 0 && exp.InputOpts != nil && exp.InputOpts.Sort == "" && !isView {
+	if !exp.InputOpts.ForceTableScan && len(query) == 0 && exp.InputOpts != nil && exp.InputOpts.Sort == "" &&
+		exp.InputOpts.IncrementalField == "" && !isView {
 		flags = flags | db.Snapshot
 	}
 
@@ -279,11 +462,26 @@ func (exp *MongoExport) getCursor() (*mgo.Iter, *mgo.Session, error) {
 		}
 	}
 
+	if exp.InputOpts != nil && exp.InputOpts.HasPipeline() {
+		cursor, err := exp.getPipelineCursor(collection)
+		return cursor, session, err
+	}
+
 	// build the query
 	q := collection.Find(query).Sort(sortFields...).Skip(skip).Limit(limit)
 
 	if len(exp.OutputOpts.Fields) > 0 {
-		q.Select(makeFieldSelector(exp.OutputOpts.Fields))
+		fields := exp.OutputOpts.Fields
+		if exp.InputOpts != nil && exp.InputOpts.IncrementalField != "" {
+			// the watermark is taken from the exported documents, and the
+			// field removed from them by watermarkOutput
+			fields += "," + exp.InputOpts.IncrementalField
+		}
+		q.Select(makeFieldSelector(fields))
+	}
+
+	if exp.InputOpts != nil && exp.InputOpts.BatchSize > 0 {
+		q.Batch(exp.InputOpts.BatchSize)
 	}
 
 	q = db.ApplyFlags(q, session, flags)
@@ -292,9 +490,47 @@ func (exp *MongoExport) getCursor() (*mgo.Iter, *mgo.Session, error) {
 
 }
 
+// getPipelineCursor returns a cursor over the results of the aggregation
+// pipeline given to mongoexport. Fields selected with --fields are projected
+// by an extra $project stage at the end of the pipeline.
+func (exp *MongoExport) getPipelineCursor(collection *mgo.Collection) (*mgo.Iter, error) {
+	content, err := exp.InputOpts.GetPipeline()
+	if err != nil {
+		return nil, err
+	}
+	pipeline, err := getPipelineFromArg(content)
+	if err != nil {
+		return nil, err
+	}
+	if len(exp.OutputOpts.Fields) > 0 {
+		pipeline = append(pipeline, bson.D{{"$project", makeFieldSelector(exp.OutputOpts.Fields)}})
+	}
+
+	pipe := collection.Pipe(pipeline)
+	if exp.InputOpts.AllowDiskUse {
+		pipe = pipe.AllowDiskUse()
+	}
+	if exp.InputOpts.BatchSize > 0 {
+		pipe = pipe.Batch(exp.InputOpts.BatchSize)
+	}
+	return pipe.Iter(), nil
+}
+
 // Internal function that handles exporting to the given writer. Used primarily
 // for testing, because it bypasses writing to the file system.
 func (exp *MongoExport) exportInternal(out io.Writer) (int64, error) {
+	exportOutput, err := exp.getExportOutput(out)
+	if err != nil {
+		return 0, err
+	}
+	if exp.InputOpts != nil && exp.InputOpts.Follow {
+		return exp.follow(exportOutput)
+	}
+	return exp.exportTo(exportOutput)
+}
+
+// exportTo exports all the documents to the given ExportOutput.
+func (exp *MongoExport) exportTo(exportOutput ExportOutput) (int64, error) {
 
 	max, err := exp.getCount()
 	if err != nil {
@@ -308,18 +544,23 @@ func (exp *MongoExport) exportInternal(out io.Writer) (int64, error) {
 		defer exp.ProgressManager.Detach(name)
 	}
 
-	exportOutput, err := exp.getExportOutput(out)
-	if err != nil {
-		return 0, err
-	}
-
-	cursor, session, err := exp.getCursor()
+	cursor, session, err := exp.getCursor(nil)
 	if err != nil {
 		return 0, err
 	}
 	defer session.Close()
 	defer cursor.Close()
 
+	exp.logConnection()
+
+	if exp.InputOpts != nil && exp.InputOpts.IncrementalField != "" {
+		exportOutput = newWatermarkOutput(exportOutput, exp)
+	}
+	return exportCursor(cursor, exportOutput, watchProgressor)
+}
+
+// logConnection logs the host mongoexport is connected to.
+func (exp *MongoExport) logConnection() {
 	connURL := exp.ToolOptions.Host
 	if connURL == "" {
 		connURL = util.DefaultHost
@@ -328,9 +569,13 @@ func (exp *MongoExport) exportInternal(out io.Writer) (int64, error) {
 		connURL = connURL + ":" + exp.ToolOptions.Port
 	}
 	log.Logvf(log.Always, "connected to: %v", connURL)
+}
 
+// exportCursor writes the documents of the cursor to the ExportOutput, along
+// with its header and footer, and adds them to the progress counter.
+func exportCursor(cursor *mgo.Iter, exportOutput ExportOutput, watchProgressor progress.Updateable) (int64, error) {
 	// Write headers
-	err = exportOutput.WriteHeader()
+	err := exportOutput.WriteHeader()
 	if err != nil {
 		return 0, err
 	}
@@ -347,10 +592,10 @@ func (exp *MongoExport) exportInternal(out io.Writer) (int64, error) {
 		}
 		docsCount++
 		if docsCount%watchProgressorUpdateFrequency == 0 {
-			watchProgressor.Set(docsCount)
+			watchProgressor.Inc(watchProgressorUpdateFrequency)
 		}
 	}
-	watchProgressor.Set(docsCount)
+	watchProgressor.Inc(docsCount % watchProgressorUpdateFrequency)
 	if err := cursor.Err(); err != nil {
 		return docsCount, err
 	}
@@ -387,8 +632,8 @@ func (exp *MongoExport) getExportOutput(out io.Writer) (ExportOutput, error) {
 			if err != nil {
 				return nil, err
 			}
-		} else {
-			return nil, fmt.Errorf("CSV mode requires a field list")
+		} else if exp.OutputOpts.AutoFields == 0 {
+			return nil, fmt.Errorf("CSV mode requires a field list, or --autoFields")
 		}
 
 		exportFields := make([]string, 0, len(fields))
@@ -401,9 +646,71 @@ func (exp *MongoExport) getExportOutput(out io.Writer) (ExportOutput, error) {
 			}
 		}
 
-		return NewCSVExportOutput(exportFields, exp.OutputOpts.NoHeaderLine, out), nil
+		flattening, err := ParseArrayFlattening(exp.OutputOpts.FlattenArrays)
+		if err != nil {
+			return nil, err
+		}
+		csvOutput := NewCSVExportOutput(exportFields, exp.OutputOpts.NoHeaderLine, out)
+		csvOutput.AutoFields = exp.OutputOpts.AutoFields
+		csvOutput.FlattenArrays = flattening
+		if csvOutput.Format, err = exp.getCSVFormat(); err != nil {
+			return nil, err
+		}
+		return csvOutput, nil
+	}
+	if exp.OutputOpts.Type == Template {
+		return exp.getTemplateOutput(out)
 	}
-	return NewJSONExportOutput(exp.OutputOpts.JSONArray, exp.OutputOpts.Pretty, out), nil
+	if exp.OutputOpts.Type == SQL {
+		table := exp.OutputOpts.Table
+		if table == "" {
+			table = exp.ToolOptions.Namespace.Collection
+		}
+		sqlOutput := NewSQLExportOutput(exp.OutputOpts.SQLDialect, table, out)
+		sqlOutput.SampleSize = exp.OutputOpts.SQLSampleSize
+		sqlOutput.BatchSize = exp.OutputOpts.SQLBatchSize
+		sqlOutput.Copy = exp.OutputOpts.SQLCopy
+		if len(exp.OutputOpts.Fields) > 0 {
+			sqlOutput.Fields = strings.Split(exp.OutputOpts.Fields, ",")
+		} else if exp.OutputOpts.FieldFile != "" {
+			fields, err := util.GetFieldsFromFile(exp.OutputOpts.FieldFile)
+			if err != nil {
+				return nil, err
+			}
+			sqlOutput.Fields = fields
+		}
+		return sqlOutput, nil
+	}
+	if exp.OutputOpts.Type == Avro {
+		avroOutput := NewAvroExportOutput(exp.OutputOpts.AvroCodec, out)
+		avroOutput.SampleSize = exp.OutputOpts.AvroSampleSize
+		avroOutput.RecordName = exp.ToolOptions.Namespace.Collection
+		avroOutput.ObjectIDType = exp.OutputOpts.AvroObjectID
+		if exp.OutputOpts.AvroSchemaFile != "" {
+			schema, err := exp.getAvroSchema()
+			if err != nil {
+				return nil, err
+			}
+			avroOutput.SetSchema(schema)
+		}
+		return avroOutput, nil
+	}
+	format, err := json.ParseExtJSONFormat(exp.OutputOpts.JSONFormat)
+	if err != nil {
+		return nil, err
+	}
+	jsonOutput := NewJSONExportOutput(exp.OutputOpts.JSONArray, exp.OutputOpts.Pretty, out)
+	jsonOutput.Format = format
+	return jsonOutput, nil
+}
+
+// getAvroSchema reads and parses the Avro schema file.
+func (exp *MongoExport) getAvroSchema() (*avroType, error) {
+	content, err := ioutil.ReadFile(exp.OutputOpts.AvroSchemaFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading avroSchemaFile: %v", err)
+	}
+	return ParseAvroSchema(content)
 }
 
 // getObjectFromByteArg takes an object in extended JSON, and converts it to an object that
@@ -423,6 +730,33 @@ func getObjectFromByteArg(queryRaw []byte) (map[string]interface{}, error) {
 	return parsedJSON, nil
 }
 
+// getPipelineFromArg takes an aggregation pipeline as a JSON array of stages in
+// extended JSON, and returns the stages as bson.D documents, which preserve the
+// ordering of the keys as they appear in the input, e.g. for $sort.
+func getPipelineFromArg(pipelineRaw []byte) ([]interface{}, error) {
+	// the JSON parser only keeps the ordering of keys in documents, so the
+	// array is parsed as the value of a document
+	wrapped := append(append([]byte(`{"pipeline":`), pipelineRaw...), '}')
+	parsedJSON, err := json.UnmarshalBsonD(wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("pipeline '%v' is not valid JSON: %v", string(pipelineRaw), err)
+	}
+	parsedJSON, err = bsonutil.GetExtendedBsonD(parsedJSON)
+	if err != nil {
+		return nil, err
+	}
+	stages, ok := parsedJSON[0].Value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("pipeline '%v' must be a JSON array of stages", string(pipelineRaw))
+	}
+	for i, stage := range stages {
+		if stageDoc, ok := stage.(bson.D); !ok || len(stageDoc) != 1 {
+			return nil, fmt.Errorf("stage %v of the pipeline must be a document with a single field", i+1)
+		}
+	}
+	return stages, nil
+}
+
 // getSortFromArg takes a sort specification in JSON and returns it as a bson.D
 // object which preserves the ordering of the keys as they appear in the input.
 func getSortFromArg(queryRaw string) (bson.D, error) {
@@ -434,3 +768,57 @@ func getSortFromArg(queryRaw string) (bson.D, error) {
 	// TODO: verify sort specification before returning a nil error
 	return parsedJSON, nil
 }
+
+// getCSVFormat returns the formatting of CSV cells selected by the options.
+func (exp *MongoExport) getCSVFormat() (CSVFormat, error) {
+	format := CSVFormat{
+		DateLayout:     exp.OutputOpts.CSVDateFormat,
+		ObjectIDFormat: exp.OutputOpts.CSVObjectIDFormat,
+		BinaryFormat:   exp.OutputOpts.CSVBinaryFormat,
+		NullValue:      exp.OutputOpts.CSVNullValue,
+		PlainNumbers:   exp.OutputOpts.CSVPlainNumbers,
+	}
+	switch format.ObjectIDFormat {
+	case "", CSVObjectIDHex, CSVObjectIDWrapped:
+	default:
+		return format, fmt.Errorf("invalid --csvObjectIdFormat '%v', choose 'hex' or 'wrapped'", format.ObjectIDFormat)
+	}
+	switch format.BinaryFormat {
+	case "", CSVBinaryHex, CSVBinaryBase64:
+	default:
+		return format, fmt.Errorf("invalid --csvBinaryFormat '%v', choose 'base64' or 'hex'", format.BinaryFormat)
+	}
+	if exp.OutputOpts.Timezone != "" {
+		location, err := time.LoadLocation(exp.OutputOpts.Timezone)
+		if err != nil {
+			return format, fmt.Errorf("invalid --timezone '%v': %v", exp.OutputOpts.Timezone, err)
+		}
+		format.Location = location
+	}
+	return format, nil
+}
+
+// getTemplateOutput reads and parses the templates, and returns a
+// TemplateExportOutput that renders them.
+func (exp *MongoExport) getTemplateOutput(out io.Writer) (*TemplateExportOutput, error) {
+	document, err := ReadExportTemplate(exp.OutputOpts.Template)
+	if err != nil {
+		return nil, err
+	}
+	templateOutput := NewTemplateExportOutput(document, out)
+	templateOutput.Info = TemplateInfo{
+		DB:         exp.ToolOptions.Namespace.DB,
+		Collection: exp.ToolOptions.Namespace.Collection,
+	}
+	if exp.OutputOpts.TemplateHeader != "" {
+		if templateOutput.Header, err = ReadExportTemplate(exp.OutputOpts.TemplateHeader); err != nil {
+			return nil, err
+		}
+	}
+	if exp.OutputOpts.TemplateFooter != "" {
+		if templateOutput.Footer, err = ReadExportTemplate(exp.OutputOpts.TemplateFooter); err != nil {
+			return nil, err
+		}
+	}
+	return templateOutput, nil
+}
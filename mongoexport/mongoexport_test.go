@@ -3,6 +3,7 @@ package mongoexport
 import (
 	"encoding/json"
 	"github.com/mongodb/mongo-tools/common/bsonutil"
+	"github.com/mongodb/mongo-tools/common/options"
 	"github.com/mongodb/mongo-tools/common/testutil"
 	. "github.com/smartystreets/goconvey/convey"
 	"gopkg.in/mgo.v2/bson"
@@ -42,3 +43,36 @@ func TestFieldSelect(t *testing.T) {
 		So(makeFieldSelector("x,foo.baz"), ShouldResemble, bson.M{"_id": 1, "foo": 1, "x": 1})
 	})
 }
+
+func TestPipelineFromArg(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+
+	Convey("Parsing a pipeline should keep the order of its stages and keys", t, func() {
+		pipeline, err := getPipelineFromArg([]byte(`[{"$match": {"n": {"$numberLong": "5"}}}, {"$sort": {"b": 1, "a": -1}}]`))
+		So(err, ShouldBeNil)
+		So(pipeline, ShouldResemble, []interface{}{
+			bson.D{{"$match", bson.D{{"n", int64(5)}}}},
+			bson.D{{"$sort", bson.D{{"b", int32(1)}, {"a", int32(-1)}}}},
+		})
+	})
+
+	Convey("Pipelines that aren't an array of stages should be rejected", t, func() {
+		for _, pipeline := range []string{`{"$match": {}}`, `[{"$match": {}}`, `[1]`, `[{"$match": {}, "$limit": 1}]`} {
+			_, err := getPipelineFromArg([]byte(pipeline))
+			So(err, ShouldNotBeNil)
+		}
+	})
+
+	Convey("Pipelines should not be combined with find options", t, func() {
+		newExport := func(input *InputOptions) *MongoExport {
+			exp := &MongoExport{OutputOpts: &OutputFormatOptions{Type: JSON}, InputOpts: input}
+			exp.ToolOptions.Namespace = &options.Namespace{DB: "db", Collection: "c"}
+			return exp
+		}
+		So(newExport(&InputOptions{Pipeline: `[]`, AllowDiskUse: true, BatchSize: 10}).ValidateSettings(), ShouldBeNil)
+		So(newExport(&InputOptions{Pipeline: `[]`, Query: `{}`}).ValidateSettings(), ShouldNotBeNil)
+		So(newExport(&InputOptions{Pipeline: `[]`, Limit: 1}).ValidateSettings(), ShouldNotBeNil)
+		So(newExport(&InputOptions{Pipeline: `[]`, PipelineFile: "p.json"}).ValidateSettings(), ShouldNotBeNil)
+		So(newExport(&InputOptions{AllowDiskUse: true}).ValidateSettings(), ShouldNotBeNil)
+	})
+}
@@ -7,7 +7,7 @@ import (
 
 var Usage = `<options>
 
-Export data from MongoDB in CSV or JSON format.
+Export data from MongoDB in CSV, JSON, Avro or SQL format, or through a template.
 
 See http://docs.mongodb.org/manual/reference/program/mongoexport/ for more information.`
 
@@ -19,14 +19,23 @@ type OutputFormatOptions struct {
 	// FieldFile is a filename that refers to a list of fields to export, 1 per line.
 	FieldFile string `long:"fieldFile" value-name:"<filename>" description:"file with field names - 1 per line"`
 
-	// Type selects the type of output to export as (json or csv).
-	Type string `long:"type" value-name:"<type>" default:"json" default-mask:"-" description:"the output format, either json or csv (defaults to 'json')"`
+	// Type selects the type of output to export as (json, csv, avro, sql or template).
+	Type string `long:"type" value-name:"<type>" default:"json" default-mask:"-" description:"the output format, either json, csv, avro, sql or template (defaults to 'json')"`
 
 	// Deprecated: allow legacy --csv option in place of --type=csv
 	CSVOutputType bool `long:"csv" default:"false" hidden:"true"`
 
 	// OutputFile specifies an output file path.
-	OutputFile string `long:"out" value-name:"<filename>" short:"o" description:"output file; if not specified, stdout is used"`
+	OutputFile string `long:"out" value-name:"<filename>" short:"o" description:"output file; if not specified, stdout is used. When writing multiple files, a pattern for their names with a number, e.g. 'part-%03d.json'"`
+
+	// NumParallelPartitions is the number of _id ranges to export concurrently, each to its own file.
+	NumParallelPartitions int `long:"numParallelPartitions" value-name:"<number>" description:"split the collection into this many ranges of _id and export them concurrently, each to its own file named by --out"`
+
+	// MaxFileSize is the size in megabytes after which a new output file is started.
+	MaxFileSize int `long:"maxFileSize" value-name:"<megabytes>" description:"start a new output file, named by --out, once a file reaches this size"`
+
+	// MaxRecordsPerFile is the number of records after which a new output file is started.
+	MaxRecordsPerFile int `long:"maxRecordsPerFile" value-name:"<count>" description:"start a new output file, named by --out, once a file holds this many records"`
 
 	// JSONArray if set will export the documents an array of JSON documents.
 	JSONArray bool `long:"jsonArray" description:"output to a JSON array rather than one object per line"`
@@ -34,6 +43,67 @@ type OutputFormatOptions struct {
 	// Pretty displays JSON data in a human-readable form.
 	Pretty bool `long:"pretty" description:"output JSON formatted to be human-readable"`
 
+	// JSONFormat selects the dialect of Extended JSON to export.
+	JSONFormat string `long:"jsonFormat" value-name:"<format>" default:"legacy" choice:"canonical" choice:"relaxed" choice:"legacy" description:"Extended JSON format of json output: canonical, relaxed or legacy (defaults to 'legacy')"`
+
+	// AutoFields is the number of documents to sample to discover the fields to export to CSV.
+	AutoFields int `long:"autoFields" value-name:"<sampleSize>" optional:"true" optional-value:"1000" description:"discover the fields to export to CSV from the first documents of the export, 1000 unless a sample size is given, instead of requiring --fields"`
+
+	// FlattenArrays controls how arrays are written to CSV.
+	FlattenArrays string `long:"flattenArrays" value-name:"index|join:<sep>|json" description:"how to write arrays to CSV: a column per element with --autoFields (index), the elements joined by a separator in one cell (e.g. join:|), or one JSON array per cell (json) (defaults to 'json')"`
+
+	// CSVDateFormat is the Go time layout of dates in CSV output.
+	CSVDateFormat string `long:"csvDateFormat" value-name:"<layout>" description:"Go time layout of dates in CSV output, e.g. '2006-01-02 15:04:05' (defaults to ISO-8601 with milliseconds)"`
+
+	// Timezone is the time zone of dates in CSV output.
+	Timezone string `long:"timezone" value-name:"<zone>" description:"time zone of dates in CSV output, e.g. 'Europe/Paris' or 'Local' (defaults to UTC)"`
+
+	// CSVObjectIDFormat selects how ObjectIds are written to CSV.
+	CSVObjectIDFormat string `long:"csvObjectIdFormat" value-name:"<format>" description:"write ObjectIds to CSV as hex digits (hex) or like ObjectId(...) (wrapped) (defaults to 'wrapped')"`
+
+	// CSVBinaryFormat selects how binary data is written to CSV.
+	CSVBinaryFormat string `long:"csvBinaryFormat" value-name:"<format>" description:"write binary data to CSV as base64 or uppercase hex (defaults to 'hex')"`
+
+	// CSVNullValue is written to CSV for null and missing fields.
+	CSVNullValue string `long:"csvNullValue" value-name:"<string>" description:"text to write to CSV for null and missing fields, e.g. NULL (defaults to an empty cell)"`
+
+	// CSVPlainNumbers writes doubles and decimals to CSV without an exponent.
+	CSVPlainNumbers bool `long:"csvPlainNumbers" description:"write doubles and decimals to CSV in decimal notation, e.g. 1500000 rather than 1.5e+06, with an exponent only for very large or small numbers"`
+
+	// AvroSchemaFile is the path of an Avro schema to write the documents with, instead of inferring one.
+	AvroSchemaFile string `long:"avroSchemaFile" value-name:"<filename>" description:"Avro schema (.avsc) of the records to write, instead of inferring one from the first documents"`
+
+	// AvroSampleSize is the number of documents to infer the Avro schema from.
+	AvroSampleSize int `long:"avroSampleSize" value-name:"<count>" default:"1000" description:"number of documents to infer the Avro schema from; later values of types not in the schema are written as null (defaults to 1000)"`
+
+	// AvroCodec is the compression codec of Avro blocks.
+	AvroCodec string `long:"avroCodec" value-name:"<codec>" default:"deflate" choice:"null" choice:"deflate" choice:"snappy" description:"compression codec of Avro blocks: null, deflate or snappy (defaults to 'deflate')"`
+
+	// AvroObjectID selects how ObjectIds are written to Avro when inferring the schema.
+	AvroObjectID string `long:"avroObjectId" value-name:"<type>" default:"string" choice:"string" choice:"fixed" description:"write ObjectIds to Avro as hex strings, or as 12 byte fixed values (defaults to 'string')"`
+
+	// SQLDialect is the SQL dialect to write with --type=sql.
+	SQLDialect string `long:"sqlDialect" value-name:"<dialect>" default:"postgres" choice:"postgres" choice:"mysql" description:"SQL dialect of sql output: postgres or mysql (defaults to 'postgres')"`
+
+	// Table is the name of the table to create and insert into with --type=sql.
+	Table string `long:"table" value-name:"<name>" description:"name of the table to create and insert into with --type=sql (defaults to the collection name)"`
+
+	// SQLSampleSize is the number of documents to infer the columns of the table from.
+	SQLSampleSize int `long:"sqlSampleSize" value-name:"<count>" default:"1000" description:"number of documents to infer the columns of the SQL table from (defaults to 1000)"`
+
+	// SQLBatchSize is the number of rows in each INSERT statement.
+	SQLBatchSize int `long:"sqlBatchSize" value-name:"<count>" default:"100" description:"number of rows in each SQL INSERT statement (defaults to 100)"`
+
+	// SQLCopy writes the rows as a Postgres COPY block instead of INSERT statements.
+	SQLCopy bool `long:"sqlCopy" description:"write the rows of sql output in a Postgres COPY ... FROM STDIN block, instead of INSERT statements"`
+
+	// Template is the path of a text/template to render every document with.
+	Template string `long:"template" value-name:"<filename>" description:"Go text/template file to render every document with, for --type=template"`
+
+	// TemplateHeader and TemplateFooter are templates rendered before and after the documents.
+	TemplateHeader string `long:"templateHeader" value-name:"<filename>" description:"Go text/template file to render before the documents, for --type=template"`
+	TemplateFooter string `long:"templateFooter" value-name:"<filename>" description:"Go text/template file to render after the documents, for --type=template"`
+
 	// NoHeaderLine, if set, will export CSV data without a list of field names at the first line.
 	NoHeaderLine bool `long:"noHeaderLine" description:"export CSV data without a list of field names at the first line"`
 }
@@ -54,6 +124,16 @@ type InputOptions struct {
 	Limit          int    `long:"limit" value-name:"<count>" description:"limit the number of documents to export"`
 	Sort           string `long:"sort" value-name:"<json>" description:"sort order, as a JSON string, e.g. '{x:1}'"`
 	AssertExists   bool   `long:"assertExists" default:"false" description:"if specified, export fails if the collection does not exist"`
+	Pipeline       string `long:"pipeline" value-name:"<json>" description:"aggregation pipeline to export the results of, as a JSON array of stages, e.g. '[{$match:{x:1}},{$group:{_id:\"$y\"}}]'"`
+	PipelineFile   string `long:"pipelineFile" value-name:"<filename>" description:"path to a file containing an aggregation pipeline (JSON array)"`
+	AllowDiskUse   bool   `long:"allowDiskUse" description:"allow the aggregation pipeline to write temporary data to disk"`
+	BatchSize      int    `long:"batchSize" value-name:"<count>" description:"number of documents for the server to return per batch"`
+
+	IncrementalField string `long:"incrementalField" value-name:"<field>" description:"export only the documents whose field, e.g. updatedAt or _id, is greater than the watermark in --stateFile, in the order of the field; the field should only ever increase"`
+	StateFile        string `long:"stateFile" value-name:"<filename>" description:"file holding the watermark of --incrementalField, which is updated once the export succeeds"`
+
+	Follow     bool   `long:"follow" description:"keep exporting new documents until interrupted: tail a capped collection, or else an insert event for every document of the collection and then an event for every insert, update and delete in the oplog, including those of transactions, except prepared ones or those too large for one oplog entry, which stop the follow"`
+	ResumeFile string `long:"resumeFile" value-name:"<filename>" description:"file holding the position of --follow, from which it resumes"`
 }
 
 // Name returns a human-readable group name for input options.
@@ -77,3 +157,20 @@ func (inputOptions *InputOptions) GetQuery() ([]byte, error) {
 	}
 	panic("GetQuery can return valid values only for query or queryFile input")
 }
+
+func (inputOptions *InputOptions) HasPipeline() bool {
+	return inputOptions.Pipeline != "" || inputOptions.PipelineFile != ""
+}
+
+func (inputOptions *InputOptions) GetPipeline() ([]byte, error) {
+	if inputOptions.Pipeline != "" {
+		return []byte(inputOptions.Pipeline), nil
+	} else if inputOptions.PipelineFile != "" {
+		content, err := ioutil.ReadFile(inputOptions.PipelineFile)
+		if err != nil {
+			err = fmt.Errorf("error reading pipelineFile: %s", err)
+		}
+		return content, err
+	}
+	panic("GetPipeline can return valid values only for pipeline or pipelineFile input")
+}
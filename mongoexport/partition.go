@@ -0,0 +1,205 @@
+package mongoexport
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/progress"
+	"github.com/mongodb/mongo-tools/common/util"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// exportPartition is a range of _id values, from Min (inclusive) to Max
+// (exclusive). The first partition has no Min and the last has no Max.
+type exportPartition struct {
+	Index          int
+	Min, Max       interface{}
+	HasMin, HasMax bool
+}
+
+// restrict returns the query restricted to the _id values of the partition.
+func (p *exportPartition) restrict(query map[string]interface{}) map[string]interface{} {
+	idRange := bson.M{}
+	if p.HasMin {
+		idRange["$gte"] = p.Min
+	}
+	if p.HasMax {
+		idRange["$lt"] = p.Max
+	}
+	if len(idRange) == 0 {
+		return query
+	}
+	if len(query) == 0 {
+		return map[string]interface{}{"_id": idRange}
+	}
+	return map[string]interface{}{"$and": []interface{}{query, bson.M{"_id": idRange}}}
+}
+
+// makePartitions returns the partitions separated by the given _id values,
+// which must be in ascending order.
+func makePartitions(bounds []interface{}) []exportPartition {
+	partitions := make([]exportPartition, len(bounds)+1)
+	for i := range partitions {
+		partitions[i].Index = i
+		if i > 0 {
+			partitions[i].Min, partitions[i].HasMin = bounds[i-1], true
+		}
+		if i < len(bounds) {
+			partitions[i].Max, partitions[i].HasMax = bounds[i], true
+		}
+	}
+	return partitions
+}
+
+// idTypeClass returns the class of values an _id is compared with in range
+// queries. Numbers of any type are compared with each other, but otherwise a
+// range only matches values of the type of its bounds.
+func idTypeClass(id interface{}) string {
+	switch id.(type) {
+	case int, int32, int64, float64, bson.Decimal128:
+		return "number"
+	case string, bson.Symbol:
+		return "string"
+	case nil:
+		return "null"
+	}
+	return reflect.TypeOf(id).String()
+}
+
+// getPartitionBounds returns up to n-1 _id values that split the documents to
+// export into n ranges of roughly equal size, using $bucketAuto.
+func (exp *MongoExport) getPartitionBounds(n int) ([]interface{}, error) {
+	session, err := exp.SessionProvider.GetSession()
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+
+	pipeline := []bson.M{}
+	if exp.InputOpts != nil && exp.InputOpts.HasQuery() {
+		content, err := exp.InputOpts.GetQuery()
+		if err != nil {
+			return nil, err
+		}
+		query, err := getObjectFromByteArg(content)
+		if err != nil {
+			return nil, err
+		}
+		pipeline = append(pipeline, bson.M{"$match": query})
+	}
+	pipeline = append(pipeline, bson.M{"$bucketAuto": bson.M{"groupBy": "$_id", "buckets": n}})
+
+	var buckets []struct {
+		ID struct {
+			Min interface{} `bson:"min"`
+			Max interface{} `bson:"max"`
+		} `bson:"_id"`
+	}
+	collection := session.DB(exp.ToolOptions.Namespace.DB).C(exp.ToolOptions.Namespace.Collection)
+	if err = collection.Pipe(pipeline).AllowDiskUse().All(&buckets); err != nil {
+		return nil, fmt.Errorf("error splitting the collection into partitions: %v", err)
+	}
+	if len(buckets) == 0 {
+		return nil, nil
+	}
+
+	// ranges only match _id values of the type of their bounds, and _id
+	// values are sorted by type, so all of them must be of one type
+	first, last := buckets[0].ID.Min, buckets[len(buckets)-1].ID.Max
+	if idTypeClass(first) != idTypeClass(last) {
+		return nil, fmt.Errorf("cannot partition a collection with _id values of different types (%v and %v)",
+			idTypeClass(first), idTypeClass(last))
+	}
+
+	bounds := make([]interface{}, 0, len(buckets)-1)
+	for _, bucket := range buckets[1:] {
+		bounds = append(bounds, bucket.ID.Min)
+	}
+	return bounds, nil
+}
+
+// exportPartitions splits the collection into ranges of _id and exports them
+// concurrently, each to its own file.
+func (exp *MongoExport) exportPartitions() (int64, error) {
+	bounds, err := exp.getPartitionBounds(exp.OutputOpts.NumParallelPartitions)
+	if err != nil {
+		return 0, err
+	}
+	partitions := makePartitions(bounds)
+	if len(partitions) < exp.OutputOpts.NumParallelPartitions {
+		log.Logvf(log.Always, "only %v distinct _id ranges found, exporting %v %v",
+			len(partitions), len(partitions), util.Pluralize(len(partitions), "partition", "partitions"))
+	}
+
+	max, err := exp.getCount()
+	if err != nil {
+		return 0, err
+	}
+	watchProgressor := progress.NewCounter(int64(max))
+	if exp.ProgressManager != nil {
+		name := fmt.Sprintf("%v.%v", exp.ToolOptions.Namespace.DB, exp.ToolOptions.Namespace.Collection)
+		exp.ProgressManager.Attach(name, watchProgressor)
+		defer exp.ProgressManager.Detach(name)
+	}
+	exp.logConnection()
+
+	counts := make([]int64, len(partitions))
+	errs := make([]error, len(partitions))
+	var wg sync.WaitGroup
+	for i := range partitions {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			counts[i], errs[i] = exp.exportPartition(&partitions[i], watchProgressor)
+		}(i)
+	}
+	wg.Wait()
+
+	var total int64
+	for i := range partitions {
+		total += counts[i]
+		if errs[i] != nil && err == nil {
+			err = fmt.Errorf("error exporting partition %v: %v", i, errs[i])
+		}
+	}
+	return total, err
+}
+
+// exportPartition exports the documents of a partition to its numbered file.
+func (exp *MongoExport) exportPartition(partition *exportPartition, watchProgressor progress.Updateable) (count int64, err error) {
+	start := time.Now()
+	file, err := createOutputFile(exp.OutputOpts.OutputFile, partition.Index)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	exportOutput, err := exp.getExportOutput(file)
+	if err != nil {
+		return 0, err
+	}
+	cursor, session, err := exp.getCursor(partition)
+	if err != nil {
+		if session != nil {
+			session.Close()
+		}
+		return 0, err
+	}
+	defer session.Close()
+	defer cursor.Close()
+
+	count, err = exportCursor(cursor, exportOutput, watchProgressor)
+	if err != nil {
+		return count, err
+	}
+	log.Logvf(log.Info, "exported %v %v to %v in %v", count,
+		util.Pluralize(int(count), "record", "records"), file.Name(), time.Since(start))
+	return count, nil
+}
@@ -0,0 +1,520 @@
+package mongoexport
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/json"
+	"github.com/mongodb/mongo-tools/common/log"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// SQL dialects supported by SQLExportOutput.
+const (
+	Postgres = "postgres"
+	MySQL    = "mysql"
+)
+
+// sqlKind is the kind of a SQL column, inferred from the BSON values of a field.
+type sqlKind int
+
+const (
+	sqlNull sqlKind = iota
+	sqlBool
+	sqlInt
+	sqlLong
+	sqlDouble
+	sqlDecimal
+	sqlText
+	sqlDate
+	sqlObjectID
+	sqlBinary
+	sqlJSON
+)
+
+// sqlTypes are the column types of the kinds, in each dialect.
+var sqlTypes = map[string]map[sqlKind]string{
+	Postgres: {
+		sqlBool:     "BOOLEAN",
+		sqlInt:      "INTEGER",
+		sqlLong:     "BIGINT",
+		sqlDouble:   "DOUBLE PRECISION",
+		sqlDecimal:  "NUMERIC",
+		sqlText:     "TEXT",
+		sqlDate:     "TIMESTAMP WITH TIME ZONE",
+		sqlObjectID: "CHAR(24)",
+		sqlBinary:   "BYTEA",
+		sqlJSON:     "JSONB",
+	},
+	MySQL: {
+		sqlBool:     "BOOLEAN",
+		sqlInt:      "INT",
+		sqlLong:     "BIGINT",
+		sqlDouble:   "DOUBLE",
+		sqlDecimal:  "DECIMAL(65,30)",
+		sqlText:     "LONGTEXT",
+		sqlDate:     "DATETIME(3)",
+		sqlObjectID: "CHAR(24)",
+		sqlBinary:   "LONGBLOB",
+		sqlJSON:     "JSON",
+	},
+}
+
+// sqlColumn is a column of the table, holding the value of a field.
+type sqlColumn struct {
+	Field string
+	Kind  sqlKind
+}
+
+// SQLExportOutput is an implementation of ExportOutput that writes documents as
+// rows of a SQL table: a CREATE TABLE statement, with column types inferred
+// from the first documents, followed by batched INSERT statements or, for
+// Postgres, a COPY ... FROM STDIN block. Nested documents are flattened into a
+// column per dotted field, and arrays are written as JSON.
+type SQLExportOutput struct {
+	// Dialect is the SQL dialect to write, Postgres or MySQL.
+	Dialect string
+
+	// Table is the name of the table to create and insert into.
+	Table string
+
+	// Fields is a list of the fields to export, one per column. If empty, the
+	// fields are discovered from the sample.
+	Fields []string
+
+	// SampleSize is the number of documents to infer the column types from.
+	// The sampled documents are held back until the table is created, and
+	// fields that first appear after them are not exported.
+	SampleSize int
+
+	// BatchSize is the number of rows written by each INSERT statement.
+	BatchSize int
+
+	// Copy writes the rows in a Postgres COPY ... FROM STDIN block, instead of
+	// INSERT statements.
+	Copy bool
+
+	// NumExported maintains a running total of the number of documents written.
+	NumExported int64
+
+	out *bufio.Writer
+
+	columns []sqlColumn
+	sample  []bson.D
+	// noCreate is set when the table was created in a previous file
+	noCreate bool
+	// started is set once the column types are known and the table created
+	started bool
+	// batched is the number of rows in the current INSERT or COPY statement
+	batched int
+}
+
+// NewSQLExportOutput returns a SQLExportOutput configured to write output to the
+// given io.Writer, in the given dialect.
+func NewSQLExportOutput(dialect, table string, out io.Writer) *SQLExportOutput {
+	return &SQLExportOutput{
+		Dialect:    dialect,
+		Table:      table,
+		SampleSize: 1000,
+		BatchSize:  100,
+		out:        bufio.NewWriter(out),
+	}
+}
+
+// setColumns sets the columns of a table that was already created, so that
+// only rows are written.
+func (sqlExporter *SQLExportOutput) setColumns(columns []sqlColumn) {
+	sqlExporter.columns = columns
+	sqlExporter.noCreate = true
+	sqlExporter.started = true
+}
+
+// WriteHeader does nothing, since the table is created once the column types
+// have been inferred from the sample.
+func (sqlExporter *SQLExportOutput) WriteHeader() error {
+	return nil
+}
+
+// ExportDocument writes the document as a row of the table, holding it back
+// while the sample is taken.
+func (sqlExporter *SQLExportOutput) ExportDocument(document bson.D) error {
+	if !sqlExporter.started {
+		// the caller may reuse the document, so hold back a copy
+		sqlExporter.sample = append(sqlExporter.sample, append(bson.D{}, document...))
+		if len(sqlExporter.sample) >= sqlExporter.SampleSize {
+			return sqlExporter.endSampling()
+		}
+		return nil
+	}
+	if err := sqlExporter.writeRow(document); err != nil {
+		return err
+	}
+	sqlExporter.NumExported++
+	return nil
+}
+
+// WriteFooter writes out the documents held back for the sample, if there
+// were fewer of them than the sample size, and ends the last statement.
+func (sqlExporter *SQLExportOutput) WriteFooter() error {
+	if !sqlExporter.started {
+		if err := sqlExporter.endSampling(); err != nil {
+			return err
+		}
+	}
+	return sqlExporter.endStatement()
+}
+
+// buffered returns the number of bytes written but not flushed yet.
+func (sqlExporter *SQLExportOutput) buffered() int {
+	return sqlExporter.out.Buffered()
+}
+
+// Flush writes any pending data to the underlying I/O stream.
+func (sqlExporter *SQLExportOutput) Flush() error {
+	return sqlExporter.out.Flush()
+}
+
+// endSampling infers the columns from the sampled documents, creates the
+// table and writes the sampled documents.
+func (sqlExporter *SQLExportOutput) endSampling() error {
+	sqlExporter.started = true
+	fields := sqlExporter.Fields
+	if len(fields) == 0 {
+		fields = discoverFields(sqlExporter.sample, false)
+	}
+	sqlExporter.columns = inferSQLColumns(sqlExporter.sample, fields)
+	log.Logvf(log.Info, "inferred %v columns from %v sampled documents",
+		len(sqlExporter.columns), len(sqlExporter.sample))
+	if len(sqlExporter.columns) == 0 {
+		if len(sqlExporter.sample) > 0 {
+			return fmt.Errorf("no fields to export to table %v", sqlExporter.Table)
+		}
+		return nil
+	}
+	if err := sqlExporter.writeCreateTable(); err != nil {
+		return err
+	}
+	for _, document := range sqlExporter.sample {
+		if err := sqlExporter.ExportDocument(document); err != nil {
+			return err
+		}
+	}
+	sqlExporter.sample = nil
+	return nil
+}
+
+// writeCreateTable writes the CREATE TABLE statement of the columns.
+func (sqlExporter *SQLExportOutput) writeCreateTable() error {
+	if sqlExporter.noCreate {
+		return nil
+	}
+	types := sqlTypes[sqlExporter.Dialect]
+	definitions := make([]string, len(sqlExporter.columns))
+	for i, column := range sqlExporter.columns {
+		definitions[i] = "  " + sqlExporter.quoteIdentifier(column.Field) + " " + types[column.Kind]
+	}
+	_, err := fmt.Fprintf(sqlExporter.out, "CREATE TABLE %v (\n%v\n);\n",
+		sqlExporter.quoteIdentifier(sqlExporter.Table), strings.Join(definitions, ",\n"))
+	return err
+}
+
+// columnList returns the quoted names of the columns, for INSERT and COPY.
+func (sqlExporter *SQLExportOutput) columnList() string {
+	names := make([]string, len(sqlExporter.columns))
+	for i, column := range sqlExporter.columns {
+		names[i] = sqlExporter.quoteIdentifier(column.Field)
+	}
+	return strings.Join(names, ", ")
+}
+
+// writeRow writes the values of the document's fields, starting a new
+// statement if the current one is full.
+func (sqlExporter *SQLExportOutput) writeRow(document bson.D) error {
+	if sqlExporter.Copy {
+		if sqlExporter.batched == 0 {
+			fmt.Fprintf(sqlExporter.out, "COPY %v (%v) FROM STDIN;\n",
+				sqlExporter.quoteIdentifier(sqlExporter.Table), sqlExporter.columnList())
+		}
+		for i, column := range sqlExporter.columns {
+			if i > 0 {
+				sqlExporter.out.WriteByte('\t')
+			}
+			value, _ := lookupDottedField(document, column.Field)
+			sqlExporter.out.WriteString(sqlExporter.copyValue(column.Kind, value))
+		}
+		sqlExporter.batched++
+		return sqlExporter.out.WriteByte('\n')
+	}
+
+	if sqlExporter.batched == 0 {
+		fmt.Fprintf(sqlExporter.out, "INSERT INTO %v (%v) VALUES\n",
+			sqlExporter.quoteIdentifier(sqlExporter.Table), sqlExporter.columnList())
+	} else {
+		sqlExporter.out.WriteString(",\n")
+	}
+	sqlExporter.out.WriteByte('(')
+	for i, column := range sqlExporter.columns {
+		if i > 0 {
+			sqlExporter.out.WriteString(", ")
+		}
+		value, _ := lookupDottedField(document, column.Field)
+		sqlExporter.out.WriteString(sqlExporter.literal(column.Kind, value))
+	}
+	sqlExporter.out.WriteByte(')')
+	sqlExporter.batched++
+	if sqlExporter.batched >= sqlExporter.BatchSize {
+		return sqlExporter.endStatement()
+	}
+	return nil
+}
+
+// endStatement ends the current INSERT statement or COPY block.
+func (sqlExporter *SQLExportOutput) endStatement() error {
+	if sqlExporter.batched == 0 {
+		return nil
+	}
+	sqlExporter.batched = 0
+	var err error
+	if sqlExporter.Copy {
+		_, err = sqlExporter.out.WriteString("\\.\n")
+	} else {
+		_, err = sqlExporter.out.WriteString(";\n")
+	}
+	return err
+}
+
+// quoteIdentifier quotes a table or column name.
+func (sqlExporter *SQLExportOutput) quoteIdentifier(name string) string {
+	if sqlExporter.Dialect == MySQL {
+		return "`" + strings.Replace(name, "`", "``", -1) + "`"
+	}
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
+
+// quoteString quotes a string literal.
+func (sqlExporter *SQLExportOutput) quoteString(s string) string {
+	if sqlExporter.Dialect == MySQL {
+		return "'" + mysqlEscaper.Replace(s) + "'"
+	}
+	// with standard_conforming_strings, only quotes are escaped; Postgres
+	// text can't hold NUL characters
+	return "'" + postgresEscaper.Replace(s) + "'"
+}
+
+var (
+	postgresEscaper = strings.NewReplacer("'", "''", "\x00", "")
+	mysqlEscaper    = strings.NewReplacer(`\`, `\\`, "'", "''", "\x00", `\0`,
+		"\n", `\n`, "\r", `\r`, "\x1a", `\Z`)
+	copyEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, "\r", `\r`, "\t", `\t`, "\x00", "")
+)
+
+// literal returns the SQL literal of a value, in a column of the given kind.
+func (sqlExporter *SQLExportOutput) literal(kind sqlKind, value interface{}) string {
+	text, quoted, ok := sqlExporter.formatValue(kind, value)
+	switch {
+	case !ok:
+		return "NULL"
+	case quoted:
+		return sqlExporter.quoteString(text)
+	}
+	return text
+}
+
+// copyValue returns the COPY text format of a value, in a column of the given kind.
+func (sqlExporter *SQLExportOutput) copyValue(kind sqlKind, value interface{}) string {
+	text, _, ok := sqlExporter.formatValue(kind, value)
+	if !ok {
+		return `\N`
+	}
+	return copyEscaper.Replace(text)
+}
+
+// formatValue returns the text of a value in a column of the given kind, and
+// whether it needs quoting as a string literal. It returns false if the value
+// is null, or can't be represented in the dialect. Values that don't fit the
+// kind of their column, like a string in a numeric column of a field that was
+// only numbers in the sample, are written as strings for the database to convert.
+func (sqlExporter *SQLExportOutput) formatValue(kind sqlKind, value interface{}) (string, bool, bool) {
+	valueKind := sqlKindOf(value)
+	if valueKind == sqlNull {
+		return "", false, false
+	}
+	if kind == sqlJSON {
+		return relaxedJSON(value), true, true
+	}
+	if !sqlKindFits(valueKind, kind) {
+		return plainText(value), true, true
+	}
+	switch v := value.(type) {
+	case bool:
+		if v {
+			return "TRUE", false, true
+		}
+		return "FALSE", false, true
+	case int:
+		return strconv.Itoa(v), false, true
+	case int64:
+		return strconv.FormatInt(v, 10), false, true
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			if sqlExporter.Dialect == MySQL {
+				return "", false, false
+			}
+			return plainText(v), true, true
+		}
+		return strconv.FormatFloat(v, 'g', -1, 64), false, true
+	case bson.Decimal128:
+		text := v.String()
+		if sqlExporter.Dialect == MySQL && (text == "NaN" || strings.HasSuffix(text, "Inf")) {
+			return "", false, false
+		}
+		return text, true, true
+	case time.Time:
+		if sqlExporter.Dialect == MySQL {
+			// DATETIME has no time zone
+			return v.UTC().Format("2006-01-02 15:04:05.000"), true, true
+		}
+		return v.UTC().Format("2006-01-02 15:04:05.000Z07:00"), true, true
+	case []byte:
+		return sqlExporter.binaryLiteral(v)
+	case bson.Binary:
+		return sqlExporter.binaryLiteral(v.Data)
+	}
+	return plainText(value), true, true
+}
+
+// binaryLiteral returns the literal of binary data: a hex bytea for Postgres,
+// or a hex literal for MySQL.
+func (sqlExporter *SQLExportOutput) binaryLiteral(data []byte) (string, bool, bool) {
+	if sqlExporter.Dialect == MySQL && !sqlExporter.Copy {
+		return "X'" + hex.EncodeToString(data) + "'", false, true
+	}
+	return `\x` + hex.EncodeToString(data), true, true
+}
+
+// sqlKindOf returns the column kind that holds a value.
+func sqlKindOf(value interface{}) sqlKind {
+	switch v := value.(type) {
+	case nil:
+		return sqlNull
+	case bool:
+		return sqlBool
+	case int:
+		if v < math.MinInt32 || v > math.MaxInt32 {
+			return sqlLong
+		}
+		return sqlInt
+	case int64:
+		return sqlLong
+	case float64:
+		return sqlDouble
+	case bson.Decimal128:
+		return sqlDecimal
+	case string, bson.Symbol:
+		return sqlText
+	case time.Time:
+		return sqlDate
+	case bson.ObjectId:
+		return sqlObjectID
+	case []byte, bson.Binary:
+		return sqlBinary
+	}
+	return sqlJSON
+}
+
+// sqlKindFits returns true if a value of one kind can be written to a column
+// of another, which holds wider numbers.
+func sqlKindFits(valueKind, columnKind sqlKind) bool {
+	switch columnKind {
+	case valueKind:
+		return true
+	case sqlLong:
+		return valueKind == sqlInt
+	case sqlDouble, sqlDecimal:
+		return valueKind == sqlInt || valueKind == sqlLong
+	}
+	return false
+}
+
+// mergeSQLKinds returns the kind of a column holding values of two kinds:
+// the wider of two numeric kinds, or else text.
+func mergeSQLKinds(a, b sqlKind) sqlKind {
+	switch {
+	case a == sqlNull || a == b:
+		return b
+	case b == sqlNull:
+		return a
+	case sqlKindFits(a, b):
+		return b
+	case sqlKindFits(b, a):
+		return a
+	case (a == sqlDouble && b == sqlDecimal) || (a == sqlDecimal && b == sqlDouble):
+		return sqlDecimal
+	}
+	return sqlText
+}
+
+// inferSQLColumns returns the columns of the fields, with the kinds of their
+// values in the documents. Fields without values are text.
+func inferSQLColumns(documents []bson.D, fields []string) []sqlColumn {
+	columns := make([]sqlColumn, len(fields))
+	for i, field := range fields {
+		kind := sqlNull
+		for _, document := range documents {
+			value, _ := lookupDottedField(document, field)
+			kind = mergeSQLKinds(kind, sqlKindOf(value))
+		}
+		if kind == sqlNull {
+			kind = sqlText
+		}
+		columns[i] = sqlColumn{Field: field, Kind: kind}
+	}
+	return columns
+}
+
+// plainText returns the text of a value, in a text column or a template:
+// strings as they are, ObjectIds as hex, dates in UTC and other values as JSON.
+func plainText(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case bson.Symbol:
+		return string(v)
+	case bool, int, int64:
+		return fmt.Sprint(v)
+	case float64:
+		switch {
+		case math.IsNaN(v):
+			return "NaN"
+		case math.IsInf(v, 1):
+			return "Infinity"
+		case math.IsInf(v, -1):
+			return "-Infinity"
+		}
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case bson.Decimal128:
+		return v.String()
+	case time.Time:
+		return v.UTC().Format("2006-01-02T15:04:05.000Z07:00")
+	case bson.ObjectId:
+		return v.Hex()
+	}
+	return relaxedJSON(value)
+}
+
+// relaxedJSON returns the relaxed Extended JSON of a value, for JSON columns and templates.
+func relaxedJSON(value interface{}) string {
+	text, err := json.MarshalExtJSON(value, json.Relaxed)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(text)
+}
@@ -0,0 +1,126 @@
+package mongoexport
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestSQLExport(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+
+	date := time.Date(2017, 3, 4, 5, 6, 7, 890000000, time.FixedZone("", 3600))
+	documents := []bson.D{
+		{
+			{Name: "_id", Value: 1},
+			{Name: "name", Value: "O'Brien\\"},
+			{Name: "address", Value: bson.D{{Name: "city", Value: "Cork"}}},
+			{Name: "when", Value: date},
+			{Name: "tags", Value: []interface{}{"a", "b"}},
+			{Name: "data", Value: []byte{1, 0xab}},
+		},
+		{
+			{Name: "_id", Value: int64(1) << 40},
+			{Name: "name", Value: nil},
+			{Name: "score", Value: 1.5},
+		},
+	}
+	export := func(output *SQLExportOutput, docs []bson.D) {
+		So(output.WriteHeader(), ShouldBeNil)
+		for _, document := range docs {
+			So(output.ExportDocument(document), ShouldBeNil)
+		}
+		So(output.WriteFooter(), ShouldBeNil)
+		So(output.Flush(), ShouldBeNil)
+	}
+
+	Convey("With a Postgres SQL export", t, func() {
+		out := &bytes.Buffer{}
+		output := NewSQLExportOutput(Postgres, "people", out)
+
+		Convey("the table should be created with flattened fields and inferred types", func() {
+			export(output, documents)
+			So(out.String(), ShouldEqual, `CREATE TABLE "people" (
+  "_id" BIGINT,
+  "name" TEXT,
+  "address.city" TEXT,
+  "when" TIMESTAMP WITH TIME ZONE,
+  "tags" JSONB,
+  "data" BYTEA,
+  "score" DOUBLE PRECISION
+);
+INSERT INTO "people" ("_id", "name", "address.city", "when", "tags", "data", "score") VALUES
+(1, 'O''Brien\', 'Cork', '2017-03-04 04:06:07.890Z', '["a","b"]', '\x01ab', NULL),
+(1099511627776, NULL, NULL, NULL, NULL, NULL, 1.5);
+`)
+			So(output.NumExported, ShouldEqual, 2)
+		})
+
+		Convey("rows should be batched into INSERT statements", func() {
+			output.BatchSize = 2
+			output.Fields = []string{"_id"}
+			export(output, []bson.D{{{Name: "_id", Value: 1}}, {{Name: "_id", Value: 2}}, {{Name: "_id", Value: 3}}})
+			So(out.String(), ShouldEqual, `CREATE TABLE "people" (
+  "_id" INTEGER
+);
+INSERT INTO "people" ("_id") VALUES
+(1),
+(2);
+INSERT INTO "people" ("_id") VALUES
+(3);
+`)
+		})
+
+		Convey("a COPY block should escape the text format", func() {
+			output.Copy = true
+			output.Fields = []string{"name", "data"}
+			export(output, []bson.D{
+				{{Name: "name", Value: "tab\there\\"}, {Name: "data", Value: []byte{0xff}}},
+				{{Name: "name", Value: nil}},
+			})
+			So(out.String(), ShouldEqual, "CREATE TABLE \"people\" (\n  \"name\" TEXT,\n  \"data\" BYTEA\n);\n"+
+				"COPY \"people\" (\"name\", \"data\") FROM STDIN;\n"+
+				"tab\\there\\\\\t\\\\xff\n"+
+				"\\N\t\\N\n"+
+				"\\.\n")
+		})
+
+		Convey("values that don't fit the inferred type should be written as strings", func() {
+			output.SampleSize = 1
+			export(output, []bson.D{{{Name: "n", Value: 1}}, {{Name: "n", Value: "many"}}})
+			So(out.String(), ShouldEndWith, "(1),\n('many');\n")
+		})
+	})
+
+	Convey("With a MySQL SQL export", t, func() {
+		out := &bytes.Buffer{}
+		output := NewSQLExportOutput(MySQL, "my`table", out)
+
+		Convey("identifiers, strings, dates and binary should use MySQL syntax", func() {
+			export(output, documents[:1])
+			So(out.String(), ShouldEqual, "CREATE TABLE `my``table` (\n"+
+				"  `_id` INT,\n"+
+				"  `name` LONGTEXT,\n"+
+				"  `address.city` LONGTEXT,\n"+
+				"  `when` DATETIME(3),\n"+
+				"  `tags` JSON,\n"+
+				"  `data` LONGBLOB\n"+
+				");\n"+
+				"INSERT INTO `my``table` (`_id`, `name`, `address.city`, `when`, `tags`, `data`) VALUES\n"+
+				"(1, 'O''Brien\\\\', 'Cork', '2017-03-04 04:06:07.890', '[\"a\",\"b\"]', X'01ab');\n")
+		})
+	})
+
+	Convey("Column kinds should widen to hold all the sampled values", t, func() {
+		So(mergeSQLKinds(sqlInt, sqlLong), ShouldEqual, sqlLong)
+		So(mergeSQLKinds(sqlLong, sqlDouble), ShouldEqual, sqlDouble)
+		So(mergeSQLKinds(sqlDouble, sqlDecimal), ShouldEqual, sqlDecimal)
+		So(mergeSQLKinds(sqlNull, sqlDate), ShouldEqual, sqlDate)
+		So(mergeSQLKinds(sqlInt, sqlText), ShouldEqual, sqlText)
+		So(mergeSQLKinds(sqlBool, sqlObjectID), ShouldEqual, sqlText)
+	})
+}
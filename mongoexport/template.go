@@ -0,0 +1,231 @@
+package mongoexport
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"text/template"
+	"time"
+	"unicode/utf8"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// TemplateExportOutput is an implementation of ExportOutput that renders every
+// document through a text/template. The document is the template's data, as a
+// map, so that fields can be read like {{.name}} or {{.address.city}}.
+type TemplateExportOutput struct {
+	// Document is rendered for every document.
+	Document *template.Template
+
+	// Header and Footer, if set, are rendered before the first and after the
+	// last document, with a TemplateInfo.
+	Header *template.Template
+	Footer *template.Template
+
+	// Info is the data of the header and footer.
+	Info TemplateInfo
+
+	// NumExported maintains a running total of the number of documents written.
+	NumExported int64
+
+	out *bufio.Writer
+}
+
+// TemplateInfo is the data of the header and footer templates.
+type TemplateInfo struct {
+	DB         string
+	Collection string
+	// Count is the number of documents exported, in the footer.
+	Count int64
+}
+
+// NewTemplateExportOutput returns a TemplateExportOutput configured to write
+// output to the given io.Writer, rendering documents with the given template.
+func NewTemplateExportOutput(document *template.Template, out io.Writer) *TemplateExportOutput {
+	return &TemplateExportOutput{
+		Document: document,
+		out:      bufio.NewWriter(out),
+	}
+}
+
+// WriteHeader renders the header template, if any.
+func (templateExporter *TemplateExportOutput) WriteHeader() error {
+	if templateExporter.Header == nil {
+		return nil
+	}
+	return templateExporter.Header.Execute(templateExporter.out, templateExporter.Info)
+}
+
+// WriteFooter renders the footer template, if any.
+func (templateExporter *TemplateExportOutput) WriteFooter() error {
+	if templateExporter.Footer == nil {
+		return nil
+	}
+	templateExporter.Info.Count = templateExporter.NumExported
+	return templateExporter.Footer.Execute(templateExporter.out, templateExporter.Info)
+}
+
+// buffered returns the number of bytes written but not flushed yet.
+func (templateExporter *TemplateExportOutput) buffered() int {
+	return templateExporter.out.Buffered()
+}
+
+// Flush writes any pending data to the underlying I/O stream.
+func (templateExporter *TemplateExportOutput) Flush() error {
+	return templateExporter.out.Flush()
+}
+
+// ExportDocument renders the document template with the document.
+func (templateExporter *TemplateExportOutput) ExportDocument(document bson.D) error {
+	if err := templateExporter.Document.Execute(templateExporter.out, templateData(document)); err != nil {
+		return err
+	}
+	templateExporter.NumExported++
+	return nil
+}
+
+// templateData converts documents into maps, so that templates can read their
+// fields by name.
+func templateData(value interface{}) interface{} {
+	switch v := value.(type) {
+	case bson.D:
+		doc := make(map[string]interface{}, len(v))
+		for _, elem := range v {
+			doc[elem.Name] = templateData(elem.Value)
+		}
+		return doc
+	case []interface{}:
+		array := make([]interface{}, len(v))
+		for i, element := range v {
+			array[i] = templateData(element)
+		}
+		return array
+	}
+	return value
+}
+
+// ReadExportTemplate reads and parses a template file, with the helper functions
+// of templates.
+func ReadExportTemplate(path string) (*template.Template, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading template: %v", err)
+	}
+	return ParseExportTemplate(path, string(content))
+}
+
+// ParseExportTemplate parses a template, with the helper functions of templates:
+//
+//	get "a.b.0" .        the value of a dotted field, or "" if it is missing or null
+//	date "2006-01-02" v  a date in UTC, in a Go time layout
+//	dateIn "Europe/Paris" "2006-01-02" v
+//	                     a date in a time zone
+//	json v               a value as relaxed Extended JSON; maps have sorted keys
+//	str v                a value as plain text, e.g. an ObjectId as hex
+//	xml v, shell v, csv v
+//	                     a value escaped for XML, quoted for a POSIX shell, or
+//	                     quoted as a CSV field if needed
+//	pad n v, padLeft n v a value padded or truncated to n characters, for
+//	                     fixed-width columns
+//	upper v, lower v     a value in upper or lower case
+func ParseExportTemplate(name, text string) (*template.Template, error) {
+	parsed, err := template.New(name).Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing template: %v", err)
+	}
+	return parsed, nil
+}
+
+var templateFuncs = template.FuncMap{
+	"get":     templateGet,
+	"date":    func(layout string, value interface{}) string { return templateDate(time.UTC, layout, value) },
+	"dateIn":  templateDateIn,
+	"json":    relaxedJSON,
+	"str":     plainText,
+	"xml":     templateXML,
+	"shell":   templateShell,
+	"csv":     templateCSV,
+	"pad":     func(width int, value interface{}) string { return templatePad(width, value, false) },
+	"padLeft": func(width int, value interface{}) string { return templatePad(width, value, true) },
+	"upper":   func(value interface{}) string { return strings.ToUpper(plainText(value)) },
+	"lower":   func(value interface{}) string { return strings.ToLower(plainText(value)) },
+}
+
+// templateGet returns the value of a dotted field of a document converted by
+// templateData, or "" if it is missing or null.
+func templateGet(path string, value interface{}) interface{} {
+	for _, part := range strings.Split(path, ".") {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			value = v[part]
+		case []interface{}:
+			value = nil
+			if index, found := parseArrayIndex(part, len(v)); found {
+				value = v[index]
+			}
+		default:
+			value = nil
+		}
+		if value == nil {
+			return ""
+		}
+	}
+	return value
+}
+
+func templateDateIn(zone, layout string, value interface{}) (string, error) {
+	location, err := time.LoadLocation(zone)
+	if err != nil {
+		return "", err
+	}
+	return templateDate(location, layout, value), nil
+}
+
+// templateDate formats a date in a location, and other values as plain text.
+func templateDate(location *time.Location, layout string, value interface{}) string {
+	if date, ok := value.(time.Time); ok {
+		return date.In(location).Format(layout)
+	}
+	return plainText(value)
+}
+
+func templateXML(value interface{}) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(plainText(value)))
+	return buf.String()
+}
+
+// templateShell quotes a value as a single word for a POSIX shell.
+func templateShell(value interface{}) string {
+	return "'" + strings.Replace(plainText(value), "'", `'\''`, -1) + "'"
+}
+
+// templateCSV quotes a value as a CSV field, if it needs quoting.
+func templateCSV(value interface{}) string {
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	writer.Write([]string{plainText(value)})
+	writer.Flush()
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// templatePad pads a value with spaces, on the right or the left, or
+// truncates it to the width.
+func templatePad(width int, value interface{}, left bool) string {
+	text := plainText(value)
+	length := utf8.RuneCountInString(text)
+	if length > width {
+		return string([]rune(text)[:width])
+	}
+	padding := strings.Repeat(" ", width-length)
+	if left {
+		return padding + text
+	}
+	return text + padding
+}
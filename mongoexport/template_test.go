@@ -0,0 +1,100 @@
+package mongoexport
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestTemplateExport(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+
+	id := bson.ObjectIdHex("5a1b2c3d4e5f60718293a4b5")
+	date := time.Date(2017, 3, 4, 23, 30, 0, 0, time.UTC)
+	document := bson.D{
+		{Name: "_id", Value: id},
+		{Name: "name", Value: "Tom & 'Jerry'"},
+		{Name: "when", Value: date},
+		{Name: "address", Value: bson.D{{Name: "city", Value: "Cork"}, {Name: "zip", Value: nil}}},
+		{Name: "tags", Value: []interface{}{"a", bson.D{{Name: "b", Value: 1}}}},
+	}
+	render := func(text string) (string, error) {
+		parsed, err := ParseExportTemplate("test", text)
+		So(err, ShouldBeNil)
+		out := &bytes.Buffer{}
+		output := NewTemplateExportOutput(parsed, out)
+		if err = output.ExportDocument(document); err != nil {
+			return "", err
+		}
+		So(output.Flush(), ShouldBeNil)
+		return out.String(), nil
+	}
+
+	Convey("When rendering a document through a template", t, func() {
+		Convey("fields should be readable by name and dotted path", func() {
+			out, err := render(`{{str ._id}} {{.address.city}} {{get "tags.1.b" .}} [{{get "address.zip" .}}] [{{get "nope.x" .}}]`)
+			So(err, ShouldBeNil)
+			So(out, ShouldEqual, "5a1b2c3d4e5f60718293a4b5 Cork 1 [] []")
+		})
+
+		Convey("dates should be formatted in UTC or a time zone", func() {
+			out, err := render(`{{.when | date "2006-01-02 15:04"}} {{dateIn "Asia/Tokyo" "2006-01-02" .when}}`)
+			So(err, ShouldBeNil)
+			So(out, ShouldEqual, "2017-03-04 23:30 2017-03-05")
+		})
+
+		Convey("subdocuments should be encoded as JSON", func() {
+			out, err := render(`{{json .tags}} {{json (get "address" .)}}`)
+			So(err, ShouldBeNil)
+			So(out, ShouldEqual, `["a",{"b":1}] {"city":"Cork","zip":null}`)
+		})
+
+		Convey("values should be escaped for XML, shells and CSV", func() {
+			out, err := render(`<n>{{xml .name}}</n> {{shell .name}} {{csv .name}},{{csv .address.city}}`)
+			So(err, ShouldBeNil)
+			So(out, ShouldEqual, `<n>Tom &amp; &#39;Jerry&#39;</n> 'Tom & '\''Jerry'\''' Tom & 'Jerry',Cork`)
+		})
+
+		Convey("values should be padded and truncated to fixed widths", func() {
+			out, err := render(`|{{pad 6 .address.city}}|{{padLeft 6 .address.city}}|{{pad 3 .name | upper}}|`)
+			So(err, ShouldBeNil)
+			So(out, ShouldEqual, "|Cork  |  Cork|TOM|")
+		})
+
+		Convey("an unknown time zone should fail the export", func() {
+			_, err := render(`{{dateIn "Nowhere/Special" "2006" .when}}`)
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("The header and footer should be rendered with the namespace and count", t, func() {
+		document, err := ParseExportTemplate("document", "{{.name}}\n")
+		So(err, ShouldBeNil)
+		header, err := ParseExportTemplate("header", "<{{.DB}}.{{.Collection}}>\n")
+		So(err, ShouldBeNil)
+		footer, err := ParseExportTemplate("footer", "</{{.Count}}>\n")
+		So(err, ShouldBeNil)
+
+		out := &bytes.Buffer{}
+		output := NewTemplateExportOutput(document, out)
+		output.Header, output.Footer = header, footer
+		output.Info = TemplateInfo{DB: "db", Collection: "coll"}
+		So(output.WriteHeader(), ShouldBeNil)
+		So(output.ExportDocument(bson.D{{Name: "name", Value: "a"}}), ShouldBeNil)
+		So(output.ExportDocument(bson.D{{Name: "name", Value: "b"}}), ShouldBeNil)
+		So(output.WriteFooter(), ShouldBeNil)
+		So(output.Flush(), ShouldBeNil)
+		So(out.String(), ShouldEqual, "<db.coll>\na\nb\n</2>\n")
+	})
+
+	Convey("A template that doesn't parse should be rejected", t, func() {
+		_, err := ParseExportTemplate("bad", "{{.name")
+		So(err, ShouldNotBeNil)
+		_, err = ParseExportTemplate("bad", "{{nosuchfunc .name}}")
+		So(err, ShouldNotBeNil)
+	})
+}
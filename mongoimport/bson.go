@@ -0,0 +1,264 @@
+package mongoimport
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/mongodb/mongo-tools/common/bsonutil"
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/json"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/util"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// BSONInputReader is an implementation of InputReader that reads documents
+// from a stream of raw BSON, such as a .bson file written by mongodump.
+type BSONInputReader struct {
+	// source reads the raw documents from the input source
+	source *db.BSONSource
+
+	// numProcessed indicates the number of BSON documents processed
+	numProcessed uint64
+
+	// embedded sizeTracker exposes the Size() method to check the number of bytes read so far
+	sizeTracker
+
+	// numDecoders is the number of concurrent goroutines to use for decoding
+	numDecoders int
+
+	// rejects, if set, is where documents that can't be imported are written
+	rejects *rejectWriter
+
+	// transform, if set, is applied to each document after it is converted
+	transform *documentTransform
+
+	// validator, if set, checks each document once it is transformed
+	validator *documentValidator
+
+	// offset is the number of bytes read
+	offset int64
+
+	// checkpoints, if set, keeps track of the records done with, and base is
+	// added to positions in the input when resuming from a checkpoint
+	checkpoints *checkpointer
+	base        inputPosition
+}
+
+// BSONConverter implements the Converter interface for BSON input.
+type BSONConverter struct {
+	data    []byte
+	index   uint64
+	rejects *rejectWriter
+	tag     bool
+	end     inputPosition
+}
+
+// NewBSONInputReader creates a new BSONInputReader configured to read data
+// from the given io.Reader.
+func NewBSONInputReader(in io.Reader, numDecoders int) *BSONInputReader {
+	szCount := newSizeTrackingReader(in)
+	return &BSONInputReader{
+		// every document is sent to a decoder, so it needs a buffer of its own
+		source:      db.NewBufferlessBSONSource(ioutil.NopCloser(szCount)),
+		sizeTracker: szCount,
+		numDecoders: numDecoders,
+	}
+}
+
+// keepRejects makes the reader write documents that can't be imported to
+// rejects, as BSON.
+func (r *BSONInputReader) keepRejects(rejects *rejectWriter) {
+	r.rejects = rejects
+}
+
+// transformWith makes the reader's decoding workers apply transform to the
+// documents they convert.
+func (r *BSONInputReader) transformWith(transform *documentTransform) {
+	r.transform = transform
+}
+
+// validateWith makes the reader's decoding workers check the documents they
+// convert against validator, once they are transformed.
+func (r *BSONInputReader) validateWith(validator *documentValidator) {
+	r.validator = validator
+}
+
+// checkpointWith makes the reader send its documents with where their records
+// end, for checkpoints, and continue from its checkpoint when resuming.
+func (r *BSONInputReader) checkpointWith(checkpoints *checkpointer) {
+	r.checkpoints = checkpoints
+}
+
+// ReadAndValidateHeader is a no-op for BSON imports; always returns nil.
+func (r *BSONInputReader) ReadAndValidateHeader() error {
+	return nil
+}
+
+// ReadAndValidateTypedHeader is a no-op for BSON imports; always returns nil.
+func (r *BSONInputReader) ReadAndValidateTypedHeader(parseGrace ParseGrace) error {
+	return nil
+}
+
+// StreamDocument takes a boolean indicating if the documents should be streamed
+// in read order and a channel on which to stream the documents processed from
+// the underlying reader. Returns a non-nil error if encountered
+func (r *BSONInputReader) StreamDocument(ordered bool, readChan chan bson.D) error {
+	return streamWithoutRecords(readChan, func(sourced chan sourcedDocument) error {
+		return r.streamSourced(ordered, sourced)
+	})
+}
+
+// streamSourced is StreamDocument, sending each document with its record.
+func (r *BSONInputReader) streamSourced(ordered bool, readChan chan sourcedDocument) (retErr error) {
+	if r.checkpoints != nil {
+		var err error
+		if r.base, r.numProcessed, err = r.checkpoints.begin(r.position()); err != nil {
+			return err
+		}
+	}
+
+	rawChan := make(chan Converter, r.numDecoders)
+	bsonErrChan := make(chan error)
+
+	// begin reading from source
+	go func() {
+		for {
+			rawBytes := r.source.LoadNext()
+			if rawBytes == nil {
+				close(rawChan)
+				if err := r.source.Err(); err != nil {
+					r.numProcessed++
+					bsonErrChan <- fmt.Errorf("error reading document #%v: %v", r.numProcessed, err)
+				} else {
+					bsonErrChan <- nil
+				}
+				return
+			}
+			r.offset += int64(len(rawBytes))
+			rawChan <- BSONConverter{
+				data:    rawBytes,
+				index:   r.numProcessed,
+				rejects: r.rejects,
+				tag:     r.rejects != nil || r.checkpoints != nil,
+				end:     r.position(),
+			}
+			r.numProcessed++
+		}
+	}()
+
+	// begin processing read bytes
+	go func() {
+		bsonErrChan <- streamDocuments(ordered, r.numDecoders, rawChan, readChan, r.transform, r.validator, r.checkpoints)
+	}()
+
+	return channelQuorumError(bsonErrChan, 2)
+}
+
+// position returns where the last document read ends in the input. BSON has
+// no lines.
+func (r *BSONInputReader) position() inputPosition {
+	return inputPosition{offset: r.base.offset + r.offset}
+}
+
+// Convert implements the Converter interface for BSON input. It converts a
+// BSONConverter struct to a BSON document.
+func (c BSONConverter) Convert() (bson.D, error) {
+	document := bson.D{}
+	if err := bson.Unmarshal(c.data, &document); err != nil {
+		err = fmt.Errorf("error unmarshaling bytes on document #%v: %v", c.index, err)
+		if c.rejects == nil {
+			return nil, err
+		}
+		return nil, c.rejects.rejectParseError(c.source(), err)
+	}
+	log.Logvf(log.DebugHigh, "got document: %v", document)
+	return document, nil
+}
+
+// tagged returns whether the documents of the converter are sent with their
+// record.
+func (c BSONConverter) tagged() bool {
+	return c.tag
+}
+
+// source returns the record the converter converts.
+func (c BSONConverter) source() sourceRecord {
+	return sourceRecord{data: c.data, index: c.index, end: c.end}
+}
+
+// indexDocument holds the key and the other options of an index from a
+// .metadata.json file.
+type indexDocument struct {
+	Options bson.M `bson:",inline"`
+	Key     bson.D `bson:"key"`
+}
+
+// readMetadataIndexes reads the indexes of a .metadata.json file written by
+// mongodump, other than the _id index, which every collection has.
+func readMetadataIndexes(path string) ([]indexDocument, error) {
+	content, err := ioutil.ReadFile(util.ToUniversalPath(path))
+	if err != nil {
+		return nil, fmt.Errorf("error reading metadata file: %v", err)
+	}
+	if len(content) == 0 {
+		return nil, nil
+	}
+
+	// get the ordered keys of each index, then the other options as a map
+	meta := struct {
+		Indexes []indexDocument `json:"indexes"`
+	}{}
+	if err = json.Unmarshal(content, &meta); err != nil {
+		return nil, fmt.Errorf("error parsing metadata file %v: %v", path, err)
+	}
+	metaAsMap := struct {
+		Indexes []bson.M `json:"indexes"`
+	}{}
+	if err = json.Unmarshal(content, &metaAsMap); err != nil {
+		return nil, fmt.Errorf("error parsing metadata file %v: %v", path, err)
+	}
+
+	indexes := []indexDocument{}
+	for i, index := range meta.Indexes {
+		options := metaAsMap.Indexes[i]
+		delete(options, "key")
+		if err = bsonutil.ConvertJSONDocumentToBSON(options); err != nil {
+			return nil, fmt.Errorf("extended json error in metadata file %v: %v", path, err)
+		}
+		for pos, field := range index.Key {
+			if index.Key[pos].Value, err = bsonutil.ParseJSONValue(field.Value); err != nil {
+				return nil, fmt.Errorf("extended json in '%v' field of metadata file %v: %v", field.Name, path, err)
+			}
+		}
+		if options["name"] == "_id_" {
+			continue
+		}
+		// the namespace and version are those of the dumped collection
+		delete(options, "ns")
+		delete(options, "v")
+		indexes = append(indexes, indexDocument{Options: options, Key: index.Key})
+	}
+	return indexes, nil
+}
+
+// createMetadataIndexes creates the indexes of the metadata file on the
+// target collection.
+func (imp *MongoImport) createMetadataIndexes(session *mgo.Session) error {
+	indexes, err := readMetadataIndexes(imp.InputOptions.MetadataFile)
+	if err != nil || len(indexes) == 0 {
+		return err
+	}
+	log.Logvf(log.Always, "creating %v %v from %v", len(indexes),
+		util.Pluralize(len(indexes), "index", "indexes"), imp.InputOptions.MetadataFile)
+	command := bson.D{
+		{Name: "createIndexes", Value: imp.ToolOptions.Collection},
+		{Name: "indexes", Value: indexes},
+	}
+	if err = session.DB(imp.ToolOptions.DB).Run(command, &bson.M{}); err != nil {
+		return fmt.Errorf("error creating indexes: %v", err)
+	}
+	return nil
+}
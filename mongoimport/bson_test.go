@@ -0,0 +1,104 @@
+package mongoimport
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestBSONStreamDocument(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+	Convey("With a BSON input reader", t, func() {
+		documents := []bson.D{
+			{{"_id", 1}, {"a", "x"}, {"b", bson.D{{"c", int64(2)}}}},
+			{{"_id", 2}, {"a", []interface{}{1.5, true}}},
+			{{"_id", bson.ObjectIdHex("5a1b2c3d4e5f60718293a4b5")}},
+		}
+		var data []byte
+		for _, document := range documents {
+			raw, err := bson.Marshal(document)
+			So(err, ShouldBeNil)
+			data = append(data, raw...)
+		}
+
+		Convey("documents should be read in order with their BSON types", func() {
+			r := NewBSONInputReader(bytes.NewReader(data), 2)
+			docChan := make(chan bson.D, len(documents))
+			So(r.StreamDocument(true, docChan), ShouldBeNil)
+			for _, document := range documents {
+				So(<-docChan, ShouldResemble, document)
+			}
+			So(r.Size(), ShouldEqual, len(data))
+		})
+
+		Convey("an empty stream should have no documents", func() {
+			r := NewBSONInputReader(bytes.NewReader(nil), 1)
+			docChan := make(chan bson.D, 1)
+			So(r.StreamDocument(true, docChan), ShouldBeNil)
+			_, open := <-docChan
+			So(open, ShouldBeFalse)
+		})
+
+		Convey("a truncated document should be an error", func() {
+			r := NewBSONInputReader(bytes.NewReader(data[:len(data)-3]), 1)
+			docChan := make(chan bson.D, len(documents))
+			So(r.StreamDocument(true, docChan), ShouldNotBeNil)
+		})
+	})
+}
+
+func TestReadMetadataIndexes(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+	Convey("With a mongodump metadata file", t, func() {
+		dir, err := ioutil.TempDir("", "mongoimport-metadata-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "c.metadata.json")
+
+		Convey("the indexes other than _id should be read, without their namespace", func() {
+			metadata := `{"options":{},"indexes":[` +
+				`{"v":2,"key":{"_id":1},"name":"_id_","ns":"old.c"},` +
+				`{"v":2,"key":{"b":-1,"a":{"$numberLong":"1"}},"name":"b_-1_a_1","ns":"old.c","unique":true},` +
+				`{"v":2,"key":{"loc":"2dsphere"},"name":"loc_2dsphere","ns":"old.c"}]}`
+			So(ioutil.WriteFile(path, []byte(metadata), 0644), ShouldBeNil)
+			indexes, err := readMetadataIndexes(path)
+			So(err, ShouldBeNil)
+			So(len(indexes), ShouldEqual, 2)
+			So(indexes[0].Key, ShouldResemble, bson.D{{"b", int32(-1)}, {"a", int64(1)}})
+			So(indexes[0].Options, ShouldResemble, bson.M{"name": "b_-1_a_1", "unique": true})
+			So(indexes[1].Key, ShouldResemble, bson.D{{"loc", "2dsphere"}})
+		})
+
+		Convey("an invalid file should be an error", func() {
+			So(ioutil.WriteFile(path, []byte(`{"indexes":[`), 0644), ShouldBeNil)
+			_, err := readMetadataIndexes(path)
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given a mongoimport instance for a BSON import", t, func() {
+		Convey("an error should be thrown for options of text formats", func() {
+			imp, err := NewMongoImport()
+			So(err, ShouldBeNil)
+			imp.InputOptions.Type = BSON
+			imp.InputOptions.HeaderLine = true
+			So(imp.ValidateSettings([]string{}), ShouldNotBeNil)
+			imp.InputOptions.HeaderLine = false
+			imp.InputOptions.JSONArray = true
+			So(imp.ValidateSettings([]string{}), ShouldNotBeNil)
+		})
+
+		Convey("an error should be thrown for --metadataFile with other types", func() {
+			imp, err := NewMongoImport()
+			So(err, ShouldBeNil)
+			imp.InputOptions.MetadataFile = "c.metadata.json"
+			So(imp.ValidateSettings([]string{}), ShouldNotBeNil)
+		})
+	})
+}
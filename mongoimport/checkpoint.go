@@ -0,0 +1,240 @@
+package mongoimport
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/json"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/util"
+	"gopkg.in/mgo.v2"
+)
+
+// checkpointInterval is how often the checkpoint file is written while the
+// import makes progress. It is also written when the import ends.
+const checkpointInterval = time.Second
+
+// inputPosition is a position in the input, after a record: its byte offset,
+// not counting any UTF-8 byte order mark, and the number of lines before it.
+type inputPosition struct {
+	offset int64
+	line   uint64
+}
+
+// checkpoint is what a checkpoint file holds: how far into its input an
+// import has got. Every record before Offset has been inserted, or rejected
+// or skipped, and none after it has been.
+type checkpoint struct {
+	File string `json:"file"`
+	Type string `json:"type"`
+
+	// Start is the offset of the first record, after any header line
+	Start int64 `json:"start"`
+
+	Offset  int64  `json:"offset"`
+	Line    uint64 `json:"line"`
+	Records uint64 `json:"records"`
+}
+
+// checkpointer keeps track of the records of an import as they are done with,
+// writing a checkpoint at the end of the last of them that has every record
+// before it done too. With several decoding or insertion workers, records are
+// not done with in order, so later ones are kept until those before them are.
+type checkpointer struct {
+	mutex sync.Mutex
+	path  string
+	state checkpoint
+
+	// resuming is set when the import continues from the state
+	resuming bool
+
+	// done holds the end of each record done with after the checkpoint
+	done map[uint64]inputPosition
+
+	saved time.Time
+	err   error
+}
+
+func newCheckpointer(path string, state checkpoint, resuming bool) *checkpointer {
+	return &checkpointer{
+		path:     path,
+		state:    state,
+		resuming: resuming,
+		done:     map[uint64]inputPosition{},
+		saved:    time.Now(),
+	}
+}
+
+// readCheckpointFile reads a checkpoint file, returning nil if it doesn't
+// exist, so that the first run of an import can use --resume too.
+func readCheckpointFile(path string) (*checkpoint, error) {
+	content, err := ioutil.ReadFile(util.ToUniversalPath(path))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading checkpoint file: %v", err)
+	}
+	state := &checkpoint{}
+	if err = json.Unmarshal(content, state); err != nil {
+		return nil, fmt.Errorf("error parsing checkpoint file %v: %v", path, err)
+	}
+	if state.Offset < state.Start {
+		return nil, fmt.Errorf("invalid checkpoint file %v: offset %v is before start %v", path, state.Offset, state.Start)
+	}
+	return state, nil
+}
+
+// resumeInput returns what is left to import of a file: its header, if any,
+// followed by the rest of it from the checkpoint on, and the size of that.
+func (c *checkpointer) resumeInput(file *os.File, size int64) (io.ReadCloser, int64, error) {
+	var bom int64
+	if c.state.Type != BSON {
+		prefix := make([]byte, len(UTF8_BOM))
+		if n, _ := file.ReadAt(prefix, 0); n == len(prefix) && bytes.Equal(prefix, UTF8_BOM) {
+			bom = int64(n)
+		}
+	}
+	start, offset := bom+c.state.Start, bom+c.state.Offset
+	if offset > size {
+		return nil, 0, fmt.Errorf("checkpoint offset %v is past the end of %v, which has %v bytes", c.state.Offset, c.state.File, size)
+	}
+	reader := io.MultiReader(io.NewSectionReader(file, 0, start), io.NewSectionReader(file, offset, size-offset))
+	return struct {
+		io.Reader
+		io.Closer
+	}{reader, file}, start + size - offset, nil
+}
+
+// begin is called by a reader once it has read any header, with its position.
+// It returns what the reader has to add to its positions and the number of
+// records before the checkpoint, so that it can go on where the checkpoint is.
+func (c *checkpointer) begin(at inputPosition) (inputPosition, uint64, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if !c.resuming {
+		c.state.Start, c.state.Offset, c.state.Line = at.offset, at.offset, at.line
+		return inputPosition{}, 0, nil
+	}
+	if at.offset != c.state.Start {
+		return inputPosition{}, 0, fmt.Errorf("the header of %v has changed since the checkpoint", c.state.File)
+	}
+	base := inputPosition{offset: c.state.Offset - at.offset, line: c.state.Line - at.line}
+	return base, c.state.Records, nil
+}
+
+// complete marks records as done with, writing the checkpoint if it moves on
+// and it hasn't been written for a while.
+func (c *checkpointer) complete(records ...sourceRecord) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, record := range records {
+		if record.index >= c.state.Records {
+			c.done[record.index] = record.end
+		}
+	}
+	moved := false
+	for {
+		end, ok := c.done[c.state.Records]
+		if !ok {
+			break
+		}
+		delete(c.done, c.state.Records)
+		c.state.Offset, c.state.Line = end.offset, end.line
+		c.state.Records++
+		moved = true
+	}
+	if moved && time.Since(c.saved) >= checkpointInterval {
+		c.write()
+	}
+}
+
+// save writes the checkpoint, returning the first error writing it.
+func (c *checkpointer) save() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.write()
+	return c.err
+}
+
+// write replaces the checkpoint file with the current state. The new file
+// is written next to it first, so that the checkpoint file is never left
+// half written.
+func (c *checkpointer) write() {
+	c.saved = time.Now()
+	content, err := json.Marshal(c.state)
+	if err == nil {
+		path := util.ToUniversalPath(c.path)
+		temp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+		if err = ioutil.WriteFile(temp, append(content, '\n'), 0644); err == nil {
+			err = os.Rename(temp, path)
+		}
+	}
+	if err != nil && c.err == nil {
+		c.err = fmt.Errorf("error writing checkpoint file: %v", err)
+	}
+}
+
+// loadCheckpoint sets up --checkpointFile, reading the checkpoint to continue
+// from with --resume.
+func (imp *MongoImport) loadCheckpoint() error {
+	path := imp.IngestOptions.CheckpointFile
+	if imp.InputOptions.File == "" {
+		return fmt.Errorf("--checkpointFile requires --file, standard input can't be resumed")
+	}
+	for _, other := range []string{imp.InputOptions.File, imp.IngestOptions.RejectFile} {
+		if other != "" && filepath.Clean(path) == filepath.Clean(other) {
+			return fmt.Errorf("--checkpointFile can not be the file to import or the reject file")
+		}
+	}
+	state := checkpoint{File: imp.InputOptions.File, Type: imp.InputOptions.Type}
+	resuming := false
+	if imp.IngestOptions.Resume {
+		if imp.IngestOptions.Drop {
+			return fmt.Errorf("incompatible options: --resume and --drop")
+		}
+		saved, err := readCheckpointFile(path)
+		if err != nil {
+			return err
+		}
+		if saved == nil {
+			log.Logvf(log.Always, "no checkpoint in %v, starting from the beginning", path)
+		} else {
+			if filepath.Clean(saved.File) != filepath.Clean(state.File) || saved.Type != state.Type {
+				return fmt.Errorf("checkpoint file %v is for the %v file %v, not the %v file %v",
+					path, saved.Type, saved.File, state.Type, state.File)
+			}
+			log.Logvf(log.Always, "resuming after %v %v, at byte %v of %v", saved.Records,
+				util.Pluralize(int(saved.Records), "record", "records"), saved.Offset, saved.File)
+			state, resuming = *saved, true
+		}
+	}
+	imp.checkpoints = newCheckpointer(path, state, resuming)
+	return nil
+}
+
+// insertedBefore returns the number of documents of a batch known to have
+// been inserted when the import stops on an insert error: those before the
+// first that failed.
+func insertedBefore(batch int, err error) int {
+	bulkError, ok := err.(*mgo.BulkError)
+	if !ok {
+		return 0
+	}
+	for _, failure := range bulkError.Cases() {
+		if failure.Index < 0 {
+			// an error of the whole batch
+			return 0
+		}
+		if failure.Index < batch {
+			batch = failure.Index
+		}
+	}
+	return batch
+}
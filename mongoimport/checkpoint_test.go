@@ -0,0 +1,163 @@
+package mongoimport
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// readFromCheckpoint reads the documents of a file with checkpoints, from
+// the checkpoint when resuming, returning them with their records.
+func readFromCheckpoint(path string, checkpoints *checkpointer,
+	newReader func(io.Reader) internalInputReader) ([]bson.D, []sourceRecord) {
+	file, err := os.Open(path)
+	So(err, ShouldBeNil)
+	stat, err := file.Stat()
+	So(err, ShouldBeNil)
+	var source io.ReadCloser = file
+	if checkpoints.resuming {
+		source, _, err = checkpoints.resumeInput(file, stat.Size())
+		So(err, ShouldBeNil)
+	}
+	defer source.Close()
+	r := newReader(source)
+	r.checkpointWith(checkpoints)
+	documents, records, err := readTagged(r)
+	So(err, ShouldBeNil)
+	return documents, records
+}
+
+func TestCheckpoints(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+
+	Convey("With an input file and a checkpoint file", t, func() {
+		dir, err := ioutil.TempDir("", "mongoimport-checkpoint-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "input")
+		checkpointPath := filepath.Join(dir, "checkpoint.json")
+
+		Convey("a CSV import should resume after the last record done with", func() {
+			header := "name,age\r\n"
+			done := "ann,1\r\n\"bob\nsmith\",2\r\n"
+			rest := "cat,3\r\n"
+			So(ioutil.WriteFile(path, []byte("\xEF\xBB\xBF"+header+done+rest), 0644), ShouldBeNil)
+			newReader := func(in io.Reader) internalInputReader {
+				r := NewCSVInputReader(nil, in, os.Stdout, 2, false)
+				So(r.ReadAndValidateHeader(), ShouldBeNil)
+				return r
+			}
+
+			checkpoints := newCheckpointer(checkpointPath, checkpoint{File: path, Type: CSV}, false)
+			documents, records := readFromCheckpoint(path, checkpoints, newReader)
+			So(len(documents), ShouldEqual, 3)
+			// records done with out of order only move the checkpoint once
+			// those before them are done with
+			checkpoints.complete(records[1])
+			So(checkpoints.state.Records, ShouldEqual, 0)
+			checkpoints.complete(records[0])
+			So(checkpoints.save(), ShouldBeNil)
+
+			saved, err := readCheckpointFile(checkpointPath)
+			So(err, ShouldBeNil)
+			So(*saved, ShouldResemble, checkpoint{
+				File:    path,
+				Type:    CSV,
+				Start:   int64(len(header)),
+				Offset:  int64(len(header + done)),
+				Line:    4,
+				Records: 2,
+			})
+
+			checkpoints = newCheckpointer(checkpointPath, *saved, true)
+			documents, records = readFromCheckpoint(path, checkpoints, newReader)
+			So(documents, ShouldResemble, []bson.D{{{"name", "cat"}, {"age", int32(3)}}})
+			So(records[0].index, ShouldEqual, 2)
+			So(records[0].end, ShouldResemble, inputPosition{offset: int64(len(header + done + rest)), line: 5})
+			checkpoints.complete(records...)
+			So(checkpoints.state.Records, ShouldEqual, 3)
+		})
+
+		Convey("a JSON array import should resume after a separator", func() {
+			So(ioutil.WriteFile(path, []byte("[\n{\"a\": 1},\n{\"a\": 2},\n  {\"a\": 3}\n]\n"), 0644), ShouldBeNil)
+			newReader := func(in io.Reader) internalInputReader {
+				return NewJSONInputReader(true, in, 1)
+			}
+
+			checkpoints := newCheckpointer(checkpointPath, checkpoint{File: path, Type: JSON}, false)
+			_, records := readFromCheckpoint(path, checkpoints, newReader)
+			checkpoints.complete(records[0])
+			So(checkpoints.state.Offset, ShouldEqual, len("[\n{\"a\": 1}"))
+			So(checkpoints.state.Line, ShouldEqual, 1)
+
+			checkpoints = newCheckpointer(checkpointPath, checkpoints.state, true)
+			documents, records := readFromCheckpoint(path, checkpoints, newReader)
+			So(documents, ShouldResemble, []bson.D{{{"a", int32(2)}}, {{"a", int32(3)}}})
+			So(records[1].index, ShouldEqual, 2)
+			So(records[1].end, ShouldResemble, inputPosition{offset: int64(len("[\n{\"a\": 1},\n{\"a\": 2},\n  {\"a\": 3}")), line: 3})
+		})
+
+		Convey("resuming should fail if the header has changed", func() {
+			So(ioutil.WriteFile(path, []byte("a,b\n1,2\n"), 0644), ShouldBeNil)
+			checkpoints := newCheckpointer(checkpointPath, checkpoint{File: path, Type: CSV, Start: 8, Offset: 8, Line: 2, Records: 1}, true)
+			file, err := os.Open(path)
+			So(err, ShouldBeNil)
+			defer file.Close()
+			source, _, err := checkpoints.resumeInput(file, 8)
+			So(err, ShouldBeNil)
+			r := NewCSVInputReader(nil, source, os.Stdout, 1, false)
+			So(r.ReadAndValidateHeader(), ShouldBeNil)
+			r.checkpointWith(checkpoints)
+			So(r.StreamDocument(true, make(chan bson.D, 1)), ShouldNotBeNil)
+		})
+	})
+
+	Convey("Documents whose insert fails should only be checkpointed if the import goes on", t, func() {
+		for _, stopOnError := range []bool{false, true} {
+			checkpoints := newCheckpointer("unused", checkpoint{}, false)
+			ti := newTrackingInserter(&failingInserter{}, nil, checkpoints, stopOnError)
+			for i, value := range []string{"ok", "bad", "ok"} {
+				record := sourceRecord{index: uint64(i), end: inputPosition{offset: int64(i + 1)}}
+				ti.insert(sourcedDocument{bson.D{{"v", value}}, &record})
+			}
+			So(ti.Flush(), ShouldBeNil)
+			if stopOnError {
+				So(checkpoints.state.Records, ShouldEqual, 1)
+			} else {
+				So(checkpoints.state.Records, ShouldEqual, 3)
+				So(checkpoints.state.Offset, ShouldEqual, 3)
+			}
+		}
+	})
+
+	Convey("Given a mongoimport instance", t, func() {
+		Convey("an error should be thrown for --resume without --checkpointFile", func() {
+			imp, err := NewMongoImport()
+			So(err, ShouldBeNil)
+			imp.InputOptions.File = "input.json"
+			imp.IngestOptions.Resume = true
+			So(imp.ValidateSettings([]string{}), ShouldNotBeNil)
+		})
+		Convey("an error should be thrown for --checkpointFile without --file", func() {
+			imp, err := NewMongoImport()
+			So(err, ShouldBeNil)
+			imp.IngestOptions.CheckpointFile = "checkpoint.json"
+			So(imp.ValidateSettings([]string{}), ShouldNotBeNil)
+		})
+		Convey("an error should be thrown for --resume with --drop", func() {
+			imp, err := NewMongoImport()
+			So(err, ShouldBeNil)
+			imp.InputOptions.File = "input.json"
+			imp.IngestOptions.CheckpointFile = "checkpoint.json"
+			imp.IngestOptions.Resume = true
+			imp.IngestOptions.Drop = true
+			So(imp.ValidateSettings([]string{}), ShouldNotBeNil)
+		})
+	})
+}
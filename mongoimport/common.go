@@ -58,6 +58,17 @@ type Converter interface {
 	Convert() (document bson.D, err error)
 }
 
+// sourcedConverter is a Converter that knows the input record it converts, so
+// that records which don't make a document can be checkpointed too.
+type sourcedConverter interface {
+	Converter
+	source() sourceRecord
+
+	// tagged returns whether the documents of the converter are sent with
+	// their record, for rejects or checkpoints
+	tagged() bool
+}
+
 // An importWorker reads Converter from the unprocessedDataChan channel and
 // sends processed BSON documents on the processedDocumentChan channel
 type importWorker struct {
@@ -65,10 +76,19 @@ type importWorker struct {
 	unprocessedDataChan chan Converter
 
 	// used to stream the processed document back to the caller
-	processedDocumentChan chan bson.D
+	processedDocumentChan chan sourcedDocument
 
 	// used to synchronise all worker goroutines
 	tomb *tomb.Tomb
+
+	// transform, if set, is applied to each converted document
+	transform *documentTransform
+
+	// validator, if set, checks each document once it is transformed
+	validator *documentValidator
+
+	// checkpoints, if set, is told of the records skipped by the worker
+	checkpoints *checkpointer
 }
 
 // an interface for tracking the number of bytes, which is used in mongoimport to feed
@@ -161,7 +181,7 @@ func constructUpsertDocument(upsertFields []string, document bson.D) bson.D {
 // an outputChan (output) channel. It sequentially writes unprocessed data read from
 // the input channel to each worker and then sequentially reads the processed data
 // from each worker before passing it on to the output channel
-func doSequentialStreaming(workers []*importWorker, readDocs chan Converter, outputChan chan bson.D) {
+func doSequentialStreaming(workers []*importWorker, readDocs chan Converter, outputChan chan sourcedDocument) {
 	numWorkers := len(workers)
 
 	// feed in the data to be processed and do round-robin
@@ -197,6 +217,25 @@ func doSequentialStreaming(workers []*importWorker, readDocs chan Converter, out
 	}
 }
 
+// streamWithoutRecords runs stream, which sends documents with their records,
+// and sends the documents alone on readDocs, closing it once stream is done.
+func streamWithoutRecords(readDocs chan bson.D, stream func(chan sourcedDocument) error) error {
+	sourced := make(chan sourcedDocument, workerBufferSize)
+	forwarded := make(chan struct{})
+	go func() {
+		defer close(forwarded)
+		for doc := range sourced {
+			readDocs <- doc.document
+		}
+		close(readDocs)
+	}()
+	if err := stream(sourced); err != nil {
+		return err
+	}
+	<-forwarded
+	return nil
+}
+
 // getUpsertValue takes a given BSON document and a given field, and returns the
 // field's associated value in the document. The field is specified using dot
 // notation for nested fields. e.g. "person.age" would return 34 would return
@@ -293,8 +332,11 @@ func setNestedValue(key string, value interface{}, document *bson.D) {
 // streamDocuments concurrently processes data gotten from the inputChan
 // channel in parallel and then sends over the processed data to the outputChan
 // channel - either in sequence or concurrently (depending on the value of
-// ordered) - in which the data was received
-func streamDocuments(ordered bool, numDecoders int, readDocs chan Converter, outputChan chan bson.D) (retErr error) {
+// ordered) - in which the data was received. If transform is not nil, it is
+// applied to each document after it is converted, and if validator is not nil,
+// it checks each document after that. If checkpoints is not nil, the records
+// that don't make a document are marked as done with.
+func streamDocuments(ordered bool, numDecoders int, readDocs chan Converter, outputChan chan sourcedDocument, transform *documentTransform, validator *documentValidator, checkpoints *checkpointer) (retErr error) {
 	if numDecoders == 0 {
 		numDecoders = 1
 	}
@@ -306,12 +348,15 @@ func streamDocuments(ordered bool, numDecoders int, readDocs chan Converter, out
 	for i := 0; i < numDecoders; i++ {
 		if ordered {
 			inChan = make(chan Converter, workerBufferSize)
-			outChan = make(chan bson.D, workerBufferSize)
+			outChan = make(chan sourcedDocument, workerBufferSize)
 		}
 		iw := &importWorker{
 			unprocessedDataChan:   inChan,
 			processedDocumentChan: outChan,
 			tomb: importTomb,
+			transform:             transform,
+			validator:             validator,
+			checkpoints:           checkpoints,
 		}
 		importWorkers = append(importWorkers, iw)
 		wg.Add(1)
@@ -338,10 +383,13 @@ func streamDocuments(ordered bool, numDecoders int, readDocs chan Converter, out
 }
 
 // coercionError should only be used as a specific error type to check
-// whether tokensToBSON wants the row to print
-type coercionError struct{}
+// whether tokensToBSON wants the row to print; reason says which token
+// could not be parsed
+type coercionError struct {
+	reason string
+}
 
-func (coercionError) Error() string { return "coercionError" }
+func (e coercionError) Error() string { return e.reason }
 
 // tokensToBSON reads in slice of records - along with ordered column names -
 // and returns a BSON document for the record.
@@ -366,7 +414,8 @@ func tokensToBSON(colSpecs []ColumnSpec, tokens []string, numProcessed uint64, i
 					continue
 				case pgSkipRow:
 					log.Logvf(log.Always, "skipping row #%d: %v", numProcessed, tokens)
-					return nil, coercionError{}
+					return nil, coercionError{fmt.Sprintf("could not parse token '%s' "+
+						"for column '%s' to type %s", token, colSpecs[index].Name, colSpecs[index].TypeName)}
 				case pgStop:
 					return nil, fmt.Errorf("type coercion failure in document #%d for column '%s', "+
 						"could not parse token '%s' to type %s",
@@ -444,7 +493,7 @@ func validateReaderFields(fields []string) error {
 }
 
 // processDocuments reads from the Converter channel and for each record, converts it
-// to a bson.D document before sending it on the processedDocumentChan channel. Once the
+// to a bson.D document before sending it, with its record, on the processedDocumentChan channel. Once the
 // input channel is closed the processed channel is also closed if the worker streams its
 // reads in order
 func (iw *importWorker) processDocuments(ordered bool) error {
@@ -461,12 +510,36 @@ func (iw *importWorker) processDocuments(ordered bool) error {
 			if err != nil {
 				return err
 			}
+			var record *sourceRecord
+			if sc, ok := converter.(sourcedConverter); ok && document != nil && sc.tagged() {
+				source := sc.source()
+				record = &source
+			}
+			if document != nil && iw.transform != nil {
+				if document, err = iw.transform.apply(document, record); err != nil {
+					return err
+				}
+			}
+			if document != nil && iw.validator != nil {
+				if document, err = iw.validator.validate(document, record); err != nil {
+					return err
+				}
+			}
 			if document == nil {
+				iw.skipped(converter)
 				continue
 			}
-			iw.processedDocumentChan <- document
+			iw.processedDocumentChan <- sourcedDocument{document: document, record: record}
 		case <-iw.tomb.Dying():
 			return nil
 		}
 	}
 }
+
+// skipped marks the record of a converter that didn't make a document as done
+// with, for checkpoints.
+func (iw *importWorker) skipped(converter Converter) {
+	if sc, ok := converter.(sourcedConverter); ok && iw.checkpoints != nil {
+		iw.checkpoints.complete(sc.source())
+	}
+}
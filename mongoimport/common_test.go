@@ -417,7 +417,7 @@ func TestProcessDocuments(t *testing.T) {
 		Convey("processDocuments should execute the expected conversion for documents, "+
 			"pass then on the output channel, and close the input channel if ordered is true", func() {
 			inputChannel := make(chan Converter, 100)
-			outputChannel := make(chan bson.D, 100)
+			outputChannel := make(chan sourcedDocument, 100)
 			iw := &importWorker{
 				unprocessedDataChan:   inputChannel,
 				processedDocumentChan: outputChannel,
@@ -428,10 +428,10 @@ func TestProcessDocuments(t *testing.T) {
 			close(inputChannel)
 			So(iw.processDocuments(true), ShouldBeNil)
 			doc1, open := <-outputChannel
-			So(doc1, ShouldResemble, expectedDocuments[0])
+			So(doc1.document, ShouldResemble, expectedDocuments[0])
 			So(open, ShouldEqual, true)
 			doc2, open := <-outputChannel
-			So(doc2, ShouldResemble, expectedDocuments[1])
+			So(doc2.document, ShouldResemble, expectedDocuments[1])
 			So(open, ShouldEqual, true)
 			_, open = <-outputChannel
 			So(open, ShouldEqual, false)
@@ -439,7 +439,7 @@ func TestProcessDocuments(t *testing.T) {
 		Convey("processDocuments should execute the expected conversion for documents, "+
 			"pass then on the output channel, and leave the input channel open if ordered is false", func() {
 			inputChannel := make(chan Converter, 100)
-			outputChannel := make(chan bson.D, 100)
+			outputChannel := make(chan sourcedDocument, 100)
 			iw := &importWorker{
 				unprocessedDataChan:   inputChannel,
 				processedDocumentChan: outputChannel,
@@ -450,10 +450,10 @@ func TestProcessDocuments(t *testing.T) {
 			close(inputChannel)
 			So(iw.processDocuments(false), ShouldBeNil)
 			doc1, open := <-outputChannel
-			So(doc1, ShouldResemble, expectedDocuments[0])
+			So(doc1.document, ShouldResemble, expectedDocuments[0])
 			So(open, ShouldEqual, true)
 			doc2, open := <-outputChannel
-			So(doc2, ShouldResemble, expectedDocuments[1])
+			So(doc2.document, ShouldResemble, expectedDocuments[1])
 			So(open, ShouldEqual, true)
 			// close will throw a runtime error if outputChannel is already closed
 			close(outputChannel)
@@ -464,16 +464,16 @@ func TestProcessDocuments(t *testing.T) {
 func TestDoSequentialStreaming(t *testing.T) {
 	testutil.VerifyTestType(t, testutil.UnitTestType)
 
-	Convey("Given some import workers, a Converters input channel and an bson.D output channel", t, func() {
+	Convey("Given some import workers, a Converters input channel and a sourcedDocument output channel", t, func() {
 		inputChannel := make(chan Converter, 5)
-		outputChannel := make(chan bson.D, 5)
+		outputChannel := make(chan sourcedDocument, 5)
 		workerInputChannel := []chan Converter{
 			make(chan Converter),
 			make(chan Converter),
 		}
-		workerOutputChannel := []chan bson.D{
-			make(chan bson.D),
-			make(chan bson.D),
+		workerOutputChannel := []chan sourcedDocument{
+			make(chan sourcedDocument),
+			make(chan sourcedDocument),
 		}
 		importWorkers := []*importWorker{
 			&importWorker{
@@ -499,7 +499,7 @@ func TestDoSequentialStreaming(t *testing.T) {
 			close(inputChannel)
 			doSequentialStreaming(importWorkers, inputChannel, outputChannel)
 			for _, document := range expectedDocuments {
-				So(<-outputChannel, ShouldResemble, document)
+				So((<-outputChannel).document, ShouldResemble, document)
 			}
 		})
 	})
@@ -513,7 +513,7 @@ func TestStreamDocuments(t *testing.T) {
 			3. an output channel where processed documents are streamed out`, t, func() {
 
 		inputChannel := make(chan Converter, 5)
-		outputChannel := make(chan bson.D, 5)
+		outputChannel := make(chan sourcedDocument, 5)
 
 		Convey("the entire pipeline should complete without error under normal circumstances", func() {
 			// stream in some documents
@@ -521,13 +521,26 @@ func TestStreamDocuments(t *testing.T) {
 				inputChannel <- csvConverter
 			}
 			close(inputChannel)
-			So(streamDocuments(true, 3, inputChannel, outputChannel), ShouldBeNil)
+			So(streamDocuments(true, 3, inputChannel, outputChannel, nil, nil, nil), ShouldBeNil)
 
 			// ensure documents are streamed out and processed in the correct manner
 			for _, expectedDocument := range expectedDocuments {
-				So(<-outputChannel, ShouldResemble, expectedDocument)
+				So((<-outputChannel).document, ShouldResemble, expectedDocument)
 			}
 		})
+		Convey("documents that don't match the schema of the validator should be skipped", func() {
+			for _, csvConverter := range csvConverters {
+				inputChannel <- csvConverter
+			}
+			close(inputChannel)
+			schema, err := parseSchema(`{"required": ["field1"]}`)
+			So(err, ShouldBeNil)
+			validator := &documentValidator{schema: schema, parseGrace: pgSkipRow}
+			So(streamDocuments(true, 3, inputChannel, outputChannel, nil, validator, nil), ShouldBeNil)
+			So((<-outputChannel).document, ShouldResemble, expectedDocuments[0])
+			_, alive := <-outputChannel
+			So(alive, ShouldBeFalse)
+		})
 		Convey("the entire pipeline should complete with error if an error is encountered", func() {
 			// stream in some documents - create duplicate headers to simulate an error
 			csvConverter := CSVConverter{
@@ -542,7 +555,7 @@ func TestStreamDocuments(t *testing.T) {
 			close(inputChannel)
 
 			// ensure that an error is returned on the error channel
-			So(streamDocuments(true, 3, inputChannel, outputChannel), ShouldNotBeNil)
+			So(streamDocuments(true, 3, inputChannel, outputChannel, nil, nil, nil), ShouldNotBeNil)
 		})
 	})
 }
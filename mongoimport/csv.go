@@ -20,6 +20,20 @@ type CSVInputReader struct {
 	// csvRejectWriter is where coercion-failed rows are written, if applicable
 	csvRejectWriter *gocsv.Writer
 
+	// rejects, if set, is where rejected rows are written verbatim instead
+	rejects *rejectWriter
+
+	// transform, if set, is applied to each document after it is converted
+	transform *documentTransform
+
+	// validator, if set, checks each document once it is transformed
+	validator *documentValidator
+
+	// checkpoints, if set, keeps track of the records done with, and base is
+	// added to positions in the input when resuming from a checkpoint
+	checkpoints *checkpointer
+	base        inputPosition
+
 	// csvRecord stores each line of input we read from the underlying reader
 	csvRecord []string
 
@@ -43,6 +57,11 @@ type CSVConverter struct {
 	index        uint64
 	ignoreBlanks bool
 	rejectWriter *gocsv.Writer
+	rejects      *rejectWriter
+	raw          []byte
+	line         uint64
+	tag          bool
+	end          inputPosition
 }
 
 // NewCSVInputReader returns a CSVInputReader configured to read data from the
@@ -65,6 +84,30 @@ func NewCSVInputReader(colSpecs []ColumnSpec, in io.Reader, rejects io.Writer, n
 	}
 }
 
+// keepRejects makes the reader write rejected rows verbatim to rejects.
+func (r *CSVInputReader) keepRejects(rejects *rejectWriter) {
+	r.rejects = rejects
+	r.csvReader.KeepRaw = true
+}
+
+// transformWith makes the reader's decoding workers apply transform to the
+// documents they convert.
+func (r *CSVInputReader) transformWith(transform *documentTransform) {
+	r.transform = transform
+}
+
+// validateWith makes the reader's decoding workers check the documents they
+// convert against validator, once they are transformed.
+func (r *CSVInputReader) validateWith(validator *documentValidator) {
+	r.validator = validator
+}
+
+// checkpointWith makes the reader send its documents with where their records
+// end, for checkpoints, and continue from its checkpoint when resuming.
+func (r *CSVInputReader) checkpointWith(checkpoints *checkpointer) {
+	r.checkpoints = checkpoints
+}
+
 // ReadAndValidateHeader reads the header from the underlying reader and validates
 // the header fields. It sets err if the read/validation fails.
 func (r *CSVInputReader) ReadAndValidateHeader() (err error) {
@@ -72,6 +115,7 @@ func (r *CSVInputReader) ReadAndValidateHeader() (err error) {
 	if err != nil {
 		return err
 	}
+	r.setRejectHeader()
 	r.colSpecs = ParseAutoHeaders(fields)
 	return validateReaderFields(ColumnNames(r.colSpecs))
 }
@@ -83,6 +127,7 @@ func (r *CSVInputReader) ReadAndValidateTypedHeader(parseGrace ParseGrace) (err
 	if err != nil {
 		return err
 	}
+	r.setRejectHeader()
 	r.colSpecs, err = ParseTypedHeaders(fields, parseGrace)
 	if err != nil {
 		return err
@@ -90,10 +135,38 @@ func (r *CSVInputReader) ReadAndValidateTypedHeader(parseGrace ParseGrace) (err
 	return validateReaderFields(ColumnNames(r.colSpecs))
 }
 
+// setRejectHeader copies the header line to the reject file, so that it can be
+// imported with --headerline too.
+func (r *CSVInputReader) setRejectHeader() {
+	if r.rejects != nil {
+		r.rejects.setHeader(textRecord(r.csvReader.Raw(), 0, 0).data)
+	}
+}
+
+// position returns where the last record read ends in the input.
+func (r *CSVInputReader) position() inputPosition {
+	offset, line := r.csvReader.Position()
+	return inputPosition{offset: r.base.offset + offset, line: r.base.line + uint64(line)}
+}
+
 // StreamDocument takes a boolean indicating if the documents should be streamed
 // in read order and a channel on which to stream the documents processed from
 // the underlying reader. Returns a non-nil error if streaming fails.
-func (r *CSVInputReader) StreamDocument(ordered bool, readDocs chan bson.D) (retErr error) {
+func (r *CSVInputReader) StreamDocument(ordered bool, readDocs chan bson.D) error {
+	return streamWithoutRecords(readDocs, func(sourced chan sourcedDocument) error {
+		return r.streamSourced(ordered, sourced)
+	})
+}
+
+// streamSourced is StreamDocument, sending each document with its record.
+func (r *CSVInputReader) streamSourced(ordered bool, readDocs chan sourcedDocument) (retErr error) {
+	if r.checkpoints != nil {
+		var err error
+		if r.base, r.numProcessed, err = r.checkpoints.begin(r.position()); err != nil {
+			return err
+		}
+	}
+
 	csvRecordChan := make(chan Converter, r.numDecoders)
 	csvErrChan := make(chan error)
 
@@ -112,19 +185,27 @@ func (r *CSVInputReader) StreamDocument(ordered bool, readDocs chan bson.D) (ret
 				}
 				return
 			}
-			csvRecordChan <- CSVConverter{
+			converter := CSVConverter{
 				colSpecs:     r.colSpecs,
 				data:         r.csvRecord,
 				index:        r.numProcessed,
 				ignoreBlanks: r.ignoreBlanks,
 				rejectWriter: r.csvRejectWriter,
+				rejects:      r.rejects,
+				tag:          r.rejects != nil || r.checkpoints != nil,
+				end:          r.position(),
+			}
+			if r.rejects != nil {
+				converter.raw = append([]byte{}, r.csvReader.Raw()...)
+				converter.line = r.base.line + uint64(r.csvReader.Line())
 			}
+			csvRecordChan <- converter
 			r.numProcessed++
 		}
 	}()
 
 	go func() {
-		csvErrChan <- streamDocuments(ordered, r.numDecoders, csvRecordChan, readDocs)
+		csvErrChan <- streamDocuments(ordered, r.numDecoders, csvRecordChan, readDocs, r.transform, r.validator, r.checkpoints)
 	}()
 
 	return channelQuorumError(csvErrChan, 2)
@@ -140,12 +221,29 @@ func (c CSVConverter) Convert() (b bson.D, err error) {
 		c.ignoreBlanks,
 	)
 	if _, ok := err.(coercionError); ok {
-		c.Print()
+		if c.rejects != nil {
+			c.rejects.reject(c.source(), rejectCoercion, err)
+		} else {
+			c.Print()
+		}
 		err = nil
 	}
 	return
 }
 
+// tagged returns whether the documents of the converter are sent with their
+// record.
+func (c CSVConverter) tagged() bool {
+	return c.tag
+}
+
+// source returns the record the converter converts.
+func (c CSVConverter) source() sourceRecord {
+	record := textRecord(c.raw, c.line, c.index)
+	record.end = c.end
+	return record
+}
+
 func (c CSVConverter) Print() {
 	c.rejectWriter.Write(c.data)
 }
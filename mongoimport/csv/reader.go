@@ -56,6 +56,7 @@ import (
 	"fmt"
 	"io"
 	"unicode"
+	"unicode/utf8"
 )
 
 // A ParseError is returned for parsing errors.
@@ -99,6 +100,12 @@ var (
 // non-doubled quote may appear in a quoted field.
 //
 // If TrimLeadingSpace is true, leading white space in a field is ignored.
+//
+// If KeepRaw is true, the text of each record is kept as it was in the input,
+// for Raw.
+//
+// Position reports how far into the input the records read so far go, so
+// that reading can resume there.
 type Reader struct {
 	Comma            rune // field delimiter (set to ',' by NewReader)
 	Comment          rune // comment character for start of line
@@ -106,8 +113,12 @@ type Reader struct {
 	LazyQuotes       bool // allow lazy quotes
 	TrailingComma    bool // ignored; here for backwards compatibility
 	TrimLeadingSpace bool // trim leading space
+	KeepRaw          bool // keep the text of each record
 	line             int
+	recordLine       int
+	offset           int64
 	column           int
+	raw              bytes.Buffer
 	r                *bufio.Reader
 	field            bytes.Buffer
 }
@@ -153,6 +164,24 @@ func (r *Reader) Read() (record []string, err error) {
 	return record, nil
 }
 
+// Raw returns the text of the last record read, including its line ending,
+// if KeepRaw is set. It is valid until the next call to Read.
+func (r *Reader) Raw() []byte {
+	return r.raw.Bytes()
+}
+
+// Line returns the line on which the last record read started. The first line
+// is 1.
+func (r *Reader) Line() int {
+	return r.recordLine
+}
+
+// Position returns the number of bytes and lines of input read up to the end
+// of the last record.
+func (r *Reader) Position() (offset int64, line int) {
+	return r.offset, r.line
+}
+
 // ReadAll reads all the remaining records from r.
 // Each record is a slice of fields.
 // A successful call returns err == nil, not err == EOF. Because ReadAll is
@@ -175,17 +204,26 @@ func (r *Reader) ReadAll() (records [][]string, err error) {
 // of how far into the line we have read.  r.column will point to the start
 // of this rune, not the end of this rune.
 func (r *Reader) readRune() (rune, error) {
-	r1, _, err := r.r.ReadRune()
+	r1, size, err := r.r.ReadRune()
+	r.offset += int64(size)
+	if r.KeepRaw && err == nil {
+		r.keepRaw(r1, size)
+	}
 
 	// Handle \r\n here.  We make the simplifying assumption that
 	// anytime \r is followed by \n that it can be folded to \n.
 	// We will not detect files which contain both \r\n and bare \n.
 	if r1 == '\r' {
-		r1, _, err = r.r.ReadRune()
+		r1, size, err = r.r.ReadRune()
 		if err == nil {
 			if r1 != '\n' {
 				r.r.UnreadRune()
 				r1 = '\r'
+			} else {
+				r.offset += int64(size)
+				if r.KeepRaw {
+					r.keepRaw(r1, size)
+				}
 			}
 		}
 	}
@@ -193,6 +231,19 @@ func (r *Reader) readRune() (rune, error) {
 	return r1, err
 }
 
+// keepRaw appends a rune just read to the raw record as the bytes it was read
+// from. An invalid byte is read as utf8.RuneError, so it is read again to keep
+// it as it was.
+func (r *Reader) keepRaw(r1 rune, size int) {
+	if r1 != utf8.RuneError || size != 1 {
+		r.raw.WriteRune(r1)
+		return
+	}
+	r.r.UnreadRune()
+	b, _ := r.r.ReadByte()
+	r.raw.WriteByte(b)
+}
+
 // skip reads runes up to and including the rune delim or until error.
 func (r *Reader) skip(delim rune) error {
 	for {
@@ -212,7 +263,9 @@ func (r *Reader) parseRecord() (fields []string, err error) {
 	// number (lines start at 1, not 0) and set column to -1
 	// so as we increment in readRune it points to the character we read.
 	r.line++
+	r.recordLine = r.line
 	r.column = -1
+	r.raw.Reset()
 
 	// Peek at the first rune.  If it is an error we are done.
 	// If we are support comments and it is the comment character
@@ -0,0 +1,330 @@
+package mongoimport
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/progress"
+	"github.com/mongodb/mongo-tools/common/util"
+)
+
+// metadataSuffix ends the name of the files mongodump writes the options and
+// indexes of a collection to, next to its BSON file.
+const metadataSuffix = ".metadata.json"
+
+// extensionTypes maps the extensions of the files of a --dir import to
+// their input type.
+var extensionTypes = map[string]string{
+	".csv":    CSV,
+	".tsv":    TSV,
+	".json":   JSON,
+	".jsonl":  JSON,
+	".ndjson": JSON,
+	".bson":   BSON,
+}
+
+// importFile is one of the files of a --dir import, imported by a MongoImport
+// of its own.
+type importFile struct {
+	imp      *MongoImport
+	imported uint64
+	err      error
+
+	// started is false for files never imported because another failed
+	// with --stopOnError
+	started bool
+}
+
+// typeFromExtension returns the input type of a file from its extension, or
+// the given type if it has none that mongoimport knows.
+func typeFromExtension(path, inputType string) string {
+	if fileType, ok := extensionTypes[strings.ToLower(filepath.Ext(path))]; ok {
+		return fileType
+	}
+	return inputType
+}
+
+// fileBaseName returns the name of a file without its extension.
+func fileBaseName(path string) string {
+	name := filepath.Base(path)
+	if lastDotIndex := strings.LastIndex(name, "."); lastDotIndex != -1 {
+		name = name[0:lastDotIndex]
+	}
+	return name
+}
+
+// collectionTemplateFuncs returns the functions of a --collectionTemplate for
+// a file of the given type.
+func collectionTemplateFuncs(path, inputType string) template.FuncMap {
+	return template.FuncMap{
+		"base": func() string { return fileBaseName(path) },
+		"name": func() string { return filepath.Base(path) },
+		"ext":  func() string { return strings.TrimPrefix(filepath.Ext(path), ".") },
+		"dir":  func() string { return filepath.Base(filepath.Dir(path)) },
+		"type": func() string { return inputType },
+
+		"lower":   strings.ToLower,
+		"upper":   strings.ToUpper,
+		"replace": func(old, new, s string) string { return strings.Replace(s, old, new, -1) },
+	}
+}
+
+// parseCollectionTemplate parses a --collectionTemplate.
+func parseCollectionTemplate(text string) (*template.Template, error) {
+	parsed, err := template.New("collection").Funcs(collectionTemplateFuncs("", "")).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing --collectionTemplate: %v", err)
+	}
+	return parsed, nil
+}
+
+// fileCollection returns the collection a file is imported into with
+// --collectionFromFilename, or with a --collectionTemplate if it isn't nil.
+func fileCollection(path, inputType string, collectionTemplate *template.Template) (string, error) {
+	if collectionTemplate == nil {
+		return fileBaseName(path), nil
+	}
+	fileTemplate, err := collectionTemplate.Clone()
+	if err != nil {
+		return "", err
+	}
+	out := &bytes.Buffer{}
+	err = fileTemplate.Funcs(collectionTemplateFuncs(path, inputType)).Execute(out, nil)
+	if err != nil {
+		return "", fmt.Errorf("error applying --collectionTemplate to %v: %v", path, err)
+	}
+	if out.Len() == 0 {
+		return "", fmt.Errorf("--collectionTemplate makes an empty collection name of %v", path)
+	}
+	return out.String(), nil
+}
+
+// matchDirFiles returns the paths of the files of --dir whose names match
+// --pattern, in name order. Subdirectories, hidden files and the metadata
+// files written by mongodump are left out.
+func matchDirFiles(dir, pattern string) ([]string, error) {
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("invalid --pattern %v: %v", pattern, err)
+	}
+	entries, err := ioutil.ReadDir(util.ToUniversalPath(dir))
+	if err != nil {
+		return nil, fmt.Errorf("error reading --dir: %v", err)
+	}
+	var paths []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasPrefix(name, ".") || strings.HasSuffix(name, metadataSuffix) {
+			continue
+		}
+		if matched, _ := filepath.Match(pattern, name); matched {
+			paths = append(paths, filepath.Join(dir, name))
+		}
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no files in %v match %v", dir, pattern)
+	}
+	return paths, nil
+}
+
+// validateDirSettings sets up a --dir import, once the database and type are
+// checked: every file to import gets a MongoImport with its own options,
+// whose settings are validated before any file is imported.
+func (imp *MongoImport) validateDirSettings(args []string, collectionTemplate *template.Template) error {
+	if imp.InputOptions.File != "" || len(args) != 0 {
+		return fmt.Errorf("incompatible options: --dir and --file or positional argument(s)")
+	}
+	if imp.ToolOptions.Collection == "" && !imp.InputOptions.CollectionFromFilename && collectionTemplate == nil {
+		return fmt.Errorf("--dir requires --collection, --collectionFromFilename or --collectionTemplate")
+	}
+	if imp.InputOptions.MetadataFile != "" {
+		return fmt.Errorf("can not use --metadataFile with --dir, the metadata file of each BSON file is used")
+	}
+	if imp.IngestOptions.RejectFile != "" {
+		return fmt.Errorf("can not use --rejectFile with --dir")
+	}
+	if imp.IngestOptions.CheckpointFile != "" || imp.IngestOptions.Resume {
+		return fmt.Errorf("can not use --checkpointFile or --resume with --dir")
+	}
+	if imp.InputOptions.NumParallelFiles <= 0 {
+		imp.InputOptions.NumParallelFiles = 1
+	}
+
+	paths, err := matchDirFiles(imp.InputOptions.Dir, imp.InputOptions.Pattern)
+	if err != nil {
+		return err
+	}
+	imp.files = make([]*importFile, 0, len(paths))
+	for _, path := range paths {
+		fileImp, err := imp.newFileImport(path, collectionTemplate)
+		if err != nil {
+			return err
+		}
+		imp.files = append(imp.files, &importFile{imp: fileImp})
+	}
+	log.Logvf(log.Info, "importing %v %v from %v, %v at a time", len(paths),
+		util.Pluralize(len(paths), "file", "files"), imp.InputOptions.Dir, imp.InputOptions.NumParallelFiles)
+	return nil
+}
+
+// newFileImport returns the MongoImport of a file of a --dir import, with
+// the options of the directory import for the type of the file.
+func (imp *MongoImport) newFileImport(path string, collectionTemplate *template.Template) (*MongoImport, error) {
+	toolOptions := *imp.ToolOptions
+	namespace := *imp.ToolOptions.Namespace
+	toolOptions.Namespace = &namespace
+	inputOptions := *imp.InputOptions
+	ingestOptions := *imp.IngestOptions
+
+	inputOptions.Dir, inputOptions.CollectionFromFilename, inputOptions.CollectionTemplate = "", false, ""
+	inputOptions.File = path
+	inputOptions.Type = typeFromExtension(path, imp.InputOptions.Type)
+	switch inputOptions.Type {
+	case CSV, TSV:
+		inputOptions.JSONArray = false
+	default:
+		inputOptions.HeaderLine, inputOptions.ColumnsHaveTypes = false, false
+		inputOptions.Fields, inputOptions.FieldFile = nil, nil
+		ingestOptions.IgnoreBlanks = false
+	}
+	if inputOptions.Type == BSON {
+		metadataFile := strings.TrimSuffix(path, filepath.Ext(path)) + metadataSuffix
+		if _, err := os.Stat(util.ToUniversalPath(metadataFile)); err == nil {
+			inputOptions.MetadataFile = metadataFile
+		}
+	}
+	if imp.InputOptions.CollectionFromFilename || collectionTemplate != nil {
+		collection, err := fileCollection(path, inputOptions.Type, collectionTemplate)
+		if err != nil {
+			return nil, err
+		}
+		namespace.Collection = collection
+	}
+	// collections are dropped once, before any file is imported
+	ingestOptions.Drop = false
+
+	fileImp := &MongoImport{
+		ToolOptions:     &toolOptions,
+		InputOptions:    &inputOptions,
+		IngestOptions:   &ingestOptions,
+		SessionProvider: imp.SessionProvider,
+	}
+	if err := fileImp.ValidateSettings(nil); err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	return fileImp, nil
+}
+
+// importFiles imports the files of a --dir import, --numParallelFiles at a
+// time, and prints what became of each of them. It returns the number of
+// documents imported from all of them.
+func (imp *MongoImport) importFiles() (uint64, error) {
+	if imp.IngestOptions.Drop {
+		if err := imp.dropFileCollections(); err != nil {
+			return 0, err
+		}
+	}
+
+	manager := progress.NewBarWriter(log.Writer(0), progress.DefaultWaitTime, progressBarLength, true)
+	manager.Start()
+	next := make(chan *importFile, len(imp.files))
+	for _, file := range imp.files {
+		file.imp.progressManager = manager
+		next <- file
+	}
+	close(next)
+
+	var mutex sync.Mutex
+	failed := false
+	wg := &sync.WaitGroup{}
+	for i := 0; i < imp.InputOptions.NumParallelFiles; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for file := range next {
+				mutex.Lock()
+				stop := failed && imp.IngestOptions.StopOnError
+				mutex.Unlock()
+				if stop {
+					continue
+				}
+				file.started = true
+				file.imported, file.err = file.imp.ImportDocuments()
+				if file.err != nil {
+					mutex.Lock()
+					failed = true
+					mutex.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	manager.Stop()
+	return imp.summarizeFiles()
+}
+
+// dropFileCollections drops the collections of a --dir import with --drop,
+// each once, reading their validator first with --schemaFromCollection.
+func (imp *MongoImport) dropFileCollections() error {
+	session, err := imp.SessionProvider.GetSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	dropped := map[string]bool{}
+	for _, file := range imp.files {
+		options := file.imp.ToolOptions
+		if file.imp.InputOptions.SchemaFromCollection {
+			if err = file.imp.loadCollectionSchema(session); err != nil {
+				return err
+			}
+			file.imp.InputOptions.SchemaFromCollection = false
+		}
+		if dropped[options.Collection] {
+			continue
+		}
+		dropped[options.Collection] = true
+		log.Logvf(log.Always, "dropping: %v.%v", options.DB, options.Collection)
+		if err = session.DB(options.DB).C(options.Collection).DropCollection(); err != nil {
+			if err.Error() != db.ErrNsNotFound {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// summarizeFiles prints how many documents were imported from each file of a
+// --dir import, and into which collection, or why it failed.
+func (imp *MongoImport) summarizeFiles() (uint64, error) {
+	var total uint64
+	failed := 0
+	for _, file := range imp.files {
+		options := file.imp.ToolOptions
+		total += file.imported
+		imported := fmt.Sprintf("%v %v into %v.%v", file.imported,
+			util.Pluralize(int(file.imported), "document", "documents"), options.DB, options.Collection)
+		switch {
+		case !file.started:
+			failed++
+			log.Logvf(log.Always, "%v: not imported, stopped by an earlier error", file.imp.InputOptions.File)
+		case file.err != nil:
+			failed++
+			log.Logvf(log.Always, "%v: failed after importing %v: %v", file.imp.InputOptions.File, imported, file.err)
+		default:
+			log.Logvf(log.Always, "%v: imported %v", file.imp.InputOptions.File, imported)
+		}
+	}
+	if failed > 0 {
+		return total, fmt.Errorf("%v of %v %v failed to import", failed, len(imp.files),
+			util.Pluralize(len(imp.files), "file", "files"))
+	}
+	return total, nil
+}
@@ -0,0 +1,170 @@
+package mongoimport
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCollectionTemplate(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+
+	Convey("A collection template should make the collection from the file name", t, func() {
+		for text, expected := range map[string]string{
+			"{{base}}_v2":                        "daily-sales_v2",
+			`{{base | replace "-" "_" | upper}}`: "DAILY_SALES",
+			"{{dir}}.{{name}}":                   "exports.daily-sales.csv",
+			"{{type}}_{{ext}}":                   "csv_csv",
+			"archive_{{base | lower}}":           "archive_daily-sales",
+		} {
+			collectionTemplate, err := parseCollectionTemplate(text)
+			So(err, ShouldBeNil)
+			collection, err := fileCollection(filepath.Join("exports", "daily-sales.csv"), CSV, collectionTemplate)
+			So(err, ShouldBeNil)
+			So(collection, ShouldEqual, expected)
+		}
+	})
+
+	Convey("Without a template the collection should be the file name without its extension", t, func() {
+		collection, err := fileCollection(filepath.Join("exports", "people.2016.json"), JSON, nil)
+		So(err, ShouldBeNil)
+		So(collection, ShouldEqual, "people.2016")
+	})
+
+	Convey("Invalid templates should fail", t, func() {
+		_, err := parseCollectionTemplate("{{base")
+		So(err, ShouldNotBeNil)
+		_, err = parseCollectionTemplate("{{size}}")
+		So(err, ShouldNotBeNil)
+		collectionTemplate, err := parseCollectionTemplate(`{{if eq type "bson"}}{{base}}{{end}}`)
+		So(err, ShouldBeNil)
+		_, err = fileCollection("people.csv", CSV, collectionTemplate)
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestDirImport(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+
+	Convey("With a directory of exports", t, func() {
+		dir, err := ioutil.TempDir("", "mongoimport-dir-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		for name, content := range map[string]string{
+			"people.csv":          "name,age\nann,1\n",
+			"orders.JSON":         "{\"a\": 1}\n",
+			"items.bson":          "",
+			"items.metadata.json": "{\"indexes\": []}\n",
+			"notes.txt":           "{\"b\": 2}\n",
+			".people.csv.swp":     "",
+			"archive/old.csv":     "name\nbob\n",
+		} {
+			path := filepath.Join(dir, name)
+			So(os.MkdirAll(filepath.Dir(path), 0755), ShouldBeNil)
+			So(ioutil.WriteFile(path, []byte(content), 0644), ShouldBeNil)
+		}
+		imp, err := NewMongoImport()
+		So(err, ShouldBeNil)
+		imp.ToolOptions.Collection = ""
+		imp.InputOptions.Dir = dir
+		imp.InputOptions.Pattern = "*"
+		imp.InputOptions.HeaderLine = true
+		imp.InputOptions.CollectionFromFilename = true
+
+		Convey("each file should be imported with the options for its type", func() {
+			So(imp.ValidateSettings([]string{}), ShouldBeNil)
+			So(imp.InputOptions.NumParallelFiles, ShouldEqual, 1)
+			type fileSettings struct {
+				file, fileType, collection, metadataFile string
+				headerLine                               bool
+			}
+			var settings []fileSettings
+			for _, file := range imp.files {
+				settings = append(settings, fileSettings{
+					filepath.Base(file.imp.InputOptions.File),
+					file.imp.InputOptions.Type,
+					file.imp.ToolOptions.Collection,
+					file.imp.InputOptions.MetadataFile,
+					file.imp.InputOptions.HeaderLine,
+				})
+			}
+			So(settings, ShouldResemble, []fileSettings{
+				{"items.bson", BSON, "items", filepath.Join(dir, "items.metadata.json"), false},
+				{"notes.txt", JSON, "notes", "", false},
+				{"orders.JSON", JSON, "orders", "", false},
+				{"people.csv", CSV, "people", "", true},
+			})
+			So(imp.ToolOptions.Collection, ShouldEqual, "")
+		})
+
+		Convey("only the files matching the pattern should be imported, into the templated collections", func() {
+			imp.InputOptions.Pattern = "*.csv"
+			imp.InputOptions.CollectionFromFilename = false
+			imp.InputOptions.CollectionTemplate = "{{base}}_v2"
+			imp.InputOptions.NumParallelFiles = 4
+			So(imp.ValidateSettings([]string{}), ShouldBeNil)
+			So(len(imp.files), ShouldEqual, 1)
+			So(imp.files[0].imp.ToolOptions.Collection, ShouldEqual, "people_v2")
+		})
+
+		Convey("all the files should go into --collection if it is given", func() {
+			imp.InputOptions.CollectionFromFilename = false
+			imp.ToolOptions.Collection = "everything"
+			So(imp.ValidateSettings([]string{}), ShouldBeNil)
+			for _, file := range imp.files {
+				So(file.imp.ToolOptions.Collection, ShouldEqual, "everything")
+			}
+		})
+
+		Convey("an error should be thrown for a CSV file without its fields", func() {
+			imp.InputOptions.HeaderLine = false
+			So(imp.ValidateSettings([]string{}), ShouldNotBeNil)
+		})
+
+		Convey("an error should be thrown for a pattern matching no files", func() {
+			imp.InputOptions.Pattern = "*.tsv"
+			So(imp.ValidateSettings([]string{}), ShouldNotBeNil)
+		})
+
+		Convey("an error should be thrown for an invalid pattern", func() {
+			imp.InputOptions.Pattern = "[a"
+			So(imp.ValidateSettings([]string{}), ShouldNotBeNil)
+		})
+
+		Convey("an error should be thrown for --dir with a file", func() {
+			So(imp.ValidateSettings([]string{"people.csv"}), ShouldNotBeNil)
+		})
+
+		Convey("an error should be thrown for --dir without a way to name collections", func() {
+			imp.InputOptions.CollectionFromFilename = false
+			So(imp.ValidateSettings([]string{}), ShouldNotBeNil)
+		})
+
+		Convey("an error should be thrown for --dir with --rejectFile", func() {
+			imp.IngestOptions.RejectFile = filepath.Join(dir, "rejects")
+			So(imp.ValidateSettings([]string{}), ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given a mongoimport instance", t, func() {
+		Convey("an error should be thrown for --collection with --collectionFromFilename", func() {
+			imp, err := NewMongoImport()
+			So(err, ShouldBeNil)
+			imp.InputOptions.File = "people.json"
+			imp.InputOptions.CollectionFromFilename = true
+			So(imp.ValidateSettings([]string{}), ShouldNotBeNil)
+		})
+		Convey("--collectionTemplate should name the collection of a single file too", func() {
+			imp, err := NewMongoImport()
+			So(err, ShouldBeNil)
+			imp.ToolOptions.Collection = ""
+			imp.InputOptions.CollectionTemplate = "{{base}}_v2"
+			So(imp.ValidateSettings([]string{"people.json"}), ShouldBeNil)
+			So(imp.ToolOptions.Collection, ShouldEqual, "people_v2")
+		})
+	})
+}
@@ -1,6 +1,7 @@
 package mongoimport
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
@@ -48,12 +49,36 @@ type JSONInputReader struct {
 
 	// numDecoders is the number of concurrent goroutines to use for decoding
 	numDecoders int
+
+	// rejects, if set, is where documents that can't be imported are written
+	rejects *rejectWriter
+
+	// transform, if set, is applied to each document after it is converted
+	transform *documentTransform
+
+	// validator, if set, checks each document once it is transformed
+	validator *documentValidator
+
+	// numLines is the number of lines read before the next document
+	numLines uint64
+
+	// offset is the number of bytes read before the next document
+	offset int64
+
+	// checkpoints, if set, keeps track of the records done with, and base is
+	// added to positions in the input when resuming from a checkpoint
+	checkpoints *checkpointer
+	base        inputPosition
 }
 
 // JSONConverter implements the Converter interface for JSON input.
 type JSONConverter struct {
-	data  []byte
-	index uint64
+	data    []byte
+	index   uint64
+	line    uint64
+	rejects *rejectWriter
+	tag     bool
+	end     inputPosition
 }
 
 var (
@@ -82,6 +107,30 @@ func NewJSONInputReader(isArray bool, in io.Reader, numDecoders int) *JSONInputR
 	}
 }
 
+// keepRejects makes the reader write documents that can't be imported to
+// rejects, one per line.
+func (r *JSONInputReader) keepRejects(rejects *rejectWriter) {
+	r.rejects = rejects
+}
+
+// transformWith makes the reader's decoding workers apply transform to the
+// documents they convert.
+func (r *JSONInputReader) transformWith(transform *documentTransform) {
+	r.transform = transform
+}
+
+// validateWith makes the reader's decoding workers check the documents they
+// convert against validator, once they are transformed.
+func (r *JSONInputReader) validateWith(validator *documentValidator) {
+	r.validator = validator
+}
+
+// checkpointWith makes the reader send its documents with where their records
+// end, for checkpoints, and continue from its checkpoint when resuming.
+func (r *JSONInputReader) checkpointWith(checkpoints *checkpointer) {
+	r.checkpoints = checkpoints
+}
+
 // ReadAndValidateHeader is a no-op for JSON imports; always returns nil.
 func (r *JSONInputReader) ReadAndValidateHeader() error {
 	return nil
@@ -95,7 +144,23 @@ func (r *JSONInputReader) ReadAndValidateTypedHeader(parseGrace ParseGrace) erro
 // StreamDocument takes a boolean indicating if the documents should be streamed
 // in read order and a channel on which to stream the documents processed from
 // the underlying reader. Returns a non-nil error if encountered
-func (r *JSONInputReader) StreamDocument(ordered bool, readChan chan bson.D) (retErr error) {
+func (r *JSONInputReader) StreamDocument(ordered bool, readChan chan bson.D) error {
+	return streamWithoutRecords(readChan, func(sourced chan sourcedDocument) error {
+		return r.streamSourced(ordered, sourced)
+	})
+}
+
+// streamSourced is StreamDocument, sending each document with its record.
+func (r *JSONInputReader) streamSourced(ordered bool, readChan chan sourcedDocument) (retErr error) {
+	if r.checkpoints != nil {
+		var err error
+		if r.base, r.numProcessed, err = r.checkpoints.begin(r.position()); err != nil {
+			return err
+		}
+		// an array resumed after its first document goes on with a separator
+		r.readOpeningBracket = r.numProcessed > 0
+	}
+
 	rawChan := make(chan Converter, r.numDecoders)
 	jsonErrChan := make(chan error)
 
@@ -126,9 +191,19 @@ func (r *JSONInputReader) StreamDocument(ordered bool, readChan chan bson.D) (re
 				}
 				return
 			}
+			// the document starts after the whitespace preceding it
+			document := bytes.TrimLeft(rawBytes, " \t\r\n")
+			leading := rawBytes[:len(rawBytes)-len(document)]
+			line := r.base.line + r.numLines + uint64(bytes.Count(leading, []byte("\n"))) + 1
+			r.numLines += uint64(bytes.Count(rawBytes, []byte("\n")))
+			r.offset += int64(len(rawBytes))
 			rawChan <- JSONConverter{
-				data:  rawBytes,
-				index: r.numProcessed,
+				data:    document,
+				index:   r.numProcessed,
+				line:    line,
+				rejects: r.rejects,
+				tag:     r.rejects != nil || r.checkpoints != nil,
+				end:     r.position(),
 			}
 			r.numProcessed++
 		}
@@ -136,29 +211,56 @@ func (r *JSONInputReader) StreamDocument(ordered bool, readChan chan bson.D) (re
 
 	// begin processing read bytes
 	go func() {
-		jsonErrChan <- streamDocuments(ordered, r.numDecoders, rawChan, readChan)
+		jsonErrChan <- streamDocuments(ordered, r.numDecoders, rawChan, readChan, r.transform, r.validator, r.checkpoints)
 	}()
 
 	return channelQuorumError(jsonErrChan, 2)
 }
 
+// position returns where the last document read ends in the input.
+func (r *JSONInputReader) position() inputPosition {
+	return inputPosition{offset: r.base.offset + r.offset, line: r.base.line + r.numLines}
+}
+
 // Convert implements the Converter interface for JSON input. It converts a
 // JSONConverter struct to a BSON document.
 func (c JSONConverter) Convert() (bson.D, error) {
 	document, err := json.UnmarshalBsonD(c.data)
 	if err != nil {
-		return nil, fmt.Errorf("error unmarshaling bytes on document #%v: %v", c.index, err)
+		return nil, c.reject(fmt.Errorf("error unmarshaling bytes on document #%v: %v", c.index, err))
 	}
 	log.Logvf(log.DebugHigh, "got line: %v", document)
 
 	bsonD, err := bsonutil.GetExtendedBsonD(document)
 	if err != nil {
-		return nil, fmt.Errorf("error getting extended BSON for document #%v: %v", c.index, err)
+		return nil, c.reject(fmt.Errorf("error getting extended BSON for document #%v: %v", c.index, err))
 	}
 	log.Logvf(log.DebugHigh, "got extended line: %#v", bsonD)
 	return bsonD, nil
 }
 
+// tagged returns whether the documents of the converter are sent with their
+// record.
+func (c JSONConverter) tagged() bool {
+	return c.tag
+}
+
+// source returns the record the converter converts.
+func (c JSONConverter) source() sourceRecord {
+	record := textRecord(c.data, c.line, c.index)
+	record.end = c.end
+	return record
+}
+
+// reject writes a document that could not be parsed to the reject file, if
+// any, returning the error if the import should stop.
+func (c JSONConverter) reject(err error) error {
+	if c.rejects == nil {
+		return err
+	}
+	return c.rejects.rejectParseError(c.source(), err)
+}
+
 // readJSONArraySeparator is a helper method used to process JSON arrays. It is
 // used to read any of the valid separators for a JSON array and flag invalid
 // characters.
@@ -193,6 +295,9 @@ func (r *JSONInputReader) readJSONArraySeparator() error {
 			return err
 		}
 		readByte = r.bytesFromReader[0]
+		if readByte == '\n' {
+			r.numLines++
+		}
 
 		if readByte == json.ArrayEnd {
 			// if we read the end of the JSON array, ensure we have no other
@@ -228,6 +333,7 @@ func (r *JSONInputReader) readJSONArraySeparator() error {
 				"JSON object/array in input source", string(readByte))
 		}
 	}
+	r.offset += int64(scanp)
 	// adjust the buffer to account for read bytes
 	if scanp < len(r.decoder.Buf) {
 		r.decoder.Buf = r.decoder.Buf[scanp:]
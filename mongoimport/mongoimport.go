@@ -1,4 +1,4 @@
-// Package mongoimport allows importing content from a JSON, CSV, or TSV into a MongoDB instance.
+// Package mongoimport allows importing content from a JSON, CSV, TSV or BSON file into a MongoDB instance.
 package mongoimport
 
 import (
@@ -18,6 +18,7 @@ import (
 	"strings"
 	"sync"
 	"sync/atomic"
+	"text/template"
 )
 
 // Input format types accepted by mongoimport.
@@ -25,6 +26,7 @@ const (
 	CSV  = "csv"
 	TSV  = "tsv"
 	JSON = "json"
+	BSON = "bson"
 )
 
 // Modes accepted by mongoimport.
@@ -68,6 +70,30 @@ type MongoImport struct {
 
 	// type of node the SessionProvider is connected to
 	nodeType db.NodeType
+
+	// rejects is where records that can't be imported are written, with
+	// --rejectFile
+	rejects *rejectFile
+
+	// validator checks documents against a schema before they are inserted,
+	// with --schemaFile or --schemaFromCollection
+	validator *documentValidator
+
+	// transform changes documents once they are converted, with
+	// --transformFile
+	transform *documentTransform
+
+	// checkpoints keeps track of how far into the input the import has got,
+	// with --checkpointFile
+	checkpoints *checkpointer
+
+	// files are the files of a --dir import, each imported by a MongoImport
+	// of its own
+	files []*importFile
+
+	// progressManager, if set, shows the progress of the import among that
+	// of the other files of a --dir import
+	progressManager progress.Manager
 }
 
 type InputReader interface {
@@ -109,11 +135,25 @@ func (imp *MongoImport) ValidateSettings(args []string) error {
 	} else {
 		if !(imp.InputOptions.Type == TSV ||
 			imp.InputOptions.Type == JSON ||
-			imp.InputOptions.Type == CSV) {
+			imp.InputOptions.Type == CSV ||
+			imp.InputOptions.Type == BSON) {
 			return fmt.Errorf("unknown type %v", imp.InputOptions.Type)
 		}
 	}
 
+	var collectionTemplate *template.Template
+	if imp.InputOptions.CollectionTemplate != "" {
+		if collectionTemplate, err = parseCollectionTemplate(imp.InputOptions.CollectionTemplate); err != nil {
+			return err
+		}
+	}
+	if imp.ToolOptions.Collection != "" && (imp.InputOptions.CollectionFromFilename || collectionTemplate != nil) {
+		return fmt.Errorf("incompatible options: --collection and --collectionFromFilename or --collectionTemplate")
+	}
+	if imp.InputOptions.Dir != "" {
+		return imp.validateDirSettings(args, collectionTemplate)
+	}
+
 	// ensure headers are supplied for CSV/TSV
 	if imp.InputOptions.Type == CSV ||
 		imp.InputOptions.Type == TSV {
@@ -143,24 +183,64 @@ func (imp *MongoImport) ValidateSettings(args []string) error {
 			return err
 		}
 	} else {
-		// input type is JSON
+		// input type is JSON or BSON
+		inputType := strings.ToUpper(imp.InputOptions.Type)
 		if imp.InputOptions.HeaderLine {
-			return fmt.Errorf("can not use --headerline when input type is JSON")
+			return fmt.Errorf("can not use --headerline when input type is %v", inputType)
 		}
 		if imp.InputOptions.Fields != nil {
-			return fmt.Errorf("can not use --fields when input type is JSON")
+			return fmt.Errorf("can not use --fields when input type is %v", inputType)
 		}
 		if imp.InputOptions.FieldFile != nil {
-			return fmt.Errorf("can not use --fieldFile when input type is JSON")
+			return fmt.Errorf("can not use --fieldFile when input type is %v", inputType)
 		}
 		if imp.IngestOptions.IgnoreBlanks {
-			return fmt.Errorf("can not use --ignoreBlanks when input type is JSON")
+			return fmt.Errorf("can not use --ignoreBlanks when input type is %v", inputType)
 		}
 		if imp.InputOptions.ColumnsHaveTypes {
-			return fmt.Errorf("can not use --columnsHaveTypes when input type is JSON")
+			return fmt.Errorf("can not use --columnsHaveTypes when input type is %v", inputType)
 		}
 	}
 
+	if imp.InputOptions.Type == BSON && imp.InputOptions.JSONArray {
+		return fmt.Errorf("can not use --jsonArray when input type is BSON")
+	}
+	if imp.InputOptions.MetadataFile != "" {
+		if imp.InputOptions.Type != BSON {
+			return fmt.Errorf("can not use --metadataFile unless input type is BSON")
+		}
+		if _, err := readMetadataIndexes(imp.InputOptions.MetadataFile); err != nil {
+			return err
+		}
+	}
+
+	if imp.InputOptions.SchemaFile != "" || imp.InputOptions.SchemaFromCollection {
+		if imp.InputOptions.SchemaFile != "" && imp.InputOptions.SchemaFromCollection {
+			return fmt.Errorf("incompatible options: --schemaFile and --schemaFromCollection")
+		}
+		if _, err := ValidatePG(imp.InputOptions.ParseGrace); err != nil {
+			return err
+		}
+		if imp.InputOptions.SchemaFile != "" {
+			schema, err := readSchemaFile(imp.InputOptions.SchemaFile)
+			if err != nil {
+				return err
+			}
+			imp.validator = &documentValidator{schema: schema, parseGrace: ParsePG(imp.InputOptions.ParseGrace)}
+		}
+	}
+
+	if imp.InputOptions.TransformFile != "" {
+		if _, err := ValidatePG(imp.InputOptions.ParseGrace); err != nil {
+			return err
+		}
+		transform, err := readTransformFile(imp.InputOptions.TransformFile, ParsePG(imp.InputOptions.ParseGrace))
+		if err != nil {
+			return err
+		}
+		imp.transform = transform
+	}
+
 	// deprecated
 	if imp.IngestOptions.Upsert == true {
 		imp.IngestOptions.Mode = modeUpsert
@@ -239,16 +319,34 @@ func (imp *MongoImport) ValidateSettings(args []string) error {
 		}
 	}
 
+	if imp.IngestOptions.RejectFile != "" && imp.InputOptions.File != "" &&
+		filepath.Clean(imp.IngestOptions.RejectFile) == filepath.Clean(imp.InputOptions.File) {
+		return fmt.Errorf("--rejectFile can not be the file to import")
+	}
+
+	if imp.IngestOptions.Resume && imp.IngestOptions.CheckpointFile == "" {
+		return fmt.Errorf("--resume requires --checkpointFile")
+	}
+	if imp.IngestOptions.CheckpointFile != "" {
+		if err := imp.loadCheckpoint(); err != nil {
+			return err
+		}
+	}
+
 	// ensure we have a valid string to use for the collection
-	if imp.ToolOptions.Collection == "" {
-		log.Logvf(log.Always, "no collection specified")
-		fileBaseName := filepath.Base(imp.InputOptions.File)
-		lastDotIndex := strings.LastIndex(fileBaseName, ".")
-		if lastDotIndex != -1 {
-			fileBaseName = fileBaseName[0:lastDotIndex]
+	if imp.InputOptions.CollectionFromFilename || collectionTemplate != nil {
+		if imp.InputOptions.File == "" {
+			return fmt.Errorf("--collectionFromFilename and --collectionTemplate require a file to import")
+		}
+		imp.ToolOptions.Collection, err = fileCollection(imp.InputOptions.File, imp.InputOptions.Type, collectionTemplate)
+		if err != nil {
+			return err
 		}
-		log.Logvf(log.Always, "using filename '%v' as collection", fileBaseName)
-		imp.ToolOptions.Collection = fileBaseName
+	} else if imp.ToolOptions.Collection == "" {
+		log.Logvf(log.Always, "no collection specified")
+		collection := fileBaseName(imp.InputOptions.File)
+		log.Logvf(log.Always, "using filename '%v' as collection", collection)
+		imp.ToolOptions.Collection = collection
 	}
 	err = util.ValidateCollectionName(imp.ToolOptions.Collection)
 	if err != nil {
@@ -271,6 +369,13 @@ func (imp *MongoImport) getSourceReader() (io.ReadCloser, int64, error) {
 			return nil, -1, err
 		}
 		log.Logvf(log.Info, "filesize: %v bytes", fileStat.Size())
+		if imp.checkpoints != nil && imp.checkpoints.resuming {
+			source, size, err := imp.checkpoints.resumeInput(file, fileStat.Size())
+			if err != nil {
+				file.Close()
+			}
+			return source, size, err
+		}
 		return file, int64(fileStat.Size()), err
 	}
 
@@ -294,13 +399,42 @@ func (fsp *fileSizeProgressor) Progress() (int64, int64) {
 // ImportDocuments is used to write input data to the database. It returns the
 // number of documents successfully imported to the appropriate namespace and
 // any error encountered in doing this
-func (imp *MongoImport) ImportDocuments() (uint64, error) {
+func (imp *MongoImport) ImportDocuments() (numImported uint64, retErr error) {
+	if imp.files != nil {
+		return imp.importFiles()
+	}
+
 	source, fileSize, err := imp.getSourceReader()
 	if err != nil {
 		return 0, err
 	}
 	defer source.Close()
 
+	if imp.IngestOptions.RejectFile != "" {
+		appending := imp.checkpoints != nil && imp.checkpoints.resuming
+		imp.rejects, err = newRejectFile(imp.IngestOptions.RejectFile, imp.IngestOptions.StopOnError, appending)
+		if err != nil {
+			return 0, err
+		}
+		defer func() {
+			if err := imp.rejects.Close(); err != nil && retErr == nil {
+				retErr = err
+			}
+			if count := imp.rejects.Count(); count > 0 {
+				log.Logvf(log.Always, "%v %v rejected, written to %v", count,
+					util.Pluralize(int(count), "record", "records"), imp.IngestOptions.RejectFile)
+			}
+		}()
+	}
+
+	if imp.checkpoints != nil {
+		defer func() {
+			if err := imp.checkpoints.save(); err != nil && retErr == nil {
+				retErr = err
+			}
+		}()
+	}
+
 	inputReader, err := imp.getInputReader(source)
 	if err != nil {
 		return 0, err
@@ -317,15 +451,22 @@ func (imp *MongoImport) ImportDocuments() (uint64, error) {
 		}
 	}
 
-	bar := &progress.Bar{
-		Name:      fmt.Sprintf("%v.%v", imp.ToolOptions.DB, imp.ToolOptions.Collection),
-		Watching:  &fileSizeProgressor{fileSize, inputReader},
-		Writer:    log.Writer(0),
-		BarLength: progressBarLength,
-		IsBytes:   true,
+	name := fmt.Sprintf("%v.%v", imp.ToolOptions.DB, imp.ToolOptions.Collection)
+	if imp.progressManager != nil {
+		name = fmt.Sprintf("%v (%v)", name, filepath.Base(imp.InputOptions.File))
+		imp.progressManager.Attach(name, &fileSizeProgressor{fileSize, inputReader})
+		defer imp.progressManager.Detach(name)
+	} else {
+		bar := &progress.Bar{
+			Name:      name,
+			Watching:  &fileSizeProgressor{fileSize, inputReader},
+			Writer:    log.Writer(0),
+			BarLength: progressBarLength,
+			IsBytes:   true,
+		}
+		bar.Start()
+		defer bar.Stop()
 	}
-	bar.Start()
-	defer bar.Stop()
 	return imp.importDocuments(inputReader)
 }
 
@@ -363,6 +504,25 @@ func (imp *MongoImport) importDocuments(inputReader InputReader) (numImported ui
 		return 0, fmt.Errorf("error configuring session: %v", err)
 	}
 
+	// read the validator before the collection is dropped
+	if imp.InputOptions.SchemaFromCollection {
+		if err = imp.loadCollectionSchema(session); err != nil {
+			return 0, err
+		}
+	}
+	if imp.validator != nil {
+		if imp.rejects != nil {
+			imp.validator.rejects = imp.rejects.rejectWriter
+		}
+		// the validator may only be read now, so it is given to the reader here
+		if reader, ok := inputReader.(internalInputReader); ok {
+			reader.validateWith(imp.validator)
+		}
+	}
+	if imp.transform != nil && imp.rejects != nil {
+		imp.transform.rejects = imp.rejects.rejectWriter
+	}
+
 	// drop the database if necessary
 	if imp.IngestOptions.Drop {
 		log.Logvf(log.Always, "dropping: %v.%v",
@@ -377,13 +537,13 @@ func (imp *MongoImport) importDocuments(inputReader InputReader) (numImported ui
 		}
 	}
 
-	readDocs := make(chan bson.D, workerBufferSize)
+	readDocs := make(chan sourcedDocument, workerBufferSize)
 	processingErrChan := make(chan error)
 	ordered := imp.IngestOptions.MaintainInsertionOrder
 
 	// read and process from the input reader
 	go func() {
-		processingErrChan <- inputReader.StreamDocument(ordered, readDocs)
+		processingErrChan <- streamSourced(inputReader, ordered, readDocs)
 	}()
 
 	// insert documents into the target database
@@ -393,13 +553,17 @@ func (imp *MongoImport) importDocuments(inputReader InputReader) (numImported ui
 
 	e1 := channelQuorumError(processingErrChan, 2)
 	insertionCount := atomic.LoadUint64(&imp.insertionCount)
+	if e1 == nil && imp.InputOptions.MetadataFile != "" {
+		// like mongorestore, build the indexes once the documents are in
+		e1 = imp.createMetadataIndexes(session)
+	}
 	return insertionCount, e1
 }
 
 // ingestDocuments accepts a channel from which it reads documents to be inserted
 // into the target collection. It spreads the insert/upsert workload across one
 // or more workers.
-func (imp *MongoImport) ingestDocuments(readDocs chan bson.D) (retErr error) {
+func (imp *MongoImport) ingestDocuments(readDocs chan sourcedDocument) (retErr error) {
 	numInsertionWorkers := imp.IngestOptions.NumInsertionWorkers
 	if numInsertionWorkers <= 0 {
 		numInsertionWorkers = 1
@@ -430,6 +594,22 @@ func (imp *MongoImport) ingestDocuments(readDocs chan bson.D) (retErr error) {
 	return
 }
 
+// streamSourced streams the documents of inputReader on readDocs, with their
+// records if it is one of mongoimport's readers.
+func streamSourced(inputReader InputReader, ordered bool, readDocs chan sourcedDocument) error {
+	if reader, ok := inputReader.(internalInputReader); ok {
+		return reader.streamSourced(ordered, readDocs)
+	}
+	documents := make(chan bson.D, workerBufferSize)
+	go func() {
+		for document := range documents {
+			readDocs <- sourcedDocument{document: document}
+		}
+		close(readDocs)
+	}()
+	return inputReader.StreamDocument(ordered, documents)
+}
+
 // configureSession takes in a session and modifies it with properly configured
 // settings. It does the following configurations:
 //
@@ -457,7 +637,7 @@ type flushInserter interface {
 
 // runInsertionWorker is a helper to InsertDocuments - it reads document off
 // the read channel and prepares then in batches for insertion into the databas
-func (imp *MongoImport) runInsertionWorker(readDocs chan bson.D) (err error) {
+func (imp *MongoImport) runInsertionWorker(readDocs chan sourcedDocument) (err error) {
 	session, err := imp.SessionProvider.GetSession()
 	if err != nil {
 		return fmt.Errorf("error connecting to mongod: %v", err)
@@ -468,14 +648,22 @@ func (imp *MongoImport) runInsertionWorker(readDocs chan bson.D) (err error) {
 	}
 	collection := session.DB(imp.ToolOptions.DB).C(imp.ToolOptions.Collection)
 
-	var inserter flushInserter
+	var flusher flushInserter
 	if imp.IngestOptions.Mode == modeInsert {
-		inserter = db.NewBufferedBulkInserter(collection, imp.IngestOptions.BulkBufferSize, !imp.IngestOptions.StopOnError)
+		flusher = db.NewBufferedBulkInserter(collection, imp.IngestOptions.BulkBufferSize, !imp.IngestOptions.StopOnError)
 		if !imp.IngestOptions.MaintainInsertionOrder {
-			inserter.(*db.BufferedBulkInserter).Unordered()
+			flusher.(*db.BufferedBulkInserter).Unordered()
 		}
 	} else {
-		inserter = imp.newUpserter(collection)
+		flusher = imp.newUpserter(collection)
+	}
+	var inserter documentInserter = untrackedInserter{flusher}
+	if imp.rejects != nil || imp.checkpoints != nil {
+		var rejects *rejectWriter
+		if imp.rejects != nil {
+			rejects = imp.rejects.rejectWriter
+		}
+		inserter = newTrackingInserter(flusher, rejects, imp.checkpoints, imp.IngestOptions.StopOnError)
 	}
 
 readLoop:
@@ -485,7 +673,7 @@ readLoop:
 			if !alive {
 				break readLoop
 			}
-			err = filterIngestError(imp.IngestOptions.StopOnError, inserter.Insert(document))
+			err = filterIngestError(imp.IngestOptions.StopOnError, inserter.insert(document))
 			if err != nil {
 				return err
 			}
@@ -596,11 +784,49 @@ func (imp *MongoImport) getInputReader(in io.Reader) (InputReader, error) {
 
 	out := os.Stdout
 
+	var inputReader internalInputReader
 	ignoreBlanks := imp.IngestOptions.IgnoreBlanks && imp.InputOptions.Type != JSON
-	if imp.InputOptions.Type == CSV {
-		return NewCSVInputReader(colSpecs, in, out, imp.IngestOptions.NumDecodingWorkers, ignoreBlanks), nil
+	if imp.InputOptions.Type == BSON {
+		inputReader = NewBSONInputReader(in, imp.IngestOptions.NumDecodingWorkers)
+	} else if imp.InputOptions.Type == CSV {
+		inputReader = NewCSVInputReader(colSpecs, in, out, imp.IngestOptions.NumDecodingWorkers, ignoreBlanks)
 	} else if imp.InputOptions.Type == TSV {
-		return NewTSVInputReader(colSpecs, in, out, imp.IngestOptions.NumDecodingWorkers, ignoreBlanks), nil
+		inputReader = NewTSVInputReader(colSpecs, in, out, imp.IngestOptions.NumDecodingWorkers, ignoreBlanks)
+	} else {
+		inputReader = NewJSONInputReader(imp.InputOptions.JSONArray, in, imp.IngestOptions.NumDecodingWorkers)
+	}
+	if imp.rejects != nil {
+		inputReader.keepRejects(imp.rejects.rejectWriter)
 	}
-	return NewJSONInputReader(imp.InputOptions.JSONArray, in, imp.IngestOptions.NumDecodingWorkers), nil
+	if imp.transform != nil {
+		inputReader.transformWith(imp.transform)
+	}
+	if imp.checkpoints != nil {
+		inputReader.checkpointWith(imp.checkpoints)
+	}
+	return inputReader, nil
+}
+
+// internalInputReader is an InputReader with the hooks mongoimport sets up
+// before reading.
+type internalInputReader interface {
+	InputReader
+
+	// keepRejects makes the reader write the records it rejects to rejects,
+	// and send the documents it reads with their record for insert failures.
+	keepRejects(rejects *rejectWriter)
+
+	// transformWith makes the reader apply transform to its documents.
+	transformWith(transform *documentTransform)
+
+	// validateWith makes the reader check its documents against validator,
+	// skipping those that don't match per --parseGrace.
+	validateWith(validator *documentValidator)
+
+	// checkpointWith makes the reader send its documents with where their
+	// records end, and continue from the checkpoint when resuming.
+	checkpointWith(checkpoints *checkpointer)
+
+	// streamSourced is StreamDocument, sending each document with its record.
+	streamSourced(ordered bool, read chan sourcedDocument) error
 }
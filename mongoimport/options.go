@@ -2,7 +2,7 @@ package mongoimport
 
 var Usage = `<options> <file>
 
-Import CSV, TSV or JSON data into MongoDB. If no file is provided, mongoimport reads from stdin.
+Import CSV, TSV, JSON or BSON data into MongoDB, from a file or from the files of a directory with --dir. If no file is provided, mongoimport reads from stdin.
 
 See http://docs.mongodb.org/manual/reference/program/mongoimport/ for more information.`
 
@@ -17,17 +17,44 @@ type InputOptions struct {
 	// Specifies the location and name of a file containing the data to import.
 	File string `long:"file" value-name:"<filename>" description:"file to import from; if not specified, stdin is used"`
 
+	// Dir is a directory whose files matching Pattern are each imported, in parallel with NumParallelFiles.
+	Dir string `long:"dir" value-name:"<directory>" description:"directory whose files matching --pattern to import, each with its type detected from its extension (.csv, .tsv, .json, .jsonl, .ndjson or .bson, otherwise --type); CSV and TSV options only apply to CSV and TSV files, and the indexes of a <name>.metadata.json next to a BSON file are created"`
+
+	// Pattern selects the files of Dir to import by name.
+	Pattern string `long:"pattern" value-name:"<glob>" default:"*" default-mask:"-" description:"shell pattern the names of the files in --dir must match, e.g. '*.csv' (defaults to all files)"`
+
+	// NumParallelFiles is the number of files of Dir imported at the same time.
+	NumParallelFiles int `long:"numParallelFiles" value-name:"<number>" default:"1" default-mask:"-" description:"number of files of --dir to import concurrently (defaults to 1)"`
+
+	// CollectionFromFilename imports each file into the collection named after it.
+	CollectionFromFilename bool `long:"collectionFromFilename" description:"import each file into the collection named after the file, without its extension"`
+
+	// CollectionTemplate is a text/template making the collection of each file from its name.
+	CollectionTemplate string `long:"collectionTemplate" value-name:"<template>" description:"template of the collection to import each file into, e.g. '{{base}}_v2'; base is the file name without its extension, name the file name, ext its extension, dir the name of its directory and type its input type, and lower, upper and replace change text, e.g. '{{base | replace \"-\" \"_\"}}'"`
+
 	// Treats the input source's first line as field list (csv and tsv only).
 	HeaderLine bool `long:"headerline" description:"use first line in input source as the field list (CSV and TSV only)"`
 
 	// Indicates that the underlying input source contains a single JSON array with the documents to import.
 	JSONArray bool `long:"jsonArray" description:"treat input source as a JSON array"`
 
-	// Indicates how to handle type coercion failures
-	ParseGrace string `long:"parseGrace" value-name:"<grace>" default:"stop" description:"controls behavior when type coercion fails - one of: autoCast, skipField, skipRow, stop (defaults to 'stop')"`
+	// Indicates how to handle type coercion failures, documents that don't match the schema, and failed transform rules
+	ParseGrace string `long:"parseGrace" value-name:"<grace>" default:"stop" description:"controls behavior when type coercion fails, when a document doesn't match the schema, or when a transform rule fails - one of: autoCast, skipField, skipRow, stop (defaults to 'stop')"`
+
+	// Specifies the file type to import. The default format is JSON, but it’s possible to import CSV, TSV and BSON files.
+	Type string `long:"type" value-name:"<type>" default:"json" default-mask:"-" description:"input format to import: json, csv, tsv or bson (defaults to 'json')"`
 
-	// Specifies the file type to import. The default format is JSON, but it’s possible to import CSV and TSV files.
-	Type string `long:"type" value-name:"<type>" default:"json" default-mask:"-" description:"input format to import: json, csv, or tsv (defaults to 'json')"`
+	// MetadataFile is a .metadata.json file written by mongodump, whose indexes are created after a BSON import.
+	MetadataFile string `long:"metadataFile" value-name:"<filename>" description:"mongodump .metadata.json file whose indexes to create once the documents are imported (BSON only)"`
+
+	// SchemaFile is an Extended JSON file with a $jsonSchema, or a validator document holding one.
+	SchemaFile string `long:"schemaFile" value-name:"<filename>" description:"Extended JSON file with a $jsonSchema to validate documents against before inserting them; documents that don't match are handled per --parseGrace"`
+
+	// SchemaFromCollection validates documents against the $jsonSchema of the target collection's validator.
+	SchemaFromCollection bool `long:"schemaFromCollection" description:"validate documents against the $jsonSchema validator of the target collection before inserting them, per --parseGrace"`
+
+	// TransformFile is a JSON or YAML file of rules applied in order to each document once it is converted.
+	TransformFile string `long:"transformFile" value-name:"<filename>" description:"JSON or YAML file of rules to rename, move, copy, remove, set, split, concat or cast fields, applied in order to each document before it is validated and inserted; rules that fail are handled per --parseGrace"`
 
 	// Indicates that field names include type descriptions
 	ColumnsHaveTypes bool `long:"columnsHaveTypes" description:"indicated that the field list (from --fields, --fieldsFile, or --headerline) specifies types; They must be in the form of '<colName>.<type>(<arg>)'. The type can be one of: auto, binary, bool, date, date_go, date_ms, date_oracle, double, int32, int64, string. For each of the date types, the argument is a datetime layout string. For the binary type, the argument can be one of: base32, base64, hex. All other types take an empty argument. Only valid for CSV and TSV imports. e.g. zipcode.string(), thumbnail.binary(base64)"`
@@ -55,6 +82,15 @@ type IngestOptions struct {
 	// Forces mongoimport to halt the import operation at the first insert or upsert error.
 	StopOnError bool `long:"stopOnError" description:"stop importing at first insert/upsert error"`
 
+	// Specifies a file to write the records that could not be imported to, as they were in the input.
+	RejectFile string `long:"rejectFile" value-name:"<filename>" description:"file to write the records that fail to parse, to coerce or validate with --parseGrace=skipRow, or to insert, as they were in the input; the line, stage and error of each are written to <filename>.errors. Records that fail to parse stop the import with --stopOnError"`
+
+	// CheckpointFile is where the byte offset and line of the last record imported with all those before it is kept.
+	CheckpointFile string `long:"checkpointFile" value-name:"<filename>" description:"file to periodically write the byte offset and line up to which every record has been imported to, so that a failed import can be continued with --resume (requires --file)"`
+
+	// Resume continues an import from the checkpoint in CheckpointFile.
+	Resume bool `long:"resume" description:"continue the import from the offset in --checkpointFile, if it exists; records after the checkpoint may be imported twice, unless --mode is upsert or merge"`
+
 	// Modify the import process.
 	// Always insert the documents if they are new (do NOT match --upsertFields).
 	// For existing documents (match --upsertFields) in the database:
@@ -0,0 +1,320 @@
+package mongoimport
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/json"
+	"github.com/mongodb/mongo-tools/common/util"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Stages at which an input record can be rejected.
+const (
+	rejectParse      = "parse"
+	rejectCoercion   = "coercion"
+	rejectValidation = "validation"
+	rejectTransform  = "transform"
+	rejectInsert     = "insert"
+)
+
+// rejectErrorsSuffix is appended to the path of the reject file to get the
+// path of the file describing why each record was rejected.
+const rejectErrorsSuffix = ".errors"
+
+// sourceRecord is an input record as it was read, so that it can be written
+// verbatim to the reject file.
+type sourceRecord struct {
+	data []byte
+
+	// line is the line on which the record started, or 0 for BSON input
+	line uint64
+
+	// index is the position of the record in the input, starting at 0
+	index uint64
+
+	// end is where the record ends in the input, for --checkpointFile
+	end inputPosition
+}
+
+// rejectEntry describes a rejected record in the errors file.
+type rejectEntry struct {
+	Line     uint64 `json:"line,omitempty"`
+	Document uint64 `json:"document"`
+	Stage    string `json:"stage"`
+	Error    string `json:"error"`
+}
+
+// rejectWriter writes rejected records verbatim to a reject file, in the
+// format of the input so that they can be fixed and imported again, and a
+// line of JSON for each of them to the errors file, with its source line, the
+// stage it was rejected at and the error.
+type rejectWriter struct {
+	mutex sync.Mutex
+
+	records io.Writer
+	errors  io.Writer
+
+	// header is written before the first rejected record, for CSV and TSV
+	// input with --headerline, unless wroteHeader is set
+	header      []byte
+	wroteHeader bool
+
+	// stopOnError makes records that can't be parsed stop the import after
+	// they are rejected
+	stopOnError bool
+
+	count uint64
+	err   error
+}
+
+// rejectFile is a rejectWriter writing to files, which have to be closed.
+type rejectFile struct {
+	*rejectWriter
+	files []*os.File
+}
+
+// newRejectFile creates the reject file at the given path, and its errors file.
+// When appending, as when resuming an import, rejected records are added to
+// the files if they exist.
+func newRejectFile(path string, stopOnError, appending bool) (*rejectFile, error) {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if appending {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+	records, err := os.OpenFile(util.ToUniversalPath(path), flags, 0666)
+	if err != nil {
+		return nil, fmt.Errorf("error creating reject file: %v", err)
+	}
+	errors, err := os.OpenFile(util.ToUniversalPath(path+rejectErrorsSuffix), flags, 0666)
+	if err != nil {
+		records.Close()
+		return nil, fmt.Errorf("error creating reject file: %v", err)
+	}
+	rf := &rejectFile{
+		rejectWriter: newRejectWriter(records, errors, stopOnError),
+		files:        []*os.File{records, errors},
+	}
+	// the header is already there if records were rejected before
+	if stat, err := records.Stat(); err == nil && stat.Size() > 0 {
+		rf.wroteHeader = true
+	}
+	return rf, nil
+}
+
+func newRejectWriter(records, errors io.Writer, stopOnError bool) *rejectWriter {
+	return &rejectWriter{records: records, errors: errors, stopOnError: stopOnError}
+}
+
+// Close closes the files, returning the first error writing or closing them.
+func (rf *rejectFile) Close() error {
+	err := rf.Err()
+	for _, file := range rf.files {
+		if closeErr := file.Close(); err == nil && closeErr != nil {
+			err = fmt.Errorf("error writing reject file: %v", closeErr)
+		}
+	}
+	return err
+}
+
+// setHeader sets the header line to write before the first rejected record.
+func (rw *rejectWriter) setHeader(header []byte) {
+	rw.mutex.Lock()
+	defer rw.mutex.Unlock()
+	rw.header = append([]byte{}, header...)
+}
+
+// Count returns the number of records rejected so far.
+func (rw *rejectWriter) Count() uint64 {
+	rw.mutex.Lock()
+	defer rw.mutex.Unlock()
+	return rw.count
+}
+
+// Err returns the first error writing the reject file. Errors are kept rather
+// than returned so that they don't get mistaken for errors of the record.
+func (rw *rejectWriter) Err() error {
+	rw.mutex.Lock()
+	defer rw.mutex.Unlock()
+	return rw.err
+}
+
+// reject writes a record and the reason it was rejected.
+func (rw *rejectWriter) reject(record sourceRecord, stage string, reason error) {
+	entry, err := json.Marshal(rejectEntry{
+		Line:     record.line,
+		Document: record.index + 1,
+		Stage:    stage,
+		Error:    reason.Error(),
+	})
+
+	rw.mutex.Lock()
+	defer rw.mutex.Unlock()
+	if rw.err != nil {
+		return
+	}
+	if err == nil && !rw.wroteHeader && rw.header != nil {
+		_, err = rw.records.Write(rw.header)
+		rw.wroteHeader = err == nil
+	}
+	if err == nil {
+		_, err = rw.records.Write(record.data)
+	}
+	if err == nil {
+		_, err = rw.errors.Write(append(entry, '\n'))
+	}
+	if err != nil {
+		rw.err = fmt.Errorf("error writing reject file: %v", err)
+		return
+	}
+	rw.count++
+}
+
+// rejectParseError rejects a record that could not be parsed. It returns the
+// error if the import should stop, with --stopOnError.
+func (rw *rejectWriter) rejectParseError(record sourceRecord, err error) error {
+	rw.reject(record, rejectParse, err)
+	if rw.stopOnError {
+		return err
+	}
+	return nil
+}
+
+// textRecord returns a text record that ends with a newline.
+func textRecord(data []byte, line, index uint64) sourceRecord {
+	if !bytes.HasSuffix(data, []byte("\n")) {
+		data = append(append([]byte{}, data...), '\n')
+	}
+	return sourceRecord{data: data, line: line, index: index}
+}
+
+// sourcedDocument is a document read from the input, with the record it was
+// converted from so that it can be traced back to it if inserting it fails.
+// record is nil unless the reader keeps rejects or checkpoints.
+type sourcedDocument struct {
+	document bson.D
+	record   *sourceRecord
+}
+
+// documentInserter inserts the documents read from the input.
+type documentInserter interface {
+	insert(doc sourcedDocument) error
+	Flush() error
+}
+
+// untrackedInserter inserts documents with a flushInserter, leaving out their
+// records.
+type untrackedInserter struct {
+	flushInserter
+}
+
+func (ui untrackedInserter) insert(doc sourcedDocument) error {
+	return ui.Insert(doc.document)
+}
+
+// trackingInserter wraps a flushInserter, keeping the source records of the
+// documents it buffers to reject those whose insert fails, and to checkpoint
+// the batches it flushes. Either rejects or checkpoints may be nil.
+type trackingInserter struct {
+	inserter    flushInserter
+	rejects     *rejectWriter
+	checkpoints *checkpointer
+
+	// stopOnError is whether insert errors stop the import
+	stopOnError bool
+
+	// buffered returns the number of documents the inserter has buffered
+	buffered func() int
+
+	pending []sourceRecord
+}
+
+func newTrackingInserter(inserter flushInserter, rejects *rejectWriter, checkpoints *checkpointer, stopOnError bool) *trackingInserter {
+	ti := &trackingInserter{
+		inserter:    inserter,
+		rejects:     rejects,
+		checkpoints: checkpoints,
+		stopOnError: stopOnError,
+	}
+	if bulk, ok := inserter.(*db.BufferedBulkInserter); ok {
+		ti.buffered = bulk.Buffered
+	} else {
+		ti.buffered = func() int { return 0 }
+	}
+	return ti
+}
+
+// insert inserts or buffers the document, tracking the documents of any batch
+// it flushes. A document that can't be encoded fails on its own, without
+// being buffered.
+func (ti *trackingInserter) insert(doc sourcedDocument) error {
+	var record sourceRecord
+	if doc.record != nil {
+		record = *doc.record
+	}
+	err := ti.inserter.Insert(doc.document)
+	if _, ok := err.(*db.EncodingError); ok {
+		ti.flushed([]sourceRecord{record}, err)
+		return err
+	}
+	ti.pending = append(ti.pending, record)
+	flushed := len(ti.pending) - ti.buffered()
+	ti.flushed(ti.pending[:flushed], err)
+	ti.pending = ti.pending[flushed:]
+	return err
+}
+
+// Flush inserts the buffered documents, tracking them.
+func (ti *trackingInserter) Flush() error {
+	err := ti.inserter.Flush()
+	ti.flushed(ti.pending, err)
+	ti.pending = nil
+	return err
+}
+
+// flushed rejects the records of a batch that failed to insert and
+// checkpoints those that are done with. Those that failed are done with too
+// unless the import stops because of the error.
+func (ti *trackingInserter) flushed(records []sourceRecord, err error) {
+	if err != nil && ti.rejects != nil {
+		ti.rejectFailures(records, err)
+	}
+	if ti.checkpoints == nil || len(records) == 0 {
+		return
+	}
+	if err != nil && (ti.stopOnError || isLostConnection(err)) {
+		records = records[:insertedBefore(len(records), err)]
+	}
+	ti.checkpoints.complete(records...)
+}
+
+// rejectFailures rejects the records of a batch that failed to insert. A
+// bulk error gives the position of each failed document in the batch; other
+// errors are those of the whole batch, unless the server could not be reached.
+func (ti *trackingInserter) rejectFailures(records []sourceRecord, err error) {
+	if bulkError, ok := err.(*mgo.BulkError); ok {
+		for _, failure := range bulkError.Cases() {
+			if failure.Index >= 0 && failure.Index < len(records) {
+				ti.rejects.reject(records[failure.Index], rejectInsert, failure.Err)
+			}
+		}
+		return
+	}
+	if isLostConnection(err) {
+		return
+	}
+	for _, record := range records {
+		ti.rejects.reject(record, rejectInsert, err)
+	}
+}
+
+// isLostConnection returns whether an insert failed because the server could
+// not be reached.
+func isLostConnection(err error) bool {
+	return db.IsConnectionError(err) || err.Error() == io.EOF.Error()
+}
@@ -0,0 +1,195 @@
+package mongoimport
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// readTagged reads the documents of an input reader, and the records they are
+// sent with.
+func readTagged(r internalInputReader) ([]bson.D, []sourceRecord, error) {
+	docChan := make(chan sourcedDocument, 16)
+	if err := r.streamSourced(true, docChan); err != nil {
+		return nil, nil, err
+	}
+	var documents []bson.D
+	var records []sourceRecord
+	for doc := range docChan {
+		So(doc.record, ShouldNotBeNil)
+		documents = append(documents, doc.document)
+		records = append(records, *doc.record)
+	}
+	return documents, records, nil
+}
+
+func TestRejectFile(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+
+	Convey("With a reject writer", t, func() {
+		records := &bytes.Buffer{}
+		errors := &bytes.Buffer{}
+		rejects := newRejectWriter(records, errors, false)
+
+		Convey("CSV rows skipped by --parseGrace should be written verbatim after the header", func() {
+			contents := "a.int32(),b.string()\r\n" +
+				"1,x\r\n" +
+				"oops,\"multi\nline\"\n" +
+				"2,y\n" +
+				"bad,z"
+			r := NewCSVInputReader(nil, strings.NewReader(contents), os.Stdout, 1, false)
+			r.keepRejects(rejects)
+			So(r.ReadAndValidateTypedHeader(pgSkipRow), ShouldBeNil)
+			documents, sources, err := readTagged(r)
+			So(err, ShouldBeNil)
+			So(documents, ShouldResemble, []bson.D{
+				{{"a", int32(1)}, {"b", "x"}},
+				{{"a", int32(2)}, {"b", "y"}},
+			})
+			So(sources[0].line, ShouldEqual, 2)
+			So(string(sources[1].data), ShouldEqual, "2,y\n")
+			So(sources[1].line, ShouldEqual, 5)
+
+			So(records.String(), ShouldEqual, "a.int32(),b.string()\r\noops,\"multi\nline\"\nbad,z\n")
+			So(errors.String(), ShouldEqual,
+				`{"line":3,"document":2,"stage":"coercion","error":"could not parse token 'oops' for column 'a' to type int32"}`+"\n"+
+					`{"line":6,"document":4,"stage":"coercion","error":"could not parse token 'bad' for column 'a' to type int32"}`+"\n")
+			So(rejects.Count(), ShouldEqual, 2)
+		})
+
+		Convey("CSV rows with invalid UTF-8 should be written byte for byte", func() {
+			contents := "a.int32(),b.string()\n" +
+				"oops,\xff\xfe\r\n" +
+				"1,x\n"
+			r := NewCSVInputReader(nil, strings.NewReader(contents), os.Stdout, 1, false)
+			r.keepRejects(rejects)
+			So(r.ReadAndValidateTypedHeader(pgSkipRow), ShouldBeNil)
+			_, _, err := readTagged(r)
+			So(err, ShouldBeNil)
+			So(records.String(), ShouldEqual, "a.int32(),b.string()\noops,\xff\xfe\r\n")
+		})
+
+		Convey("TSV rows should keep their line numbers", func() {
+			colSpecs := []ColumnSpec{{"a", new(FieldInt32Parser), pgSkipRow, "int32"}}
+			r := NewTSVInputReader(colSpecs, strings.NewReader("1\nx\n3\n"), os.Stdout, 1, false)
+			r.keepRejects(rejects)
+			documents, _, err := readTagged(r)
+			So(err, ShouldBeNil)
+			So(len(documents), ShouldEqual, 2)
+			So(records.String(), ShouldEqual, "x\n")
+			So(errors.String(), ShouldStartWith, `{"line":2,"document":2,"stage":"coercion"`)
+		})
+
+		Convey("JSON documents that can't be parsed should be rejected on their own line", func() {
+			contents := "{\"a\": 1}\n{\"a\":\n  {\"$date\": \"nope\"}}\n\n   {\"a\": 3}"
+			r := NewJSONInputReader(false, strings.NewReader(contents), 1)
+			r.keepRejects(rejects)
+			documents, sources, err := readTagged(r)
+			So(err, ShouldBeNil)
+			So(documents, ShouldResemble, []bson.D{{{"a", int32(1)}}, {{"a", int32(3)}}})
+			So(sources[1].line, ShouldEqual, 5)
+			So(string(sources[1].data), ShouldEqual, "{\"a\": 3}\n")
+			So(records.String(), ShouldEqual, "{\"a\":\n  {\"$date\": \"nope\"}}\n")
+			So(errors.String(), ShouldStartWith, `{"line":2,"document":2,"stage":"parse","error":"error getting extended BSON`)
+		})
+
+		Convey("lines should be counted across JSON array separators", func() {
+			contents := "[\n{\"a\": 1},\n\n{\"a\": {\"$date\": \"nope\"}}\n]"
+			r := NewJSONInputReader(true, strings.NewReader(contents), 1)
+			r.keepRejects(rejects)
+			_, _, err := readTagged(r)
+			So(err, ShouldBeNil)
+			So(records.String(), ShouldEqual, "{\"a\": {\"$date\": \"nope\"}}\n")
+			So(errors.String(), ShouldStartWith, `{"line":4,"document":2,"stage":"parse"`)
+		})
+
+		Convey("a document that can't be parsed should stop the import with --stopOnError", func() {
+			rejects.stopOnError = true
+			r := NewJSONInputReader(false, strings.NewReader(`{"a": {"$date": "nope"}}`), 1)
+			r.keepRejects(rejects)
+			_, _, err := readTagged(r)
+			So(err, ShouldNotBeNil)
+			So(rejects.Count(), ShouldEqual, 1)
+		})
+
+		Convey("BSON documents should be rejected without a line", func() {
+			raw, err := bson.Marshal(bson.D{{"a", 1}})
+			So(err, ShouldBeNil)
+			corrupt := append([]byte{}, raw...)
+			corrupt[4] = 0x7f // not a BSON type
+			r := NewBSONInputReader(bytes.NewReader(append(corrupt, raw...)), 1)
+			r.keepRejects(rejects)
+			documents, _, err := readTagged(r)
+			So(err, ShouldBeNil)
+			So(documents, ShouldResemble, []bson.D{{{"a", 1}}})
+			So(records.Bytes(), ShouldResemble, corrupt)
+			So(errors.String(), ShouldStartWith, `{"document":1,"stage":"parse"`)
+		})
+
+		Convey("documents whose insert fails should be rejected with their record", func() {
+			inserter := &failingInserter{}
+			ri := newTrackingInserter(inserter, rejects, nil, false)
+			for i, value := range []string{"ok", "bad", "ok"} {
+				record := textRecord([]byte(value), uint64(i+1), uint64(i))
+				err := ri.insert(sourcedDocument{bson.D{{"v", value}}, &record})
+				So(err != nil, ShouldEqual, value == "bad")
+			}
+			So(ri.Flush(), ShouldBeNil)
+			So(inserter.inserted, ShouldResemble, []bson.D{{{"v", "ok"}}, {{"v", "bad"}}, {{"v", "ok"}}})
+			So(records.String(), ShouldEqual, "bad\n")
+			So(errors.String(), ShouldEqual, `{"line":2,"document":2,"stage":"insert","error":"duplicate key"}`+"\n")
+		})
+	})
+
+	Convey("A document that can't be encoded should be rejected rather than a buffered one", t, func() {
+		records := &bytes.Buffer{}
+		errors := &bytes.Buffer{}
+		rejects := newRejectWriter(records, errors, false)
+		inserter := db.NewBufferedBulkInserter(&mgo.Collection{}, 10, false)
+		ti := newTrackingInserter(inserter, rejects, nil, false)
+		ok, bad := textRecord([]byte("ok"), 1, 0), textRecord([]byte("bad"), 2, 1)
+		So(ti.insert(sourcedDocument{bson.D{{"v", "ok"}}, &ok}), ShouldBeNil)
+		err := ti.insert(sourcedDocument{bson.D{{"v", make(chan int)}}, &bad})
+		So(err, ShouldNotBeNil)
+		So(records.String(), ShouldEqual, "bad\n")
+		So(errors.String(), ShouldStartWith, `{"line":2,"document":2,"stage":"insert"`)
+		So(len(ti.pending), ShouldEqual, 1)
+		So(ti.pending[0].line, ShouldEqual, 1)
+	})
+
+	Convey("Given a mongoimport instance", t, func() {
+		Convey("an error should be thrown if --rejectFile is the file to import", func() {
+			imp, err := NewMongoImport()
+			So(err, ShouldBeNil)
+			imp.InputOptions.File = "input.json"
+			imp.IngestOptions.RejectFile = "./input.json"
+			So(imp.ValidateSettings([]string{}), ShouldNotBeNil)
+		})
+	})
+}
+
+// failingInserter fails to insert documents with a "bad" value.
+type failingInserter struct {
+	inserted []bson.D
+}
+
+func (fi *failingInserter) Insert(doc interface{}) error {
+	document := doc.(bson.D)
+	fi.inserted = append(fi.inserted, document)
+	if document[0].Value == "bad" {
+		return fmt.Errorf("duplicate key")
+	}
+	return nil
+}
+
+func (fi *failingInserter) Flush() error {
+	return nil
+}
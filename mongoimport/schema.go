@@ -0,0 +1,1192 @@
+package mongoimport
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mongodb/mongo-tools/common/bsonutil"
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/json"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/util"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// jsonTypes maps the types of the JSON Schema type keyword to BSON types.
+// Like the server, the integer type is not supported: bsonType int or long
+// should be used instead.
+var jsonTypes = map[string][]string{
+	"object":  {"object"},
+	"array":   {"array"},
+	"number":  {"int", "long", "double", "decimal"},
+	"boolean": {"bool"},
+	"string":  {"string"},
+	"null":    {"null"},
+}
+
+// bsonTypes are the aliases of the bsonType keyword.
+var bsonTypes = map[string][]string{
+	"double":              {"double"},
+	"string":              {"string"},
+	"object":              {"object"},
+	"array":               {"array"},
+	"binData":             {"binData"},
+	"undefined":           {"undefined"},
+	"objectId":            {"objectId"},
+	"bool":                {"bool"},
+	"date":                {"date"},
+	"null":                {"null"},
+	"regex":               {"regex"},
+	"dbPointer":           {"dbPointer"},
+	"javascript":          {"javascript"},
+	"symbol":              {"symbol"},
+	"javascriptWithScope": {"javascriptWithScope"},
+	"int":                 {"int"},
+	"timestamp":           {"timestamp"},
+	"long":                {"long"},
+	"decimal":             {"decimal"},
+	"minKey":              {"minKey"},
+	"maxKey":              {"maxKey"},
+	"number":              {"int", "long", "double", "decimal"},
+}
+
+// jsonSchema is a compiled $jsonSchema: the subset of JSON Schema draft 4
+// that the server supports in collection validators, with its bsonType
+// keyword. Keywords the server doesn't support, like $ref or format, are
+// rejected when compiling. Like the server, pattern and patternProperties use
+// Go regular expressions rather than ECMA 262 ones.
+type jsonSchema struct {
+	rules []schemaRule
+}
+
+// schemaRule checks a value against a keyword, or a group of keywords that
+// depend on each other, adding an error for each failure.
+type schemaRule func(value interface{}, path string, errs *schemaErrors)
+
+// schemaError is a failure of a value at a field path. The path of the
+// document itself is empty.
+type schemaError struct {
+	path    string
+	message string
+
+	// types are the BSON types the value should have had, for type failures
+	types []string
+}
+
+type schemaErrors []schemaError
+
+func (errs *schemaErrors) add(path string, format string, args ...interface{}) {
+	*errs = append(*errs, schemaError{path: path, message: fmt.Sprintf(format, args...)})
+}
+
+// schemaValidationError is the error of a document that doesn't match the
+// schema, listing the failure of each field.
+type schemaValidationError schemaErrors
+
+func (e schemaValidationError) Error() string {
+	messages := make([]string, len(e))
+	for i, failure := range e {
+		path := failure.path
+		if path == "" {
+			path = "(document)"
+		}
+		messages[i] = path + ": " + failure.message
+	}
+	return strings.Join(messages, "; ")
+}
+
+// check returns the failures of a value against the schema.
+func (schema *jsonSchema) check(value interface{}, path string) schemaErrors {
+	errs := schemaErrors{}
+	for _, rule := range schema.rules {
+		rule(value, path, &errs)
+	}
+	return errs
+}
+
+// readSchemaFile reads a $jsonSchema from an Extended JSON file. The file may
+// hold the schema itself or a validator document like {"$jsonSchema": {...}}.
+func readSchemaFile(path string) (*jsonSchema, error) {
+	content, err := ioutil.ReadFile(util.ToUniversalPath(path))
+	if err != nil {
+		return nil, fmt.Errorf("error reading schema file: %v", err)
+	}
+	doc, err := json.UnmarshalBsonD(content)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing schema file %v: %v", path, err)
+	}
+	if doc, err = bsonutil.GetExtendedBsonD(doc); err != nil {
+		return nil, fmt.Errorf("extended json error in schema file %v: %v", path, err)
+	}
+	var schema *jsonSchema
+	if len(doc) == 1 && doc[0].Name == "$jsonSchema" {
+		schema, err = compileValidatorSchema(doc)
+	} else {
+		schema, err = compileSchema(doc, "")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error in schema file %v: %v", path, err)
+	}
+	return schema, nil
+}
+
+// compileValidatorSchema compiles the $jsonSchema of a collection validator.
+func compileValidatorSchema(validator bson.D) (*jsonSchema, error) {
+	value, err := bsonutil.FindValueByKey("$jsonSchema", &validator)
+	if err != nil {
+		return nil, fmt.Errorf("validator has no $jsonSchema")
+	}
+	return compileSchema(value, "")
+}
+
+// compileSchema compiles a schema found at a keyword path of the whole schema.
+func compileSchema(value interface{}, at string) (*jsonSchema, error) {
+	doc, ok := value.(bson.D)
+	if !ok {
+		return nil, schemaSyntaxError(at, "a schema must be a document")
+	}
+	keywords := doc.Map()
+	schema := &jsonSchema{}
+	for _, elem := range doc {
+		keyword := elem.Name
+		here := joinPath(at, keyword)
+		var rule schemaRule
+		var err error
+		switch keyword {
+		case "title", "description":
+			if _, ok := elem.Value.(string); !ok {
+				err = schemaSyntaxError(here, "must be a string")
+			}
+		case "type":
+			rule, err = compileType(elem.Value, here, jsonTypes)
+		case "bsonType":
+			rule, err = compileType(elem.Value, here, bsonTypes)
+		case "properties", "patternProperties", "additionalProperties":
+			// compiled together, once
+			if keyword == firstKeyword(doc, "properties", "patternProperties", "additionalProperties") {
+				rule, err = compileProperties(keywords, at)
+			}
+		case "required":
+			rule, err = compileRequired(elem.Value, here)
+		case "minProperties", "maxProperties":
+			rule, err = compileCount(keyword, elem.Value, here)
+		case "dependencies":
+			rule, err = compileDependencies(elem.Value, here)
+		case "items", "additionalItems":
+			if keyword == firstKeyword(doc, "items", "additionalItems") {
+				rule, err = compileItems(keywords, at)
+			}
+		case "minItems", "maxItems", "minLength", "maxLength":
+			rule, err = compileCount(keyword, elem.Value, here)
+		case "uniqueItems":
+			rule, err = compileUniqueItems(elem.Value, here)
+		case "minimum", "maximum":
+			rule, err = compileBound(keyword, keywords, here)
+		case "exclusiveMinimum", "exclusiveMaximum":
+			bound := "m" + keyword[len("exclusiveM"):]
+			if _, ok := elem.Value.(bool); !ok {
+				err = schemaSyntaxError(here, "must be a boolean")
+			} else if _, ok := keywords[bound]; !ok {
+				err = schemaSyntaxError(here, "needs %v", bound)
+			}
+		case "multipleOf":
+			rule, err = compileMultipleOf(elem.Value, here)
+		case "pattern":
+			rule, err = compilePattern(elem.Value, here)
+		case "enum":
+			rule, err = compileEnum(elem.Value, here)
+		case "allOf", "anyOf", "oneOf":
+			rule, err = compileCombination(keyword, elem.Value, here)
+		case "not":
+			rule, err = compileNot(elem.Value, here)
+		default:
+			err = schemaSyntaxError(here, "unsupported keyword")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if rule != nil {
+			schema.rules = append(schema.rules, rule)
+		}
+	}
+	return schema, nil
+}
+
+func schemaSyntaxError(at string, format string, args ...interface{}) error {
+	if at == "" {
+		return fmt.Errorf("invalid $jsonSchema: "+format, args...)
+	}
+	return fmt.Errorf("invalid $jsonSchema at %v: "+format, append([]interface{}{at}, args...)...)
+}
+
+// firstKeyword returns which of the keywords comes first in the schema.
+func firstKeyword(doc bson.D, keywords ...string) string {
+	for _, elem := range doc {
+		if util.StringSliceContains(keywords, elem.Name) {
+			return elem.Name
+		}
+	}
+	return ""
+}
+
+func joinPath(path, field string) string {
+	if path == "" {
+		return field
+	}
+	return path + "." + field
+}
+
+func compileType(value interface{}, at string, aliases map[string][]string) (schemaRule, error) {
+	var names []string
+	switch v := value.(type) {
+	case string:
+		names = []string{v}
+	case []interface{}:
+		for _, name := range v {
+			s, ok := name.(string)
+			if !ok {
+				return nil, schemaSyntaxError(at, "types must be strings")
+			}
+			names = append(names, s)
+		}
+	default:
+		return nil, schemaSyntaxError(at, "must be a string or an array of strings")
+	}
+	if len(names) == 0 {
+		return nil, schemaSyntaxError(at, "must not be empty")
+	}
+	var allowed []string
+	for _, name := range names {
+		types, ok := aliases[name]
+		if !ok {
+			if name == "integer" {
+				return nil, schemaSyntaxError(at, "type integer is not supported, use bsonType int or long")
+			}
+			return nil, schemaSyntaxError(at, "unknown type %v", name)
+		}
+		allowed = append(allowed, types...)
+	}
+	expected := strings.Join(names, " or ")
+	return func(value interface{}, path string, errs *schemaErrors) {
+		actual := bsonTypeOf(value)
+		if !util.StringSliceContains(allowed, actual) {
+			*errs = append(*errs, schemaError{
+				path:    path,
+				message: fmt.Sprintf("has type %v, expected %v", actual, expected),
+				types:   allowed,
+			})
+		}
+	}, nil
+}
+
+// compileProperties compiles the properties, patternProperties and
+// additionalProperties keywords, since which fields are additional depends on
+// the other two.
+func compileProperties(keywords bson.M, at string) (schemaRule, error) {
+	properties := map[string]*jsonSchema{}
+	if value, ok := keywords["properties"]; ok {
+		doc, ok := value.(bson.D)
+		if !ok {
+			return nil, schemaSyntaxError(joinPath(at, "properties"), "must be a document")
+		}
+		for _, elem := range doc {
+			schema, err := compileSchema(elem.Value, joinPath(at, "properties."+elem.Name))
+			if err != nil {
+				return nil, err
+			}
+			properties[elem.Name] = schema
+		}
+	}
+
+	type patternSchema struct {
+		pattern *regexp.Regexp
+		schema  *jsonSchema
+	}
+	var patterns []patternSchema
+	if value, ok := keywords["patternProperties"]; ok {
+		doc, ok := value.(bson.D)
+		if !ok {
+			return nil, schemaSyntaxError(joinPath(at, "patternProperties"), "must be a document")
+		}
+		for _, elem := range doc {
+			pattern, err := regexp.Compile(elem.Name)
+			if err != nil {
+				return nil, schemaSyntaxError(joinPath(at, "patternProperties"), "invalid pattern %v: %v", elem.Name, err)
+			}
+			schema, err := compileSchema(elem.Value, joinPath(at, "patternProperties."+elem.Name))
+			if err != nil {
+				return nil, err
+			}
+			patterns = append(patterns, patternSchema{pattern, schema})
+		}
+	}
+
+	additionalAllowed := true
+	var additional *jsonSchema
+	if value, ok := keywords["additionalProperties"]; ok {
+		switch v := value.(type) {
+		case bool:
+			additionalAllowed = v
+		case bson.D:
+			schema, err := compileSchema(v, joinPath(at, "additionalProperties"))
+			if err != nil {
+				return nil, err
+			}
+			additional = schema
+		default:
+			return nil, schemaSyntaxError(joinPath(at, "additionalProperties"), "must be a boolean or a document")
+		}
+	}
+
+	return func(value interface{}, path string, errs *schemaErrors) {
+		fields, ok := documentFields(value)
+		if !ok {
+			return
+		}
+		for _, field := range fields {
+			fieldPath := joinPath(path, field.Name)
+			schema, matched := properties[field.Name]
+			if matched {
+				*errs = append(*errs, schema.check(field.Value, fieldPath)...)
+			}
+			for _, p := range patterns {
+				if p.pattern.MatchString(field.Name) {
+					matched = true
+					*errs = append(*errs, p.schema.check(field.Value, fieldPath)...)
+				}
+			}
+			if matched {
+				continue
+			}
+			if !additionalAllowed {
+				errs.add(fieldPath, "is not allowed by additionalProperties")
+			} else if additional != nil {
+				*errs = append(*errs, additional.check(field.Value, fieldPath)...)
+			}
+		}
+	}, nil
+}
+
+// stringArray returns the strings of a non-empty array of unique strings.
+func stringArray(value interface{}, at string) ([]string, error) {
+	values, ok := value.([]interface{})
+	if !ok || len(values) == 0 {
+		return nil, schemaSyntaxError(at, "must be a non-empty array of strings")
+	}
+	var strs []string
+	for _, v := range values {
+		s, ok := v.(string)
+		if !ok {
+			return nil, schemaSyntaxError(at, "must be a non-empty array of strings")
+		}
+		if util.StringSliceContains(strs, s) {
+			return nil, schemaSyntaxError(at, "has %v twice", s)
+		}
+		strs = append(strs, s)
+	}
+	return strs, nil
+}
+
+func compileRequired(value interface{}, at string) (schemaRule, error) {
+	required, err := stringArray(value, at)
+	if err != nil {
+		return nil, err
+	}
+	return func(value interface{}, path string, errs *schemaErrors) {
+		fields, ok := documentFields(value)
+		if !ok {
+			return
+		}
+		for _, name := range required {
+			if !hasField(fields, name) {
+				errs.add(joinPath(path, name), "is required")
+			}
+		}
+	}, nil
+}
+
+func compileDependencies(value interface{}, at string) (schemaRule, error) {
+	doc, ok := value.(bson.D)
+	if !ok {
+		return nil, schemaSyntaxError(at, "must be a document")
+	}
+	type dependency struct {
+		field    string
+		required []string
+		schema   *jsonSchema
+	}
+	var dependencies []dependency
+	for _, elem := range doc {
+		here := joinPath(at, elem.Name)
+		d := dependency{field: elem.Name}
+		var err error
+		if _, isArray := elem.Value.([]interface{}); isArray {
+			d.required, err = stringArray(elem.Value, here)
+		} else {
+			d.schema, err = compileSchema(elem.Value, here)
+		}
+		if err != nil {
+			return nil, err
+		}
+		dependencies = append(dependencies, d)
+	}
+	return func(value interface{}, path string, errs *schemaErrors) {
+		fields, ok := documentFields(value)
+		if !ok {
+			return
+		}
+		for _, d := range dependencies {
+			if !hasField(fields, d.field) {
+				continue
+			}
+			for _, name := range d.required {
+				if !hasField(fields, name) {
+					errs.add(joinPath(path, name), "is required by field %v", d.field)
+				}
+			}
+			if d.schema != nil {
+				*errs = append(*errs, d.schema.check(value, path)...)
+			}
+		}
+	}, nil
+}
+
+// compileItems compiles the items and additionalItems keywords.
+func compileItems(keywords bson.M, at string) (schemaRule, error) {
+	var all *jsonSchema
+	var positional []*jsonSchema
+	if value, ok := keywords["items"]; ok {
+		here := joinPath(at, "items")
+		switch v := value.(type) {
+		case []interface{}:
+			for i, item := range v {
+				schema, err := compileSchema(item, joinPath(here, strconv.Itoa(i)))
+				if err != nil {
+					return nil, err
+				}
+				positional = append(positional, schema)
+			}
+		default:
+			schema, err := compileSchema(v, here)
+			if err != nil {
+				return nil, err
+			}
+			all = schema
+		}
+	}
+
+	additionalAllowed := true
+	var additional *jsonSchema
+	if value, ok := keywords["additionalItems"]; ok && positional != nil {
+		switch v := value.(type) {
+		case bool:
+			additionalAllowed = v
+		case bson.D:
+			schema, err := compileSchema(v, joinPath(at, "additionalItems"))
+			if err != nil {
+				return nil, err
+			}
+			additional = schema
+		default:
+			return nil, schemaSyntaxError(joinPath(at, "additionalItems"), "must be a boolean or a document")
+		}
+	}
+
+	return func(value interface{}, path string, errs *schemaErrors) {
+		items, ok := value.([]interface{})
+		if !ok {
+			return
+		}
+		for i, item := range items {
+			itemPath := joinPath(path, strconv.Itoa(i))
+			switch {
+			case all != nil:
+				*errs = append(*errs, all.check(item, itemPath)...)
+			case i < len(positional):
+				*errs = append(*errs, positional[i].check(item, itemPath)...)
+			case !additionalAllowed:
+				errs.add(itemPath, "is not allowed by additionalItems")
+			case additional != nil:
+				*errs = append(*errs, additional.check(item, itemPath)...)
+			}
+		}
+	}, nil
+}
+
+// compileCount compiles the keywords limiting the number of fields, items or
+// characters of a value.
+func compileCount(keyword string, value interface{}, at string) (schemaRule, error) {
+	limit, ok := numberValue(value)
+	if !ok || limit < 0 || limit != math.Trunc(limit) {
+		return nil, schemaSyntaxError(at, "must be a non-negative integer")
+	}
+	isMin := strings.HasPrefix(keyword, "min")
+	return func(value interface{}, path string, errs *schemaErrors) {
+		var count int
+		var what string
+		switch keyword {
+		case "minProperties", "maxProperties":
+			fields, ok := documentFields(value)
+			if !ok {
+				return
+			}
+			count, what = len(fields), "fields"
+		case "minItems", "maxItems":
+			items, ok := value.([]interface{})
+			if !ok {
+				return
+			}
+			count, what = len(items), "items"
+		default:
+			s, ok := value.(string)
+			if !ok {
+				return
+			}
+			count, what = utf8.RuneCountInString(s), "characters"
+		}
+		if isMin && float64(count) < limit {
+			errs.add(path, "has %v %v, expected at least %v", count, what, limit)
+		} else if !isMin && float64(count) > limit {
+			errs.add(path, "has %v %v, expected at most %v", count, what, limit)
+		}
+	}, nil
+}
+
+func compileUniqueItems(value interface{}, at string) (schemaRule, error) {
+	unique, ok := value.(bool)
+	if !ok {
+		return nil, schemaSyntaxError(at, "must be a boolean")
+	}
+	if !unique {
+		return nil, nil
+	}
+	return func(value interface{}, path string, errs *schemaErrors) {
+		items, ok := value.([]interface{})
+		if !ok {
+			return
+		}
+		for i := range items {
+			for j := i + 1; j < len(items); j++ {
+				if valuesEqual(items[i], items[j]) {
+					errs.add(path, "has duplicate items at %v and %v", i, j)
+					return
+				}
+			}
+		}
+	}, nil
+}
+
+// compileBound compiles minimum or maximum, with the exclusive keyword that
+// goes with it.
+func compileBound(keyword string, keywords bson.M, at string) (schemaRule, error) {
+	bound := keywords[keyword]
+	if _, ok := numberValue(bound); !ok {
+		return nil, schemaSyntaxError(at, "must be a number")
+	}
+	exclusiveKeyword := "exclusiveM" + keyword[1:]
+	exclusive, _ := keywords[exclusiveKeyword].(bool)
+	isMin := keyword == "minimum"
+	return func(value interface{}, path string, errs *schemaErrors) {
+		c, ok := compareNumbers(value, bound)
+		if !ok {
+			return
+		}
+		n, b := formatNumberValue(value), formatNumberValue(bound)
+		switch {
+		case isMin && exclusive && c <= 0:
+			errs.add(path, "%v is not greater than %v", n, b)
+		case isMin && c < 0:
+			errs.add(path, "%v is less than the minimum %v", n, b)
+		case !isMin && exclusive && c >= 0:
+			errs.add(path, "%v is not less than %v", n, b)
+		case !isMin && c > 0:
+			errs.add(path, "%v is greater than the maximum %v", n, b)
+		}
+	}, nil
+}
+
+func compileMultipleOf(value interface{}, at string) (schemaRule, error) {
+	divisor, ok := numberValue(value)
+	if !ok || divisor <= 0 {
+		return nil, schemaSyntaxError(at, "must be a positive number")
+	}
+	return func(value interface{}, path string, errs *schemaErrors) {
+		n, ok := numberValue(value)
+		if !ok {
+			return
+		}
+		if quotient := n / divisor; quotient != math.Trunc(quotient) {
+			errs.add(path, "%v is not a multiple of %v", formatNumber(n), formatNumber(divisor))
+		}
+	}, nil
+}
+
+func compilePattern(value interface{}, at string) (schemaRule, error) {
+	s, ok := value.(string)
+	if !ok {
+		return nil, schemaSyntaxError(at, "must be a string")
+	}
+	pattern, err := regexp.Compile(s)
+	if err != nil {
+		return nil, schemaSyntaxError(at, "%v", err)
+	}
+	return func(value interface{}, path string, errs *schemaErrors) {
+		if s, ok := value.(string); ok && !pattern.MatchString(s) {
+			errs.add(path, "does not match the pattern %v", pattern)
+		}
+	}, nil
+}
+
+func compileEnum(value interface{}, at string) (schemaRule, error) {
+	values, ok := value.([]interface{})
+	if !ok || len(values) == 0 {
+		return nil, schemaSyntaxError(at, "must be a non-empty array")
+	}
+	return func(value interface{}, path string, errs *schemaErrors) {
+		for _, v := range values {
+			if valuesEqual(value, v) {
+				return
+			}
+		}
+		errs.add(path, "is not one of the enum values")
+	}, nil
+}
+
+func compileCombination(keyword string, value interface{}, at string) (schemaRule, error) {
+	values, ok := value.([]interface{})
+	if !ok || len(values) == 0 {
+		return nil, schemaSyntaxError(at, "must be a non-empty array of schemas")
+	}
+	var schemas []*jsonSchema
+	for i, v := range values {
+		schema, err := compileSchema(v, joinPath(at, strconv.Itoa(i)))
+		if err != nil {
+			return nil, err
+		}
+		schemas = append(schemas, schema)
+	}
+	return func(value interface{}, path string, errs *schemaErrors) {
+		matches := 0
+		for _, schema := range schemas {
+			failures := schema.check(value, path)
+			if keyword == "allOf" {
+				*errs = append(*errs, failures...)
+			} else if len(failures) == 0 {
+				matches++
+			}
+		}
+		switch {
+		case keyword == "anyOf" && matches == 0:
+			errs.add(path, "does not match any schema of anyOf")
+		case keyword == "oneOf" && matches != 1:
+			errs.add(path, "matches %v schemas of oneOf, expected exactly one", matches)
+		}
+	}, nil
+}
+
+func compileNot(value interface{}, at string) (schemaRule, error) {
+	schema, err := compileSchema(value, at)
+	if err != nil {
+		return nil, err
+	}
+	return func(value interface{}, path string, errs *schemaErrors) {
+		if len(schema.check(value, path)) == 0 {
+			errs.add(path, "matches the schema of not")
+		}
+	}, nil
+}
+
+// bsonTypeOf returns the bsonType alias of a value as decoded by mongoimport.
+func bsonTypeOf(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case float64:
+		return "double"
+	case string:
+		return "string"
+	case bson.D, *bson.D, bson.M, map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case []byte, bson.Binary:
+		return "binData"
+	case bson.ObjectId:
+		return "objectId"
+	case bool:
+		return "bool"
+	case time.Time:
+		return "date"
+	case bson.RegEx:
+		return "regex"
+	case bson.DBPointer:
+		return "dbPointer"
+	case bson.JavaScript:
+		if v.Scope != nil {
+			return "javascriptWithScope"
+		}
+		return "javascript"
+	case bson.Symbol:
+		return "symbol"
+	case int32:
+		return "int"
+	case int:
+		// like mgo, store ints as int32 when they fit
+		if int64(v) == int64(int32(v)) {
+			return "int"
+		}
+		return "long"
+	case bson.MongoTimestamp:
+		return "timestamp"
+	case int64:
+		return "long"
+	case bson.Decimal128:
+		return "decimal"
+	}
+	switch value {
+	case bson.MinKey:
+		return "minKey"
+	case bson.MaxKey:
+		return "maxKey"
+	case bson.Undefined:
+		return "undefined"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+// documentFields returns the fields of a document, sorting those of maps.
+func documentFields(value interface{}) ([]bson.DocElem, bool) {
+	switch v := value.(type) {
+	case bson.D:
+		return v, true
+	case *bson.D:
+		return *v, true
+	case bson.M:
+		return sortedFields(v), true
+	case map[string]interface{}:
+		return sortedFields(v), true
+	}
+	return nil, false
+}
+
+func sortedFields(m map[string]interface{}) []bson.DocElem {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fields := make([]bson.DocElem, len(names))
+	for i, name := range names {
+		fields[i] = bson.DocElem{Name: name, Value: m[name]}
+	}
+	return fields
+}
+
+func hasField(fields []bson.DocElem, name string) bool {
+	for _, field := range fields {
+		if field.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// numberValue returns the value of a number as a float64.
+func numberValue(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	case bson.Decimal128:
+		f, err := strconv.ParseFloat(v.String(), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// integerValue returns the value of an integer as an int64.
+func integerValue(value interface{}) (int64, bool) {
+	switch v := value.(type) {
+	case int:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	}
+	return 0, false
+}
+
+// compareNumbers returns -1, 0 or 1 as the number a is less than, equal to or
+// greater than the number b. Integers are compared exactly, even beyond the 53
+// bits of a float64. It returns false if either isn't a number, or is NaN.
+func compareNumbers(a, b interface{}) (int, bool) {
+	x, aIsInteger := integerValue(a)
+	y, bIsInteger := integerValue(b)
+	if aIsInteger && bIsInteger {
+		return compareIntegers(x, y), true
+	}
+	f, aIsNumber := numberValue(a)
+	g, bIsNumber := numberValue(b)
+	if !aIsNumber || !bIsNumber || math.IsNaN(f) || math.IsNaN(g) {
+		return 0, false
+	}
+	switch {
+	case aIsInteger:
+		return compareIntegerFloat(x, g), true
+	case bIsInteger:
+		return -compareIntegerFloat(y, f), true
+	case f < g:
+		return -1, true
+	case f > g:
+		return 1, true
+	}
+	return 0, true
+}
+
+func compareIntegers(x, y int64) int {
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+// compareIntegerFloat compares an integer with a float exactly, comparing the
+// integer part of the float as an integer.
+func compareIntegerFloat(x int64, f float64) int {
+	switch {
+	case f >= math.MaxInt64:
+		return -1
+	case f < math.MinInt64:
+		return 1
+	}
+	whole := math.Trunc(f)
+	if c := compareIntegers(x, int64(whole)); c != 0 {
+		return c
+	}
+	switch {
+	case f > whole:
+		return -1
+	case f < whole:
+		return 1
+	}
+	return 0
+}
+
+func formatNumber(n float64) string {
+	return strconv.FormatFloat(n, 'g', -1, 64)
+}
+
+// formatNumberValue formats a number, writing integers in full.
+func formatNumberValue(value interface{}) string {
+	if i, ok := integerValue(value); ok {
+		return strconv.FormatInt(i, 10)
+	}
+	n, _ := numberValue(value)
+	return formatNumber(n)
+}
+
+// valuesEqual compares values like the server does for enum and uniqueItems:
+// numbers of any type are equal if their values are, and the fields of
+// documents are compared in order.
+func valuesEqual(a, b interface{}) bool {
+	if _, ok := numberValue(a); ok {
+		c, ok := compareNumbers(a, b)
+		return ok && c == 0
+	}
+	if fieldsA, ok := documentFields(a); ok {
+		fieldsB, ok := documentFields(b)
+		if !ok || len(fieldsA) != len(fieldsB) {
+			return false
+		}
+		for i := range fieldsA {
+			if fieldsA[i].Name != fieldsB[i].Name || !valuesEqual(fieldsA[i].Value, fieldsB[i].Value) {
+				return false
+			}
+		}
+		return true
+	}
+	if itemsA, ok := a.([]interface{}); ok {
+		itemsB, ok := b.([]interface{})
+		if !ok || len(itemsA) != len(itemsB) {
+			return false
+		}
+		for i := range itemsA {
+			if !valuesEqual(itemsA[i], itemsB[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// documentValidator checks the documents to insert against a schema,
+// handling those that don't match per --parseGrace: autoCast converts values
+// of the wrong type where it can, skipField removes the fields that don't
+// match, skipRow skips the document and stop stops the import. Documents that
+// still don't match after autoCast or skipField are skipped, and written to
+// the reject file if there is one.
+type documentValidator struct {
+	schema     *jsonSchema
+	parseGrace ParseGrace
+
+	// rejects, if set, is where skipped documents are written
+	rejects *rejectWriter
+}
+
+// validate returns the document to insert, or nil if it is skipped. record,
+// if not nil, is the input record of the document.
+func (dv *documentValidator) validate(document bson.D, record *sourceRecord) (bson.D, error) {
+	errs := dv.schema.check(document, "")
+	if len(errs) == 0 {
+		return document, nil
+	}
+
+	description := "document"
+	if record != nil {
+		description = fmt.Sprintf("document #%v", record.index+1)
+		if record.line != 0 {
+			description += fmt.Sprintf(" (line %v)", record.line)
+		}
+	}
+	switch dv.parseGrace {
+	case pgAutoCast:
+		casts := map[string]interface{}{}
+		for _, failure := range errs {
+			if failure.types == nil {
+				continue
+			}
+			if value, found := valueAtPath(document, failure.path); found {
+				if cast, ok := castValue(value, failure.types); ok {
+					casts[failure.path] = cast
+				}
+			}
+		}
+		fixed := rewriteValue(document, "", casts).(bson.D)
+		remaining := dv.schema.check(fixed, "")
+		if len(remaining) == 0 {
+			return fixed, nil
+		}
+		errs = remaining
+	case pgSkipField:
+		removed := map[string]interface{}{}
+		for _, failure := range errs {
+			if failure.path != "" {
+				removed[failure.path] = removedValue{}
+			}
+		}
+		fixed := rewriteValue(document, "", removed).(bson.D)
+		if remaining := dv.schema.check(fixed, ""); len(remaining) == 0 {
+			log.Logvf(log.Info, "removed fields of %v that don't match the schema: %v",
+				description, schemaValidationError(errs))
+			return fixed, nil
+		}
+	case pgStop:
+		return nil, fmt.Errorf("%v does not match the schema: %v", description, schemaValidationError(errs))
+	}
+
+	log.Logvf(log.Always, "skipping %v, which does not match the schema: %v", description, schemaValidationError(errs))
+	if dv.rejects != nil && record != nil {
+		dv.rejects.reject(*record, rejectValidation, schemaValidationError(errs))
+	}
+	return nil, nil
+}
+
+// removedValue marks the fields and items rewriteValue removes.
+type removedValue struct{}
+
+// valueAtPath returns the value at a path of the failures of a document.
+func valueAtPath(value interface{}, path string) (interface{}, bool) {
+	if path == "" {
+		return value, true
+	}
+	for _, part := range strings.Split(path, ".") {
+		if fields, ok := documentFields(value); ok {
+			found := false
+			for _, field := range fields {
+				if field.Name == part {
+					value, found = field.Value, true
+					break
+				}
+			}
+			if !found {
+				return nil, false
+			}
+		} else if items, ok := value.([]interface{}); ok {
+			index, err := strconv.Atoi(part)
+			if err != nil || index < 0 || index >= len(items) {
+				return nil, false
+			}
+			value = items[index]
+		} else {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// rewriteValue returns a copy of a value in which the fields and items at the
+// paths of edits are replaced by their new value, or removed. Values that
+// aren't edited are shared with the original.
+func rewriteValue(value interface{}, path string, edits map[string]interface{}) interface{} {
+	if edit, ok := edits[path]; ok {
+		return edit
+	}
+	switch v := value.(type) {
+	case bson.D:
+		return rewriteFields(v, path, edits)
+	case *bson.D:
+		fields := rewriteFields(*v, path, edits)
+		return &fields
+	case bson.M, map[string]interface{}:
+		fields, _ := documentFields(v)
+		doc := map[string]interface{}{}
+		for _, field := range rewriteFields(fields, path, edits) {
+			doc[field.Name] = field.Value
+		}
+		if _, isM := v.(bson.M); isM {
+			return bson.M(doc)
+		}
+		return doc
+	case []interface{}:
+		items := make([]interface{}, 0, len(v))
+		for i, item := range v {
+			item = rewriteValue(item, joinPath(path, strconv.Itoa(i)), edits)
+			if _, removed := item.(removedValue); !removed {
+				items = append(items, item)
+			}
+		}
+		return items
+	}
+	return value
+}
+
+func rewriteFields(fields []bson.DocElem, path string, edits map[string]interface{}) bson.D {
+	doc := make(bson.D, 0, len(fields))
+	for _, field := range fields {
+		field.Value = rewriteValue(field.Value, joinPath(path, field.Name), edits)
+		if _, removed := field.Value.(removedValue); !removed {
+			doc = append(doc, field)
+		}
+	}
+	return doc
+}
+
+// castValue converts a value to the first of the BSON types it can be
+// converted to, like autoCast does for typed CSV and TSV columns.
+func castValue(value interface{}, types []string) (interface{}, bool) {
+	text, isString := value.(string)
+	n, isNumber := numberValue(value)
+	for _, bsonType := range types {
+		switch bsonType {
+		case "string":
+			if isNumber {
+				return formatNumber(n), true
+			}
+			if b, ok := value.(bool); ok {
+				return strconv.FormatBool(b), true
+			}
+			if id, ok := value.(bson.ObjectId); ok {
+				return id.Hex(), true
+			}
+		case "int":
+			if isString {
+				if i, err := strconv.ParseInt(strings.TrimSpace(text), 10, 32); err == nil {
+					return int32(i), true
+				}
+			} else if isNumber && n == math.Trunc(n) && n >= math.MinInt32 && n <= math.MaxInt32 {
+				return int32(n), true
+			}
+		case "long":
+			if isString {
+				if i, err := strconv.ParseInt(strings.TrimSpace(text), 10, 64); err == nil {
+					return i, true
+				}
+			} else if isNumber && n == math.Trunc(n) && math.Abs(n) < 1<<63 {
+				return int64(n), true
+			}
+		case "double":
+			if isString {
+				if f, err := strconv.ParseFloat(strings.TrimSpace(text), 64); err == nil {
+					return f, true
+				}
+			} else if isNumber {
+				return n, true
+			}
+		case "decimal":
+			if isString {
+				if d, err := bson.ParseDecimal128(strings.TrimSpace(text)); err == nil {
+					return d, true
+				}
+			} else if isNumber {
+				if d, err := bson.ParseDecimal128(formatNumber(n)); err == nil {
+					return d, true
+				}
+			}
+		case "bool":
+			if isString {
+				if b, err := strconv.ParseBool(strings.TrimSpace(text)); err == nil {
+					return b, true
+				}
+			}
+		case "date":
+			if isString {
+				if t, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(text)); err == nil {
+					return t, true
+				}
+			}
+		case "objectId":
+			if isString && bson.IsObjectIdHex(text) {
+				return bson.ObjectIdHex(text), true
+			}
+		}
+	}
+	return nil, false
+}
+
+// loadCollectionSchema reads the $jsonSchema of the validator of the target
+// collection, for --schemaFromCollection.
+func (imp *MongoImport) loadCollectionSchema(session *mgo.Session) error {
+	collection := session.DB(imp.ToolOptions.DB).C(imp.ToolOptions.Collection)
+	options, err := db.GetCollectionOptions(collection)
+	if err != nil {
+		return fmt.Errorf("error reading the validator of %v: %v", collection.FullName, err)
+	}
+	var validator bson.D
+	if options != nil {
+		if value, err := bsonutil.FindValueByKey("validator", options); err == nil {
+			validator, _ = value.(bson.D)
+		}
+	}
+	if validator == nil {
+		return fmt.Errorf("collection %v has no validator", collection.FullName)
+	}
+	schema, err := compileValidatorSchema(validator)
+	if err != nil {
+		return fmt.Errorf("error in the validator of %v: %v", collection.FullName, err)
+	}
+	if len(validator) > 1 {
+		log.Logvf(log.Always, "only the $jsonSchema of the validator of %v is checked", collection.FullName)
+	}
+	log.Logvf(log.Info, "validating documents with the $jsonSchema of %v", collection.FullName)
+	imp.validator = &documentValidator{schema: schema, parseGrace: ParsePG(imp.InputOptions.ParseGrace)}
+	return nil
+}
@@ -0,0 +1,291 @@
+package mongoimport
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/bsonutil"
+	"github.com/mongodb/mongo-tools/common/json"
+	"github.com/mongodb/mongo-tools/common/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// parseSchema compiles a schema written in Extended JSON.
+func parseSchema(text string) (*jsonSchema, error) {
+	doc, err := json.UnmarshalBsonD([]byte(text))
+	So(err, ShouldBeNil)
+	doc, err = bsonutil.GetExtendedBsonD(doc)
+	So(err, ShouldBeNil)
+	return compileSchema(doc, "")
+}
+
+const personSchema = `{
+	"bsonType": "object",
+	"required": ["name", "age"],
+	"properties": {
+		"name": {"bsonType": "string", "minLength": 2},
+		"age": {"bsonType": "int", "minimum": 0, "maximum": 150},
+		"email": {"type": "string", "pattern": "^[^@]+@[^@]+$"},
+		"tags": {"bsonType": "array", "items": {"bsonType": "string"}, "uniqueItems": true},
+		"address": {
+			"bsonType": "object",
+			"required": ["city"],
+			"additionalProperties": false,
+			"properties": {
+				"city": {"bsonType": "string"},
+				"zip": {"bsonType": ["string", "null"]}
+			}
+		},
+		"status": {"enum": ["active", "inactive", 1]}
+	}
+}`
+
+func TestJSONSchema(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+
+	Convey("With a compiled schema", t, func() {
+		schema, err := parseSchema(personSchema)
+		So(err, ShouldBeNil)
+
+		Convey("a matching document should have no failures", func() {
+			document := bson.D{
+				{"name", "Ann"},
+				{"age", int32(30)},
+				{"email", "ann@example.com"},
+				{"tags", []interface{}{"a", "b"}},
+				{"address", bson.D{{"city", "Cork"}, {"zip", nil}}},
+				{"status", float64(1)},
+			}
+			So(schema.check(document, ""), ShouldBeEmpty)
+		})
+
+		Convey("failures should give the path of each field", func() {
+			document := bson.D{
+				{"name", "A"},
+				{"age", "thirty"},
+				{"email", "nope"},
+				{"tags", []interface{}{"a", 2, "a"}},
+				{"address", &bson.D{{"zip", int32(12345)}, {"country", "IE"}}},
+				{"status", "gone"},
+			}
+			So(schemaValidationError(schema.check(document, "")).Error(), ShouldEqual,
+				"name: has 1 characters, expected at least 2; "+
+					"age: has type string, expected int; "+
+					"email: does not match the pattern ^[^@]+@[^@]+$; "+
+					"tags.1: has type int, expected string; "+
+					"tags: has duplicate items at 0 and 2; "+
+					"address.city: is required; "+
+					"address.zip: has type int, expected string or null; "+
+					"address.country: is not allowed by additionalProperties; "+
+					"status: is not one of the enum values")
+		})
+
+		Convey("missing required fields should be reported at the document level", func() {
+			errs := schema.check(bson.D{}, "")
+			So(schemaValidationError(errs).Error(), ShouldEqual, "name: is required; age: is required")
+		})
+	})
+
+	Convey("Combinations, bounds and dependencies should be checked", t, func() {
+		schema, err := parseSchema(`{
+			"properties": {
+				"n": {"bsonType": "number", "minimum": 1, "exclusiveMinimum": true, "multipleOf": 0.5},
+				"v": {"oneOf": [{"bsonType": "string"}, {"bsonType": "int"}]},
+				"w": {"not": {"bsonType": "null"}},
+				"x": {"anyOf": [{"bsonType": "date"}, {"bsonType": "objectId"}]}
+			},
+			"dependencies": {"card": ["billing"]},
+			"maxProperties": 3
+		}`)
+		So(err, ShouldBeNil)
+		So(schema.check(bson.D{{"n", int64(2)}, {"v", 1}}, ""), ShouldBeEmpty)
+		So(schemaValidationError(schema.check(bson.D{
+			{"n", 1.25}, {"v", 1.5}, {"w", nil}, {"x", "today"}, {"card", "1234"},
+		}, "")).Error(), ShouldEqual,
+			"n: 1.25 is not a multiple of 0.5; "+
+				"v: matches 0 schemas of oneOf, expected exactly one; "+
+				"w: matches the schema of not; "+
+				"x: does not match any schema of anyOf; "+
+				"billing: is required by field card; "+
+				"(document): has 5 fields, expected at most 3")
+		So(len(schema.check(bson.D{{"n", 1}}, "")), ShouldEqual, 1)
+	})
+
+	Convey("Longs beyond the precision of a double should be compared exactly", t, func() {
+		schema, err := parseSchema(`{
+			"properties": {
+				"n": {"maximum": {"$numberLong": "9007199254740992"}},
+				"e": {"enum": [{"$numberLong": "9007199254740993"}]}
+			}
+		}`)
+		So(err, ShouldBeNil)
+		So(schema.check(bson.D{{"n", int64(9007199254740992)}}, ""), ShouldBeEmpty)
+		So(schemaValidationError(schema.check(bson.D{{"n", int64(9007199254740993)}}, "")).Error(), ShouldEqual,
+			"n: 9007199254740993 is greater than the maximum 9007199254740992")
+		So(schema.check(bson.D{{"e", int64(9007199254740993)}}, ""), ShouldBeEmpty)
+		So(len(schema.check(bson.D{{"e", int64(9007199254740992)}}, "")), ShouldEqual, 1)
+
+		for _, c := range []struct {
+			a, b     interface{}
+			expected int
+		}{
+			{int64(9007199254740993), 9007199254740992.0, 1},
+			{int64(3), 2.5, 1},
+			{int64(-3), -2.5, -1},
+			{2.5, int32(3), -1},
+			{int64(math.MaxInt64), 1e19, -1},
+		} {
+			compared, ok := compareNumbers(c.a, c.b)
+			So(ok, ShouldBeTrue)
+			So(compared, ShouldEqual, c.expected)
+		}
+	})
+
+	Convey("Schemas the server would not accept should fail to compile", t, func() {
+		_, err := parseSchema(`{"properties": {"a": {"$ref": "#/definitions/a"}}}`)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "properties.a.$ref: unsupported keyword")
+		_, err = parseSchema(`{"type": "integer"}`)
+		So(err, ShouldNotBeNil)
+		_, err = parseSchema(`{"bsonType": "int128"}`)
+		So(err, ShouldNotBeNil)
+		_, err = parseSchema(`{"exclusiveMaximum": true}`)
+		So(err, ShouldNotBeNil)
+		_, err = parseSchema(`{"minLength": -1}`)
+		So(err, ShouldNotBeNil)
+		_, err = parseSchema(`{"required": []}`)
+		So(err, ShouldNotBeNil)
+		_, err = parseSchema(`{"pattern": "("}`)
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("A schema file may hold a validator document", t, func() {
+		dir, err := ioutil.TempDir("", "mongoimport-schema-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "schema.json")
+		validator := `{"$jsonSchema": {"properties": {"n": {"bsonType": "long", "maximum": {"$numberLong": "5"}}}}}`
+		So(ioutil.WriteFile(path, []byte(validator), 0644), ShouldBeNil)
+		schema, err := readSchemaFile(path)
+		So(err, ShouldBeNil)
+		So(schema.check(bson.D{{"n", int64(5)}}, ""), ShouldBeEmpty)
+		So(len(schema.check(bson.D{{"n", int64(6)}}, "")), ShouldEqual, 1)
+	})
+}
+
+func TestDocumentValidator(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+
+	Convey("With a document validator", t, func() {
+		schema, err := parseSchema(personSchema)
+		So(err, ShouldBeNil)
+		validator := &documentValidator{schema: schema}
+		document := bson.D{
+			{"name", "Ann"},
+			{"age", "30"},
+			{"address", bson.D{{"city", "Cork"}, {"country", "IE"}}},
+			{"tags", []interface{}{"a", 2}},
+		}
+
+		Convey("stop should fail the import with the failures", func() {
+			validator.parseGrace = pgStop
+			_, err := validator.validate(document, nil)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldStartWith, "document does not match the schema: age: has type string")
+		})
+
+		Convey("autoCast should convert values to the type of the schema", func() {
+			validator.parseGrace = pgAutoCast
+			validated, err := validator.validate(bson.D{{"name", "Ann"}, {"age", "30"}, {"tags", []interface{}{"a", 2}}}, nil)
+			So(err, ShouldBeNil)
+			So(validated, ShouldResemble, bson.D{{"name", "Ann"}, {"age", int32(30)}, {"tags", []interface{}{"a", "2"}}})
+		})
+
+		Convey("autoCast should skip documents it can't fix", func() {
+			validator.parseGrace = pgAutoCast
+			validated, err := validator.validate(document, nil)
+			So(err, ShouldBeNil)
+			So(validated, ShouldBeNil)
+		})
+
+		Convey("autoCast should reject documents it can't fix with their record", func() {
+			records := &bytes.Buffer{}
+			errors := &bytes.Buffer{}
+			validator.rejects = newRejectWriter(records, errors, false)
+			validator.parseGrace = pgAutoCast
+			record := textRecord([]byte(`{"name": "Ann", "age": "thirty"}`), 4, 3)
+			validated, err := validator.validate(bson.D{{"name", "Ann"}, {"age", "thirty"}}, &record)
+			So(err, ShouldBeNil)
+			So(validated, ShouldBeNil)
+			So(records.String(), ShouldEqual, `{"name": "Ann", "age": "thirty"}`+"\n")
+			So(errors.String(), ShouldStartWith, `{"line":4,"document":4,"stage":"validation"`)
+		})
+
+		Convey("skipField should remove the fields that don't match", func() {
+			validator.parseGrace = pgSkipField
+			validated, err := validator.validate(bson.D{
+				{"name", "Ann"},
+				{"age", int32(30)},
+				{"address", bson.D{{"city", "Cork"}, {"country", "IE"}}},
+				{"tags", []interface{}{"a", 2, "b"}},
+			}, nil)
+			So(err, ShouldBeNil)
+			So(validated, ShouldResemble, bson.D{
+				{"name", "Ann"},
+				{"age", int32(30)},
+				{"address", bson.D{{"city", "Cork"}}},
+				{"tags", []interface{}{"a", "b"}},
+			})
+		})
+
+		Convey("skipField should skip documents missing required fields", func() {
+			validator.parseGrace = pgSkipField
+			validated, err := validator.validate(bson.D{{"name", "Ann"}}, nil)
+			So(err, ShouldBeNil)
+			So(validated, ShouldBeNil)
+		})
+
+		Convey("skipRow should skip the document and write its record to the reject file", func() {
+			records := &bytes.Buffer{}
+			errors := &bytes.Buffer{}
+			validator.rejects = newRejectWriter(records, errors, false)
+			validator.parseGrace = pgSkipRow
+			record := textRecord([]byte(`{"name": "Ann", "age": "30"}`), 7, 6)
+			validated, err := validator.validate(bson.D{{"name", "Ann"}, {"age", "30"}}, &record)
+			So(err, ShouldBeNil)
+			So(validated, ShouldBeNil)
+			So(records.String(), ShouldEqual, `{"name": "Ann", "age": "30"}`+"\n")
+			So(errors.String(), ShouldEqual,
+				`{"line":7,"document":7,"stage":"validation","error":"age: has type string, expected int"}`+"\n")
+
+			Convey("and return documents that match as they are", func() {
+				validated, err := validator.validate(bson.D{{"name", "Ann"}, {"age", 30}}, &record)
+				So(err, ShouldBeNil)
+				So(validated, ShouldResemble, bson.D{{"name", "Ann"}, {"age", 30}})
+				So(records.String(), ShouldEqual, `{"name": "Ann", "age": "30"}`+"\n")
+			})
+		})
+	})
+
+	Convey("Given a mongoimport instance", t, func() {
+		Convey("an error should be thrown for --schemaFile with --schemaFromCollection", func() {
+			imp, err := NewMongoImport()
+			So(err, ShouldBeNil)
+			imp.InputOptions.SchemaFile = "schema.json"
+			imp.InputOptions.SchemaFromCollection = true
+			So(imp.ValidateSettings([]string{}), ShouldNotBeNil)
+		})
+		Convey("an error should be thrown for an invalid --parseGrace with a schema", func() {
+			imp, err := NewMongoImport()
+			So(err, ShouldBeNil)
+			imp.InputOptions.SchemaFromCollection = true
+			imp.InputOptions.ParseGrace = "sometimes"
+			So(imp.ValidateSettings([]string{}), ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,527 @@
+package mongoimport
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/bsonutil"
+	"github.com/mongodb/mongo-tools/common/json"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/util"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// transformNow is the value of a set rule that sets a field to the time the
+// import started, like the server's $$NOW.
+const transformNow = "$$NOW"
+
+// documentTransform applies the ordered rules of a --transformFile to the
+// documents converted by the import workers. A rule that fails on a document,
+// like a cast of a value that doesn't parse, is handled per --parseGrace:
+// autoCast leaves the field as it was, skipField removes it, skipRow skips the
+// document and stop stops the import.
+type documentTransform struct {
+	rules      []transformRule
+	parseGrace ParseGrace
+
+	// now is the time the import started, for $$NOW
+	now time.Time
+
+	// rejects, if set, is where skipped documents are written
+	rejects *rejectWriter
+}
+
+// transformRule is a compiled rule. field is the field the rule fails on.
+type transformRule struct {
+	description string
+	field       string
+	apply       func(document bson.D, now time.Time) (bson.D, error)
+}
+
+// transformRuleKeys are the keys each rule operation accepts, besides op.
+var transformRuleKeys = map[string][]string{
+	"rename": {"field", "to"},
+	"move":   {"field", "to"},
+	"copy":   {"field", "to"},
+	"remove": {"field"},
+	"set":    {"field", "value"},
+	"split":  {"field", "separator", "to"},
+	"concat": {"fields", "separator", "to"},
+	"cast":   {"field", "type"},
+}
+
+// readTransformFile reads the rules of a transform file. Files named .yaml or
+// .yml are read as YAML, others as Extended JSON. Either way, the file holds
+// a document whose rules field is the array of rules, like
+//
+//	{"rules": [
+//		{"op": "rename", "field": "lname", "to": "lastName"},
+//		{"op": "move", "field": "zip", "to": "address.zip"},
+//		{"op": "copy", "field": "email", "to": "contact.email"},
+//		{"op": "remove", "field": "tmp"},
+//		{"op": "set", "field": "importedAt", "value": "$$NOW"},
+//		{"op": "split", "field": "name", "separator": ", ", "to": ["lastName", "firstName"]},
+//		{"op": "concat", "fields": ["firstName", "lastName"], "separator": " ", "to": "fullName"},
+//		{"op": "cast", "field": "address.zip", "type": "string()"}
+//	]}
+//
+// A rename keeps the field where it is in the document, and a move or copy
+// creates the subdocuments of its target. A split without "to" replaces the
+// string with the array of its parts. Cast types are those of
+// --columnsHaveTypes, like int32() or date_go(2006-01-02).
+func readTransformFile(path string, parseGrace ParseGrace) (*documentTransform, error) {
+	content, err := ioutil.ReadFile(util.ToUniversalPath(path))
+	if err != nil {
+		return nil, fmt.Errorf("error reading transform file: %v", err)
+	}
+	var doc bson.D
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		doc, err = parseYAMLDocument(content)
+	default:
+		doc, err = json.UnmarshalBsonD(content)
+	}
+	if err == nil {
+		doc, err = bsonutil.GetExtendedBsonD(doc)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error parsing transform file %v: %v", path, err)
+	}
+	transform, err := compileTransform(doc, parseGrace)
+	if err != nil {
+		return nil, fmt.Errorf("error in transform file %v: %v", path, err)
+	}
+	return transform, nil
+}
+
+func compileTransform(doc bson.D, parseGrace ParseGrace) (*documentTransform, error) {
+	transform := &documentTransform{parseGrace: parseGrace, now: time.Now()}
+	var rules []interface{}
+	for _, elem := range doc {
+		if elem.Name != "rules" {
+			return nil, fmt.Errorf("unknown field %v", elem.Name)
+		}
+		var ok bool
+		if rules, ok = elem.Value.([]interface{}); !ok {
+			return nil, fmt.Errorf("rules must be an array")
+		}
+	}
+	if len(rules) == 0 {
+		return nil, fmt.Errorf("no rules")
+	}
+	for i, value := range rules {
+		ruleDoc, ok := value.(bson.D)
+		if !ok {
+			return nil, fmt.Errorf("rule %v must be a document", i+1)
+		}
+		rule, err := compileTransformRule(ruleDoc)
+		if err != nil {
+			return nil, fmt.Errorf("rule %v: %v", i+1, err)
+		}
+		rule.description = fmt.Sprintf("rule %v (%v)", i+1, rule.description)
+		transform.rules = append(transform.rules, rule)
+	}
+	return transform, nil
+}
+
+func compileTransformRule(doc bson.D) (transformRule, error) {
+	params := doc.Map()
+	op, _ := params["op"].(string)
+	keys, ok := transformRuleKeys[op]
+	if !ok {
+		return transformRule{}, fmt.Errorf("unknown op %v", params["op"])
+	}
+	for _, elem := range doc {
+		if elem.Name != "op" && !util.StringSliceContains(keys, elem.Name) {
+			return transformRule{}, fmt.Errorf("unknown field %v for %v", elem.Name, op)
+		}
+	}
+
+	var field, to string
+	var err error
+	if op != "concat" {
+		if field, err = fieldParam(params, "field"); err != nil {
+			return transformRule{}, err
+		}
+	}
+	if util.StringSliceContains([]string{"rename", "move", "copy", "concat"}, op) {
+		if to, err = fieldParam(params, "to"); err != nil {
+			return transformRule{}, err
+		}
+	}
+	rule := transformRule{description: op + " " + field, field: field}
+
+	switch op {
+	case "rename":
+		if strings.Contains(to, ".") {
+			return transformRule{}, fmt.Errorf("to must be a field name, use move for %v", to)
+		}
+		rule.description += " to " + to
+		rule.apply = func(document bson.D, _ time.Time) (bson.D, error) {
+			return renamePath(document, field, to)
+		}
+	case "move":
+		rule.description += " to " + to
+		rule.apply = func(document bson.D, _ time.Time) (bson.D, error) {
+			document, value, found := removePath(document, field)
+			if !found {
+				return document, nil
+			}
+			return setPath(document, to, value)
+		}
+	case "copy":
+		rule.description += " to " + to
+		rule.apply = func(document bson.D, _ time.Time) (bson.D, error) {
+			value, found := getPath(document, field)
+			if !found {
+				return document, nil
+			}
+			return setPath(document, to, copyValue(value))
+		}
+	case "remove":
+		rule.apply = func(document bson.D, _ time.Time) (bson.D, error) {
+			document, _, _ = removePath(document, field)
+			return document, nil
+		}
+	case "set":
+		value, ok := params["value"]
+		if !ok {
+			return transformRule{}, fmt.Errorf("set needs a value")
+		}
+		rule.apply = func(document bson.D, now time.Time) (bson.D, error) {
+			if value == transformNow {
+				return setPath(document, field, now)
+			}
+			return setPath(document, field, copyValue(value))
+		}
+	case "split":
+		rule, err = compileSplit(rule, params)
+	case "concat":
+		rule, err = compileConcat(rule, params, to)
+	case "cast":
+		rule, err = compileCast(rule, params)
+	}
+	return rule, err
+}
+
+// fieldParam returns a parameter naming a field.
+func fieldParam(params bson.M, name string) (string, error) {
+	field, ok := params[name].(string)
+	if !ok || field == "" {
+		return "", fmt.Errorf("%v must be a field name", name)
+	}
+	if err := validateFields([]string{field}); err != nil {
+		return "", err
+	}
+	return field, nil
+}
+
+func compileSplit(rule transformRule, params bson.M) (transformRule, error) {
+	separator, ok := params["separator"].(string)
+	if !ok || separator == "" {
+		return rule, fmt.Errorf("separator must be a non-empty string")
+	}
+	var targets []string
+	if value, ok := params["to"]; ok {
+		values, ok := value.([]interface{})
+		if !ok || len(values) == 0 {
+			return rule, fmt.Errorf("to must be a non-empty array of field names")
+		}
+		for i := range values {
+			target, err := fieldParam(bson.M{"to": values[i]}, "to")
+			if err != nil {
+				return rule, err
+			}
+			targets = append(targets, target)
+		}
+		if err := validateFields(targets); err != nil {
+			return rule, err
+		}
+	}
+	field := rule.field
+	rule.apply = func(document bson.D, _ time.Time) (bson.D, error) {
+		value, found := getPath(document, field)
+		if !found || value == nil {
+			return document, nil
+		}
+		s, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("%v is not a string", field)
+		}
+		if targets == nil {
+			parts := []interface{}{}
+			for _, part := range strings.Split(s, separator) {
+				parts = append(parts, part)
+			}
+			return setPath(document, field, parts)
+		}
+		// the last field gets the rest of the string
+		var err error
+		for i, part := range strings.SplitN(s, separator, len(targets)) {
+			if document, err = setPath(document, targets[i], part); err != nil {
+				return nil, err
+			}
+		}
+		return document, nil
+	}
+	return rule, nil
+}
+
+func compileConcat(rule transformRule, params bson.M, to string) (transformRule, error) {
+	values, ok := params["fields"].([]interface{})
+	if !ok || len(values) == 0 {
+		return rule, fmt.Errorf("fields must be a non-empty array of field names")
+	}
+	var fields []string
+	for _, value := range values {
+		field, err := fieldParam(bson.M{"fields": value}, "fields")
+		if err != nil {
+			return rule, err
+		}
+		fields = append(fields, field)
+	}
+	separator, _ := params["separator"].(string)
+	if _, ok := params["separator"]; ok && params["separator"] != separator {
+		return rule, fmt.Errorf("separator must be a string")
+	}
+	rule.field = to
+	rule.description = fmt.Sprintf("concat %v to %v", strings.Join(fields, ","), to)
+	rule.apply = func(document bson.D, _ time.Time) (bson.D, error) {
+		var parts []string
+		for _, field := range fields {
+			// missing and null fields are left out
+			if value, found := getPath(document, field); found && value != nil {
+				parts = append(parts, transformText(value))
+			}
+		}
+		if parts == nil {
+			return document, nil
+		}
+		return setPath(document, to, strings.Join(parts, separator))
+	}
+	return rule, nil
+}
+
+func compileCast(rule transformRule, params bson.M) (transformRule, error) {
+	typeSpec, ok := params["type"].(string)
+	if !ok || typeSpec == "" {
+		return rule, fmt.Errorf("type must be a type like int32() or date_go(2006-01-02)")
+	}
+	if !strings.HasSuffix(typeSpec, ")") {
+		typeSpec += "()"
+	}
+	// the same types as typed columns
+	spec, err := ParseTypedHeader(rule.field+"."+typeSpec, pgStop)
+	if err != nil {
+		return rule, err
+	}
+	field := rule.field
+	rule.description += " to " + spec.TypeName
+	rule.apply = func(document bson.D, _ time.Time) (bson.D, error) {
+		value, found := getPath(document, field)
+		if !found || value == nil {
+			return document, nil
+		}
+		text := transformText(value)
+		parsed, err := spec.Parser.Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse '%v' to type %v", text, spec.TypeName)
+		}
+		return setPath(document, field, parsed)
+	}
+	return rule, nil
+}
+
+// transformText returns a value as text for concat and cast.
+func transformText(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case bson.ObjectId:
+		return v.Hex()
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	}
+	return fmt.Sprint(value)
+}
+
+// apply transforms a document, returning nil if it is skipped. record, if not
+// nil, is the input record of the document.
+func (t *documentTransform) apply(document bson.D, record *sourceRecord) (bson.D, error) {
+	for _, rule := range t.rules {
+		// rules change documents in place, and can fail half way, like a move
+		// whose field is removed before it can't be set, so each is applied
+		// to a copy, and the document is left as it was if the rule fails
+		transformed, err := rule.apply(copyValue(document).(bson.D), t.now)
+		if err == nil {
+			document = transformed
+			continue
+		}
+		description := "document"
+		if record != nil {
+			description = fmt.Sprintf("document #%v", record.index+1)
+		}
+		err = fmt.Errorf("%v: %v", rule.description, err)
+		switch t.parseGrace {
+		case pgAutoCast:
+			log.Logvf(log.DebugLow, "leaving %v of %v as it is: %v", rule.field, description, err)
+		case pgSkipField:
+			log.Logvf(log.DebugLow, "removing %v of %v: %v", rule.field, description, err)
+			document, _, _ = removePath(document, rule.field)
+		case pgSkipRow:
+			log.Logvf(log.Always, "skipping %v: %v", description, err)
+			if t.rejects != nil && record != nil {
+				t.rejects.reject(*record, rejectTransform, err)
+			}
+			return nil, nil
+		case pgStop:
+			return nil, fmt.Errorf("error transforming %v: %v", description, err)
+		}
+	}
+	return document, nil
+}
+
+// getPath returns the value at a dotted path of a document.
+func getPath(document bson.D, path string) (interface{}, bool) {
+	name, rest := splitPath(path)
+	for _, elem := range document {
+		if elem.Name != name {
+			continue
+		}
+		if rest == "" {
+			return elem.Value, true
+		}
+		switch sub := elem.Value.(type) {
+		case bson.D:
+			return getPath(sub, rest)
+		case *bson.D:
+			return getPath(*sub, rest)
+		}
+		return nil, false
+	}
+	return nil, false
+}
+
+// setPath sets the value at a dotted path of a document, replacing the field
+// if it exists and appending it otherwise, creating subdocuments as needed.
+func setPath(document bson.D, path string, value interface{}) (bson.D, error) {
+	name, rest := splitPath(path)
+	for i, elem := range document {
+		if elem.Name != name {
+			continue
+		}
+		if rest == "" {
+			document[i].Value = value
+			return document, nil
+		}
+		var err error
+		switch sub := elem.Value.(type) {
+		case bson.D:
+			document[i].Value, err = setPath(sub, rest, value)
+		case *bson.D:
+			*sub, err = setPath(*sub, rest, value)
+		default:
+			err = fmt.Errorf("can not set %v: %v is not a document", path, name)
+		}
+		return document, err
+	}
+	if rest == "" {
+		return append(document, bson.DocElem{Name: name, Value: value}), nil
+	}
+	sub, err := setPath(bson.D{}, rest, value)
+	return append(document, bson.DocElem{Name: name, Value: sub}), err
+}
+
+// removePath removes the field at a dotted path of a document, returning
+// its value.
+func removePath(document bson.D, path string) (bson.D, interface{}, bool) {
+	name, rest := splitPath(path)
+	for i, elem := range document {
+		if elem.Name != name {
+			continue
+		}
+		if rest == "" {
+			return append(document[:i:i], document[i+1:]...), elem.Value, true
+		}
+		switch sub := elem.Value.(type) {
+		case bson.D:
+			var value interface{}
+			var found bool
+			document[i].Value, value, found = removePath(sub, rest)
+			return document, value, found
+		case *bson.D:
+			var value interface{}
+			var found bool
+			*sub, value, found = removePath(*sub, rest)
+			return document, value, found
+		}
+		return document, nil, false
+	}
+	return document, nil, false
+}
+
+// renamePath renames the field at a dotted path of a document in place. It
+// fails if the subdocument of the field already has a field named to, rather
+// than leave it with the name twice.
+func renamePath(document bson.D, path, to string) (bson.D, error) {
+	name, rest := splitPath(path)
+	for i, elem := range document {
+		if elem.Name != name {
+			continue
+		}
+		if rest == "" {
+			if to == name {
+				return document, nil
+			}
+			for _, other := range document {
+				if other.Name == to {
+					return document, fmt.Errorf("field %v already exists", to)
+				}
+			}
+			document[i].Name = to
+			return document, nil
+		}
+		var err error
+		switch sub := elem.Value.(type) {
+		case bson.D:
+			document[i].Value, err = renamePath(sub, rest, to)
+		case *bson.D:
+			*sub, err = renamePath(*sub, rest, to)
+		}
+		return document, err
+	}
+	return document, nil
+}
+
+func splitPath(path string) (string, string) {
+	if index := strings.Index(path, "."); index != -1 {
+		return path[:index], path[index+1:]
+	}
+	return path, ""
+}
+
+// copyValue returns a deep copy of the documents and arrays of a value, so
+// that copies can be changed by later rules independently.
+func copyValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case bson.D:
+		doc := make(bson.D, len(v))
+		for i, elem := range v {
+			doc[i] = bson.DocElem{Name: elem.Name, Value: copyValue(elem.Value)}
+		}
+		return doc
+	case *bson.D:
+		doc := copyValue(*v).(bson.D)
+		return &doc
+	case []interface{}:
+		items := make([]interface{}, len(v))
+		for i, item := range v {
+			items[i] = copyValue(item)
+		}
+		return items
+	}
+	return value
+}
@@ -0,0 +1,309 @@
+package mongoimport
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/bsonutil"
+	"github.com/mongodb/mongo-tools/common/json"
+	"github.com/mongodb/mongo-tools/common/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// parseTransform compiles transform rules written in Extended JSON.
+func parseTransform(text string, parseGrace ParseGrace) (*documentTransform, error) {
+	doc, err := json.UnmarshalBsonD([]byte(text))
+	So(err, ShouldBeNil)
+	doc, err = bsonutil.GetExtendedBsonD(doc)
+	So(err, ShouldBeNil)
+	return compileTransform(doc, parseGrace)
+}
+
+func TestDocumentTransform(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+
+	Convey("With transform rules", t, func() {
+		transform, err := parseTransform(`{"rules": [
+			{"op": "rename", "field": "lname", "to": "lastName"},
+			{"op": "split", "field": "name", "separator": ", ", "to": ["last", "first"]},
+			{"op": "concat", "fields": ["first", "middle", "last"], "separator": " ", "to": "fullName"},
+			{"op": "move", "field": "zip", "to": "address.zip"},
+			{"op": "cast", "field": "address.zip", "type": "string()"},
+			{"op": "copy", "field": "address", "to": "billing.address"},
+			{"op": "set", "field": "billing.address.country", "value": "IE"},
+			{"op": "set", "field": "importedAt", "value": "$$NOW"},
+			{"op": "set", "field": "version", "value": {"$numberLong": "2"}},
+			{"op": "remove", "field": "tmp"}
+		]}`, pgStop)
+		So(err, ShouldBeNil)
+		now := time.Date(2016, 5, 4, 3, 2, 1, 0, time.UTC)
+		transform.now = now
+
+		Convey("they should be applied in order", func() {
+			transformed, err := transform.apply(bson.D{
+				{"lname", "Doe"},
+				{"name", "Doe, Jane, Q"},
+				{"zip", int32(2134)},
+				{"address", &bson.D{{"city", "Boston"}}},
+				{"tmp", true},
+			}, nil)
+			So(err, ShouldBeNil)
+			So(transformed, ShouldResemble, bson.D{
+				{"lastName", "Doe"},
+				{"name", "Doe, Jane, Q"},
+				{"address", &bson.D{{"city", "Boston"}, {"zip", "2134"}}},
+				{"last", "Doe"},
+				{"first", "Jane, Q"},
+				{"fullName", "Jane, Q Doe"},
+				{"billing", bson.D{{"address", &bson.D{{"city", "Boston"}, {"zip", "2134"}, {"country", "IE"}}}}},
+				{"importedAt", now},
+				{"version", int64(2)},
+			})
+		})
+
+		Convey("rules on missing fields should do nothing", func() {
+			transformed, err := transform.apply(bson.D{{"other", 1}}, nil)
+			So(err, ShouldBeNil)
+			So(transformed, ShouldResemble, bson.D{
+				{"other", 1},
+				{"billing", bson.D{{"address", bson.D{{"country", "IE"}}}}},
+				{"importedAt", now},
+				{"version", int64(2)},
+			})
+		})
+
+		Convey("a document with a record should be transformed the same way", func() {
+			record := textRecord([]byte("x"), 3, 2)
+			transformed, err := transform.apply(bson.D{{"tmp", 1}}, &record)
+			So(err, ShouldBeNil)
+			So(transformed[len(transformed)-1], ShouldResemble, bson.DocElem{"version", int64(2)})
+		})
+	})
+
+	Convey("With a rule that fails", t, func() {
+		rules := `{"rules": [
+			{"op": "cast", "field": "n", "type": "int32"},
+			{"op": "split", "field": "tags", "separator": ";"}
+		]}`
+		document := bson.D{{"n", "x"}, {"tags", "a;b"}}
+
+		Convey("stop should fail the import", func() {
+			transform, err := parseTransform(rules, pgStop)
+			So(err, ShouldBeNil)
+			_, err = transform.apply(document, nil)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "error transforming document: rule 1 (cast n to int32): could not parse 'x' to type int32")
+		})
+
+		Convey("autoCast should leave the field as it is", func() {
+			transform, err := parseTransform(rules, pgAutoCast)
+			So(err, ShouldBeNil)
+			transformed, err := transform.apply(document, nil)
+			So(err, ShouldBeNil)
+			So(transformed, ShouldResemble, bson.D{{"n", "x"}, {"tags", []interface{}{"a", "b"}}})
+		})
+
+		Convey("skipField should remove the field", func() {
+			transform, err := parseTransform(rules, pgSkipField)
+			So(err, ShouldBeNil)
+			transformed, err := transform.apply(document, nil)
+			So(err, ShouldBeNil)
+			So(transformed, ShouldResemble, bson.D{{"tags", []interface{}{"a", "b"}}})
+		})
+
+		Convey("skipRow should skip the document and write its record to the reject file", func() {
+			transform, err := parseTransform(rules, pgSkipRow)
+			So(err, ShouldBeNil)
+			records := &bytes.Buffer{}
+			errors := &bytes.Buffer{}
+			transform.rejects = newRejectWriter(records, errors, false)
+			record := textRecord([]byte("x,a;b"), 2, 0)
+			transformed, err := transform.apply(document, &record)
+			So(err, ShouldBeNil)
+			So(transformed, ShouldBeNil)
+			So(records.String(), ShouldEqual, "x,a;b\n")
+			So(errors.String(), ShouldEqual,
+				`{"line":2,"document":1,"stage":"transform","error":"rule 1 (cast n to int32): could not parse 'x' to type int32"}`+"\n")
+		})
+	})
+
+	Convey("A rename onto a field that already exists should fail", t, func() {
+		transform, err := parseTransform(`{"rules": [{"op": "rename", "field": "a.old", "to": "new"}]}`, pgStop)
+		So(err, ShouldBeNil)
+		_, err = transform.apply(bson.D{{"a", bson.D{{"old", 1}, {"new", 2}}}}, nil)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldEqual, "error transforming document: rule 1 (rename a.old to new): field new already exists")
+
+		transform.parseGrace = pgSkipField
+		transformed, err := transform.apply(bson.D{{"a", bson.D{{"old", 1}, {"new", 2}}}}, nil)
+		So(err, ShouldBeNil)
+		So(transformed, ShouldResemble, bson.D{{"a", bson.D{{"new", 2}}}})
+	})
+
+	Convey("A rule that fails half way should leave the document as it was with autoCast", t, func() {
+		transform, err := parseTransform(`{"rules": [
+			{"op": "move", "field": "a.b", "to": "c.d"},
+			{"op": "split", "field": "e.f", "separator": ";", "to": ["g", "c.h"]}
+		]}`, pgAutoCast)
+		So(err, ShouldBeNil)
+		document := bson.D{{"a", bson.D{{"b", 1}}}, {"c", "text"}, {"e", bson.D{{"f", "x;y"}}}}
+		transformed, err := transform.apply(document, nil)
+		So(err, ShouldBeNil)
+		So(transformed, ShouldResemble, bson.D{{"a", bson.D{{"b", 1}}}, {"c", "text"}, {"e", bson.D{{"f", "x;y"}}}})
+		So(document, ShouldResemble, transformed)
+	})
+
+	Convey("Invalid rules should fail to compile", t, func() {
+		for _, rules := range []string{
+			`{"rules": []}`,
+			`{"rules": [{"op": "explode", "field": "a"}]}`,
+			`{"rules": [{"op": "remove"}]}`,
+			`{"rules": [{"op": "remove", "field": "$a"}]}`,
+			`{"rules": [{"op": "rename", "field": "a", "to": "b.c"}]}`,
+			`{"rules": [{"op": "set", "field": "a"}]}`,
+			`{"rules": [{"op": "copy", "field": "a", "to": "b", "extra": 1}]}`,
+			`{"rules": [{"op": "split", "field": "a", "separator": ""}]}`,
+			`{"rules": [{"op": "cast", "field": "a", "type": "int128"}]}`,
+			`{"rules": [{"op": "concat", "fields": "a", "to": "b"}]}`,
+			`{"other": []}`,
+		} {
+			_, err := parseTransform(rules, pgStop)
+			So(err, ShouldNotBeNil)
+		}
+	})
+
+	Convey("Documents should be transformed by the import workers", t, func() {
+		transform, err := parseTransform(`{"rules": [{"op": "move", "field": "b", "to": "x.b"}]}`, pgStop)
+		So(err, ShouldBeNil)
+		r := NewCSVInputReader(ParseAutoHeaders([]string{"a", "b"}), strings.NewReader("1,2\n3,4\n"), os.Stdout, 2, false)
+		r.transformWith(transform)
+		docChan := make(chan bson.D, 4)
+		So(r.StreamDocument(true, docChan), ShouldBeNil)
+		So(<-docChan, ShouldResemble, bson.D{{"a", int32(1)}, {"x", bson.D{{"b", int32(2)}}}})
+		So(<-docChan, ShouldResemble, bson.D{{"a", int32(3)}, {"x", bson.D{{"b", int32(4)}}}})
+	})
+
+	Convey("A transform file may be written in YAML", t, func() {
+		dir, err := ioutil.TempDir("", "mongoimport-transform-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "transform.yaml")
+		So(ioutil.WriteFile(path, []byte(`# fix up the people export
+rules:
+  - op: rename
+    field: lname
+    to: lastName
+  - {op: set, field: active, value: true}
+  - op: concat
+    fields: [first, lastName]
+    separator: " "   # a space
+    to: name
+  - op: cast
+    field: born
+    type: 'date_go(2006-01-02)'
+`), 0644), ShouldBeNil)
+		transform, err := readTransformFile(path, pgStop)
+		So(err, ShouldBeNil)
+		transformed, err := transform.apply(bson.D{{"first", "Jane"}, {"lname", "Doe"}, {"born", "1980-02-03"}}, nil)
+		So(err, ShouldBeNil)
+		So(transformed, ShouldResemble, bson.D{
+			{"first", "Jane"},
+			{"lastName", "Doe"},
+			{"born", time.Date(1980, 2, 3, 0, 0, 0, 0, time.UTC)},
+			{"active", true},
+			{"name", "Jane Doe"},
+		})
+	})
+
+	Convey("Given a mongoimport instance", t, func() {
+		Convey("an error should be thrown for a missing --transformFile", func() {
+			imp, err := NewMongoImport()
+			So(err, ShouldBeNil)
+			imp.InputOptions.TransformFile = "does-not-exist.json"
+			So(imp.ValidateSettings([]string{}), ShouldNotBeNil)
+		})
+	})
+}
+
+func TestParseYAMLDocument(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+
+	Convey("YAML should be parsed into a document", t, func() {
+		doc, err := parseYAMLDocument([]byte("---\r\n" +
+			"name: Jane # comment\r\n" +
+			"'quoted key': \"a \\\"b\\\" #c\"\n" +
+			"single: 'it''s'\n" +
+			"count: 12\n" +
+			"big: 12345678901\n" +
+			"ratio: 1.5\n" +
+			"none: ~\n" +
+			"empty:\n" +
+			"flags: [yes, false, {k: v, num: 1}]\n" +
+			"list:\n" +
+			"- a\n" +
+			"- - b\n" +
+			"  - c\n" +
+			"nested:\n" +
+			"  deeper:\n" +
+			"    x: 1\n" +
+			"  items:\n" +
+			"    - name: a\n" +
+			"      value: 1\n" +
+			"    -\n" +
+			"      name: b\n" +
+			"url: http://example.com/a#b\n" +
+			"text: |\n" +
+			"  two\n" +
+			"  lines\n" +
+			"anchored: &n 3\n" +
+			"alias: *n\n" +
+			"tagged: !!str 4\n"))
+		So(err, ShouldBeNil)
+		So(doc, ShouldResemble, bson.D{
+			{"name", "Jane"},
+			{"quoted key", `a "b" #c`},
+			{"single", "it's"},
+			{"count", int32(12)},
+			{"big", int64(12345678901)},
+			{"ratio", 1.5},
+			{"none", nil},
+			{"empty", nil},
+			{"flags", []interface{}{true, false, bson.D{{"k", "v"}, {"num", int32(1)}}}},
+			{"list", []interface{}{"a", []interface{}{"b", "c"}}},
+			{"nested", bson.D{
+				{"deeper", bson.D{{"x", int32(1)}}},
+				{"items", []interface{}{
+					bson.D{{"name", "a"}, {"value", int32(1)}},
+					bson.D{{"name", "b"}},
+				}},
+			}},
+			{"url", "http://example.com/a#b"},
+			{"text", "two\nlines\n"},
+			{"anchored", int32(3)},
+			{"alias", int32(3)},
+			{"tagged", "4"},
+		})
+	})
+
+	Convey("Invalid YAML should fail to parse", t, func() {
+		for _, text := range []string{
+			"a: b: c\n",
+			"a: 1\n  b: 2\n",
+			"a: 1\na: 2\n",
+			"- a\n",
+			"a: [1, 2\n",
+			"a: \"open\n",
+			"a: 1\n- b\n",
+			"\ta: 1\n",
+		} {
+			_, err := parseYAMLDocument([]byte(text))
+			So(err, ShouldNotBeNil)
+		}
+	})
+}
@@ -27,6 +27,26 @@ type TSVInputReader struct {
 	// tsvRejectWriter is where coercion-failed rows are written, if applicable
 	tsvRejectWriter io.Writer
 
+	// rejects, if set, is where rejected rows are written verbatim instead
+	rejects *rejectWriter
+
+	// transform, if set, is applied to each document after it is converted
+	transform *documentTransform
+
+	// validator, if set, checks each document once it is transformed
+	validator *documentValidator
+
+	// checkpoints, if set, keeps track of the records done with, and base is
+	// added to positions in the input when resuming from a checkpoint
+	checkpoints *checkpointer
+	base        inputPosition
+
+	// offset is the number of bytes read
+	offset int64
+
+	// numLines is the number of lines read, including the header
+	numLines uint64
+
 	// tsvRecord stores each line of input we read from the underlying reader
 	tsvRecord string
 
@@ -50,6 +70,10 @@ type TSVConverter struct {
 	index        uint64
 	ignoreBlanks bool
 	rejectWriter io.Writer
+	rejects      *rejectWriter
+	line         uint64
+	tag          bool
+	end          inputPosition
 }
 
 // NewTSVInputReader returns a TSVInputReader configured to read input from the
@@ -67,10 +91,33 @@ func NewTSVInputReader(colSpecs []ColumnSpec, in io.Reader, rejects io.Writer, n
 	}
 }
 
+// keepRejects makes the reader write rejected rows verbatim to rejects.
+func (r *TSVInputReader) keepRejects(rejects *rejectWriter) {
+	r.rejects = rejects
+}
+
+// transformWith makes the reader's decoding workers apply transform to the
+// documents they convert.
+func (r *TSVInputReader) transformWith(transform *documentTransform) {
+	r.transform = transform
+}
+
+// validateWith makes the reader's decoding workers check the documents they
+// convert against validator, once they are transformed.
+func (r *TSVInputReader) validateWith(validator *documentValidator) {
+	r.validator = validator
+}
+
+// checkpointWith makes the reader send its documents with where their records
+// end, for checkpoints, and continue from its checkpoint when resuming.
+func (r *TSVInputReader) checkpointWith(checkpoints *checkpointer) {
+	r.checkpoints = checkpoints
+}
+
 // ReadAndValidateHeader reads the header from the underlying reader and validates
 // the header fields. It sets err if the read/validation fails.
 func (r *TSVInputReader) ReadAndValidateHeader() (err error) {
-	header, err := r.tsvReader.ReadString(entryDelimiter)
+	header, err := r.readHeader()
 	if err != nil {
 		return err
 	}
@@ -86,7 +133,7 @@ func (r *TSVInputReader) ReadAndValidateHeader() (err error) {
 // ReadAndValidateTypedHeader reads the header from the underlying reader and validates
 // the header fields. It sets err if the read/validation fails.
 func (r *TSVInputReader) ReadAndValidateTypedHeader(parseGrace ParseGrace) (err error) {
-	header, err := r.tsvReader.ReadString(entryDelimiter)
+	header, err := r.readHeader()
 	if err != nil {
 		return err
 	}
@@ -101,10 +148,44 @@ func (r *TSVInputReader) ReadAndValidateTypedHeader(parseGrace ParseGrace) (err
 	return validateReaderFields(ColumnNames(r.colSpecs))
 }
 
+// readHeader reads the header line, copying it to the reject file so that it
+// can be imported with --headerline too.
+func (r *TSVInputReader) readHeader() (string, error) {
+	header, err := r.tsvReader.ReadString(entryDelimiter)
+	if err != nil {
+		return "", err
+	}
+	r.numLines++
+	r.offset += int64(len(header))
+	if r.rejects != nil {
+		r.rejects.setHeader([]byte(header))
+	}
+	return header, nil
+}
+
+// position returns where the last record read ends in the input.
+func (r *TSVInputReader) position() inputPosition {
+	return inputPosition{offset: r.base.offset + r.offset, line: r.base.line + r.numLines}
+}
+
 // StreamDocument takes a boolean indicating if the documents should be streamed
 // in read order and a channel on which to stream the documents processed from
 // the underlying reader. Returns a non-nil error if streaming fails.
-func (r *TSVInputReader) StreamDocument(ordered bool, readDocs chan bson.D) (retErr error) {
+func (r *TSVInputReader) StreamDocument(ordered bool, readDocs chan bson.D) error {
+	return streamWithoutRecords(readDocs, func(sourced chan sourcedDocument) error {
+		return r.streamSourced(ordered, sourced)
+	})
+}
+
+// streamSourced is StreamDocument, sending each document with its record.
+func (r *TSVInputReader) streamSourced(ordered bool, readDocs chan sourcedDocument) (retErr error) {
+	if r.checkpoints != nil {
+		var err error
+		if r.base, r.numProcessed, err = r.checkpoints.begin(r.position()); err != nil {
+			return err
+		}
+	}
+
 	tsvRecordChan := make(chan Converter, r.numDecoders)
 	tsvErrChan := make(chan error)
 
@@ -123,12 +204,18 @@ func (r *TSVInputReader) StreamDocument(ordered bool, readDocs chan bson.D) (ret
 				}
 				return
 			}
+			r.numLines++
+			r.offset += int64(len(r.tsvRecord))
 			tsvRecordChan <- TSVConverter{
 				colSpecs:     r.colSpecs,
 				data:         r.tsvRecord,
 				index:        r.numProcessed,
 				ignoreBlanks: r.ignoreBlanks,
 				rejectWriter: r.tsvRejectWriter,
+				rejects:      r.rejects,
+				line:         r.base.line + r.numLines,
+				tag:          r.rejects != nil || r.checkpoints != nil,
+				end:          r.position(),
 			}
 			r.numProcessed++
 		}
@@ -136,7 +223,7 @@ func (r *TSVInputReader) StreamDocument(ordered bool, readDocs chan bson.D) (ret
 
 	// begin processing read bytes
 	go func() {
-		tsvErrChan <- streamDocuments(ordered, r.numDecoders, tsvRecordChan, readDocs)
+		tsvErrChan <- streamDocuments(ordered, r.numDecoders, tsvRecordChan, readDocs, r.transform, r.validator, r.checkpoints)
 	}()
 
 	return channelQuorumError(tsvErrChan, 2)
@@ -152,12 +239,29 @@ func (c TSVConverter) Convert() (b bson.D, err error) {
 		c.ignoreBlanks,
 	)
 	if _, ok := err.(coercionError); ok {
-		c.Print()
+		if c.rejects != nil {
+			c.rejects.reject(c.source(), rejectCoercion, err)
+		} else {
+			c.Print()
+		}
 		err = nil
 	}
 	return
 }
 
+// tagged returns whether the documents of the converter are sent with their
+// record.
+func (c TSVConverter) tagged() bool {
+	return c.tag
+}
+
+// source returns the record the converter converts.
+func (c TSVConverter) source() sourceRecord {
+	record := textRecord([]byte(c.data), c.line, c.index)
+	record.end = c.end
+	return record
+}
+
 func (c TSVConverter) Print() {
 	c.rejectWriter.Write([]byte(c.data + "\n"))
 }
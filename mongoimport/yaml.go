@@ -0,0 +1,75 @@
+package mongoimport
+
+import (
+	"fmt"
+	"math"
+
+	"gopkg.in/mgo.v2/bson"
+	"gopkg.in/yaml.v2"
+)
+
+// parseYAMLDocument parses a YAML configuration file like --transformFile
+// into a document, with mappings as bson.D, keeping the order of their keys,
+// and sequences as []interface{}, like the documents of the JSON reader.
+func parseYAMLDocument(content []byte) (bson.D, error) {
+	var top yaml.MapSlice
+	if err := yaml.Unmarshal(content, &top); err != nil {
+		if _, ok := err.(*yaml.TypeError); ok {
+			return nil, fmt.Errorf("expected a mapping at the top level")
+		}
+		return nil, err
+	}
+	doc, err := yamlToBSON(top)
+	if err != nil {
+		return nil, err
+	}
+	return doc.(bson.D), nil
+}
+
+// yamlToBSON converts a value decoded by the YAML library, turning mappings
+// into bson.D and integers into int32 or int64.
+func yamlToBSON(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case yaml.MapSlice:
+		doc := make(bson.D, 0, len(v))
+		seen := map[string]bool{}
+		for _, item := range v {
+			key, ok := item.Key.(string)
+			if !ok {
+				return nil, fmt.Errorf("mapping keys must be strings, not %v", item.Key)
+			}
+			if seen[key] {
+				return nil, fmt.Errorf("mapping key %v is repeated", key)
+			}
+			seen[key] = true
+			converted, err := yamlToBSON(item.Value)
+			if err != nil {
+				return nil, err
+			}
+			doc = append(doc, bson.DocElem{Name: key, Value: converted})
+		}
+		return doc, nil
+	case []interface{}:
+		array := make([]interface{}, len(v))
+		for i, item := range v {
+			converted, err := yamlToBSON(item)
+			if err != nil {
+				return nil, err
+			}
+			array[i] = converted
+		}
+		return array, nil
+	case int:
+		if v >= math.MinInt32 && v <= math.MaxInt32 {
+			return int32(v), nil
+		}
+		return int64(v), nil
+	case uint64:
+		if v > math.MaxInt64 {
+			return nil, fmt.Errorf("integer %v is too large", v)
+		}
+		return int64(v), nil
+	default:
+		return v, nil
+	}
+}
@@ -210,7 +210,7 @@ func (restore *MongoRestore) getInfoFromFilename(filename string) (string, FileT
 	// Gzip indicates that files in a dump directory should have a .gz suffix
 	// but it does not indicate that the "files" provided by the archive should,
 	// compressed or otherwise.
-	if restore.InputOptions.Gzip && restore.InputOptions.Archive == "" {
+	if restore.InputOptions.Gzip && restore.archiveSource() == "" {
 		if strings.HasSuffix(baseFileName, ".metadata.json.gz") {
 			baseName := strings.TrimSuffix(baseFileName, ".metadata.json.gz")
 			return baseName, MetadataFileType
@@ -258,7 +258,7 @@ func (restore *MongoRestore) CreateAllIntents(dir archive.DirLike) error {
 					Location: entry.Path(),
 				}
 				if !restore.InputOptions.OplogReplay {
-					if restore.InputOptions.Archive != "" {
+					if restore.archiveSource() != "" {
 						mutedOut := &archive.MutedCollection{
 							Intent: oplogIntent,
 							Demux:  restore.archive.Demux,
@@ -270,11 +270,11 @@ func (restore *MongoRestore) CreateAllIntents(dir archive.DirLike) error {
 					}
 					continue
 				}
-				if restore.InputOptions.Archive != "" {
-					if restore.InputOptions.Archive == "-" {
+				if restore.archiveSource() != "" {
+					if restore.archiveSource() == "-" {
 						oplogIntent.Location = "archive on stdin"
 					} else {
-						oplogIntent.Location = fmt.Sprintf("archive '%v'", restore.InputOptions.Archive)
+						oplogIntent.Location = fmt.Sprintf("archive '%v'", restore.archiveSource())
 					}
 
 					// no need to check that we want to cache here
@@ -381,11 +381,11 @@ func (restore *MongoRestore) CreateIntentsForDB(db string, dir archive.DirLike)
 					C:    destC,
 					Size: entry.Size(),
 				}
-				if restore.InputOptions.Archive != "" {
-					if restore.InputOptions.Archive == "-" {
+				if restore.archiveSource() != "" {
+					if restore.archiveSource() == "-" {
 						intent.Location = "archive on stdin"
 					} else {
-						intent.Location = fmt.Sprintf("archive '%v'", restore.InputOptions.Archive)
+						intent.Location = fmt.Sprintf("archive '%v'", restore.archiveSource())
 					}
 					if skip {
 						// adding the DemuxOut to the demux, but not adding the intent to the manager
@@ -435,11 +435,11 @@ func (restore *MongoRestore) CreateIntentsForDB(db string, dir archive.DirLike)
 					C:  rnC,
 				}
 
-				if restore.InputOptions.Archive != "" {
-					if restore.InputOptions.Archive == "-" {
+				if restore.archiveSource() != "" {
+					if restore.archiveSource() == "-" {
 						intent.MetadataLocation = "archive on stdin"
 					} else {
-						intent.MetadataLocation = fmt.Sprintf("archive '%v'", restore.InputOptions.Archive)
+						intent.MetadataLocation = fmt.Sprintf("archive '%v'", restore.archiveSource())
 					}
 					intent.MetadataFile = &archive.MetadataPreludeFile{Origin: sourceNS, Intent: intent, Prelude: restore.archive.Prelude}
 				} else {
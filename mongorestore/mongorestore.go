@@ -3,6 +3,7 @@ package mongorestore
 
 import (
 	"compress/gzip"
+	"crypto/tls"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -60,7 +61,8 @@ type MongoRestore struct {
 	// indexes belonging to dbs and collections
 	dbCollectionIndexes map[string]collectionIndexes
 
-	archive *archive.Reader
+	archive       *archive.Reader
+	archiveStream *archive.StreamReader
 
 	// channel on which to notify if/when a termination signal is received
 	termChan chan struct{}
@@ -128,7 +130,7 @@ func (restore *MongoRestore) ParseAndValidateOptions() error {
 		if !restore.InputOptions.OplogReplay {
 			return fmt.Errorf("cannot use --oplogFile without --oplogReplay enabled")
 		}
-		if restore.InputOptions.Archive != "" {
+		if restore.archiveSource() != "" {
 			return fmt.Errorf("cannot use --oplogFile with --archive specified")
 		}
 	}
@@ -214,14 +216,37 @@ func (restore *MongoRestore) ParseAndValidateOptions() error {
 		return fmt.Errorf("invalid renames: %v", err)
 	}
 
+	if restore.InputOptions.ArchiveListen != "" && restore.InputOptions.Archive != "" {
+		return fmt.Errorf("cannot use --archive with --archiveListen specified")
+	}
+	if (restore.InputOptions.ArchiveTLSCertFile == "") != (restore.InputOptions.ArchiveTLSKeyFile == "") {
+		return fmt.Errorf("--archiveTLSCertFile and --archiveTLSKeyFile must be specified together")
+	}
+	if restore.InputOptions.ArchiveTLSCertFile != "" && restore.InputOptions.ArchiveListen == "" {
+		return fmt.Errorf("cannot use --archiveTLSCertFile without --archiveListen")
+	}
+
 	if restore.OutputOptions.NumInsertionWorkers < 0 {
 		return fmt.Errorf(
 			"cannot specify a negative number of insertion workers per collection")
 	}
 
+	if restore.OutputOptions.MaxCollectionsPerDB < 0 {
+		return fmt.Errorf("cannot specify a negative --maxCollectionsPerDB")
+	}
+	if restore.archiveSource() != "" {
+		// archive collections must be restored in the order they appear in the archive
+		if restore.OutputOptions.Priority == "cost" {
+			return fmt.Errorf("cannot use --priority=cost with --archive specified")
+		}
+		if restore.OutputOptions.MaxCollectionsPerDB > 0 {
+			return fmt.Errorf("cannot use --maxCollectionsPerDB with --archive specified")
+		}
+	}
+
 	// a single dash signals reading from stdin
 	if restore.TargetDirectory == "-" {
-		if restore.InputOptions.Archive != "" {
+		if restore.archiveSource() != "" {
 			return fmt.Errorf(
 				"cannot restore from \"-\" when --archive is specified")
 		}
@@ -237,9 +262,9 @@ func (restore *MongoRestore) ParseAndValidateOptions() error {
 }
 
 // Restore runs the mongorestore program.
-func (restore *MongoRestore) Restore() error {
+func (restore *MongoRestore) Restore() (err error) {
 	var target archive.DirLike
-	err := restore.ParseAndValidateOptions()
+	err = restore.ParseAndValidateOptions()
 	if err != nil {
 		log.Logvf(log.DebugLow, "got error from options parsing: %v", err)
 		return err
@@ -247,11 +272,11 @@ func (restore *MongoRestore) Restore() error {
 
 	// Build up all intents to be restored
 	restore.manager = intents.NewIntentManager()
-	if restore.InputOptions.Archive == "" && restore.InputOptions.OplogReplay {
+	if restore.archiveSource() == "" && restore.InputOptions.OplogReplay {
 		restore.manager.SetSmartPickOplog(true)
 	}
 
-	if restore.InputOptions.Archive != "" {
+	if restore.archiveSource() != "" {
 		if restore.archive == nil {
 			archiveReader, err := restore.getArchiveReader()
 			if err != nil {
@@ -262,6 +287,10 @@ func (restore *MongoRestore) Restore() error {
 				Prelude: &archive.Prelude{},
 			}
 		}
+		if restore.archiveStream != nil {
+			// tell mongodump how the restore went, which stops it if the restore failed
+			defer func() { restore.archiveStream.Finish(err) }()
+		}
 		err = restore.archive.Prelude.Read(restore.archive.In)
 		if err != nil {
 			return err
@@ -308,7 +337,7 @@ func (restore *MongoRestore) Restore() error {
 			restore.OutputOptions.NumParallelCollections)
 		restore.OutputOptions.NumInsertionWorkers = restore.OutputOptions.NumParallelCollections
 	}
-	if restore.InputOptions.Archive != "" {
+	if restore.archiveSource() != "" {
 		if int(restore.archive.Prelude.Header.ConcurrentCollections) > restore.OutputOptions.NumParallelCollections {
 			restore.OutputOptions.NumParallelCollections = int(restore.archive.Prelude.Header.ConcurrentCollections)
 			restore.OutputOptions.NumInsertionWorkers = int(restore.archive.Prelude.Header.ConcurrentCollections)
@@ -321,12 +350,12 @@ func (restore *MongoRestore) Restore() error {
 
 	// Create the demux before intent creation, because muted archive intents need
 	// to register themselves with the demux directly
-	if restore.InputOptions.Archive != "" {
+	if restore.archiveSource() != "" {
 		restore.archive.Demux = archive.CreateDemux(restore.archive.Prelude.NamespaceMetadatas, restore.archive.In)
 	}
 
 	switch {
-	case restore.InputOptions.Archive != "":
+	case restore.archiveSource() != "":
 		log.Logvf(log.Always, "preparing collections to restore from")
 		err = restore.CreateAllIntents(target)
 	case restore.NSOptions.DB != "" && restore.NSOptions.Collection == "":
@@ -392,7 +421,7 @@ func (restore *MongoRestore) Restore() error {
 
 	demuxFinished := make(chan interface{})
 	var demuxErr error
-	if restore.InputOptions.Archive != "" {
+	if restore.archiveSource() != "" {
 		namespaceChan := make(chan string, 1)
 		namespaceErrorChan := make(chan error)
 		restore.archive.Demux.NamespaceChan = namespaceChan
@@ -459,14 +488,20 @@ func (restore *MongoRestore) Restore() error {
 	}
 
 	// Restore the regular collections
-	if restore.InputOptions.Archive != "" {
+	if restore.archiveSource() != "" {
 		restore.manager.UsePrioritizer(restore.archive.Demux.NewPrioritizer(restore.manager))
+	} else if restore.OutputOptions.Priority == "cost" {
+		restore.estimateIntentCosts()
+		restore.manager.Finalize(intents.CostModel)
 	} else if restore.OutputOptions.NumParallelCollections > 1 {
 		restore.manager.Finalize(intents.MultiDatabaseLTF)
 	} else {
 		// use legacy restoration order if we are single-threaded
 		restore.manager.Finalize(intents.Legacy)
 	}
+	if restore.OutputOptions.MaxCollectionsPerDB > 0 {
+		restore.manager.LimitIntentsPerDB(restore.OutputOptions.MaxCollectionsPerDB)
+	}
 
 	restore.termChan = make(chan struct{})
 
@@ -492,7 +527,7 @@ func (restore *MongoRestore) Restore() error {
 
 	defer log.Logv(log.Always, "done")
 
-	if restore.InputOptions.Archive != "" {
+	if restore.archiveSource() != "" {
 		<-demuxFinished
 		return demuxErr
 	}
@@ -500,29 +535,56 @@ func (restore *MongoRestore) Restore() error {
 	return nil
 }
 
+// archiveSource returns where the archive is read from: a file path, '-' for stdin,
+// or the address mongodump connects to. It is empty when not restoring an archive.
+func (restore *MongoRestore) archiveSource() string {
+	if restore.InputOptions.ArchiveListen != "" {
+		return "tcp://" + restore.InputOptions.ArchiveListen
+	}
+	return restore.InputOptions.Archive
+}
+
+// getArchiveStream waits for mongodump to connect to the --archiveListen address.
+func (restore *MongoRestore) getArchiveStream() (*archive.StreamReader, error) {
+	var tlsConfig *tls.Config
+	if restore.InputOptions.ArchiveTLSCertFile != "" {
+		var err error
+		tlsConfig, err = archive.ServerTLSConfig(
+			restore.InputOptions.ArchiveTLSCertFile, restore.InputOptions.ArchiveTLSKeyFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	listener, err := archive.ListenStream(restore.InputOptions.ArchiveListen, tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	return listener.Accept()
+}
+
 func (restore *MongoRestore) getArchiveReader() (rc io.ReadCloser, err error) {
-	if restore.InputOptions.Archive == "-" {
-		rc = ioutil.NopCloser(restore.InputReader)
-	} else {
-		targetStat, err := os.Stat(restore.InputOptions.Archive)
+	if restore.InputOptions.ArchiveListen != "" {
+		restore.archiveStream, err = restore.getArchiveStream()
 		if err != nil {
 			return nil, err
 		}
-		if targetStat.IsDir() {
-			defaultArchiveFilePath := filepath.Join(restore.InputOptions.Archive, "archive")
+		rc = restore.archiveStream
+	} else if restore.InputOptions.Archive == "-" {
+		rc = ioutil.NopCloser(restore.InputReader)
+	} else {
+		archiveFilePath := restore.InputOptions.Archive
+		targetStat, err := os.Stat(archiveFilePath)
+		if err == nil && targetStat.IsDir() {
+			archiveFilePath = filepath.Join(restore.InputOptions.Archive, "archive")
 			if restore.InputOptions.Gzip {
-				defaultArchiveFilePath = defaultArchiveFilePath + ".gz"
-			}
-			rc, err = os.Open(defaultArchiveFilePath)
-			if err != nil {
-				return nil, err
-			}
-		} else {
-			rc, err = os.Open(restore.InputOptions.Archive)
-			if err != nil {
-				return nil, err
+				archiveFilePath = archiveFilePath + ".gz"
 			}
 		}
+		// archive.OpenFile reads the remaining parts of multi-volume archives
+		rc, err = archive.OpenFile(archiveFilePath)
+		if err != nil {
+			return nil, err
+		}
 	}
 	if restore.InputOptions.Gzip {
 		gzrc, err := gzip.NewReader(rc)
@@ -16,7 +16,10 @@ type InputOptions struct {
 	OplogReplay            bool   `long:"oplogReplay" description:"replay oplog for point-in-time restore"`
 	OplogLimit             string `long:"oplogLimit" value-name:"<seconds>[:ordinal]" description:"only include oplog entries before the provided Timestamp"`
 	OplogFile              string `long:"oplogFile" value-name:"<filename>" description:"oplog file to use for replay of oplog"`
-	Archive                string `long:"archive" value-name:"<filename>" optional:"true" optional-value:"-" description:"restore dump from the specified archive file.  If flag is specified without a value, archive is read from stdin"`
+	Archive                string `long:"archive" value-name:"<filename>" optional:"true" optional-value:"-" description:"restore dump from the specified archive file, or the first part of a multi-volume archive.  If flag is specified without a value, archive is read from stdin"`
+	ArchiveListen          string `long:"archiveListen" value-name:"[<host>]:<port>" description:"restore an archive streamed by mongodump with --archiveTo, listening for it on the given address; the restore fails if the connection is lost, since streams are not resumed"`
+	ArchiveTLSCertFile     string `long:"archiveTLSCertFile" value-name:"<filename>" description:"certificate file to accept --archiveListen connections over TLS with"`
+	ArchiveTLSKeyFile      string `long:"archiveTLSKeyFile" value-name:"<filename>" description:"private key file for --archiveTLSCertFile"`
 	RestoreDBUsersAndRoles bool   `long:"restoreDbUsersAndRoles" description:"restore user and role definitions for the given database"`
 	Directory              string `long:"dir" value-name:"<directory-name>" description:"input directory, use '-' for stdin"`
 	Gzip                   bool   `long:"gzip" description:"decompress gzipped input"`
@@ -38,6 +41,8 @@ type OutputOptions struct {
 	MaintainInsertionOrder   bool   `long:"maintainInsertionOrder" description:"preserve order of documents during restoration"`
 	NumParallelCollections   int    `long:"numParallelCollections" short:"j" description:"number of collections to restore in parallel (4 by default)" default:"4" default-mask:"-"`
 	NumInsertionWorkers      int    `long:"numInsertionWorkersPerCollection" description:"number of insert operations to run concurrently per collection (1 by default)" default:"1" default-mask:"-"`
+	Priority                 string `long:"priority" value-name:"<type>" choice:"default" choice:"cost" default:"default" description:"order in which collections are restored: default (largest first) or cost (most estimated work first, from data size, document count and indexes)"`
+	MaxCollectionsPerDB      int    `long:"maxCollectionsPerDB" value-name:"<count>" description:"maximum number of collections of a single database to restore at a time (no limit by default)"`
 	StopOnError              bool   `long:"stopOnError" description:"stop restoring if an error is encountered on insert (off by default)"`
 	BypassDocumentValidation bool   `long:"bypassDocumentValidation" description:"bypass document validation"`
 	TempUsersColl            string `long:"tempUsersColl" default:"tempusers" hidden:"true"`
@@ -0,0 +1,86 @@
+package mongorestore
+
+import (
+	"io/ioutil"
+
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/log"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// costSampleDocuments is how many documents are read from the start of each BSON
+// file to estimate the number of documents in the file.
+const costSampleDocuments = 1000
+
+// estimateIntentCosts fills in the document counts and index keys that the
+// cost model prioritizer uses to estimate the work of restoring each collection.
+// Failures only make the estimates less accurate, so they are logged and ignored;
+// the restore itself reports them.
+func (restore *MongoRestore) estimateIntentCosts() {
+	for _, intent := range restore.manager.Intents() {
+		if !restore.OutputOptions.NoIndexRestore {
+			intent.IndexKeys = restore.indexKeysForIntent(intent)
+		}
+		if bsonFile, ok := intent.BSONFile.(*realBSONFile); ok {
+			docCount, err := sampleDocumentCount(bsonFile, intent.Size)
+			if err != nil {
+				log.Logvf(log.DebugLow, "cannot estimate document count of %v: %v", intent.Namespace(), err)
+				continue
+			}
+			intent.DocCount = docCount
+		}
+		log.Logvf(log.DebugHigh, "estimated cost of restoring %v: %v (%v documents, %v indexes)",
+			intent.Namespace(), intents.EstimateCost(intent), intent.DocCount, len(intent.IndexKeys))
+	}
+}
+
+// indexKeysForIntent returns the keys of the indexes that will be built for an intent,
+// from its metadata file or from the system.indexes collection of the dump.
+func (restore *MongoRestore) indexKeysForIntent(intent *intents.Intent) []bson.D {
+	var indexes []IndexDocument
+	if intent.MetadataFile == nil {
+		indexes = restore.dbCollectionIndexes[intent.DB][intent.C]
+	} else {
+		if err := intent.MetadataFile.Open(); err != nil {
+			log.Logvf(log.DebugLow, "cannot read indexes of %v: %v", intent.Namespace(), err)
+			return nil
+		}
+		metadata, err := ioutil.ReadAll(intent.MetadataFile)
+		intent.MetadataFile.Close()
+		if err == nil {
+			_, indexes, err = restore.MetadataFromJSON(metadata)
+		}
+		if err != nil {
+			log.Logvf(log.DebugLow, "cannot read indexes of %v: %v", intent.Namespace(), err)
+			return nil
+		}
+	}
+	keys := make([]bson.D, 0, len(indexes))
+	for _, index := range indexes {
+		keys = append(keys, index.Key)
+	}
+	return keys
+}
+
+// sampleDocumentCount reads documents from the start of a BSON file, and extrapolates
+// their count to the size of the whole file. The count is exact for small files.
+func sampleDocumentCount(bsonFile *realBSONFile, size int64) (int64, error) {
+	if err := bsonFile.Open(); err != nil {
+		return 0, err
+	}
+	bsonSource := db.NewBufferlessBSONSource(bsonFile)
+	defer bsonSource.Close()
+
+	var sampled int64
+	for sampled < costSampleDocuments && bsonSource.LoadNext() != nil {
+		sampled++
+	}
+	if err := bsonSource.Err(); err != nil {
+		return 0, err
+	}
+	if sampled < costSampleDocuments || bsonFile.Pos() <= 0 || bsonFile.Pos() >= size {
+		return sampled, nil
+	}
+	return sampled * size / bsonFile.Pos(), nil
+}
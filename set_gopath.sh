@@ -23,8 +23,10 @@ setgopath() {
 		# well as the source for the mongo tools
 		rm -rf .gopath/
 		mkdir -p .gopath/src/"$TOOLS_PKG"
+		cp -r `pwd`/bsondiff .gopath/src/$TOOLS_PKG
 		cp -r `pwd`/bsondump .gopath/src/$TOOLS_PKG
 		cp -r `pwd`/common .gopath/src/$TOOLS_PKG
+		cp -r `pwd`/mongoarchive .gopath/src/$TOOLS_PKG
 		cp -r `pwd`/mongodump .gopath/src/$TOOLS_PKG
 		cp -r `pwd`/mongoexport .gopath/src/$TOOLS_PKG
 		cp -r `pwd`/mongofiles .gopath/src/$TOOLS_PKG